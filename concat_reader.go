@@ -0,0 +1,79 @@
+package powermetrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+)
+
+// RunReaders parses several powermetrics captures as one stream, in order,
+// e.g. log files recorded back to back. It behaves like RunWithReader on
+// the concatenation of readers, with two fixes at each file boundary: a last
+// line without a trailing newline is terminated rather than glued to the
+// next file's first line, and a byte order mark at the start of a file is
+// dropped. A file that starts with a sample header closes the previous
+// sample as usual; one that starts mid-sample, because a capture was split
+// across files, continues the sample in progress. The caller is responsible
+// for closing the readers if needed.
+func RunReaders(ctx context.Context, config Config, readers ...io.Reader) *Stream {
+	for _, reader := range readers {
+		if reader == nil {
+			panic("powermetrics: reader cannot be nil")
+		}
+	}
+	return NewParser(config).RunWithReader(ctx, newConcatReader(readers))
+}
+
+// concatReader reads its sources one after another, normalizing each file
+// boundary as described on RunReaders.
+type concatReader struct {
+	sources []io.Reader
+	current *bufio.Reader
+	// last is the last byte read from the current source; terminate is set
+	// once the source is exhausted and still owes a newline.
+	last      byte
+	terminate bool
+}
+
+func newConcatReader(sources []io.Reader) *concatReader {
+	return &concatReader{sources: sources}
+}
+
+func (r *concatReader) Read(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	for {
+		if r.terminate {
+			r.terminate = false
+			buf[0] = '\n'
+			return 1, nil
+		}
+		if r.current == nil {
+			if len(r.sources) == 0 {
+				return 0, io.EOF
+			}
+			r.current = bufio.NewReader(r.sources[0])
+			r.sources = r.sources[1:]
+			r.last = 0
+			if bom, err := r.current.Peek(len(utf8BOM)); err == nil && bytes.Equal(bom, []byte(utf8BOM)) {
+				_, _ = r.current.Discard(len(utf8BOM))
+			}
+		}
+
+		n, err := r.current.Read(buf)
+		if n > 0 {
+			r.last = buf[n-1]
+		}
+		if err == io.EOF {
+			r.current = nil
+			r.terminate = r.last != 0 && r.last != '\n'
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
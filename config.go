@@ -2,23 +2,307 @@ package powermetrics
 
 import (
 	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 const defaultPowermetricsPath = "/usr/bin/powermetrics"
 
+// RecommendedSamplers is the full sampler set the parser understands, used by
+// the default arguments, ProfileFull and the example CLI. Join it with commas
+// to build a custom --samplers argument:
+//
+//	args := []string{"--samplers", strings.Join(powermetrics.RecommendedSamplers, ","), "-i", "500"}
+var RecommendedSamplers = []string{
+	"tasks", "battery", "network", "disk", "interrupts",
+	"cpu_power", "gpu_power", "ane_power", "thermal",
+}
+
 var defaultPowermetricsArgs = []string{
-	"--samplers", "tasks,battery,network,disk,interrupts,cpu_power,gpu_power,ane_power,thermal",
+	"--samplers", strings.Join(RecommendedSamplers, ","),
 	"--show-process-gpu",
 	"--show-initial-usage",
 	"-i", "1000",
 }
 
+// Profile names a preset sampler/flag combination for powermetrics.
+type Profile string
+
+const (
+	// ProfileBattery samples battery, power and thermal state only; it skips
+	// the per-process tables to keep powermetrics' own overhead low.
+	ProfileBattery Profile = "battery"
+	// ProfilePerformance samples CPU/GPU/ANE power, thermal state and the
+	// tasks table including per-process GPU usage.
+	ProfilePerformance Profile = "performance"
+	// ProfileFull enables every sampler the parser understands; it matches
+	// the default arguments.
+	ProfileFull Profile = "full"
+)
+
+// PowerUnit selects the unit Metrics.Power and Metrics.Table present power
+// readings in.
+type PowerUnit string
+
+const (
+	// PowerUnitWatts presents every power reading in watts.
+	PowerUnitWatts PowerUnit = "W"
+	// PowerUnitMilliwatts presents every power reading in milliwatts.
+	PowerUnitMilliwatts PowerUnit = "mW"
+)
+
+// fromWatts converts a power reading in watts to u; the empty unit is
+// watts.
+func (u PowerUnit) fromWatts(watts float64) float64 {
+	if u == PowerUnitMilliwatts {
+		return watts * 1000
+	}
+	return watts
+}
+
+var profileArgs = map[Profile][]string{
+	ProfileBattery: {
+		"--samplers", "battery,cpu_power,gpu_power,ane_power,thermal",
+	},
+	ProfilePerformance: {
+		"--samplers", "tasks,cpu_power,gpu_power,ane_power,thermal",
+		"--show-process-gpu",
+	},
+	ProfileFull: {
+		"--samplers", strings.Join(RecommendedSamplers, ","),
+		"--show-process-gpu",
+		"--show-initial-usage",
+	},
+}
+
+// checkProfile reports a Profile that names no preset; the empty Profile
+// selects the defaults.
+func checkProfile(profile Profile) error {
+	if _, ok := profileArgs[profile]; profile != "" && !ok {
+		return fmt.Errorf("powermetrics: unknown Profile %q", profile)
+	}
+	return nil
+}
+
 // Config holds configuration for the powermetrics collector.
 type Config struct {
 	PowermetricsPath string
 	PowermetricsArgs []string
 	SampleWindow     time.Duration
+	// Profile selects a preset argument list. It is only consulted when
+	// PowermetricsArgs is empty; an unknown profile fails the stream.
+	Profile Profile
+	// Logger receives parser diagnostics such as clamped out-of-range values.
+	// A nil Logger discards them.
+	Logger *log.Logger
+	// RawLogPath, when set, records the raw powermetrics output to this file
+	// (truncating it) while it is parsed, so the exact input can be attached
+	// to bug reports and replayed with RunWithReader. The file is flushed and
+	// closed when the stream ends.
+	RawLogPath string
+	// Env lists extra "KEY=value" variables for the powermetrics process, on
+	// top of the current environment (later entries win). For example
+	// "LC_ALL=C" forces "." decimal separators regardless of the user's locale.
+	Env []string
+	// DecimalComma treats a comma between two digits as a decimal separator,
+	// for output produced under locales that print "15,5 W". It is off by
+	// default because it would misread thousands separators; setting
+	// Env to include "LC_ALL=C" avoids the problem at the source.
+	DecimalComma bool
+	// FrequencySnapMHz, when positive, snaps parsed residency frequencies to
+	// the nearest frequency step seen earlier in the stream if it is within
+	// this many MHz, so slightly jittered readings of the same P-state
+	// aggregate under one key across samples. The step tables are built from
+	// the frequencies observed, separately for CPUs, clusters and the GPU;
+	// the first reading of each step wins.
+	FrequencySnapMHz float64
+	// MinGPUProcessBusyPercent drops GPU process samples whose BusyPercent is
+	// below this threshold while parsing. Zero keeps every process; use
+	// Metrics.FilterGPUProcesses to filter after the fact instead.
+	MinGPUProcessBusyPercent float64
+	// RestartPolicy restarts powermetrics when it exits unexpectedly during
+	// RunWithErrors, keeping the same stream. The zero value never restarts.
+	RestartPolicy RestartPolicy
+	// PowerUnit is the unit Metrics.Power and Metrics.Table present every
+	// power reading in, recorded in Metrics.PowerUnit. The default is watts.
+	// It does not change the stored fields, which keep the units their
+	// names carry (CPUPowerWatts in watts, PowerMilliwatts in milliwatts).
+	PowerUnit PowerUnit
+	// CPUResidencyHistoryDepth, when positive, keeps the last this many
+	// active residency maps of each CPU, one per sample, for
+	// Parser.CPUResidencyHistory. Zero keeps no history.
+	CPUResidencyHistoryDepth int
+	// ReadTimeout, when positive, ends the stream with ErrReadTimeout if a
+	// single read from the source blocks longer than this, e.g. a piped log
+	// that stalls mid-line. A powermetrics process started by RunWithErrors
+	// is stopped; a reader passed to RunWithReader is left to the caller to
+	// close. Zero waits indefinitely.
+	ReadTimeout time.Duration
+	// ResolveProcessPaths fills ProcessSample.Path with the executable path
+	// of each task, since the tasks table only shows a possibly truncated
+	// name. It costs a lookup (a /proc read or a ps(1) call) per new PID, so
+	// it is off by default. Processes that exited before the lookup keep an
+	// empty Path; PIDs that drop out of the tasks table are forgotten.
+	ResolveProcessPaths bool
+	// ProcessPathResolver replaces the built-in PID to path lookup used by
+	// ResolveProcessPaths; nil uses the default.
+	ProcessPathResolver func(pid int) (string, error)
+	// Clock supplies the time stamped into Metrics.ReceivedAt, which
+	// AggregateByInterval also buckets by when samples carry no header
+	// timestamp. Tests can inject a fake clock; nil uses time.Now.
+	Clock func() time.Time
+	// HostLabel is stamped into Metrics.Host of every emitted sample, to tell
+	// machines apart when metrics from several Macs are collected centrally.
+	// Empty uses os.Hostname.
+	HostLabel string
+	// RespectExplicitInterval keeps a "-i" given in PowermetricsArgs and
+	// derives SampleWindow from it. By default "-i" is rewritten to match
+	// SampleWindow, and Logger is told when the two disagreed.
+	RespectExplicitInterval bool
+	// EmitOn restricts the stream's emits to lines that changed one of these
+	// sections, e.g. []Section{SectionBattery} yields metrics only when a
+	// battery line is parsed, reducing channel traffic. Metrics still carry
+	// the latest state of every section. ParseLine is not affected. Empty
+	// emits on any change; an unknown section makes every stream fail.
+	EmitOn []Section
+	// StopOnError ends the stream at the first error instead of carrying on:
+	// a line that fails to parse, ErrNotPowermetricsOutput or an unexpected
+	// exit of powermetrics, which is then not restarted despite
+	// RestartPolicy. The error is still reported on the Errors channel
+	// and the sample in progress, such as GPU process lines read before the
+	// error, is emitted before both channels close; the rest of the output
+	// is discarded and a running powermetrics process is stopped. By
+	// default parsing continues past errors.
+	StopOnError bool
+}
+
+// maxRestartBackoff caps the doubling delay between restarts.
+const maxRestartBackoff = time.Minute
+
+// RestartPolicy controls how often and how quickly a crashed powermetrics
+// process is restarted. Each restart is reported on the stream's Errors
+// channel together with the exit error that caused it.
+type RestartPolicy struct {
+	// MaxRetries is the number of restarts allowed over the life of the
+	// stream; zero disables restarting.
+	MaxRetries int
+	// Backoff is the delay before the first restart. It doubles on each
+	// further restart, up to one minute.
+	Backoff time.Duration
+}
+
+// delay returns the backoff before the given restart attempt (1-based).
+func (r RestartPolicy) delay(attempt int) time.Duration {
+	d := r.Backoff
+	for i := 1; i < attempt && d < maxRestartBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRestartBackoff {
+		d = maxRestartBackoff
+	}
+	return d
+}
+
+// samplerFlagDeps lists flags that only produce output when certain samplers
+// are enabled.
+var samplerFlagDeps = []struct {
+	flag     string
+	samplers []string
+}{
+	{"--show-process-gpu", []string{"tasks", "gpu_power"}},
+	{"--show-process-energy", []string{"tasks"}},
+	{"--show-process-io", []string{"tasks"}},
+	{"--show-process-netstats", []string{"tasks"}},
+	{"--show-process-coalition", []string{"tasks"}},
+	{"--show-process-samp-norm", []string{"tasks"}},
+	{"--show-process-qos", []string{"tasks"}},
+	{"--show-process-wait-times", []string{"tasks"}},
+}
+
+// Validate checks the effective powermetrics arguments (after applying the
+// profile or defaults) for flags whose samplers are not enabled, such as
+// --show-process-gpu without the tasks and gpu_power samplers, and for an
+// unknown Profile, PowerUnit or EmitOn section. Arguments without a --samplers list
+// are accepted, since powermetrics then enables every sampler. It returns nil
+// when no problem is found.
+func (c Config) Validate() error {
+	var problems []string
+	if err := checkProfile(c.Profile); err != nil {
+		problems = append(problems, fmt.Sprintf("unknown Profile %q", c.Profile))
+	}
+	switch c.PowerUnit {
+	case "", PowerUnitWatts, PowerUnitMilliwatts:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown PowerUnit %q; use %q or %q", c.PowerUnit, PowerUnitWatts, PowerUnitMilliwatts))
+	}
+	for _, section := range c.EmitOn {
+		if sectionBit(section) == 0 {
+			problems = append(problems, fmt.Sprintf("unknown EmitOn section %q", section))
+		}
+	}
+
+	args := normalizeConfig(c).PowermetricsArgs
+	if samplers, ok := samplerList(args); ok && !samplers["all"] {
+		for _, dep := range samplerFlagDeps {
+			if !hasArg(args, dep.flag) {
+				continue
+			}
+			var missing []string
+			for _, sampler := range dep.samplers {
+				if !samplers[sampler] {
+					missing = append(missing, sampler)
+				}
+			}
+			if len(missing) > 0 {
+				problems = append(problems, fmt.Sprintf("%s requires the %s sampler(s); add them to --samplers",
+					dep.flag, strings.Join(missing, ",")))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("powermetrics: invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// samplerList returns the samplers named by a "--samplers"/"-s" argument and
+// whether one was present.
+func samplerList(args []string) (map[string]bool, bool) {
+	for i, arg := range args {
+		var value string
+		switch {
+		case arg == "--samplers" || arg == "-s":
+			if i+1 >= len(args) {
+				return nil, false
+			}
+			value = args[i+1]
+		case strings.HasPrefix(arg, "--samplers="):
+			value = strings.TrimPrefix(arg, "--samplers=")
+		default:
+			continue
+		}
+		samplers := make(map[string]bool)
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				samplers[name] = true
+			}
+		}
+		return samplers, true
+	}
+	return nil, false
+}
+
+func hasArg(args []string, want string) bool {
+	for _, arg := range args {
+		if arg == want {
+			return true
+		}
+	}
+	return false
 }
 
 func normalizeConfig(cfg Config) Config {
@@ -30,7 +314,11 @@ func normalizeConfig(cfg Config) Config {
 
 	args := normalized.PowermetricsArgs
 	if len(args) == 0 {
-		args = append([]string{}, defaultPowermetricsArgs...)
+		if preset, ok := profileArgs[normalized.Profile]; ok {
+			args = append([]string{}, preset...)
+		} else {
+			args = append([]string{}, defaultPowermetricsArgs...)
+		}
 	} else {
 		args = append([]string{}, args...)
 	}
@@ -40,14 +328,37 @@ func normalizeConfig(cfg Config) Config {
 		window = time.Second
 	}
 
+	// Only an interval the caller passed can disagree; the presets follow
+	// SampleWindow.
+	if explicit, ok := intervalArgument(cfg.PowermetricsArgs); ok && cfg.RespectExplicitInterval {
+		window = explicit
+	}
+
 	args = ensureIntervalArgument(args, window)
 
 	normalized.PowermetricsArgs = args
 	normalized.SampleWindow = window
+	normalized.Env = append([]string(nil), cfg.Env...)
+	if normalized.HostLabel == "" {
+		normalized.HostLabel, _ = os.Hostname()
+	}
 
 	return normalized
 }
 
+// intervalArgument returns the sampling interval of a "-i <ms>" argument.
+func intervalArgument(args []string) (time.Duration, bool) {
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] != "-i" {
+			continue
+		}
+		if ms, err := strconv.Atoi(args[i+1]); err == nil && ms > 0 {
+			return time.Duration(ms) * time.Millisecond, true
+		}
+	}
+	return 0, false
+}
+
 func ensureIntervalArgument(args []string, window time.Duration) []string {
 	interval := fmt.Sprintf("%d", window.Milliseconds())
 	for i := 0; i < len(args)-1; i++ {
@@ -66,3 +377,28 @@ func ensureIntervalArgument(args []string, window time.Duration) []string {
 	newArgs[len(args)+1] = interval
 	return newArgs
 }
+
+// powerAverageCount returns the N of a "--poweravg N" (or "-a N",
+// "--poweravg=N") argument, or 0 when averaging is not requested.
+func powerAverageCount(args []string) int {
+	for i, arg := range args {
+		var value string
+		switch {
+		case arg == "--poweravg" || arg == "-a":
+			if i+1 >= len(args) {
+				return 0
+			}
+			value = args[i+1]
+		case strings.HasPrefix(arg, "--poweravg="):
+			value = strings.TrimPrefix(arg, "--poweravg=")
+		default:
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0
+		}
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,55 @@
+package powermetrics
+
+import (
+	"context"
+	"io"
+	"net"
+)
+
+// RunConn parses powermetrics output read from conn, e.g. a Unix socket fed
+// by a privileged helper running powermetrics, so the consumer itself does
+// not need root. Lines split across reads are reassembled, and the stream
+// ends cleanly when the peer closes the connection. RunConn takes ownership
+// of conn: it is closed when the stream ends or ctx is cancelled, which also
+// unblocks a pending read; the cancellation is then reported as ctx.Err()
+// rather than as a read on a closed connection.
+func RunConn(ctx context.Context, config Config, conn net.Conn) *Stream {
+	if conn == nil {
+		panic("powermetrics: conn cannot be nil")
+	}
+	p := NewParser(config)
+	if p.configErr != nil {
+		_ = conn.Close()
+		return failedStream(p.configErr)
+	}
+
+	ctx, stop := context.WithCancel(ctx)
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	reader, wait, err := p.open(ctx, func(context.Context) (io.Reader, func() error, error) {
+		return &connReader{ctx: ctx, conn: conn}, nil, nil
+	}, false)
+	if err != nil {
+		stop()
+		return failedStream(err)
+	}
+	return p.streamFromReader(ctx, reader, wait, nil, stop)
+}
+
+// connReader reports reads failing because ctx closed the connection as
+// ctx.Err().
+type connReader struct {
+	ctx  context.Context
+	conn net.Conn
+}
+
+func (r *connReader) Read(buf []byte) (int, error) {
+	n, err := r.conn.Read(buf)
+	if err != nil && r.ctx.Err() != nil {
+		err = r.ctx.Err()
+	}
+	return n, err
+}
@@ -0,0 +1,99 @@
+package powermetrics
+
+import "fmt"
+
+// Section names a part of the powermetrics output whose changes can trigger
+// an emit, for Config.EmitOn.
+type Section string
+
+// Sections accepted by Config.EmitOn. Apart from SectionBattery they match
+// the names ObservedSections reports.
+const (
+	SectionSystem       Section = "system"
+	SectionTasks        Section = "tasks"
+	SectionGPUProcesses Section = "gpu_processes"
+	SectionClusters     Section = "clusters"
+	SectionCPUResidency Section = "cpu_residency"
+	SectionGPU          Section = "gpu"
+	SectionNetwork      Section = "network"
+	SectionDisk         Section = "disk"
+	SectionInterrupts   Section = "interrupts"
+	// SectionBattery covers the battery charge, power source and backlight
+	// lines. They also update the SystemSample, so SectionSystem matches
+	// them too.
+	SectionBattery Section = "battery"
+)
+
+// emitSections lists the sections Config.EmitOn accepts; a section's bit in
+// a sectionSet is its index here.
+var emitSections = []Section{
+	SectionSystem,
+	SectionTasks,
+	SectionGPUProcesses,
+	SectionClusters,
+	SectionCPUResidency,
+	SectionGPU,
+	SectionNetwork,
+	SectionDisk,
+	SectionInterrupts,
+	SectionBattery,
+}
+
+// sectionSet is a bit set of emitSections indexes.
+type sectionSet uint16
+
+// sectionBit returns the bit of section, or 0 for an unknown section.
+func sectionBit(section Section) sectionSet {
+	for i, s := range emitSections {
+		if s == section {
+			return 1 << i
+		}
+	}
+	return 0
+}
+
+// checkEmitOn returns an error naming the first unknown section of
+// Config.EmitOn, which would otherwise never let anything through.
+func checkEmitOn(sections []Section) error {
+	for _, section := range sections {
+		if sectionBit(section) == 0 {
+			return fmt.Errorf("powermetrics: unknown EmitOn section %q", section)
+		}
+	}
+	return nil
+}
+
+// sectionSetOf returns the set of the sections in sections.
+func sectionSetOf(sections []Section) sectionSet {
+	var set sectionSet
+	for _, section := range sections {
+		set |= sectionBit(section)
+	}
+	return set
+}
+
+// streamLine parses line for a stream, which unlike ParseLine applies
+// Config.EmitOn: metrics are only emitted for lines that changed one of its
+// sections. Interrupt lines do not produce metrics through ParseLine, so
+// with SectionInterrupts in EmitOn a changed interrupt rate builds them here.
+func (p *Parser) streamLine(line string) (*Metrics, error) {
+	metrics, err := p.parseLine(line)
+	if p.emitOn == 0 {
+		return p.finishMetrics(metrics), err
+	}
+	interrupts := sectionBit(SectionInterrupts)
+	if metrics == nil && p.emitOn&p.emitTrigger&interrupts != 0 {
+		metrics = p.buildMetrics()
+	}
+	return p.finishMetrics(p.emitFilter(metrics)), err
+}
+
+// emitFilter returns metrics if the sections that produced it, recorded in
+// p.emitTrigger, include one of Config.EmitOn, and nil otherwise. Without
+// EmitOn every change emits.
+func (p *Parser) emitFilter(metrics *Metrics) *Metrics {
+	if metrics == nil || p.emitOn == 0 || p.emitTrigger&p.emitOn != 0 {
+		return metrics
+	}
+	return nil
+}
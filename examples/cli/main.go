@@ -8,6 +8,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -28,9 +29,23 @@ func main() {
 		onlyInterrupts   = flag.Bool("interrupts", false, "only show interrupt metrics")
 		help             = flag.Bool("help", false, "show help message")
 		debug            = flag.Bool("debug", false, "show debug information")
+		colorMode        = flag.String("color", "auto", "colorize human output: auto (when stdout is a terminal), always or never")
+		watch            = flag.Bool("watch", false, "redraw a live dashboard in place with a CPU power sparkline and top processes")
+		compact          = flag.Bool("compact", false, "print one terse line per sample (for status bars)")
+		table            = flag.Bool("table", false, "print each sample as an aligned table of the key metrics")
+		precision        = flag.Int("precision", defaultPrecision, "decimal places for power, temperature and percentage values in human output")
+		replayPath       = flag.String("replay", "", "parse and render a saved powermetrics log file (plain or gzipped) instead of running powermetrics")
+		realtime         = flag.Bool("realtime", false, "with -replay, honor the recorded timing between samples")
+		timeZone         = flag.String("tz", "local", "time zone for sample timestamps: local, UTC or an IANA name such as Europe/Berlin")
+		timeFormat       = flag.String("timefmt", "RFC3339", "sample timestamp format: RFC3339, RFC3339Nano, RFC1123, DateTime, Kitchen, Stamp or a Go layout")
+		printCmd         = flag.Bool("print-cmd", false, "print the powermetrics command line that would run and exit without running it")
+		fromStdin        = flag.Bool("stdin", false, "parse a saved powermetrics log from standard input instead of running powermetrics (same as passing \"-\")")
 	)
 
 	flag.Parse()
+	if flag.Arg(0) == "-" {
+		*fromStdin = true
+	}
 
 	if *help {
 		fmt.Println("powermetrics-go CLI tool")
@@ -53,12 +68,41 @@ func main() {
 		fmt.Printf("Debug: Disk only: %t\n", *onlyDisk)
 		fmt.Printf("Debug: Battery only: %t\n", *onlyBattery)
 		fmt.Printf("Debug: Interrupts only: %t\n", *onlyInterrupts)
+		fmt.Printf("Debug: Watch: %t\n", *watch)
+		fmt.Printf("Debug: Compact: %t\n", *compact)
+		fmt.Printf("Debug: Table: %t\n", *table)
+		fmt.Printf("Debug: Precision: %d\n", *precision)
+		fmt.Printf("Debug: Time zone: %q, format: %q\n", *timeZone, *timeFormat)
+		fmt.Printf("Debug: Stdin: %t\n", *fromStdin)
+		fmt.Printf("Debug: Print command: %t\n", *printCmd)
+		fmt.Printf("Debug: Replay: %q (realtime %t)\n", *replayPath, *realtime)
+	}
+
+	selected := sections{
+		system:       *onlySystem,
+		process:      *onlyProcess,
+		cpuResidency: *onlyCPUResidency,
+		gpuResidency: *onlyGPUResidency,
+		network:      *onlyNetwork,
+		disk:         *onlyDisk,
+		battery:      *onlyBattery,
+		interrupts:   *onlyInterrupts,
+	}
+
+	color, err := useColor(*colorMode, os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out := newRenderer(os.Stdout, *precision, color)
+	stamp, err := newTimeFormatter(*timeZone, *timeFormat)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	// Create config with custom interval - using more reliable sampler configuration
 	config := powermetrics.Config{
 		SampleWindow:     *interval,
-		PowermetricsArgs: []string{"--samplers", "tasks,battery,network,disk,interrupts,cpu_power,gpu_power,ane_power,thermal", "--show-process-gpu", "--show-initial-usage", "-i", fmt.Sprintf("%d", interval.Milliseconds())},
+		PowermetricsArgs: []string{"--samplers", strings.Join(powermetrics.RecommendedSamplers, ","), "--show-process-gpu", "--show-initial-usage", "-i", fmt.Sprintf("%d", interval.Milliseconds())},
 	}
 
 	// Set up signal handling for graceful shutdown
@@ -77,9 +121,34 @@ func main() {
 		fmt.Println("Debug: Starting powermetrics parser")
 	}
 	parser := powermetrics.NewParser(config)
-	metricsChan, err := parser.Run(ctx)
-	if err != nil {
-		log.Fatal("Failed to start powermetrics: ", err)
+	if *printCmd {
+		fmt.Println(formatCommandLine(parser.CommandLine()))
+		return
+	}
+	effectiveInterval := parser.EffectiveInterval()
+	var metricsChan <-chan powermetrics.Metrics
+	if *replayPath != "" {
+		replay, err := openReplay(*replayPath)
+		if err != nil {
+			log.Fatal("Failed to open replay file: ", err)
+		}
+		defer replay.Close()
+
+		stream := parser.RunWithReader(ctx, replay)
+		go logErrors(stream.Errors, *debug)
+		metricsChan = stream.Metrics
+		if *realtime {
+			metricsChan = pace(metricsChan, time.Sleep)
+		}
+	} else if *fromStdin {
+		stream := parser.RunWithReader(ctx, os.Stdin)
+		go logErrors(stream.Errors, *debug)
+		metricsChan = stream.Metrics
+	} else {
+		metricsChan, err = parser.Run(ctx)
+		if err != nil {
+			log.Fatal("Failed to start powermetrics: ", err)
+		}
 	}
 
 	if *debug {
@@ -87,10 +156,17 @@ func main() {
 		fmt.Println("Debug: Waiting for metrics...")
 	}
 
-	// Process metrics - add rate limiting to respect the specified interval
+	if *watch {
+		runWatch(metricsChan, out, effectiveInterval)
+		return
+	}
+
+	// Process metrics - add rate limiting to respect the specified interval.
+	// Saved logs are parsed as fast as they can be read, so they are not throttled.
+	live := !*fromStdin && *replayPath == ""
 	var lastOutputTime time.Time
 	shouldThrottle := func() bool {
-		return !lastOutputTime.IsZero() && time.Since(lastOutputTime) < *interval
+		return live && !lastOutputTime.IsZero() && time.Since(lastOutputTime) < effectiveInterval
 	}
 	markOutput := func() {
 		lastOutputTime = time.Now()
@@ -101,6 +177,26 @@ func main() {
 			fmt.Println("Debug: Received metrics")
 		}
 
+		if *compact && !*jsonOutput {
+			line := compactLine(metrics, selected)
+			if line == "" || shouldThrottle() {
+				continue
+			}
+			fmt.Println(line)
+			markOutput()
+			continue
+		}
+
+		if *table && !*jsonOutput {
+			text := metrics.Table()
+			if text == "" || shouldThrottle() {
+				continue
+			}
+			fmt.Println(text)
+			markOutput()
+			continue
+		}
+
 		if *onlyCPUResidency {
 			if len(metrics.CPUResidencies) > 0 {
 				if shouldThrottle() {
@@ -110,18 +206,7 @@ func main() {
 					data, _ := json.Marshal(metrics.CPUResidencies)
 					fmt.Println(string(data))
 				} else {
-					fmt.Printf("CPU Residencies: %d\n", len(metrics.CPUResidencies))
-					for _, cpu := range metrics.CPUResidencies {
-						fmt.Printf("  CPU %d: Freq %.0f MHz, Active: %.2f%%, Idle: %.2f%%, Down: %.2f%%\n",
-							cpu.CPUID, cpu.Frequency, calculateTotalActive(cpu.ActiveResidency), cpu.IdleResidency, cpu.DownResidency)
-						if len(cpu.ActiveResidency) > 0 {
-							fmt.Printf("    Frequency Residency: ")
-							for freq, percent := range cpu.ActiveResidency {
-								fmt.Printf("%.0fMHz:%.2f%% ", freq, percent)
-							}
-							fmt.Printf("\n")
-						}
-					}
+					out.cpuResidencies(metrics.CPUResidencies, true)
 				}
 				markOutput()
 			}
@@ -134,29 +219,7 @@ func main() {
 					data, _ := json.Marshal(metrics.GPUResidency)
 					fmt.Println(string(data))
 				} else {
-					fmt.Printf("GPU Residency: HW Active: %.2f%%, Idle: %.2f%%, Power: %.2f mW\n",
-						metrics.GPUResidency.HWActiveResidency, metrics.GPUResidency.IdleResidency, metrics.GPUResidency.PowerMilliwatts)
-					if len(metrics.GPUResidency.HWActiveFreqResidency) > 0 {
-						fmt.Printf("  Frequency Residency: ")
-						for freq, percent := range metrics.GPUResidency.HWActiveFreqResidency {
-							fmt.Printf("%.0fMHz:%.2f%% ", freq, percent)
-						}
-						fmt.Printf("\n")
-					}
-					if len(metrics.GPUResidency.SWRequestedStates) > 0 {
-						fmt.Printf("  SW Requested States: ")
-						for state, percent := range metrics.GPUResidency.SWRequestedStates {
-							fmt.Printf("%s:%.2f%% ", state, percent)
-						}
-						fmt.Printf("\n")
-					}
-					if len(metrics.GPUResidency.SWStates) > 0 {
-						fmt.Printf("  SW States: ")
-						for state, percent := range metrics.GPUResidency.SWStates {
-							fmt.Printf("%s:%.2f%% ", state, percent)
-						}
-						fmt.Printf("\n")
-					}
+					out.gpuResidency(metrics.GPUResidency, true)
 				}
 				markOutput()
 			}
@@ -169,9 +232,7 @@ func main() {
 					data, _ := json.Marshal(metrics.Network)
 					fmt.Println(string(data))
 				} else {
-					fmt.Printf("Network: Out %d packets/s, %d bytes/s | In %d packets/s, %d bytes/s\n",
-						int(metrics.Network.OutPacketsPerSec), int(metrics.Network.OutBytesPerSec),
-						int(metrics.Network.InPacketsPerSec), int(metrics.Network.InBytesPerSec))
+					out.network(metrics.Network)
 				}
 				markOutput()
 			}
@@ -184,9 +245,7 @@ func main() {
 					data, _ := json.Marshal(metrics.Disk)
 					fmt.Println(string(data))
 				} else {
-					fmt.Printf("Disk: Read %d ops/s, %d bytes/s | Write %d ops/s, %d bytes/s\n",
-						int(metrics.Disk.ReadOpsPerSec), int(metrics.Disk.ReadBytesPerSec),
-						int(metrics.Disk.WriteOpsPerSec), int(metrics.Disk.WriteBytesPerSec))
+					out.disk(metrics.Disk)
 				}
 				markOutput()
 			}
@@ -199,7 +258,7 @@ func main() {
 					data, _ := json.Marshal(map[string]float64{"battery_percent": metrics.SystemSample.BatteryPercent})
 					fmt.Println(string(data))
 				} else {
-					fmt.Printf("Battery: %.2f%%\n", metrics.SystemSample.BatteryPercent)
+					out.battery(metrics.SystemSample.BatteryPercent)
 				}
 				markOutput()
 			}
@@ -212,11 +271,7 @@ func main() {
 					data, _ := json.Marshal(metrics.Interrupts)
 					fmt.Println(string(data))
 				} else {
-					fmt.Printf("Interrupts: %d CPUs\n", len(metrics.Interrupts))
-					for _, intr := range metrics.Interrupts {
-						fmt.Printf("  CPU %d: Total IRQs %.2f/s, IPI %.2f/s, TIMER %.2f/s\n",
-							intr.CPUID, intr.TotalIRQ, intr.IPI, intr.TIMER)
-					}
+					out.interrupts(metrics.Interrupts)
 				}
 				markOutput()
 			}
@@ -253,19 +308,10 @@ func main() {
 					continue
 				}
 				if len(metrics.ProcessSamples) > 0 {
-					fmt.Printf("Processes: %d\n", len(metrics.ProcessSamples))
-					for _, proc := range metrics.ProcessSamples {
-						fmt.Printf("  PID: %d, Name: %s, CPU: %.2f ms/s, User: %.2f%%, Deadlines <2ms: %.2f, 2-5ms: %.2f, Wakeups Intr: %.2f, Pkg Idle: %.2f\n",
-							proc.PID, proc.Name, proc.CPUMsPerSec, proc.UserPercent,
-							proc.DeadlinesLT2Ms, proc.Deadlines2To5Ms, proc.WakeupsInterrupts, proc.WakeupsPkgIdle)
-					}
+					out.processes(metrics.ProcessSamples)
 				}
 				if len(metrics.GPUProcessSamples) > 0 {
-					fmt.Printf("GPU Processes: %d\n", len(metrics.GPUProcessSamples))
-					for _, proc := range metrics.GPUProcessSamples {
-						fmt.Printf("  PID: %d, Name: %s, Busy: %.2f%%, Active: %d ns\n",
-							proc.PID, proc.Name, proc.BusyPercent, proc.ActiveNanos)
-					}
+					out.gpuProcesses(metrics.GPUProcessSamples)
 				}
 				markOutput()
 			}
@@ -280,11 +326,7 @@ func main() {
 				data, _ := json.Marshal(metrics.SystemSample)
 				fmt.Println(string(data))
 			} else {
-				fmt.Printf("CPU Power: %.2f W, GPU Power: %.2f W, ANE Power: %.2f W, CPU Freq: %.0f MHz, GPU Freq: %.0f MHz, CPU Temp: %.2f°C, GPU Temp: %.2f°C, ANE Busy: %.2f%%, Battery: %.2f%%\n",
-					metrics.SystemSample.CPUPowerWatts, metrics.SystemSample.GPUPowerWatts, metrics.SystemSample.ANEPowerWatts,
-					metrics.SystemSample.CPUFrequencyMHz, metrics.SystemSample.GPUFrequencyMHz,
-					metrics.SystemSample.CPUTemperatureC, metrics.SystemSample.GPUTemperatureC,
-					metrics.SystemSample.ANEBusyPercent, metrics.SystemSample.BatteryPercent)
+				out.system(metrics.SystemSample, true)
 			}
 			markOutput()
 		} else if !*onlyProcess && !*onlySystem && !*onlyCPUResidency && !*onlyGPUResidency &&
@@ -335,6 +377,9 @@ func main() {
 				if shouldThrottle() {
 					continue
 				}
+				if ts := stamp.format(metrics.Timestamp); ts != "" {
+					output["timestamp"] = ts
+				}
 
 				data, _ := json.Marshal(output)
 				fmt.Println(string(data))
@@ -359,12 +404,11 @@ func main() {
 					continue
 				}
 
+				if ts := stamp.format(metrics.Timestamp); ts != "" {
+					fmt.Printf("Time: %s\n", ts)
+				}
 				if metrics.SystemSample != nil {
-					fmt.Printf("CPU Power: %.2f W, GPU Power: %.2f W, CPU Freq: %.0f MHz, GPU Freq: %.0f MHz, CPU Temp: %.2f°C, GPU Temp: %.2f°C, ANE Busy: %.2f%%, Battery: %.2f%%\n",
-						metrics.SystemSample.CPUPowerWatts, metrics.SystemSample.GPUPowerWatts,
-						metrics.SystemSample.CPUFrequencyMHz, metrics.SystemSample.GPUFrequencyMHz,
-						metrics.SystemSample.CPUTemperatureC, metrics.SystemSample.GPUTemperatureC,
-						metrics.SystemSample.ANEBusyPercent, metrics.SystemSample.BatteryPercent)
+					out.system(metrics.SystemSample, false)
 				}
 
 				if len(metrics.ProcessSamples) > 0 && *debug {
@@ -372,52 +416,31 @@ func main() {
 				}
 
 				if len(metrics.GPUProcessSamples) > 0 {
-					fmt.Printf("GPU Processes: %d\n", len(metrics.GPUProcessSamples))
-					for _, proc := range metrics.GPUProcessSamples {
-						fmt.Printf("  PID: %d, Name: %s, Busy: %.2f%%, Active: %d ns\n",
-							proc.PID, proc.Name, proc.BusyPercent, proc.ActiveNanos)
-					}
+					out.gpuProcesses(metrics.GPUProcessSamples)
 				}
 
 				if len(metrics.Clusters) > 0 {
-					fmt.Printf("CPU Clusters: %d\n", len(metrics.Clusters))
-					for _, cluster := range metrics.Clusters {
-						fmt.Printf("  Name: %s, Type: %s, Online: %.2f%%, Freq: %.0f MHz\n",
-							cluster.Name, cluster.Type, cluster.OnlinePercent, cluster.HWActiveFreq)
-					}
+					out.clusters(metrics.Clusters)
 				}
 
 				if len(metrics.CPUResidencies) > 0 {
-					fmt.Printf("CPU Residencies: %d\n", len(metrics.CPUResidencies))
-					for _, cpu := range metrics.CPUResidencies {
-						fmt.Printf("  CPU %d: Freq %.0f MHz, Active: %.2f%%, Idle: %.2f%%, Down: %.2f%%\n",
-							cpu.CPUID, cpu.Frequency, calculateTotalActive(cpu.ActiveResidency), cpu.IdleResidency, cpu.DownResidency)
-					}
+					out.cpuResidencies(metrics.CPUResidencies, false)
 				}
 
 				if metrics.GPUResidency != nil {
-					fmt.Printf("GPU Residency: HW Active: %.2f%%, Idle: %.2f%%, Power: %.2f mW\n",
-						metrics.GPUResidency.HWActiveResidency, metrics.GPUResidency.IdleResidency, metrics.GPUResidency.PowerMilliwatts)
+					out.gpuResidency(metrics.GPUResidency, false)
 				}
 
 				if metrics.Network != nil {
-					fmt.Printf("Network: Out %d packets/s, %d bytes/s | In %d packets/s, %d bytes/s\n",
-						int(metrics.Network.OutPacketsPerSec), int(metrics.Network.OutBytesPerSec),
-						int(metrics.Network.InPacketsPerSec), int(metrics.Network.InBytesPerSec))
+					out.network(metrics.Network)
 				}
 
 				if metrics.Disk != nil {
-					fmt.Printf("Disk: Read %d ops/s, %d bytes/s | Write %d ops/s, %d bytes/s\n",
-						int(metrics.Disk.ReadOpsPerSec), int(metrics.Disk.ReadBytesPerSec),
-						int(metrics.Disk.WriteOpsPerSec), int(metrics.Disk.WriteBytesPerSec))
+					out.disk(metrics.Disk)
 				}
 
 				if len(metrics.Interrupts) > 0 {
-					fmt.Printf("Interrupts: %d CPUs\n", len(metrics.Interrupts))
-					for _, intr := range metrics.Interrupts {
-						fmt.Printf("  CPU %d: Total IRQs %.2f/s, IPI %.2f/s, TIMER %.2f/s\n",
-							intr.CPUID, intr.TotalIRQ, intr.IPI, intr.TIMER)
-					}
+					out.interrupts(metrics.Interrupts)
 				}
 
 				markOutput()
@@ -434,6 +457,16 @@ func main() {
 	}
 }
 
+// logErrors reports stream errors on stderr when debugging and otherwise
+// drains them so the parser never blocks.
+func logErrors(errs <-chan error, debug bool) {
+	for err := range errs {
+		if debug {
+			fmt.Fprintf(os.Stderr, "Debug: %v\n", err)
+		}
+	}
+}
+
 // Helper function to calculate total active residency from the frequency map
 func calculateTotalActive(residencyMap map[float64]float64) float64 {
 	total := 0.0
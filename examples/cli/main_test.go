@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// cliHelperEnv makes the test binary run main() instead of the tests, so the
+// CLI can be exercised end to end with real flags and standard input.
+const cliHelperEnv = "POWERMETRICS_CLI_HELPER_ARGS"
+
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv(cliHelperEnv); ok {
+		os.Args = append([]string{"powermetrics-go"}, strings.Fields(args)...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runCLI runs the CLI with args, feeding it stdin, and returns its stdout.
+func runCLI(t *testing.T, stdin []byte, args ...string) string {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), cliHelperEnv+"="+strings.Join(args, " "))
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("CLI %v failed: %v\nstderr: %s", args, err, stderr.String())
+	}
+	return stdout.String()
+}
+
+func TestStdinJSON(t *testing.T) {
+	capture, err := os.ReadFile("testdata/capture.txt")
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+
+	for _, args := range [][]string{{"-stdin", "-json", "-network"}, {"-json", "-network", "-"}} {
+		out := runCLI(t, capture, args...)
+
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		if len(lines) == 0 || lines[0] == "" {
+			t.Fatalf("%v: expected JSON output, got %q", args, out)
+		}
+		var network struct {
+			InBytesPerSec  float64
+			OutBytesPerSec float64
+		}
+		if err := json.Unmarshal([]byte(lines[len(lines)-1]), &network); err != nil {
+			t.Fatalf("%v: invalid JSON %q: %v", args, lines[len(lines)-1], err)
+		}
+		if network.InBytesPerSec != 113827.21 || network.OutBytesPerSec != 4586.65 {
+			t.Errorf("%v: unexpected network metrics %+v", args, network)
+		}
+	}
+}
+
+func TestStdinEmptyInputExitsCleanly(t *testing.T) {
+	if out := runCLI(t, nil, "-stdin", "-system"); out != "" {
+		t.Errorf("expected no output for empty stdin, got %q", out)
+	}
+}
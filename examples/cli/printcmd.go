@@ -0,0 +1,23 @@
+package main
+
+import "strings"
+
+// formatCommandLine renders path and args as one shell command line for
+// -print-cmd, single-quoting the words the shell would otherwise split or
+// expand.
+func formatCommandLine(path string, args []string) string {
+	words := make([]string, 0, len(args)+1)
+	for _, word := range append([]string{path}, args...) {
+		words = append(words, shellQuote(word))
+	}
+	return strings.Join(words, " ")
+}
+
+// shellQuote returns word unchanged when it only has characters that are
+// safe in a POSIX shell, and single-quoted otherwise.
+func shellQuote(word string) string {
+	if word != "" && strings.Trim(word, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./,:=+%@") == "" {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
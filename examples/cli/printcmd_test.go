@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/BinSquare/powermetrics-go"
+)
+
+func TestFormatCommandLine(t *testing.T) {
+	got := formatCommandLine("/usr/bin/powermetrics", []string{"--samplers", "cpu_power,gpu_power", "-i", "500", "--output-file", "/tmp/my log's.txt", ""})
+	want := `/usr/bin/powermetrics --samplers cpu_power,gpu_power -i 500 --output-file '/tmp/my log'\''s.txt' ''`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestFormatCommandLineMatchesParser(t *testing.T) {
+	parser := powermetrics.NewParser(powermetrics.Config{
+		PowermetricsArgs: []string{"--samplers", strings.Join(powermetrics.RecommendedSamplers, ","), "--show-process-gpu"},
+	})
+	want := "/usr/bin/powermetrics --samplers " + strings.Join(powermetrics.RecommendedSamplers, ",") + " --show-process-gpu -i 1000"
+	if got := formatCommandLine(parser.CommandLine()); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
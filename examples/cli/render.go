@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/BinSquare/powermetrics-go"
+)
+
+const defaultPrecision = 2
+
+const (
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+	ansiReset = "\x1b[0m"
+
+	// Thresholds used to highlight values when color output is enabled.
+	highPowerWatts   = 5.0
+	idlePowerWatts   = 0.1
+	highTemperatureC = 80.0
+)
+
+// renderer writes the human-readable representation of metrics sections.
+type renderer struct {
+	w         io.Writer
+	precision int
+	color     bool
+}
+
+func newRenderer(w io.Writer, precision int, color bool) *renderer {
+	if precision < 0 {
+		precision = defaultPrecision
+	}
+	return &renderer{w: w, precision: precision, color: color}
+}
+
+// useColor resolves the -color flag value ("auto", "always" or "never").
+// In auto mode color is enabled only when stdout is a terminal.
+func useColor(mode string, out *os.File) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto", "":
+		info, err := out.Stat()
+		if err != nil {
+			return false, nil
+		}
+		return info.Mode()&os.ModeCharDevice != 0, nil
+	default:
+		return false, fmt.Errorf("invalid -color value %q (want auto, always or never)", mode)
+	}
+}
+
+// num formats a power, temperature or percentage value using the configured precision.
+func (r *renderer) num(v float64) string {
+	return strconv.FormatFloat(v, 'f', r.precision, 64)
+}
+
+// power formats a wattage, coloring high draw red and idle draw green.
+func (r *renderer) power(watts float64) string {
+	return r.scaledPower(watts, 1)
+}
+
+// scaledPower formats a power value expressed in 1/divisor watts, using the
+// same thresholds as power.
+func (r *renderer) scaledPower(value, divisor float64) string {
+	text := r.num(value)
+	switch watts := value / divisor; {
+	case watts >= highPowerWatts:
+		return r.paint(ansiRed, text)
+	case watts < idlePowerWatts:
+		return r.paint(ansiGreen, text)
+	}
+	return text
+}
+
+// temperature formats a temperature, coloring hot readings red. Zero means
+// the sensor was not reported and is left uncolored.
+func (r *renderer) temperature(celsius float64) string {
+	if celsius >= highTemperatureC {
+		return r.paint(ansiRed, r.num(celsius))
+	}
+	return r.num(celsius)
+}
+
+func (r *renderer) paint(code, s string) string {
+	if !r.color {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+func (r *renderer) printf(format string, args ...interface{}) {
+	fmt.Fprintf(r.w, format, args...)
+}
+
+func (r *renderer) system(s *powermetrics.SystemSample, withANEPower bool) {
+	if withANEPower {
+		r.printf("CPU Power: %s W, GPU Power: %s W, ANE Power: %s W, CPU Freq: %.0f MHz, GPU Freq: %.0f MHz, CPU Temp: %s°C, GPU Temp: %s°C, ANE Busy: %s%%, Battery: %s%%\n",
+			r.power(s.CPUPowerWatts), r.power(s.GPUPowerWatts), r.power(s.ANEPowerWatts),
+			s.CPUFrequencyMHz, s.GPUFrequencyMHz,
+			r.temperature(s.CPUTemperatureC), r.temperature(s.GPUTemperatureC),
+			r.num(s.ANEBusyPercent), r.num(s.BatteryPercent))
+		return
+	}
+	r.printf("CPU Power: %s W, GPU Power: %s W, CPU Freq: %.0f MHz, GPU Freq: %.0f MHz, CPU Temp: %s°C, GPU Temp: %s°C, ANE Busy: %s%%, Battery: %s%%\n",
+		r.power(s.CPUPowerWatts), r.power(s.GPUPowerWatts),
+		s.CPUFrequencyMHz, s.GPUFrequencyMHz,
+		r.temperature(s.CPUTemperatureC), r.temperature(s.GPUTemperatureC),
+		r.num(s.ANEBusyPercent), r.num(s.BatteryPercent))
+}
+
+func (r *renderer) processes(procs []powermetrics.ProcessSample) {
+	r.printf("Processes: %d\n", len(procs))
+	for _, proc := range procs {
+		r.printf("  PID: %d, Name: %s, CPU: %s ms/s, User: %s%%, Deadlines <2ms: %s, 2-5ms: %s, Wakeups Intr: %s, Pkg Idle: %s",
+			proc.PID, proc.Name, r.num(proc.CPUMsPerSec), r.num(proc.UserPercent),
+			r.num(proc.DeadlinesLT2Ms), r.num(proc.Deadlines2To5Ms), r.num(proc.WakeupsInterrupts), r.num(proc.WakeupsPkgIdle))
+		// Only --show-process-energy output has an Energy Impact column.
+		if proc.EnergyImpactReported() {
+			r.printf(", Energy Impact: %s", r.num(proc.EnergyImpact))
+		}
+		r.printf("\n")
+	}
+}
+
+func (r *renderer) gpuProcesses(procs []powermetrics.GPUProcessSample) {
+	r.printf("GPU Processes: %d\n", len(procs))
+	for _, proc := range procs {
+		r.printf("  PID: %d, Name: %s, Busy: %s%%, Active: %d ns\n",
+			proc.PID, proc.Name, r.num(proc.BusyPercent), proc.ActiveNanos)
+	}
+}
+
+func (r *renderer) clusters(clusters []powermetrics.ClusterInfo) {
+	r.printf("CPU Clusters: %d\n", len(clusters))
+	for _, cluster := range clusters {
+		r.printf("  Name: %s, Type: %s, Online: %s%%, Freq: %.0f MHz\n",
+			cluster.Name, cluster.Type, r.num(cluster.OnlinePercent), cluster.HWActiveFreq)
+	}
+}
+
+func (r *renderer) cpuResidencies(cpus []powermetrics.CPUResidencyMetrics, detailed bool) {
+	r.printf("CPU Residencies: %d\n", len(cpus))
+	for _, cpu := range cpus {
+		r.printf("  CPU %d: Freq %.0f MHz, Active: %s%%, Idle: %s%%, Down: %s%%\n",
+			cpu.CPUID, cpu.Frequency, r.num(calculateTotalActive(cpu.ActiveResidency)), r.num(cpu.IdleResidency), r.num(cpu.DownResidency))
+		if detailed && len(cpu.ActiveResidency) > 0 {
+			r.printf("    Frequency Residency: ")
+			for freq, percent := range cpu.ActiveResidency {
+				r.printf("%.0fMHz:%s%% ", freq, r.num(percent))
+			}
+			r.printf("\n")
+		}
+	}
+}
+
+func (r *renderer) gpuResidency(g *powermetrics.GPUResidencyMetrics, detailed bool) {
+	r.printf("GPU Residency: HW Active: %s%%, Idle: %s%%, Power: %s mW\n",
+		r.num(g.HWActiveResidency), r.num(g.IdleResidency), r.scaledPower(g.PowerMilliwatts, 1000))
+	if !detailed {
+		return
+	}
+	if len(g.HWActiveFreqResidency) > 0 {
+		r.printf("  Frequency Residency: ")
+		for freq, percent := range g.HWActiveFreqResidency {
+			r.printf("%.0fMHz:%s%% ", freq, r.num(percent))
+		}
+		r.printf("\n")
+	}
+	if len(g.SWRequestedStates) > 0 {
+		r.printf("  SW Requested States: ")
+		for state, percent := range g.SWRequestedStates {
+			r.printf("%s:%s%% ", state, r.num(percent))
+		}
+		r.printf("\n")
+	}
+	if len(g.SWStates) > 0 {
+		r.printf("  SW States: ")
+		for state, percent := range g.SWStates {
+			r.printf("%s:%s%% ", state, r.num(percent))
+		}
+		r.printf("\n")
+	}
+}
+
+func (r *renderer) network(n *powermetrics.NetworkMetrics) {
+	r.printf("Network: Out %d packets/s, %d bytes/s | In %d packets/s, %d bytes/s\n",
+		int(n.OutPacketsPerSec), int(n.OutBytesPerSec),
+		int(n.InPacketsPerSec), int(n.InBytesPerSec))
+}
+
+func (r *renderer) disk(d *powermetrics.DiskMetrics) {
+	r.printf("Disk: Read %d ops/s, %d bytes/s | Write %d ops/s, %d bytes/s\n",
+		int(d.ReadOpsPerSec), int(d.ReadBytesPerSec),
+		int(d.WriteOpsPerSec), int(d.WriteBytesPerSec))
+}
+
+func (r *renderer) battery(percent float64) {
+	r.printf("Battery: %s%%\n", r.num(percent))
+}
+
+func (r *renderer) interrupts(interrupts []powermetrics.InterruptMetrics) {
+	r.printf("Interrupts: %d CPUs\n", len(interrupts))
+	for _, intr := range interrupts {
+		r.printf("  CPU %d: Total IRQs %s/s, IPI %s/s, TIMER %s/s\n",
+			intr.CPUID, r.num(intr.TotalIRQ), r.num(intr.IPI), r.num(intr.TIMER))
+	}
+}
+
+// sections records which section-only flags were passed on the command line.
+type sections struct {
+	system       bool
+	process      bool
+	cpuResidency bool
+	gpuResidency bool
+	network      bool
+	disk         bool
+	battery      bool
+	interrupts   bool
+}
+
+// all reports whether no section flag was given, i.e. every section is shown.
+func (s sections) all() bool {
+	return s == sections{}
+}
+
+// compactLine renders a terse single-line summary suitable for status bars,
+// e.g. "CPU 1.2W GPU 0.3W 45°C bat 86%". It returns an empty string when the
+// sample carries nothing for the selected sections.
+func compactLine(m powermetrics.Metrics, sel sections) string {
+	var parts []string
+
+	if (sel.all() || sel.system) && m.SystemSample != nil {
+		s := m.SystemSample
+		parts = append(parts,
+			"CPU "+strconv.FormatFloat(s.CPUPowerWatts, 'f', 1, 64)+"W",
+			"GPU "+strconv.FormatFloat(s.GPUPowerWatts, 'f', 1, 64)+"W")
+		temp := s.CPUTemperatureC
+		if s.GPUTemperatureC > temp {
+			temp = s.GPUTemperatureC
+		}
+		if temp > 0 {
+			parts = append(parts, strconv.FormatFloat(temp, 'f', 0, 64)+"°C")
+		}
+	}
+	if (sel.all() || sel.system || sel.battery) && m.SystemSample != nil && m.SystemSample.BatteryPercent > 0 {
+		parts = append(parts, "bat "+strconv.FormatFloat(m.SystemSample.BatteryPercent, 'f', 0, 64)+"%")
+	}
+	if sel.process && len(m.ProcessSamples) > 0 {
+		top := m.ProcessSamples[0]
+		for _, proc := range m.ProcessSamples[1:] {
+			if proc.CPUMsPerSec > top.CPUMsPerSec {
+				top = proc
+			}
+		}
+		parts = append(parts, fmt.Sprintf("procs %d top %s %sms/s", len(m.ProcessSamples), top.Name, strconv.FormatFloat(top.CPUMsPerSec, 'f', 0, 64)))
+	}
+	if sel.process && len(m.GPUProcessSamples) > 0 {
+		parts = append(parts, fmt.Sprintf("gpu-procs %d", len(m.GPUProcessSamples)))
+	}
+	if sel.cpuResidency && len(m.CPUResidencies) > 0 {
+		total := 0.0
+		for _, cpu := range m.CPUResidencies {
+			total += calculateTotalActive(cpu.ActiveResidency)
+		}
+		parts = append(parts, "cpu "+strconv.FormatFloat(total/float64(len(m.CPUResidencies)), 'f', 0, 64)+"%")
+	}
+	if sel.gpuResidency && m.GPUResidency != nil {
+		parts = append(parts, "gpu "+strconv.FormatFloat(m.GPUResidency.HWActiveResidency, 'f', 0, 64)+"%")
+	}
+	if sel.network && m.Network != nil {
+		parts = append(parts, fmt.Sprintf("net in %dB/s out %dB/s", int(m.Network.InBytesPerSec), int(m.Network.OutBytesPerSec)))
+	}
+	if sel.disk && m.Disk != nil {
+		parts = append(parts, fmt.Sprintf("disk r %dB/s w %dB/s", int(m.Disk.ReadBytesPerSec), int(m.Disk.WriteBytesPerSec)))
+	}
+	if sel.interrupts && len(m.Interrupts) > 0 {
+		total := 0.0
+		for _, intr := range m.Interrupts {
+			total += intr.TotalIRQ
+		}
+		parts = append(parts, "irq "+strconv.FormatFloat(total, 'f', 0, 64)+"/s")
+	}
+
+	return strings.Join(parts, " ")
+}
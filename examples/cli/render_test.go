@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BinSquare/powermetrics-go"
+)
+
+func TestRendererPrecision(t *testing.T) {
+	sample := &powermetrics.SystemSample{
+		CPUPowerWatts:   1.23456,
+		GPUPowerWatts:   0.5,
+		CPUFrequencyMHz: 2447.6,
+		GPUFrequencyMHz: 338,
+		BatteryPercent:  82.175,
+	}
+
+	tests := []struct {
+		precision int
+		expected  string
+	}{
+		{0, "CPU Power: 1 W, GPU Power: 0 W, CPU Freq: 2448 MHz, GPU Freq: 338 MHz, CPU Temp: 0°C, GPU Temp: 0°C, ANE Busy: 0%, Battery: 82%\n"},
+		{2, "CPU Power: 1.23 W, GPU Power: 0.50 W, CPU Freq: 2448 MHz, GPU Freq: 338 MHz, CPU Temp: 0.00°C, GPU Temp: 0.00°C, ANE Busy: 0.00%, Battery: 82.17%\n"},
+		{4, "CPU Power: 1.2346 W, GPU Power: 0.5000 W, CPU Freq: 2448 MHz, GPU Freq: 338 MHz, CPU Temp: 0.0000°C, GPU Temp: 0.0000°C, ANE Busy: 0.0000%, Battery: 82.1750%\n"},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		newRenderer(&buf, tt.precision, false).system(sample, false)
+		if buf.String() != tt.expected {
+			t.Errorf("precision %d:\n got %q\nwant %q", tt.precision, buf.String(), tt.expected)
+		}
+	}
+}
+
+func TestRendererNegativePrecisionFallsBack(t *testing.T) {
+	var buf bytes.Buffer
+	newRenderer(&buf, -1, false).battery(36)
+	if got, want := buf.String(), "Battery: 36.00%\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCompactLineGolden(t *testing.T) {
+	metrics := powermetrics.Metrics{
+		SystemSample: &powermetrics.SystemSample{
+			CPUPowerWatts:   1.234,
+			GPUPowerWatts:   0.28,
+			CPUTemperatureC: 45.2,
+			GPUTemperatureC: 41,
+			BatteryPercent:  86,
+		},
+		Network: &powermetrics.NetworkMetrics{InBytesPerSec: 113827.21, OutBytesPerSec: 4586.65},
+		Disk:    &powermetrics.DiskMetrics{ReadBytesPerSec: 45.67 * 1024, WriteBytesPerSec: 2070.85 * 1024},
+	}
+
+	lines := []string{
+		compactLine(metrics, sections{}),
+		compactLine(metrics, sections{battery: true}),
+		compactLine(metrics, sections{network: true, disk: true}),
+	}
+	got := strings.Join(lines, "\n") + "\n"
+
+	want, err := os.ReadFile(filepath.Join("testdata", "compact.golden"))
+	if err != nil {
+		t.Fatalf("read golden file: %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("compact output mismatch:\n got %q\nwant %q", got, string(want))
+	}
+
+	if line := compactLine(powermetrics.Metrics{}, sections{}); line != "" {
+		t.Errorf("expected empty line for empty metrics, got %q", line)
+	}
+}
+
+func TestRendererColor(t *testing.T) {
+	sample := &powermetrics.SystemSample{
+		CPUPowerWatts:   12.5,
+		GPUPowerWatts:   0.02,
+		CPUTemperatureC: 92,
+	}
+
+	var colored bytes.Buffer
+	newRenderer(&colored, 2, true).system(sample, false)
+	out := colored.String()
+	if !strings.Contains(out, ansiRed+"12.50"+ansiReset) {
+		t.Errorf("expected high CPU power in red, got %q", out)
+	}
+	if !strings.Contains(out, ansiGreen+"0.02"+ansiReset) {
+		t.Errorf("expected idle GPU power in green, got %q", out)
+	}
+	if !strings.Contains(out, ansiRed+"92.00"+ansiReset) {
+		t.Errorf("expected hot CPU temperature in red, got %q", out)
+	}
+
+	var plain bytes.Buffer
+	newRenderer(&plain, 2, false).system(sample, false)
+	if strings.Contains(plain.String(), "\x1b[") {
+		t.Errorf("expected no ANSI escapes with color off, got %q", plain.String())
+	}
+}
+
+func TestUseColor(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if on, _ := useColor("auto", f); on {
+		t.Errorf("expected auto mode to disable color for a regular file")
+	}
+	if on, _ := useColor("always", f); !on {
+		t.Errorf("expected always mode to enable color")
+	}
+	if _, err := useColor("sometimes", f); err == nil {
+		t.Errorf("expected error for invalid mode")
+	}
+}
+
+func TestRendererProcessEnergyImpact(t *testing.T) {
+	// The column decides, so a reported 0.0 is shown and a missing column
+	// is not.
+	parse := func(header, row string) powermetrics.ProcessSample {
+		parser := powermetrics.NewParser(powermetrics.Config{})
+		var last *powermetrics.Metrics
+		for _, line := range []string{"*** Running tasks ***", header, row, ""} {
+			metrics, err := parser.ParseLine(line)
+			if err != nil {
+				t.Fatalf("ParseLine(%q): %v", line, err)
+			}
+			if metrics != nil {
+				last = metrics
+			}
+		}
+		if last == nil || len(last.ProcessSamples) != 1 {
+			t.Fatalf("expected one process sample, got %+v", last)
+		}
+		return last.ProcessSamples[0]
+	}
+	plain := parse("Name  ID  CPU ms/s  User%  Deadlines (<2 ms, 2-5 ms)  Wakeups (Intr, Pkg idle)",
+		"launchd 1 2.00 0.00 0.00 0.00 0.00 0.00")
+	energy := parse("Name  ID  CPU ms/s  User%  Deadlines (<2 ms, 2-5 ms)  Wakeups (Intr, Pkg idle)  Energy Impact",
+		"Safari 812 25.10 0.00 0.00 0.00 0.00 0.00 0.00")
+
+	var buf bytes.Buffer
+	newRenderer(&buf, 1, false).processes([]powermetrics.ProcessSample{plain, energy})
+	want := "Processes: 2\n" +
+		"  PID: 1, Name: launchd, CPU: 2.0 ms/s, User: 0.0%, Deadlines <2ms: 0.0, 2-5ms: 0.0, Wakeups Intr: 0.0, Pkg Idle: 0.0\n" +
+		"  PID: 812, Name: Safari, CPU: 25.1 ms/s, User: 0.0%, Deadlines <2ms: 0.0, 2-5ms: 0.0, Wakeups Intr: 0.0, Pkg Idle: 0.0, Energy Impact: 0.0\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/BinSquare/powermetrics-go"
+)
+
+// replayFile wraps a saved log, transparently decompressing gzip input.
+type replayFile struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *replayFile) Close() error {
+	var first error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if err := r.closers[i].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// openReplay opens a saved powermetrics log for -replay. Gzipped files are
+// detected by their magic bytes, so the extension does not matter.
+func openReplay(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	buffered := bufio.NewReader(file)
+	magic, _ := buffered.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("open gzip replay %s: %w", path, err)
+		}
+		return &replayFile{Reader: gz, closers: []io.Closer{file, gz}}, nil
+	}
+	return &replayFile{Reader: buffered, closers: []io.Closer{file}}, nil
+}
+
+// pace forwards metrics, sleeping between samples for as long as the
+// recorded timestamps say elapsed, so -realtime replays at capture speed.
+func pace(in <-chan powermetrics.Metrics, sleep func(time.Duration)) <-chan powermetrics.Metrics {
+	out := make(chan powermetrics.Metrics)
+	go func() {
+		defer close(out)
+		var last time.Time
+		for metrics := range in {
+			if ts := metrics.Timestamp; !ts.IsZero() {
+				if !last.IsZero() && ts.After(last) {
+					sleep(ts.Sub(last))
+				}
+				last = ts
+			}
+			out <- metrics
+		}
+	}()
+	return out
+}
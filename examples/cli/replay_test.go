@@ -0,0 +1,86 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BinSquare/powermetrics-go"
+)
+
+func TestOpenReplayDetectsGzip(t *testing.T) {
+	plain, err := os.ReadFile("testdata/capture.txt")
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+
+	gzPath := filepath.Join(t.TempDir(), "capture.log")
+	file, err := os.Create(gzPath)
+	if err != nil {
+		t.Fatalf("create gzip fixture: %v", err)
+	}
+	gz := gzip.NewWriter(file)
+	if _, err := gz.Write(plain); err != nil {
+		t.Fatalf("write gzip fixture: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("close gzip writer: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("close gzip fixture: %v", err)
+	}
+
+	for _, path := range []string{"testdata/capture.txt", gzPath} {
+		replay, err := openReplay(path)
+		if err != nil {
+			t.Fatalf("openReplay(%s) error = %v", path, err)
+		}
+		got, err := io.ReadAll(replay)
+		replay.Close()
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		if string(got) != string(plain) {
+			t.Errorf("%s: replayed content differs from the capture", path)
+		}
+	}
+
+	if _, err := openReplay(filepath.Join(t.TempDir(), "missing.log")); err == nil {
+		t.Errorf("expected error for missing replay file")
+	}
+}
+
+func TestReplayFlag(t *testing.T) {
+	out := runCLI(t, nil, "-replay", "testdata/capture.txt", "-system")
+	if !strings.Contains(out, "CPU Power:") {
+		t.Errorf("expected system output from replay, got %q", out)
+	}
+}
+
+func TestPaceHonorsRecordedTiming(t *testing.T) {
+	base := time.Date(2025, time.November, 8, 15, 54, 21, 0, time.UTC)
+	in := make(chan powermetrics.Metrics, 4)
+	in <- powermetrics.Metrics{Timestamp: base}
+	in <- powermetrics.Metrics{Timestamp: base}
+	in <- powermetrics.Metrics{Timestamp: base.Add(5 * time.Second)}
+	in <- powermetrics.Metrics{Timestamp: base.Add(7 * time.Second)}
+	close(in)
+
+	var slept []time.Duration
+	count := 0
+	for range pace(in, func(d time.Duration) { slept = append(slept, d) }) {
+		count++
+	}
+
+	if count != 4 {
+		t.Errorf("expected all 4 metrics forwarded, got %d", count)
+	}
+	if want := []time.Duration{5 * time.Second, 2 * time.Second}; !reflect.DeepEqual(slept, want) {
+		t.Errorf("slept %v, want %v", slept, want)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// namedTimeLayouts are the -timefmt values accepted in place of a Go layout.
+var namedTimeLayouts = map[string]string{
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"rfc1123":     time.RFC1123,
+	"datetime":    time.DateTime,
+	"kitchen":     time.Kitchen,
+	"stamp":       time.Stamp,
+}
+
+// timeFormatter renders sample timestamps according to the -tz and -timefmt
+// flags.
+type timeFormatter struct {
+	location *time.Location
+	layout   string
+}
+
+// newTimeFormatter resolves tz ("local", "UTC" or an IANA name such as
+// "Asia/Tokyo"; empty means local) and layout (a name from
+// namedTimeLayouts, case-insensitive, or a Go reference-time layout; empty
+// means RFC3339).
+func newTimeFormatter(tz, layout string) (*timeFormatter, error) {
+	location := time.Local
+	if tz != "" && !strings.EqualFold(tz, "local") {
+		loaded, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -tz value %q: %w", tz, err)
+		}
+		location = loaded
+	}
+
+	if layout == "" {
+		layout = time.RFC3339
+	} else if named, ok := namedTimeLayouts[strings.ToLower(layout)]; ok {
+		layout = named
+	}
+	return &timeFormatter{location: location, layout: layout}, nil
+}
+
+// format renders t, or returns "" for the zero time of input without
+// sample headers.
+func (f *timeFormatter) format(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(f.location).Format(f.layout)
+}
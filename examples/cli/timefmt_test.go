@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeFormatterZones(t *testing.T) {
+	sample := time.Date(2025, time.November, 8, 6, 54, 21, 0, time.UTC)
+
+	tests := []struct {
+		tz, layout string
+		expected   string
+	}{
+		{"UTC", "", "2025-11-08T06:54:21Z"},
+		{"Asia/Tokyo", "RFC3339", "2025-11-08T15:54:21+09:00"},
+		{"America/New_York", "datetime", "2025-11-08 01:54:21"},
+		{"Asia/Tokyo", "15:04 MST", "15:54 JST"},
+	}
+	for _, tt := range tests {
+		f, err := newTimeFormatter(tt.tz, tt.layout)
+		if err != nil {
+			t.Fatalf("newTimeFormatter(%q, %q): %v", tt.tz, tt.layout, err)
+		}
+		if got := f.format(sample); got != tt.expected {
+			t.Errorf("tz %q layout %q: got %q, want %q", tt.tz, tt.layout, got, tt.expected)
+		}
+	}
+
+	f, err := newTimeFormatter("", "")
+	if err != nil {
+		t.Fatalf("default formatter: %v", err)
+	}
+	if got, want := f.format(sample), sample.In(time.Local).Format(time.RFC3339); got != want {
+		t.Errorf("default: got %q, want %q", got, want)
+	}
+	if got := f.format(time.Time{}); got != "" {
+		t.Errorf("expected no timestamp for the zero time, got %q", got)
+	}
+	if _, err := newTimeFormatter("Not/A_Zone", ""); err == nil {
+		t.Errorf("expected an error for an unknown time zone")
+	}
+}
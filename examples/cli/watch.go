@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/BinSquare/powermetrics-go"
+	"golang.org/x/term"
+)
+
+const (
+	ansiHome        = "\x1b[H"
+	ansiClearScreen = "\x1b[2J"
+	ansiClearLine   = "\x1b[K"
+	ansiClearBelow  = "\x1b[J"
+
+	defaultWatchWidth = 80
+	watchTopProcesses = 5
+)
+
+var sparkTicks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline renders values as a row of block characters scaled between the
+// smallest and largest value in the series.
+func sparkline(values []float64) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	lo, hi := values[0], values[0]
+	for _, v := range values[1:] {
+		if v < lo {
+			lo = v
+		}
+		if v > hi {
+			hi = v
+		}
+	}
+
+	var b strings.Builder
+	for _, v := range values {
+		idx := 0
+		if hi > lo {
+			idx = int((v - lo) / (hi - lo) * float64(len(sparkTicks)-1))
+		}
+		b.WriteRune(sparkTicks[idx])
+	}
+	return b.String()
+}
+
+// dashboard keeps the state redrawn in place by -watch.
+type dashboard struct {
+	out     *renderer
+	width   int
+	history []float64
+	// sampled is the Timestamp of the sample history last grew for.
+	sampled  time.Time
+	system   *powermetrics.SystemSample
+	topProcs []powermetrics.ProcessSample
+	redraw   bool
+}
+
+func newDashboard(out *renderer) *dashboard {
+	return &dashboard{out: out, width: terminalWidth(), redraw: true}
+}
+
+// terminalWidth asks the terminal on stdout for its current width, so a
+// resize is picked up on SIGWINCH. When stdout is not a terminal it falls
+// back to $COLUMNS, which shells rarely export to child processes, and then
+// to defaultWatchWidth.
+func terminalWidth() int {
+	if cols, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && cols > 0 {
+		return cols
+	}
+	if cols, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && cols > 0 {
+		return cols
+	}
+	return defaultWatchWidth
+}
+
+func (d *dashboard) historyLimit() int {
+	// Leave room for the "CPU history " label.
+	if limit := d.width - 12; limit > 0 {
+		return limit
+	}
+	return 1
+}
+
+func (d *dashboard) update(m powermetrics.Metrics) {
+	if m.SystemSample != nil {
+		d.system = m.SystemSample
+		// Snapshots of one sample share its Timestamp: refresh the last
+		// point instead of adding one per snapshot.
+		if len(d.history) > 0 && m.Timestamp.Equal(d.sampled) {
+			d.history[len(d.history)-1] = m.SystemSample.CPUPowerWatts
+		} else {
+			d.sampled = m.Timestamp
+			d.history = append(d.history, m.SystemSample.CPUPowerWatts)
+			if over := len(d.history) - d.historyLimit(); over > 0 {
+				d.history = append(d.history[:0], d.history[over:]...)
+			}
+		}
+	}
+	if len(m.ProcessSamples) > 0 {
+		procs := append([]powermetrics.ProcessSample(nil), m.ProcessSamples...)
+		sort.SliceStable(procs, func(i, j int) bool {
+			return procs[i].CPUMsPerSec > procs[j].CPUMsPerSec
+		})
+		if len(procs) > watchTopProcesses {
+			procs = procs[:watchTopProcesses]
+		}
+		d.topProcs = procs
+	}
+}
+
+// resize refreshes the width and forces a full clear on the next draw.
+func (d *dashboard) resize() {
+	d.width = terminalWidth()
+	if over := len(d.history) - d.historyLimit(); over > 0 {
+		d.history = append(d.history[:0], d.history[over:]...)
+	}
+	d.redraw = true
+}
+
+func (d *dashboard) draw(w io.Writer) {
+	var b strings.Builder
+	b.WriteString(ansiHome)
+	if d.redraw {
+		b.WriteString(ansiClearScreen)
+		d.redraw = false
+	}
+
+	line := func(format string, args ...interface{}) {
+		b.WriteString(fmt.Sprintf(format, args...))
+		b.WriteString(ansiClearLine + "\n")
+	}
+
+	line("powermetrics-go  %s", time.Now().Format("15:04:05"))
+	if s := d.system; s != nil {
+		line("CPU %s W  GPU %s W  ANE %s W  Battery %s%%",
+			d.out.power(s.CPUPowerWatts), d.out.power(s.GPUPowerWatts), d.out.power(s.ANEPowerWatts), d.out.num(s.BatteryPercent))
+	} else {
+		line("Waiting for system metrics...")
+	}
+	line("CPU history %s", sparkline(d.history))
+	line("")
+	line("Top processes (CPU ms/s)")
+	for _, proc := range d.topProcs {
+		line("  %-24.24s %7d %10s", proc.Name, proc.PID, d.out.num(proc.CPUMsPerSec))
+	}
+	b.WriteString(ansiClearBelow)
+
+	io.WriteString(w, b.String())
+}
+
+// runWatch folds metrics into the dashboard and redraws it once per interval
+// (or immediately after a terminal resize) until the channel closes.
+func runWatch(metricsChan <-chan powermetrics.Metrics, out *renderer, interval time.Duration) {
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	d := newDashboard(out)
+	d.draw(out.w)
+	for {
+		select {
+		case metrics, ok := <-metricsChan:
+			if !ok {
+				return
+			}
+			d.update(metrics)
+		case <-winch:
+			d.resize()
+			d.draw(out.w)
+		case <-ticker.C:
+			d.draw(out.w)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BinSquare/powermetrics-go"
+)
+
+func TestSparkline(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   string
+	}{
+		{"empty", nil, ""},
+		{"flat", []float64{2, 2, 2}, "▁▁▁"},
+		{"ramp", []float64{0, 1, 2, 3, 4, 5, 6, 7}, "▁▂▃▄▅▆▇█"},
+		{"spike", []float64{1, 1, 8, 1}, "▁▁█▁"},
+	}
+
+	for _, tt := range tests {
+		if got := sparkline(tt.values); got != tt.want {
+			t.Errorf("%s: sparkline(%v) = %q, want %q", tt.name, tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestDashboardHistoryBoundedByWidth(t *testing.T) {
+	t.Setenv("COLUMNS", "15")
+	d := newDashboard(newRenderer(&bytes.Buffer{}, 2, false))
+
+	start := time.Date(2025, time.November, 8, 15, 54, 21, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		d.update(powermetrics.Metrics{Timestamp: start.Add(time.Duration(i) * time.Second), SystemSample: &powermetrics.SystemSample{CPUPowerWatts: float64(i)}})
+	}
+	if len(d.history) != 3 {
+		t.Fatalf("expected history trimmed to 3 entries, got %d", len(d.history))
+	}
+	if d.history[0] != 7 {
+		t.Errorf("expected oldest retained value 7, got %v", d.history[0])
+	}
+
+	// Further snapshots of the last sample refresh its point.
+	d.update(powermetrics.Metrics{Timestamp: start.Add(9 * time.Second), SystemSample: &powermetrics.SystemSample{CPUPowerWatts: 20}})
+	if len(d.history) != 3 || d.history[2] != 20 {
+		t.Errorf("expected a repeated Timestamp to replace the last point, got %v", d.history)
+	}
+
+	var buf bytes.Buffer
+	d.draw(&buf)
+	if !strings.HasPrefix(buf.String(), ansiHome+ansiClearScreen) {
+		t.Errorf("expected first draw to clear the screen, got %q", buf.String())
+	}
+}
@@ -0,0 +1,160 @@
+package powermetrics
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// lineClass is a bit set of the handlers a line may be relevant to. It is
+// computed once per line by classifyLine so parseLine can skip handlers whose
+// regular expressions and keyword chains cannot match; each bit is a
+// necessary condition of its handler, never a sufficient one, so the handlers
+// still decide for themselves.
+type lineClass uint16
+
+const (
+	// classSection: a "*** ... ***" section or sample header.
+	classSection lineClass = 1 << iota
+	// classProcess: at least eight fields, as a tasks table row has.
+	classProcess
+	// classGPUProcess: a "pid N ..." GPU process line.
+	classGPUProcess
+	// classCluster: mentions a "-Cluster".
+	classCluster
+	// classCPU: mentions "CPU " (per-CPU residency, frequency and
+	// interrupt block lines).
+	classCPU
+	// classInterrupt: an interrupt detail line.
+	classInterrupt
+	// classNetwork and classDisk: rates of the network and disk samplers.
+	classNetwork
+	classDisk
+	// classGPU: mentions the GPU in any case.
+	classGPU
+	// classBattery: battery, backlight and power source lines.
+	classBattery
+	// classThermal: the thermal pressure line.
+	classThermal
+	// classSystem: carries a keyword of the SystemSample heuristics.
+	classSystem
+)
+
+// systemKeywords are the lowercase keywords at least one of which every
+// parseSystemMetrics branch requires.
+var systemKeywords = []string{
+	"power", "energy", "joules", "frequency", "busy", "residency", "wakeups", "dram", "temp",
+}
+
+// acPowerPrefixes are the lowercase prefixes acPowerRegex accepts.
+var acPowerPrefixes = []string{"ac ", "external ", "power source"}
+
+// classAll is returned for lines the byte-wise checks below cannot rule on.
+const classAll lineClass = 1<<16 - 1
+
+// classifyLine returns the handlers line may be relevant to. It does not
+// allocate. Lines with non-ASCII bytes get classAll, since Unicode case
+// folding and whitespace are beyond the byte-wise checks.
+func classifyLine(line string) lineClass {
+	for i := 0; i < len(line); i++ {
+		if line[i] >= utf8.RuneSelf {
+			return classAll
+		}
+	}
+
+	var class lineClass
+	if strings.Contains(line, "***") {
+		class |= classSection
+	}
+	if strings.HasPrefix(line, "pid") {
+		class |= classGPUProcess
+	}
+	if countFields(line, 8) >= 8 {
+		class |= classProcess
+	}
+	if strings.Contains(line, "-Cluster") {
+		class |= classCluster
+	}
+	if strings.Contains(line, "CPU ") {
+		class |= classCPU
+	}
+	if strings.Contains(line, "Total IRQ") || strings.Contains(line, "|-> ") {
+		class |= classInterrupt
+	}
+	if strings.Contains(line, "packets/s") {
+		class |= classNetwork
+	}
+	if strings.Contains(line, "ops/s") {
+		class |= classDisk
+	}
+	if containsFold(line, "gpu") {
+		class |= classGPU
+	}
+	if strings.Contains(line, "Battery") || strings.Contains(line, "Backlight") {
+		class |= classBattery
+	} else {
+		for _, prefix := range acPowerPrefixes {
+			if hasPrefixFold(line, prefix) {
+				class |= classBattery
+				break
+			}
+		}
+	}
+	if strings.Contains(line, "pressure level") {
+		class |= classThermal
+	}
+	for _, keyword := range systemKeywords {
+		if containsFold(line, keyword) {
+			class |= classSystem
+			break
+		}
+	}
+	return class
+}
+
+// countFields counts the whitespace-separated fields of the ASCII string s
+// like strings.Fields, stopping once it reaches limit.
+func countFields(s string, limit int) int {
+	n := 0
+	inField := false
+	for i := 0; i < len(s) && n < limit; i++ {
+		switch s[i] {
+		case ' ', '\t', '\n', '\v', '\f', '\r':
+			inField = false
+		default:
+			if !inField {
+				n++
+				inField = true
+			}
+		}
+	}
+	return n
+}
+
+// containsFold reports whether s contains the lowercase ASCII substr,
+// ignoring the case of s.
+func containsFold(s, substr string) bool {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if hasPrefixFold(s[i:], substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPrefixFold reports whether s starts with the lowercase ASCII prefix,
+// ignoring the case of s.
+func hasPrefixFold(s, prefix string) bool {
+	if len(s) < len(prefix) {
+		return false
+	}
+	for i := 0; i < len(prefix); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if c != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,6 +1,8 @@
 package powermetrics
 
 import (
+	"fmt"
+	"math"
 	"regexp"
 	"sort"
 	"strconv"
@@ -8,38 +10,98 @@ import (
 	"time"
 )
 
+// deadTasksName is the tasks table row aggregating exited tasks.
+const deadTasksName = "DEAD_TASKS"
+
+// sampleTimeLayout matches the header timestamp, e.g. "Sat Nov  8 15:54:21 2025 +0900".
+const sampleTimeLayout = "Mon Jan _2 15:04:05 2006 -0700"
+
 var (
 	procLineRegex                 = regexp.MustCompile(`^pid\s+(\d+)\s+(.+?)\s+([0-9]+(?:\.[0-9]+)?)\s*(us|ms|s)(?:\s+\(([0-9]+(?:\.[0-9]+)?)\s*%\))?(?:\s+.*)?$`)
 	numberExtractor               = regexp.MustCompile(`([0-9]+(?:\.[0-9]+)?)`)
 	clusterOnlineRegex            = regexp.MustCompile(`([A-Z0-9-]+)-Cluster Online: ([\d.]+)%`)
 	clusterHWFreqRegex            = regexp.MustCompile(`([A-Z0-9-]+)-Cluster HW active frequency: ([\d.]+) MHz`)
-	cpuFreqResidencyRegex         = regexp.MustCompile(`(\d+) MHz: +([\d.]+)%`)
+	clusterIdleResidencyRegex     = regexp.MustCompile(`([A-Z0-9-]+)-Cluster idle residency: +([\d.]+)%`)
+	clusterDownResidencyRegex     = regexp.MustCompile(`([A-Z0-9-]+)-Cluster down residency: +([\d.]+)%`)
+	clusterPowerRegex             = regexp.MustCompile(`([A-Z0-9-]+)-Cluster Power: ([\d.]+) (mW|W)`)
+	cpuFreqResidencyRegex         = regexp.MustCompile(`(\d+(?:\.\d+)?) *(MHz|GHz): +([\d.]+)%`)
 	cpuFrequencyLineRegex         = regexp.MustCompile(`CPU (\d+) frequency: ([\d.]+) MHz`)
 	cpuSpecificActiveRegex        = regexp.MustCompile(`CPU (\d+) active residency: +([\d.]+)%`)
 	cpuSpecificIdleRegex          = regexp.MustCompile(`CPU (\d+) idle residency: +([\d.]+)%`)
 	cpuSpecificDownRegex          = regexp.MustCompile(`CPU (\d+) down residency: +([\d.]+)%`)
 	clusterFreqResidencyRegex     = regexp.MustCompile(`(\d+) MHz: +([\d.]+)%`)
-	clusterHWActiveResidencyRegex = regexp.MustCompile(`HW active residency: +([\d.]+)%`)
+	clusterHWActiveResidencyRegex = regexp.MustCompile(`([A-Z0-9-]+)-Cluster HW active residency: +([\d.]+)%`)
 	cpuActiveResidencyRegex       = regexp.MustCompile(`active residency: +([\d.]+)%`)
 	cpuIdleResidencyRegex         = regexp.MustCompile(`idle residency: +([\d.]+)%`)
 	cpuDownResidencyRegex         = regexp.MustCompile(`down residency: +([\d.]+)%`)
 	batteryRegex                  = regexp.MustCompile(`Battery: percent_charge: ([\d.]+)`)
+	dramBandwidthRegex            = regexp.MustCompile(`(?i)DRAM (read|write)(?: bandwidth| BW)?: +([\d.]+) *(GB/s|MB/s)`)
+	packagePowerRegex             = regexp.MustCompile(`(?i)package power(?: \([^)]*\))?: +([\d.]+) *(mW|W)$`)
+	energyCounterRegex            = regexp.MustCompile(`(?i)^(package|cpu|ia|gpu|gt) (?:energy|joules)(?: \(joules\))?: +([\d.]+) *(?:j|joules)?$`)
+	systemWakeupsRegex            = regexp.MustCompile(`^(?:Total|Interrupt) wakeups: +([\d.]+)`)
+	acPowerRegex                  = regexp.MustCompile(`(?i)^(?:AC (?:power|attached|connected|adapter)|external (?:power|connected)|power source):\s*(.+?)\s*$`)
+	backlightRegex                = regexp.MustCompile(`Backlight level: ([\d.]+)\s*(?:(%)|\(range (\d+)-(\d+)\))?`)
 	networkRegex                  = regexp.MustCompile(`out: ([\d.]+) packets/s, ([\d.]+) bytes/s`)
 	networkInRegex                = regexp.MustCompile(`in: +([\d.]+) packets/s, ([\d.]+) bytes/s`)
 	diskReadRegex                 = regexp.MustCompile(`read: ([\d.]+) ops/s ([\d.]+) KBytes/s`)
 	diskWriteRegex                = regexp.MustCompile(`write: ([\d.]+) ops/s ([\d.]+) KBytes/s`)
-	interruptRegex                = regexp.MustCompile(`CPU (\d+):`)
-	interruptTotalRegex           = regexp.MustCompile(`Total IRQ: ([\d.]+) interrupts/sec`)
-	interruptIPITimerRegex        = regexp.MustCompile(`\|-> (IPI|TIMER): ([\d.]+) interrupts/sec`)
-	gpuFreqRegex                  = regexp.MustCompile(`GPU HW active frequency: ([\d.]+) MHz`)
+	interruptRegex                = regexp.MustCompile(`^\s*CPU (\d+):`)
+	interruptTotalRegex           = regexp.MustCompile(`Total IRQ: +([\d.]+)\s*(?:(?:interrupts|ints|irqs)(?:/s|/sec)?)?$`)
+	interruptIPITimerRegex        = regexp.MustCompile(`\|-> (IPI|TIMER): +([\d.]+)\s*(?:(?:interrupts|ints|irqs)(?:/s|/sec)?)?$`)
+	gpuFreqRegex                  = regexp.MustCompile(`(?i)\bGPU (?:HW )?(?:active )?frequency: +([\d.]+) *MHz`)
 	gpuHwActiveResidencyRegex     = regexp.MustCompile(`GPU HW active residency: +([\d.]+)%`)
 	gpuIdleResidencyRegex         = regexp.MustCompile(`GPU idle residency: +([\d.]+)%`)
-	gpuSWStateRegex               = regexp.MustCompile(`GPU SW (?:requested state|state): \(([^)]+)\)`)
+	gpuSWStateRegex               = regexp.MustCompile(`GPU SW (?:requested state|state):\s*\(([^)]+)\)`)
+	gpuCStateRegex                = regexp.MustCompile(`GPU C-state(?: residency)?:\s*\(([^)]+)\)`)
 	gpuStateValueRegex            = regexp.MustCompile(`([A-Za-z0-9_]+)\s*:\s*([\d.]+)%`)
+	thermalPressureRegex          = regexp.MustCompile(`Current pressure level: (\S+)`)
+	sampleHeaderRegex             = regexp.MustCompile(`\*\*\* Sampled system activity \((.+?)\) \(([\d.]+)\s*ms elapsed\) \*\*\*`)
+	decimalCommaRegex             = regexp.MustCompile(`(\d),(\d)`)
 )
 
+// preamblePrefixes start the lines powermetrics prints once before the first
+// sample (machine and OS details, warm-up notices). They carry no metrics but
+// can contain words such as "cpu" or "power" that would otherwise be picked
+// up by the system metric heuristics.
+var preamblePrefixes = []string{
+	"Machine model:",
+	"SMC version:",
+	"EFI version:",
+	"OS version:",
+	"Boot arguments:",
+	"Boot time:",
+	"Sampling...",
+}
+
+func isPreambleLine(line string) bool {
+	for _, prefix := range preamblePrefixes {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 // ParseLine parses a single line of powermetrics output and returns the derived metrics.
 func (p *Parser) ParseLine(line string) (*Metrics, error) {
+	metrics, err := p.parseLine(line)
+	return p.finishMetrics(metrics), err
+}
+
+// finishMetrics records the sections metrics carries and stamps the host
+// and power unit before the metrics are handed to the caller.
+func (p *Parser) finishMetrics(metrics *Metrics) *Metrics {
+	if metrics == nil {
+		return nil
+	}
+	p.observe(metrics)
+	metrics.Host = p.config.HostLabel
+	metrics.PowerUnit = p.config.PowerUnit
+	return metrics
+}
+
+func (p *Parser) parseLine(line string) (*Metrics, error) {
+	p.emitTrigger = 0
 	trimmed := strings.TrimSpace(line)
 	if trimmed == "" {
 		if metrics := p.flushProcessSamples(); metrics != nil {
@@ -50,76 +112,153 @@ func (p *Parser) ParseLine(line string) (*Metrics, error) {
 	if strings.HasPrefix(trimmed, "--") {
 		return nil, nil
 	}
+	if isPreambleLine(trimmed) {
+		return nil, nil
+	}
 
 	line = trimmed
+	if p.config.DecimalComma {
+		line = normalizeDecimalCommas(line)
+	}
+
+	// Route the line by a cheap classification first so it only reaches the
+	// handlers that could match it.
+	class := classifyLine(line)
 
 	// Handle sections
-	if strings.Contains(line, "*** Running tasks ***") {
-		// reset any existing process accumulation
-		p.processSamples = nil
-		return nil, nil
-	} else if strings.Contains(line, "**** Processor usage ****") {
-		if metrics := p.flushProcessSamples(); metrics != nil {
-			return metrics, nil
+	if class&classSection != 0 {
+		if p.cpuBusyPending {
+			p.finishCPUBusy()
 		}
-		return nil, nil
-	} else if strings.Contains(line, "**** Network activity ****") {
-		if metrics := p.flushProcessSamples(); metrics != nil {
-			return metrics, nil
+		if p.updateSampleHeader(line) {
+			return nil, nil
+		} else if strings.Contains(line, "*** Running tasks ***") {
+			// reset any existing process accumulation
+			p.processSamples = nil
+			p.deadTasks = nil
+			return nil, nil
+		} else if strings.Contains(line, "**** Processor usage ****") {
+			if metrics := p.flushProcessSamples(); metrics != nil {
+				return metrics, nil
+			}
+			return nil, nil
+		} else if strings.Contains(line, "**** Network activity ****") {
+			if metrics := p.flushProcessSamples(); metrics != nil {
+				return metrics, nil
+			}
+			return nil, nil
+		} else if strings.Contains(line, "**** Disk activity ****") {
+			if metrics := p.flushProcessSamples(); metrics != nil {
+				return metrics, nil
+			}
+			return nil, nil
+		} else if strings.Contains(line, "****  Interrupt distribution ****") {
+			if metrics := p.flushProcessSamples(); metrics != nil {
+				return metrics, nil
+			}
+			return nil, nil
+		} else if strings.Contains(line, "**** GPU usage ****") {
+			if metrics := p.flushProcessSamples(); metrics != nil {
+				return metrics, nil
+			}
+			return nil, nil
+		} else if strings.Contains(line, "**** Battery and backlight usage ****") {
+			p.batteries = nil
+			if metrics := p.flushProcessSamples(); metrics != nil {
+				return metrics, nil
+			}
+			return nil, nil
 		}
+	}
+
+	if class&classProcess != 0 && p.parseProcessLine(line) {
 		return nil, nil
-	} else if strings.Contains(line, "**** Disk activity ****") {
-		if metrics := p.flushProcessSamples(); metrics != nil {
-			return metrics, nil
+	}
+
+	// Snapshot existing values prior to update to detect true changes
+	var systemChanged, networkChanged, diskChanged, clusterChanged bool
+	var cpuResidencyChanged, clusterResidencyChanged, gpuResidencyChanged bool
+	if class&classCluster != 0 {
+		clusterChanged = p.updateClusterInfo(line)
+	}
+	if class&(classCPU|classCluster) != 0 {
+		cpuResidencyChanged, clusterResidencyChanged = p.updateCPUInfo(line)
+	}
+	if p.cpuBusyPending && !clusterChanged && !cpuResidencyChanged && !clusterResidencyChanged {
+		p.finishCPUBusy()
+	}
+	// The network and disk values are compared by value so lines that
+	// change nothing do not allocate.
+	if class&classNetwork != 0 {
+		hadNetwork := p.networkInfo != nil
+		var prevNetwork NetworkMetrics
+		if hadNetwork {
+			prevNetwork = *p.networkInfo
 		}
-		return nil, nil
-	} else if strings.Contains(line, "****  Interrupt distribution ****") {
-		if metrics := p.flushProcessSamples(); metrics != nil {
-			return metrics, nil
+		p.updateNetworkInfo(line)
+		networkChanged = p.networkInfo != nil && (!hadNetwork || *p.networkInfo != prevNetwork)
+	}
+	if class&classDisk != 0 {
+		hadDisk := p.diskInfo != nil
+		var prevDisk DiskMetrics
+		if hadDisk {
+			prevDisk = *p.diskInfo
 		}
-		return nil, nil
-	} else if strings.Contains(line, "**** GPU usage ****") {
-		if metrics := p.flushProcessSamples(); metrics != nil {
-			return metrics, nil
+		p.updateDiskInfo(line)
+		diskChanged = p.diskInfo != nil && (!hadDisk || *p.diskInfo != prevDisk)
+	}
+	if class&(classCPU|classInterrupt) != 0 && p.updateInterruptInfo(line) {
+		p.emitTrigger |= sectionBit(SectionInterrupts)
+	}
+	if class&(classGPU|classBattery|classThermal) != 0 {
+		prevSystem := p.system
+		if class&classGPU != 0 {
+			gpuResidencyChanged = p.updateGPUResidencyInfo(line)
 		}
-		return nil, nil
-	} else if strings.Contains(line, "**** Battery and backlight usage ****") {
-		if metrics := p.flushProcessSamples(); metrics != nil {
-			return metrics, nil
+		var batteryAdded bool
+		if class&classBattery != 0 {
+			prevBattery := p.system
+			batteryAdded = p.updateBatteryInfo(line)
+			if batteryAdded || p.system != prevBattery {
+				p.emitTrigger |= sectionBit(SectionBattery)
+			}
 		}
-		return nil, nil
+		if class&classThermal != 0 {
+			p.updateThermalPressure(line)
+		}
+		systemChanged = p.system != prevSystem || batteryAdded
 	}
 
-	if p.parseProcessLine(line) {
-		return nil, nil
+	if class&classGPUProcess != 0 {
+		if matched, err := p.parseGPUProcessLine(line); err != nil {
+			return nil, err
+		} else if matched {
+			return nil, nil
+		}
 	}
 
-	// Snapshot existing values prior to update to detect true changes
-	prevNetworkInfo := cloneNetworkMetrics(p.networkInfo)
-	prevDiskInfo := cloneDiskMetrics(p.diskInfo)
-	prevSystem := p.system
-
-	clusterChanged := p.updateClusterInfo(line)
-	cpuResidencyChanged, clusterResidencyChanged := p.updateCPUInfo(line)
-	p.updateNetworkInfo(line)
-	p.updateDiskInfo(line)
-	p.updateInterruptInfo(line)
-	gpuResidencyChanged := p.updateGPUResidencyInfo(line)
-	p.updateBatteryInfo(line)
-
-	// Check if any values changed or new values were added to decide whether to return metrics
-	systemChanged := p.system != prevSystem
-	networkChanged := !networkMetricsEqual(prevNetworkInfo, p.networkInfo)
-	diskChanged := !diskMetricsEqual(prevDiskInfo, p.diskInfo)
-
-	if metrics, err := p.parseGPUProcessLine(line); err != nil {
-		return nil, err
-	} else if metrics != nil {
-		return metrics, nil
+	var systemMetrics *Metrics
+	if class&classSystem != 0 {
+		systemMetrics = p.parseSystemMetrics(line, strings.ToLower(line))
+	}
+	if systemChanged || systemMetrics != nil {
+		p.emitTrigger |= sectionBit(SectionSystem)
+	}
+	if networkChanged {
+		p.emitTrigger |= sectionBit(SectionNetwork)
+	}
+	if diskChanged {
+		p.emitTrigger |= sectionBit(SectionDisk)
+	}
+	if clusterChanged || clusterResidencyChanged {
+		p.emitTrigger |= sectionBit(SectionClusters)
+	}
+	if cpuResidencyChanged {
+		p.emitTrigger |= sectionBit(SectionCPUResidency)
+	}
+	if gpuResidencyChanged {
+		p.emitTrigger |= sectionBit(SectionGPU)
 	}
-
-	lower := strings.ToLower(line)
-	systemMetrics := p.parseSystemMetrics(line, lower)
 
 	// If any metrics-related data changed, return the full metrics structure
 	if systemChanged || networkChanged || diskChanged || clusterChanged ||
@@ -130,8 +269,45 @@ func (p *Parser) ParseLine(line string) (*Metrics, error) {
 	return systemMetrics, nil
 }
 
+// newMetrics returns an empty Metrics stamped with the current sample header
+// and the battery readings seen so far in the sample.
+func (p *Parser) newMetrics() *Metrics {
+	metrics := &Metrics{
+		CPUPowerByCluster: p.clusterPowerSnapshot(),
+		Timestamp:         p.sampleTime,
+		Elapsed:           p.elapsed,
+		window:            p.sampleWindow(),
+		header:            p.headers,
+	}
+	if len(p.batteries) > 0 {
+		metrics.Batteries = append([]float64(nil), p.batteries...)
+	}
+	return metrics
+}
+
+// hasGPUResidency reports whether any GPU residency data has been parsed,
+// including samples that only carry SW-state or C-state residency.
+func (p *Parser) hasGPUResidency() bool {
+	g := p.gpuResidency
+	return g != nil && (g.HWActiveResidency > 0 || g.HWActiveFreqMHz > 0 || g.IdleResidency > 0 ||
+		len(g.HWActiveFreqResidency) > 0 || len(g.SWStates) > 0 || len(g.CStates) > 0)
+}
+
+// sampleWindow returns the elapsed time of the current sample when the header
+// reported one, falling back to the configured SampleWindow multiplied by the
+// --poweravg count, since averaged output covers that many intervals.
+func (p *Parser) sampleWindow() time.Duration {
+	if p.elapsed > 0 {
+		return p.elapsed
+	}
+	if p.powerAvg > 1 {
+		return p.config.SampleWindow * time.Duration(p.powerAvg)
+	}
+	return p.config.SampleWindow
+}
+
 func (p *Parser) buildMetrics() *Metrics {
-	metrics := &Metrics{}
+	metrics := p.newMetrics()
 
 	if p.networkInfo != nil {
 		metrics.Network = cloneNetworkMetrics(p.networkInfo)
@@ -154,15 +330,11 @@ func (p *Parser) buildMetrics() *Metrics {
 		metrics.CPUResidencies = cpuResidencies
 	}
 
-	if len(p.clusterResidencies) > 0 {
-		clusterResidencies := make([]ClusterResidencyMetrics, 0, len(p.clusterResidencies))
-		for _, cluster := range p.clusterResidencies {
-			clusterResidencies = append(clusterResidencies, cloneClusterResidencyMetrics(cluster))
-		}
+	if clusterResidencies := p.clusterResidencySnapshot(); len(clusterResidencies) > 0 {
 		metrics.ClusterResidencies = clusterResidencies
 	}
 
-	if p.gpuResidency != nil && (p.gpuResidency.HWActiveResidency > 0 || p.gpuResidency.IdleResidency > 0 || len(p.gpuResidency.HWActiveFreqResidency) > 0 || len(p.gpuResidency.SWStates) > 0) {
+	if p.hasGPUResidency() {
 		metrics.GPUResidency = cloneGPUResidencyMetrics(p.gpuResidency)
 	}
 
@@ -174,8 +346,13 @@ func (p *Parser) buildMetrics() *Metrics {
 		metrics.Interrupts = interrupts
 	}
 
-	// Always include system metrics even if not updated from current line
-	metrics.SystemSample = cloneSystemSample(&p.system)
+	// Include system metrics even if not updated from the current line, but
+	// only once something was measured (a CPU busy derived from per-core
+	// residency counts), so an absent section encodes as null rather than
+	// as a block of zeros.
+	if p.system.measured != 0 || p.system.ThermalPressure != "" || p.system.cpuBusyDerived {
+		metrics.SystemSample = p.systemSnapshot()
+	}
 
 	return metrics
 }
@@ -188,16 +365,6 @@ func cloneNetworkMetrics(m *NetworkMetrics) *NetworkMetrics {
 	return &copy
 }
 
-func networkMetricsEqual(a, b *NetworkMetrics) bool {
-	if a == nil || b == nil {
-		return a == nil && b == nil
-	}
-	return a.InPacketsPerSec == b.InPacketsPerSec &&
-		a.InBytesPerSec == b.InBytesPerSec &&
-		a.OutPacketsPerSec == b.OutPacketsPerSec &&
-		a.OutBytesPerSec == b.OutBytesPerSec
-}
-
 func cloneDiskMetrics(m *DiskMetrics) *DiskMetrics {
 	if m == nil {
 		return nil
@@ -206,14 +373,73 @@ func cloneDiskMetrics(m *DiskMetrics) *DiskMetrics {
 	return &copy
 }
 
-func diskMetricsEqual(a, b *DiskMetrics) bool {
-	if a == nil || b == nil {
-		return a == nil && b == nil
+// systemSnapshot returns a copy of the system sample.
+func (p *Parser) systemSnapshot() *SystemSample {
+	return cloneSystemSample(&p.system)
+}
+
+// finishCPUBusy derives CPUBusyPercent as the mean per-core active residency
+// once the per-CPU lines of a sample are complete, unless powermetrics
+// reported a CPU busy line. It runs once per sample, at the first line after
+// the per-CPU block.
+func (p *Parser) finishCPUBusy() {
+	p.cpuBusyPending = false
+	if p.system.measured&measuredCPUBusy != 0 || len(p.cpuResidencies) == 0 {
+		return
+	}
+	// Sum in CPU and frequency order so the result does not depend on map
+	// iteration order.
+	ids := make([]int, 0, len(p.cpuResidencies))
+	for id := range p.cpuResidencies {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	total := 0.0
+	for _, id := range ids {
+		for _, pair := range p.cpuResidencies[id].ActiveResidency.SortedPairs() {
+			total += pair.Percent
+		}
+	}
+	p.system.CPUBusyPercent = clampPercent(total / float64(len(p.cpuResidencies)))
+	p.system.cpuBusyDerived = true
+}
+
+// clusterPowerSnapshot copies the cluster powers of the current sample for
+// Metrics.CPUPowerByCluster.
+func (p *Parser) clusterPowerSnapshot() map[string]float64 {
+	if len(p.clusterPowers) == 0 {
+		return nil
+	}
+	powers := make(map[string]float64, len(p.clusterPowers))
+	for name, watts := range p.clusterPowers {
+		powers[name] = watts
+	}
+	return powers
+}
+
+// clusterPowerTolerance is how far, relative to CPU power, the summed cluster
+// powers may stray before reconcileClusterPower reports it; the absolute
+// floor covers rounding of milliwatt readings near idle.
+const (
+	clusterPowerTolerance      = 0.05
+	clusterPowerToleranceWatts = 0.01
+)
+
+// reconcileClusterPower logs a diagnostic when the cluster powers of the
+// sample do not add up to the reported CPU power. powermetrics prints "CPU
+// Power" after the cluster lines, so it is checked when that line is parsed.
+func (p *Parser) reconcileClusterPower() {
+	if len(p.clusterPowers) < 2 {
+		return
+	}
+	sum := 0.0
+	for _, watts := range p.clusterPowers {
+		sum += watts
+	}
+	cpu := p.system.CPUPowerWatts
+	if diff := math.Abs(sum - cpu); diff > math.Max(cpu*clusterPowerTolerance, clusterPowerToleranceWatts) {
+		p.logf("powermetrics: cluster powers sum to %.3f W but CPU power is %.3f W", sum, cpu)
 	}
-	return a.ReadOpsPerSec == b.ReadOpsPerSec &&
-		a.ReadBytesPerSec == b.ReadBytesPerSec &&
-		a.WriteOpsPerSec == b.WriteOpsPerSec &&
-		a.WriteBytesPerSec == b.WriteBytesPerSec
 }
 
 func cloneSystemSample(sample *SystemSample) *SystemSample {
@@ -249,15 +475,16 @@ func cloneClusterResidencyMetrics(src *ClusterResidencyMetrics) ClusterResidency
 		HWActiveResidency:     src.HWActiveResidency,
 		IdleResidency:         src.IdleResidency,
 		DownResidency:         src.DownResidency,
+		PowerWatts:            src.PowerWatts,
 		HWActiveFreqResidency: cloneFloatResidencyMap(src.HWActiveFreqResidency),
 	}
 }
 
-func cloneFloatResidencyMap(src map[float64]float64) map[float64]float64 {
+func cloneFloatResidencyMap(src FrequencyResidencyData) FrequencyResidencyData {
 	if src == nil {
 		return nil
 	}
-	clone := make(map[float64]float64, len(src))
+	clone := make(FrequencyResidencyData, len(src))
 	for k, v := range src {
 		clone[k] = v
 	}
@@ -272,6 +499,7 @@ func cloneGPUResidencyMetrics(src *GPUResidencyMetrics) *GPUResidencyMetrics {
 	clone.HWActiveFreqResidency = cloneFloatResidencyMap(src.HWActiveFreqResidency)
 	clone.SWRequestedStates = cloneGPUStateMap(src.SWRequestedStates)
 	clone.SWStates = cloneGPUStateMap(src.SWStates)
+	clone.CStates = cloneGPUStateMap(src.CStates)
 	return &clone
 }
 
@@ -286,64 +514,73 @@ func cloneGPUStateMap(src GPUSoftwareStateData) GPUSoftwareStateData {
 	return clone
 }
 
-func (p *Parser) parseGPUProcessLine(line string) (*Metrics, error) {
+// parseGPUProcessLine accumulates a per-process GPU line into the current
+// sample; the samples are emitted together by flushProcessSamples. It reports
+// whether the line was a GPU process line.
+func (p *Parser) parseGPUProcessLine(line string) (bool, error) {
 	matches := procLineRegex.FindStringSubmatch(line)
 	if matches == nil {
-		return nil, nil
+		return false, nil
 	}
 
 	pid, err := strconv.Atoi(matches[1])
 	if err != nil {
-		return nil, nil
+		return false, nil
 	}
 
-	rawName := strings.TrimSpace(matches[2])
+	rawName := matches[2]
 	valueStr := matches[3]
 	unit := matches[4]
 	percentStr := matches[5]
 
 	value, err := strconv.ParseFloat(valueStr, 64)
 	if err != nil {
-		return nil, nil
+		return false, nil
 	}
 
 	activeNs := convertToNanoseconds(value, unit)
-	busy := deriveBusyPercent(activeNs, percentStr, p.config.SampleWindow)
+	busy := deriveBusyPercent(activeNs, percentStr, p.sampleWindow())
 
 	sample := GPUProcessSample{
 		PID:          pid,
-		Name:         strings.Trim(rawName, "()"),
+		Name:         gpuProcessName(rawName),
 		BusyPercent:  busy,
 		ActiveNanos:  activeNs,
 		FrequencyMHz: p.frequencyMHz,
 	}
 
-	return &Metrics{
-		GPUProcessSamples: []GPUProcessSample{sample},
-	}, nil
+	if busy < p.config.MinGPUProcessBusyPercent {
+		return true, nil
+	}
+	p.gpuProcessSamples = append(p.gpuProcessSamples, sample)
+	return true, nil
 }
 
+// parseProcessLine accumulates a tasks table row into the current sample,
+// mapping its values through the columns of the last header row. A header row
+// replaces that mapping. It reports whether the line was consumed.
 func (p *Parser) parseProcessLine(line string) bool {
-	if strings.HasPrefix(strings.ToLower(line), "name ") {
+	if hasPrefixFold(line, "name ") || hasPrefixFold(line, "name\t") {
+		if columns, ok := parseProcessHeader(line); ok {
+			p.processColumns = columns
+			return true
+		}
+		p.logf("powermetrics: tasks table header without an ID column: %q", line)
 		return false
 	}
 
-	fields := strings.Fields(line)
-	if len(fields) < 8 {
-		return false
+	columns := p.processColumns
+	if columns == nil {
+		columns = defaultProcessColumns
 	}
 
-	const numericFields = 7
-	start := len(fields) - numericFields
-	nameParts := fields[:start]
-	if len(nameParts) == 0 {
+	fields := strings.Fields(line)
+	if len(fields) < 8 || len(fields) <= len(columns) {
 		return false
 	}
 
-	pid, err := strconv.Atoi(fields[start])
-	if err != nil {
-		return false
-	}
+	start := len(fields) - len(columns)
+	nameParts := fields[:start]
 
 	parseFloat := func(val string) float64 {
 		parsed, err := strconv.ParseFloat(val, 64)
@@ -353,15 +590,39 @@ func (p *Parser) parseProcessLine(line string) bool {
 		return parsed
 	}
 
-	sample := ProcessSample{
-		PID:               pid,
-		Name:              strings.Join(nameParts, " "),
-		CPUMsPerSec:       parseFloat(fields[start+1]),
-		UserPercent:       parseFloat(fields[start+2]),
-		DeadlinesLT2Ms:    parseFloat(fields[start+3]),
-		Deadlines2To5Ms:   parseFloat(fields[start+4]),
-		WakeupsInterrupts: parseFloat(fields[start+5]),
-		WakeupsPkgIdle:    parseFloat(fields[start+6]),
+	sample := ProcessSample{Name: strings.Join(nameParts, " ")}
+	for i, column := range columns {
+		value := fields[start+i]
+		switch column {
+		case columnPID:
+			pid, err := strconv.Atoi(value)
+			if err != nil {
+				return false
+			}
+			sample.PID = pid
+		case columnCPUMsPerSec:
+			sample.CPUMsPerSec = parseFloat(value)
+		case columnUserPercent:
+			sample.UserPercent = parseFloat(value)
+		case columnDeadlinesLT2Ms:
+			sample.DeadlinesLT2Ms = parseFloat(value)
+		case columnDeadlines2To5Ms:
+			sample.Deadlines2To5Ms = parseFloat(value)
+		case columnWakeupsInterrupts:
+			sample.WakeupsInterrupts = parseFloat(value)
+		case columnWakeupsPkgIdle:
+			sample.WakeupsPkgIdle = parseFloat(value)
+		case columnGPUMsPerSec:
+			sample.GPUMsPerSec = parseFloat(value)
+		case columnEnergyImpact:
+			sample.EnergyImpact = parseFloat(value)
+			sample.energyImpactReported = true
+		}
+	}
+
+	if sample.Name == deadTasksName {
+		p.deadTasks = &sample
+		return true
 	}
 
 	p.processSamples = append(p.processSamples, sample)
@@ -369,51 +630,130 @@ func (p *Parser) parseProcessLine(line string) bool {
 }
 
 func (p *Parser) flushProcessSamples() *Metrics {
-	if len(p.processSamples) == 0 {
+	if len(p.processSamples) == 0 && p.deadTasks == nil && len(p.gpuProcessSamples) == 0 {
 		return nil
 	}
 
-	samples := make([]ProcessSample, len(p.processSamples))
-	copy(samples, p.processSamples)
+	metrics := p.newMetrics()
+	if len(p.processSamples) > 0 || p.deadTasks != nil {
+		p.emitTrigger |= sectionBit(SectionTasks)
+	}
+	if len(p.gpuProcessSamples) > 0 {
+		p.emitTrigger |= sectionBit(SectionGPUProcesses)
+	}
+	if len(p.processSamples) > 0 {
+		samples := make([]ProcessSample, len(p.processSamples))
+		copy(samples, p.processSamples)
+		if p.config.ResolveProcessPaths {
+			p.resolveProcessPaths(samples)
+		}
+		metrics.ProcessSamples = samples
+	}
+	if len(p.gpuProcessSamples) > 0 {
+		samples := make([]GPUProcessSample, len(p.gpuProcessSamples))
+		copy(samples, p.gpuProcessSamples)
+		metrics.GPUProcessSamples = samples
+	}
+	metrics.DeadTasks = p.deadTasks
 	p.processSamples = nil
+	p.gpuProcessSamples = nil
+	p.deadTasks = nil
 
-	return &Metrics{
-		ProcessSamples: samples,
+	return metrics
+}
+
+// updateEnergyCounter stores a cumulative energy counter in joules and, when
+// the stream has not reported the matching power directly, derives that power
+// from the increase since the previous sample. A counter that went backwards
+// (e.g. after a reset) only re-bases the next difference.
+func (p *Parser) updateEnergyCounter(name string, joules float64) {
+	var counter *float64
+	var power *float64
+	var counterField, powerField systemField
+	switch name {
+	case "package":
+		counter, counterField = &p.system.PackageEnergyJoules, measuredPackageEnergy
+		power, powerField = &p.system.PackagePowerWatts, measuredPackagePower
+	case "cpu", "ia":
+		counter, counterField = &p.system.CPUEnergyJoules, measuredCPUEnergy
+		power, powerField = &p.system.CPUPowerWatts, measuredCPUPower
+	default:
+		counter, counterField = &p.system.GPUEnergyJoules, measuredGPUEnergy
+		power, powerField = &p.system.GPUPowerWatts, measuredGPUPower
 	}
+
+	previous, seen := *counter, p.system.measured&counterField != 0
+	*counter = joules
+	p.system.mark(counterField)
+
+	window := p.sampleWindow()
+	if !seen || joules < previous || window <= 0 || p.reportedPower&powerField != 0 {
+		return
+	}
+	*power = (joules - previous) / window.Seconds()
+	p.system.mark(powerField)
 }
 
 func (p *Parser) parseSystemMetrics(line, lower string) *Metrics {
 	updated := false
 
 	if hasAll(lower, "cpu", "power") && hasNone(lower, "gpu") {
-		// Try to parse watts first
-		if val, ok := parseTrailingValue(line, "w"); ok {
-			p.system.CPUPowerWatts = val
-			updated = true
-		} else if val, ok := parseTrailingValue(line, "mW"); ok {
-			// If watts not found, try milliwatts and convert to watts
-			p.system.CPUPowerWatts = val / 1000.0
+		if val, ok := parsePowerWatts(line); ok {
+			p.system.CPUPowerWatts = p.clampNonNegative("CPU power", val)
+			p.system.mark(measuredCPUPower)
+			p.reportedPower |= measuredCPUPower
+			p.reconcileClusterPower()
 			updated = true
 		}
 	}
 
+	// Intel: "Intel energy model derived package power (CPUs+GT+SA): 3.37W"
+	if matches := packagePowerRegex.FindStringSubmatch(line); matches != nil {
+		val, _ := strconv.ParseFloat(matches[1], 64)
+		if strings.EqualFold(matches[2], "mW") {
+			val /= 1000.0
+		}
+		p.system.PackagePowerWatts = p.clampNonNegative("package power", val)
+		p.system.mark(measuredPackagePower)
+		p.reportedPower |= measuredPackagePower
+		updated = true
+	}
+
+	if matches := energyCounterRegex.FindStringSubmatch(line); matches != nil {
+		val, _ := strconv.ParseFloat(matches[2], 64)
+		p.updateEnergyCounter(strings.ToLower(matches[1]), val)
+		updated = true
+	}
+
 	if hasAll(lower, "cpu", "frequency") && hasNone(lower, "gpu") {
 		if val, ok := parseTrailingValue(line, "mhz"); ok {
-			p.system.CPUFrequencyMHz = val
+			p.system.CPUFrequencyMHz = p.clampNonNegative("CPU frequency", val)
+			p.system.mark(measuredCPUFrequency)
+			updated = true
+		}
+	}
+
+	if hasAll(lower, "cpu", "busy") && hasNone(lower, "gpu") {
+		if val, ok := parseTrailingValue(line, "%"); ok {
+			p.system.CPUBusyPercent = p.clampNonNegative("CPU busy", val)
+			p.system.cpuBusyDerived = false
+			p.system.mark(measuredCPUBusy)
 			updated = true
 		}
 	}
 
 	if hasAll(lower, "gpu", "busy") {
 		if val, ok := parseTrailingValue(line, "%"); ok {
-			p.system.GPUBusyPercent = val
+			p.system.GPUBusyPercent = p.clampNonNegative("GPU busy", val)
+			p.system.mark(measuredGPUBusy)
 			updated = true
 		}
 	}
 
 	if hasAll(lower, "gpu", "hw active residency") {
 		if val, ok := parseLeadingValueAfterColon(line, "%"); ok {
-			p.system.GPUBusyPercent = val
+			p.system.GPUBusyPercent = p.clampNonNegative("GPU busy", val)
+			p.system.mark(measuredGPUBusy)
 			updated = true
 		}
 	}
@@ -422,6 +762,7 @@ func (p *Parser) parseSystemMetrics(line, lower string) *Metrics {
 		if val, ok := parseLeadingValueAfterColon(line, "%"); ok {
 			if p.system.GPUBusyPercent == 0 {
 				p.system.GPUBusyPercent = clampPercent(100 - val)
+				p.system.mark(measuredGPUBusy)
 			}
 			updated = true
 		}
@@ -429,46 +770,64 @@ func (p *Parser) parseSystemMetrics(line, lower string) *Metrics {
 
 	if hasAll(lower, "ane", "busy") {
 		if val, ok := parseTrailingValue(line, "%"); ok {
-			p.system.ANEBusyPercent = val
+			p.system.ANEBusyPercent = p.clampNonNegative("ANE busy", val)
+			p.system.mark(measuredANEBusy)
 			updated = true
 		}
 	}
 
 	if hasAll(lower, "ane", "power") {
-		// Try to parse watts first
-		if val, ok := parseTrailingValue(line, "w"); ok {
-			p.system.ANEPowerWatts = val
-			updated = true
-		} else if val, ok := parseTrailingValue(line, "mW"); ok {
-			// If watts not found, try milliwatts and convert to watts
-			p.system.ANEPowerWatts = val / 1000.0
+		if val, ok := parsePowerWatts(line); ok {
+			p.system.ANEPowerWatts = p.clampNonNegative("ANE power", val)
+			p.system.mark(measuredANEPower)
 			updated = true
 		}
 	}
 
 	if hasAll(lower, "gpu", "power") {
-		// Try to parse watts first
-		if val, ok := parseTrailingValue(line, "w"); ok {
-			p.system.GPUPowerWatts = val
-			updated = true
-		} else if val, ok := parseTrailingValue(line, "mW"); ok {
-			// If watts not found, try milliwatts and convert to watts
-			p.system.GPUPowerWatts = val / 1000.0
+		if val, ok := parsePowerWatts(line); ok {
+			p.system.GPUPowerWatts = p.clampNonNegative("GPU power", val)
+			p.system.mark(measuredGPUPower)
+			p.reportedPower |= measuredGPUPower
 			updated = true
 		}
 	}
 
 	if hasAll(lower, "dram", "power") {
-		if val, ok := parseTrailingValue(line, "w"); ok {
-			p.system.DRAMPowerWatts = val
+		if val, ok := parsePowerWatts(line); ok {
+			p.system.DRAMPowerWatts = p.clampNonNegative("DRAM power", val)
+			p.system.mark(measuredDRAMPower)
 			updated = true
 		}
 	}
 
+	// The system-wide wakeup total, not the per-CPU "Total IRQ" lines of the
+	// interrupt distribution.
+	if matches := systemWakeupsRegex.FindStringSubmatch(line); matches != nil {
+		val, _ := strconv.ParseFloat(matches[1], 64)
+		p.system.SystemWakeupsPerSec = val
+		p.system.mark(measuredSystemWakeups)
+		updated = true
+	}
+
+	if matches := dramBandwidthRegex.FindStringSubmatch(line); matches != nil {
+		val, _ := strconv.ParseFloat(matches[2], 64)
+		if strings.EqualFold(matches[3], "MB/s") {
+			val /= 1000.0
+		}
+		if strings.EqualFold(matches[1], "read") {
+			p.system.DRAMReadBandwidthGBs = val
+			p.system.mark(measuredDRAMReadBandwidth)
+		} else {
+			p.system.DRAMWriteBandwidthGBs = val
+			p.system.mark(measuredDRAMWriteBandwidth)
+		}
+		updated = true
+	}
+
 	if hasAll(lower, "gpu", "frequency") {
 		if val, ok := parseTrailingValue(line, "mhz"); ok {
-			p.frequencyMHz = val
-			p.system.GPUFrequencyMHz = val
+			p.setGPUFrequency(val)
 			updated = true
 		}
 	}
@@ -476,6 +835,7 @@ func (p *Parser) parseSystemMetrics(line, lower string) *Metrics {
 	if hasAll(lower, "gpu", "temperature") {
 		if val, ok := parseTrailingValue(line, "c"); ok {
 			p.system.GPUTemperatureC = val
+			p.system.mark(measuredGPUTemperature)
 			updated = true
 		}
 	}
@@ -483,6 +843,7 @@ func (p *Parser) parseSystemMetrics(line, lower string) *Metrics {
 	if hasAll(lower, "cpu", "temperature") {
 		if val, ok := parseTrailingValue(line, "c"); ok {
 			p.system.CPUTemperatureC = val
+			p.system.mark(measuredCPUTemperature)
 			updated = true
 		}
 	}
@@ -491,6 +852,7 @@ func (p *Parser) parseSystemMetrics(line, lower string) *Metrics {
 	if hasAll(lower, "gpu", "die", "temp") || hasAll(lower, "gpu", "junction", "temp") {
 		if val, ok := parseTrailingValue(line, "c"); ok {
 			p.system.GPUTemperatureC = val
+			p.system.mark(measuredGPUTemperature)
 			updated = true
 		}
 	}
@@ -498,6 +860,7 @@ func (p *Parser) parseSystemMetrics(line, lower string) *Metrics {
 	if hasAll(lower, "cpu", "die", "temp") || hasAll(lower, "cpu", "junction", "temp") || hasAll(lower, "package", "temp") {
 		if val, ok := parseTrailingValue(line, "c"); ok {
 			p.system.CPUTemperatureC = val
+			p.system.mark(measuredCPUTemperature)
 			updated = true
 		}
 	}
@@ -508,8 +871,10 @@ func (p *Parser) parseSystemMetrics(line, lower string) *Metrics {
 			// If we already have a CPU temp, assign to GPU, otherwise CPU
 			if p.system.CPUTemperatureC == 0 {
 				p.system.CPUTemperatureC = val
+				p.system.mark(measuredCPUTemperature)
 			} else if p.system.GPUTemperatureC == 0 {
 				p.system.GPUTemperatureC = val
+				p.system.mark(measuredGPUTemperature)
 			}
 			updated = true
 		}
@@ -520,8 +885,10 @@ func (p *Parser) parseSystemMetrics(line, lower string) *Metrics {
 		if val, ok := parseTrailingValue(line, "c"); ok {
 			if hasAny(lower, "cpu", "package") {
 				p.system.CPUTemperatureC = val
+				p.system.mark(measuredCPUTemperature)
 			} else if hasAny(lower, "gpu") {
 				p.system.GPUTemperatureC = val
+				p.system.mark(measuredGPUTemperature)
 			}
 			updated = true
 		}
@@ -532,8 +899,10 @@ func (p *Parser) parseSystemMetrics(line, lower string) *Metrics {
 		if val, ok := parseTrailingValue(line, "c"); ok {
 			if hasAny(lower, "cpu", "package") {
 				p.system.CPUTemperatureC = val
+				p.system.mark(measuredCPUTemperature)
 			} else if hasAny(lower, "gpu") {
 				p.system.GPUTemperatureC = val
+				p.system.mark(measuredGPUTemperature)
 			}
 			updated = true
 		}
@@ -545,12 +914,16 @@ func (p *Parser) parseSystemMetrics(line, lower string) *Metrics {
 			// If we can't determine CPU vs GPU, set both but prefer based on content
 			if hasAny(lower, "cpu", "package", "processor") {
 				p.system.CPUTemperatureC = val
+				p.system.mark(measuredCPUTemperature)
 			} else if hasAny(lower, "gpu", "graphics") {
 				p.system.GPUTemperatureC = val
+				p.system.mark(measuredGPUTemperature)
 			} else {
 				// Set both if uncertain
 				p.system.CPUTemperatureC = val
+				p.system.mark(measuredCPUTemperature)
 				p.system.GPUTemperatureC = val
+				p.system.mark(measuredGPUTemperature)
 			}
 			updated = true
 		}
@@ -561,51 +934,12 @@ func (p *Parser) parseSystemMetrics(line, lower string) *Metrics {
 		return nil
 	}
 
-	metrics := &Metrics{
-		SystemSample: cloneSystemSample(&p.system),
-	}
-
-	if clusters := p.clusterSnapshot(); len(clusters) > 0 {
-		metrics.Clusters = clusters
+	// A system line always carries the system section, even when it only
+	// fed an energy counter and nothing was marked measured yet.
+	metrics := p.buildMetrics()
+	if metrics.SystemSample == nil {
+		metrics.SystemSample = p.systemSnapshot()
 	}
-
-	// Add new metrics
-	if len(p.cpuResidencies) > 0 {
-		cpuResidencies := make([]CPUResidencyMetrics, 0, len(p.cpuResidencies))
-		for _, cpu := range p.cpuResidencies {
-			cpuResidencies = append(cpuResidencies, cloneCPUResidencyMetrics(cpu))
-		}
-		metrics.CPUResidencies = cpuResidencies
-	}
-
-	if len(p.clusterResidencies) > 0 {
-		clusterResidencies := make([]ClusterResidencyMetrics, 0, len(p.clusterResidencies))
-		for _, cluster := range p.clusterResidencies {
-			clusterResidencies = append(clusterResidencies, cloneClusterResidencyMetrics(cluster))
-		}
-		metrics.ClusterResidencies = clusterResidencies
-	}
-
-	if p.gpuResidency != nil && (p.gpuResidency.HWActiveResidency > 0 || p.gpuResidency.IdleResidency > 0 || len(p.gpuResidency.HWActiveFreqResidency) > 0) {
-		metrics.GPUResidency = cloneGPUResidencyMetrics(p.gpuResidency)
-	}
-
-	if p.networkInfo != nil {
-		metrics.Network = cloneNetworkMetrics(p.networkInfo)
-	}
-
-	if p.diskInfo != nil {
-		metrics.Disk = cloneDiskMetrics(p.diskInfo)
-	}
-
-	if len(p.interruptInfo) > 0 {
-		interrupts := make([]InterruptMetrics, 0, len(p.interruptInfo))
-		for _, interrupt := range p.interruptInfo {
-			interrupts = append(interrupts, *interrupt)
-		}
-		metrics.Interrupts = interrupts
-	}
-
 	return metrics
 }
 
@@ -624,39 +958,87 @@ func (p *Parser) updateClusterInfo(line string) bool {
 		freqMHz, _ := strconv.ParseFloat(matches[2], 64)
 
 		cluster := p.ensureCluster(name)
+		p.noteReading(readingClusterFrequency, 0, name)
 		cluster.HWActiveFreq = freqMHz
 		return true
 	}
 
+	if matches := clusterPowerRegex.FindStringSubmatch(line); matches != nil {
+		name := matches[1] + "-Cluster"
+		power, _ := strconv.ParseFloat(matches[2], 64)
+		if matches[3] == "mW" {
+			power /= 1000.0
+		}
+
+		cluster := p.ensureCluster(name)
+		cluster.PowerWatts = p.clampNonNegative("cluster power", power)
+		if p.clusterPowers == nil {
+			p.clusterPowers = make(map[string]float64)
+		}
+		p.clusterPowers[name] = cluster.PowerWatts
+		return true
+	}
+
 	return false
 }
 
-func (p *Parser) ensureCluster(name string) *ClusterInfo {
-	if cluster, exists := p.clusterInfo[name]; exists {
+// ensureCluster returns the tracked state for the named cluster (e.g.
+// "E-Cluster"), creating it on first use. Online percentage, frequency,
+// residency and power lines all land on this one object.
+func (p *Parser) ensureCluster(name string) *ClusterResidencyMetrics {
+	if cluster, exists := p.clusters[name]; exists {
 		return cluster
 	}
 
-	clusterType := "Performance"
-	if strings.HasPrefix(strings.ToUpper(name), "E-") {
-		clusterType = "Efficiency"
+	cluster := &ClusterResidencyMetrics{
+		Name:                  name,
+		Type:                  clusterType(name),
+		HWActiveFreqResidency: make(FrequencyResidencyData),
 	}
+	p.clusters[name] = cluster
+	return cluster
+}
 
-	cluster := &ClusterInfo{
-		Name: name,
-		Type: clusterType,
+// clusterType classifies a cluster as "Efficiency" (E-clusters) or
+// "Performance" (everything else) from its name.
+func clusterType(name string) string {
+	if strings.HasPrefix(strings.ToUpper(name), "E-") {
+		return "Efficiency"
 	}
-	p.clusterInfo[name] = cluster
-	return cluster
+	return "Performance"
 }
 
 func (p *Parser) clusterSnapshot() []ClusterInfo {
-	if len(p.clusterInfo) == 0 {
+	if len(p.clusters) == 0 {
 		return nil
 	}
 
-	clusters := make([]ClusterInfo, 0, len(p.clusterInfo))
-	for _, cluster := range p.clusterInfo {
-		clusters = append(clusters, *cluster)
+	clusters := make([]ClusterInfo, 0, len(p.clusters))
+	for _, cluster := range p.clusters {
+		clusters = append(clusters, ClusterInfo{
+			Name:          cluster.Name,
+			Type:          cluster.Type,
+			OnlinePercent: cluster.OnlinePercent,
+			HWActiveFreq:  cluster.HWActiveFreq,
+			PowerWatts:    cluster.PowerWatts,
+		})
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Name < clusters[j].Name
+	})
+
+	return clusters
+}
+
+func (p *Parser) clusterResidencySnapshot() []ClusterResidencyMetrics {
+	if len(p.clusters) == 0 {
+		return nil
+	}
+
+	clusters := make([]ClusterResidencyMetrics, 0, len(p.clusters))
+	for _, cluster := range p.clusters {
+		clusters = append(clusters, cloneClusterResidencyMetrics(cluster))
 	}
 
 	sort.Slice(clusters, func(i, j int) bool {
@@ -672,6 +1054,7 @@ func (p *Parser) updateCPUInfo(line string) (bool, bool) {
 		cpuID, _ := strconv.Atoi(cpuFreqMatch[1])
 		freq, _ := strconv.ParseFloat(cpuFreqMatch[2], 64)
 		cpu := p.ensureCPUResidency(cpuID)
+		p.noteReading(readingCPUFrequency, cpuID, "")
 		cpu.Frequency = freq
 		return true, false
 	}
@@ -693,8 +1076,10 @@ func (p *Parser) updateCPUInfo(line string) (bool, bool) {
 		if openParenIdx != -1 {
 			freqDataStr := line[openParenIdx+1:]
 			freqDataStr = strings.TrimRight(freqDataStr, ")")
-			cpu.ActiveResidency = parseFreqResidency(freqDataStr)
+			cpu.ActiveResidency = p.snapFrequencies(frequencyDomainCPU, parseFreqResidency(freqDataStr))
+			p.recordResidency(cpuID, cpu.ActiveResidency)
 		}
+		p.cpuBusyPending = true
 		return true, false
 	}
 
@@ -716,25 +1101,36 @@ func (p *Parser) updateCPUInfo(line string) (bool, bool) {
 		return true, false
 	}
 
-	// Handle cluster residency information
-	if strings.Contains(line, "-Cluster HW active residency:") {
-		parts := strings.Split(line, ":")
-		if len(parts) >= 2 {
-			clusterName := strings.TrimSpace(strings.Split(line, " ")[0]) // Get the first part before the colon
-			// Parse the percentage after "residency:"
-			if val, ok := parseTrailingValue(line, "%"); ok {
-				cluster := p.ensureClusterResidency(clusterName)
-				cluster.HWActiveResidency = val
-			}
+	// Handle cluster residency information. The residency comes from the
+	// regex capture before the parentheses, never from the frequency list.
+	if matches := clusterHWActiveResidencyRegex.FindStringSubmatch(line); matches != nil {
+		cluster := p.ensureCluster(matches[1] + "-Cluster")
+		if val, err := strconv.ParseFloat(matches[2], 64); err == nil {
+			cluster.HWActiveResidency = val
+		}
 
-			// Parse the frequency residency data in parentheses
-			openParenIdx := strings.Index(line, "(")
-			if openParenIdx != -1 {
-				freqDataStr := line[openParenIdx+1:]
-				freqDataStr = strings.TrimRight(freqDataStr, ")")
-				cluster := p.ensureClusterResidency(clusterName)
-				cluster.HWActiveFreqResidency = parseFreqResidency(freqDataStr)
-			}
+		// Parse the frequency residency data in parentheses
+		openParenIdx := strings.Index(line, "(")
+		if openParenIdx != -1 {
+			freqDataStr := line[openParenIdx+1:]
+			freqDataStr = strings.TrimRight(freqDataStr, ")")
+			cluster.HWActiveFreqResidency = p.snapFrequencies(frequencyDomainCluster, parseFreqResidency(freqDataStr))
+		}
+		return false, true
+	}
+
+	// Handle cluster idle and down residency, e.g. "P0-Cluster idle residency:   7.58%"
+	if matches := clusterIdleResidencyRegex.FindStringSubmatch(line); matches != nil {
+		cluster := p.ensureCluster(matches[1] + "-Cluster")
+		if val, err := strconv.ParseFloat(matches[2], 64); err == nil {
+			cluster.IdleResidency = val
+		}
+		return false, true
+	}
+	if matches := clusterDownResidencyRegex.FindStringSubmatch(line); matches != nil {
+		cluster := p.ensureCluster(matches[1] + "-Cluster")
+		if val, err := strconv.ParseFloat(matches[2], 64); err == nil {
+			cluster.DownResidency = val
 		}
 		return false, true
 	}
@@ -755,19 +1151,6 @@ func (p *Parser) ensureCPUResidency(cpuID int) *CPUResidencyMetrics {
 	return cpu
 }
 
-func (p *Parser) ensureClusterResidency(name string) *ClusterResidencyMetrics {
-	if cluster, exists := p.clusterResidencies[name]; exists {
-		return cluster
-	}
-
-	cluster := &ClusterResidencyMetrics{
-		Name:                  name,
-		HWActiveFreqResidency: make(map[float64]float64),
-	}
-	p.clusterResidencies[name] = cluster
-	return cluster
-}
-
 func (p *Parser) updateNetworkInfo(line string) {
 	// Parse outgoing network activity
 	outMatches := networkRegex.FindStringSubmatch(line)
@@ -777,6 +1160,7 @@ func (p *Parser) updateNetworkInfo(line string) {
 				if p.networkInfo == nil {
 					p.networkInfo = &NetworkMetrics{}
 				}
+				p.noteReading(readingNetworkOut, 0, "")
 				p.networkInfo.OutPacketsPerSec = outPackets
 				p.networkInfo.OutBytesPerSec = outBytes
 			}
@@ -791,6 +1175,7 @@ func (p *Parser) updateNetworkInfo(line string) {
 				if p.networkInfo == nil {
 					p.networkInfo = &NetworkMetrics{}
 				}
+				p.noteReading(readingNetworkIn, 0, "")
 				p.networkInfo.InPacketsPerSec = inPackets
 				p.networkInfo.InBytesPerSec = inBytes
 			}
@@ -807,6 +1192,7 @@ func (p *Parser) updateDiskInfo(line string) {
 				if p.diskInfo == nil {
 					p.diskInfo = &DiskMetrics{}
 				}
+				p.noteReading(readingDiskRead, 0, "")
 				p.diskInfo.ReadOpsPerSec = readOps
 				p.diskInfo.ReadBytesPerSec = readBytes * 1024 // Convert from KBytes to Bytes
 			}
@@ -821,6 +1207,7 @@ func (p *Parser) updateDiskInfo(line string) {
 				if p.diskInfo == nil {
 					p.diskInfo = &DiskMetrics{}
 				}
+				p.noteReading(readingDiskWrite, 0, "")
 				p.diskInfo.WriteOpsPerSec = writeOps
 				p.diskInfo.WriteBytesPerSec = writeBytes * 1024 // Convert from KBytes to Bytes
 			}
@@ -828,46 +1215,43 @@ func (p *Parser) updateDiskInfo(line string) {
 	}
 }
 
-func (p *Parser) updateInterruptInfo(line string) {
+// updateInterruptInfo records an interrupt block line and reports whether it
+// changed a rate.
+func (p *Parser) updateInterruptInfo(line string) bool {
 	// Check for CPU interrupt lines
 	cpuMatch := interruptRegex.FindStringSubmatch(line)
 	if cpuMatch != nil {
 		cpuID, _ := strconv.Atoi(cpuMatch[1])
-		p.ensureInterruptInfo(cpuID)
-		return
+		p.noteReading(readingInterrupts, cpuID, "")
+		p.interruptCPU = p.ensureInterruptInfo(cpuID)
+		return false
+	}
+
+	// The detail lines carry no CPU id of their own; they belong to the
+	// block opened by the last "CPU N:" line, whatever other sampler output
+	// is interleaved in between.
+	interrupt := p.interruptCPU
+	if interrupt == nil {
+		return false
 	}
+	prev := *interrupt
 
 	// Check for total interrupts line
-	totalMatch := interruptTotalRegex.FindStringSubmatch(line)
-	if totalMatch != nil {
-		// Find the most recently added interrupt that doesn't have this value set
-		for _, interrupt := range p.interruptInfo {
-			if interrupt.TotalIRQ == 0 { // If not yet set, assume this is for the most recently added CPU
-				totalIRQ, _ := strconv.ParseFloat(totalMatch[1], 64)
-				interrupt.TotalIRQ = totalIRQ
-				break
-			}
-		}
-		return
+	if totalMatch := interruptTotalRegex.FindStringSubmatch(line); totalMatch != nil {
+		interrupt.TotalIRQ, _ = strconv.ParseFloat(totalMatch[1], 64)
+		return *interrupt != prev
 	}
 
 	// Check for IPI and TIMER interrupt lines
-	ipiTimerMatch := interruptIPITimerRegex.FindStringSubmatch(line)
-	if ipiTimerMatch != nil {
-		interruptType := ipiTimerMatch[1]
+	if ipiTimerMatch := interruptIPITimerRegex.FindStringSubmatch(line); ipiTimerMatch != nil {
 		value, _ := strconv.ParseFloat(ipiTimerMatch[2], 64)
-
-		// Find the most recently added interrupt that doesn't have this value set
-		for _, interrupt := range p.interruptInfo {
-			if interruptType == "IPI" && interrupt.IPI == 0 {
-				interrupt.IPI = value
-				break
-			} else if interruptType == "TIMER" && interrupt.TIMER == 0 {
-				interrupt.TIMER = value
-				break
-			}
+		if ipiTimerMatch[1] == "IPI" {
+			interrupt.IPI = value
+		} else {
+			interrupt.TIMER = value
 		}
 	}
+	return *interrupt != prev
 }
 
 func (p *Parser) ensureInterruptInfo(cpuID int) *InterruptMetrics {
@@ -882,16 +1266,25 @@ func (p *Parser) ensureInterruptInfo(cpuID int) *InterruptMetrics {
 	return interrupt
 }
 
+// setGPUFrequency records a GPU frequency reading in every field that
+// carries it, so they agree regardless of the line's label.
+func (p *Parser) setGPUFrequency(mhz float64) {
+	mhz = p.clampNonNegative("GPU frequency", mhz)
+	p.frequencyMHz = mhz
+	p.system.GPUFrequencyMHz = mhz
+	p.system.mark(measuredGPUFrequency)
+	p.gpuResidency.HWActiveFreqMHz = mhz
+}
+
 func (p *Parser) updateGPUResidencyInfo(line string) bool {
 	lowerLine := strings.ToLower(line)
 
-	// Parse GPU HW active frequency
+	// Parse the GPU frequency ("GPU HW active frequency", "GPU active
+	// frequency" or "GPU Frequency", depending on the macOS version)
 	if matches := gpuFreqRegex.FindStringSubmatch(line); matches != nil {
 		freq, _ := strconv.ParseFloat(matches[1], 64)
-		p.frequencyMHz = freq
-		if p.system.GPUFrequencyMHz == 0 {
-			p.system.GPUFrequencyMHz = freq
-		}
+		p.noteReading(readingGPUFrequency, 0, "")
+		p.setGPUFrequency(freq)
 		return true
 	}
 
@@ -905,7 +1298,7 @@ func (p *Parser) updateGPUResidencyInfo(line string) bool {
 		if openParenIdx != -1 {
 			freqDataStr := line[openParenIdx+1:]
 			freqDataStr = strings.TrimRight(freqDataStr, ")")
-			p.gpuResidency.HWActiveFreqResidency = parseFreqResidency(freqDataStr)
+			p.gpuResidency.HWActiveFreqResidency = p.snapFrequencies(frequencyDomainGPU, parseFreqResidency(freqDataStr))
 		}
 		return true
 	}
@@ -929,14 +1322,17 @@ func (p *Parser) updateGPUResidencyInfo(line string) bool {
 		return true
 	}
 
-	// Parse GPU power
+	// Parse GPU C-state residency
+	if matches := gpuCStateRegex.FindStringSubmatch(line); matches != nil {
+		p.gpuResidency.CStates = parseGPUStates(matches[1])
+		return true
+	}
+
+	// Parse GPU power with the same helper as SystemSample.GPUPowerWatts so
+	// the two GPU power fields always describe the same reading.
 	if hasAll(lowerLine, "gpu", "power") {
-		var val float64
-		var ok bool
-		if val, ok = parseTrailingValue(line, "w"); ok {
-			p.gpuResidency.PowerMilliwatts = val * 1000 // Convert to milliwatts
-		} else if val, ok = parseTrailingValue(line, "mw"); ok {
-			p.gpuResidency.PowerMilliwatts = val
+		if val, ok := parsePowerWatts(line); ok {
+			p.gpuResidency.PowerMilliwatts = p.clampNonNegative("GPU power", val) * 1000
 		}
 		return true
 	}
@@ -944,23 +1340,102 @@ func (p *Parser) updateGPUResidencyInfo(line string) bool {
 	return false
 }
 
-func (p *Parser) updateBatteryInfo(line string) {
+// updateBatteryInfo parses the battery and backlight lines. Each battery
+// line of a sample is appended to p.batteries; BatteryPercent keeps the first
+// one. It reports whether a battery reading was added.
+func (p *Parser) updateBatteryInfo(line string) bool {
+	added := false
 	if matches := batteryRegex.FindStringSubmatch(line); matches != nil {
 		battery, _ := strconv.ParseFloat(matches[1], 64)
-		p.system.BatteryPercent = battery
+		if len(p.batteries) == 0 {
+			p.system.BatteryPercent = battery
+			p.system.mark(measuredBattery)
+		}
+		p.batteries = append(p.batteries, battery)
+		added = true
+	}
+
+	if matches := acPowerRegex.FindStringSubmatch(line); matches != nil {
+		switch strings.ToLower(matches[1]) {
+		case "yes", "true", "1", "ac", "ac power", "connected", "attached":
+			p.system.OnAC = true
+			p.system.mark(measuredOnAC)
+		case "no", "false", "0", "battery", "battery power", "disconnected":
+			p.system.OnAC = false
+			p.system.mark(measuredOnAC)
+		}
 	}
+
+	if matches := backlightRegex.FindStringSubmatch(line); matches != nil {
+		level, _ := strconv.ParseFloat(matches[1], 64)
+		// Raw levels come with their range, e.g. "564 (range 0-1024)".
+		if matches[3] != "" {
+			lo, _ := strconv.ParseFloat(matches[3], 64)
+			hi, _ := strconv.ParseFloat(matches[4], 64)
+			if hi <= lo {
+				return added
+			}
+			level = (level - lo) / (hi - lo) * 100
+		}
+		p.system.BacklightPercent = clampPercent(level)
+		p.system.mark(measuredBacklight)
+	}
+	return added
+}
+
+// updateSampleHeader records the timestamp and elapsed window from a
+// "*** Sampled system activity (...) (N ms elapsed) ***" header.
+func (p *Parser) updateSampleHeader(line string) bool {
+	matches := sampleHeaderRegex.FindStringSubmatch(line)
+	if matches == nil {
+		return false
+	}
+
+	// A second header closes the sample the first one opened.
+	if p.seenHeader {
+		p.complete.Store(true)
+	}
+	p.seenHeader = true
+	p.headers++
+	p.batteries = nil
+	p.clusterPowers = nil
+	p.interruptCPU = nil
+	for key := range p.sampleReadings {
+		delete(p.sampleReadings, key)
+	}
+
+	if ts, err := time.Parse(sampleTimeLayout, matches[1]); err == nil {
+		p.sampleTime = ts
+	}
+	if ms, err := strconv.ParseFloat(matches[2], 64); err == nil && ms > 0 {
+		p.elapsed = time.Duration(ms * float64(time.Millisecond))
+	}
+	return true
 }
 
+func (p *Parser) updateThermalPressure(line string) {
+	if matches := thermalPressureRegex.FindStringSubmatch(line); matches != nil {
+		p.system.ThermalPressure = matches[1]
+	}
+}
+
+// parseFreqResidency parses a "1020 MHz: 39% 1404 MHz: 2.2% ..." breakdown.
+// Entries may be given in MHz or GHz, even mixed on one line; the keys are
+// always normalized to MHz.
 func parseFreqResidency(freqDataStr string) CPUResidencyData {
 	residencies := make(CPUResidencyData)
 
 	// Find all matches of the frequency residency pattern
 	matches := cpuFreqResidencyRegex.FindAllStringSubmatch(freqDataStr, -1)
 	for _, match := range matches {
-		if len(match) >= 3 {
+		if len(match) >= 4 {
 			freq, err := strconv.ParseFloat(match[1], 64)
-			percent, err2 := strconv.ParseFloat(match[2], 64)
+			percent, err2 := strconv.ParseFloat(match[3], 64)
 			if err == nil && err2 == nil {
+				if match[2] == "GHz" {
+					// Round away float error so 1.404 GHz keys as 1404 MHz.
+					freq = math.Round(freq*1e6) / 1e3
+				}
 				residencies[freq] = percent
 			}
 		}
@@ -969,6 +1444,60 @@ func parseFreqResidency(freqDataStr string) CPUResidencyData {
 	return residencies
 }
 
+// frequencyDomain selects the frequency step table used by snapFrequencies.
+// CPUs, clusters and the GPU run different P-state tables, so a step seen in
+// one domain must not capture a reading from another.
+type frequencyDomain int
+
+const (
+	frequencyDomainCPU frequencyDomain = iota
+	frequencyDomainCluster
+	frequencyDomainGPU
+	frequencyDomainCount
+)
+
+// snapFrequencies maps each frequency in data onto the nearest step already
+// observed in domain by this parser when it is within
+// Config.FrequencySnapMHz, so that jittered readings of the same P-state
+// share one map key. Frequencies with no step close enough become new steps.
+// Residency of entries that snap to the same step is summed.
+func (p *Parser) snapFrequencies(domain frequencyDomain, data FrequencyResidencyData) FrequencyResidencyData {
+	if p.config.FrequencySnapMHz <= 0 {
+		return data
+	}
+
+	snapped := make(FrequencyResidencyData, len(data))
+	for _, pair := range data.SortedPairs() {
+		snapped[p.frequencyStep(domain, pair.FrequencyMHz)] += pair.Percent
+	}
+	return snapped
+}
+
+// frequencyStep returns the step of domain nearest to freq if it is within
+// Config.FrequencySnapMHz, recording freq as a new step otherwise.
+func (p *Parser) frequencyStep(domain frequencyDomain, freq float64) float64 {
+	steps := p.frequencySteps[domain]
+	i := sort.SearchFloat64s(steps, freq)
+	best, bestDist := 0.0, math.Inf(1)
+	for _, j := range []int{i - 1, i} {
+		if j < 0 || j >= len(steps) {
+			continue
+		}
+		if dist := math.Abs(steps[j] - freq); dist < bestDist {
+			best, bestDist = steps[j], dist
+		}
+	}
+	if bestDist <= p.config.FrequencySnapMHz {
+		return best
+	}
+
+	steps = append(steps, 0)
+	copy(steps[i+1:], steps[i:])
+	steps[i] = freq
+	p.frequencySteps[domain] = steps
+	return freq
+}
+
 func parseGPUStates(stateStr string) GPUSoftwareStateData {
 	states := make(GPUSoftwareStateData)
 
@@ -1010,6 +1539,23 @@ func deriveBusyPercent(activeNs uint64, explicitPercent string, window time.Dura
 	return clampPercent(computed)
 }
 
+// clampNonNegative guards quantities such as power and frequency that can never
+// be negative, replacing corrupted readings with zero.
+func (p *Parser) clampNonNegative(field string, value float64) float64 {
+	if value < 0 {
+		p.logf("powermetrics: clamped negative %s %g to 0", field, value)
+		return 0
+	}
+	return value
+}
+
+func (p *Parser) logf(format string, args ...interface{}) {
+	if p.config.Logger == nil {
+		return
+	}
+	_ = p.config.Logger.Output(2, fmt.Sprintf(format, args...))
+}
+
 func clampPercent(value float64) float64 {
 	if value < 0 {
 		return 0
@@ -1060,6 +1606,22 @@ func convertToNanoseconds(value float64, unit string) uint64 {
 	}
 }
 
+// parsePowerWatts reads a trailing power value in watts, converting "mW"
+// readings. The unit must be checked as a whole: matching a bare "w" would
+// also hit the W of "mW" and read milliwatts as watts.
+func parsePowerWatts(line string) (float64, bool) {
+	lower := strings.ToLower(line)
+	if mw := strings.LastIndex(lower, "mw"); mw != -1 && mw+1 == strings.LastIndex(lower, "w") {
+		val, ok := parseTrailingValue(line, "mw")
+		return val / 1000.0, ok
+	}
+	return parseTrailingValue(line, "w")
+}
+
+// parseTrailingValue returns the last number before the last occurrence of
+// suffix, looking only between the last colon and the first "(" before it.
+// That keeps lines such as "E-Cluster HW active residency: 100.00% (1020 MHz:
+// 75% ...)" at 100.00 even though the last "%" is inside the frequency list.
 func parseTrailingValue(line, suffix string) (float64, bool) {
 	idx := strings.LastIndex(strings.ToLower(line), strings.ToLower(suffix))
 	if idx == -1 {
@@ -1074,19 +1636,37 @@ func parseTrailingValue(line, suffix string) (float64, bool) {
 		segment = segment[colonIdx+1:]
 	}
 
-	matches := numberExtractor.FindAllString(segment, -1)
+	matches := numberExtractor.FindAllStringIndex(segment, -1)
 	if len(matches) == 0 {
 		return 0, false
 	}
 
-	val, err := strconv.ParseFloat(matches[len(matches)-1], 64)
+	last := matches[len(matches)-1]
+	val, err := strconv.ParseFloat(segment[last[0]:last[1]], 64)
 	if err != nil {
 		return 0, false
 	}
 
+	// Keep a leading minus sign so corrupted readings surface as negative
+	// instead of silently turning positive.
+	if isNegativeSign(segment, last[0]) {
+		val = -val
+	}
+
 	return val, true
 }
 
+func isNegativeSign(segment string, numberStart int) bool {
+	if numberStart == 0 || segment[numberStart-1] != '-' {
+		return false
+	}
+	if numberStart == 1 {
+		return true
+	}
+	prev := segment[numberStart-2]
+	return prev == ' ' || prev == '\t' || prev == ':'
+}
+
 func parseLeadingValueAfterColon(line, suffix string) (float64, bool) {
 	colonIdx := strings.Index(line, ":")
 	segment := line
@@ -1115,3 +1695,13 @@ func parseLeadingValueAfterColon(line, suffix string) (float64, bool) {
 
 	return val, true
 }
+
+// normalizeDecimalCommas rewrites comma decimal separators ("15,5 W") to dots.
+// Only commas directly between two digits are touched, so list separators
+// such as "12 packets/s, 345 bytes/s" are left alone.
+func normalizeDecimalCommas(line string) string {
+	if !strings.Contains(line, ",") {
+		return line
+	}
+	return decimalCommaRegex.ReplaceAllString(line, "$1.$2")
+}
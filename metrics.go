@@ -1,15 +1,105 @@
 package powermetrics
 
+import (
+	"strings"
+	"time"
+)
+
 // Metrics represents a single powermetrics sample.
 type Metrics struct {
-	SystemSample       *SystemSample
-	ProcessSamples     []ProcessSample
-	GPUProcessSamples  []GPUProcessSample
-	Clusters           []ClusterInfo
+	// Timestamp is the wall-clock time from the "Sampled system activity"
+	// header; zero until a header has been parsed.
+	Timestamp time.Time
+	// Elapsed is the actual sample window reported in the header, which can
+	// differ from the requested interval; zero until a header has been parsed.
+	Elapsed time.Duration
+	// ReceivedAt is the wall-clock time the stream emitted this Metrics. It
+	// is always set for streamed metrics, even when the input has no sample
+	// headers, so consumers always have a time axis.
+	ReceivedAt time.Time
+	// Sequence numbers the samples a stream emits, starting at 1 and
+	// increasing by one per Metrics, so consumers can detect dropped samples.
+	// It is zero for Metrics that did not come from a stream.
+	Sequence uint64
+	// PowerUnit is Config.PowerUnit, the unit Power and Table present power
+	// readings in when no unit is given; empty means watts. The power
+	// fields themselves always keep the units their names carry.
+	PowerUnit PowerUnit
+	// Host identifies the machine the sample came from: Config.HostLabel, or
+	// the hostname when no label is configured.
+	Host string
+
+	// SystemSample is nil until powermetrics has reported at least one system
+	// value, so JSON distinguishes an absent section (null) from measured
+	// zeros, as it already does for the other pointer sections.
+	SystemSample   *SystemSample
+	ProcessSamples []ProcessSample
+	// DeadTasks is the tasks table's DEAD_TASKS row, which aggregates tasks
+	// that exited during the sample. It is kept out of ProcessSamples and is
+	// useful as a process churn indicator.
+	DeadTasks         *ProcessSample
+	GPUProcessSamples []GPUProcessSample
+	Clusters          []ClusterInfo
+	// CPUPowerByCluster is the CPU power attributed to each cluster (e.g.
+	// "E-Cluster", "P0-Cluster") from the "<name> Power" lines of this
+	// sample, on machines that report them; nil otherwise. The parser logs a
+	// diagnostic when the clusters do not add up to
+	// SystemSample.CPUPowerWatts.
+	CPUPowerByCluster  map[string]float64
 	CPUResidencies     []CPUResidencyMetrics
 	ClusterResidencies []ClusterResidencyMetrics
 	GPUResidency       *GPUResidencyMetrics
 	Network            *NetworkMetrics
 	Disk               *DiskMetrics
 	Interrupts         []InterruptMetrics
+	// RawNetwork and RawDisk hold the unsmoothed rates when the stream was
+	// wrapped with SmoothIO; nil otherwise.
+	RawNetwork *NetworkMetrics
+	RawDisk    *DiskMetrics
+	// Batteries lists every "Battery: percent_charge" reading of the sample
+	// in output order, for machines with more than one battery or output
+	// that repeats the line; SystemSample.BatteryPercent is the first one.
+	Batteries []float64
+
+	// window is the parser's sample window (Config.SampleWindow scaled by
+	// --poweravg), used by the *ThisSample helpers when Elapsed is unknown.
+	window time.Duration
+	// header numbers the sample header the parser stamped this Metrics with,
+	// counting from 1 per parser; zero for Metrics built elsewhere. It lets
+	// DedupSamples tell a repeated header apart from snapshots of one sample.
+	header uint64
+}
+
+// IsLikelyThrottled reports whether the sample shows signs of thermal
+// throttling: either powermetrics reports elevated thermal pressure while at
+// least one CPU is busy at low frequencies, or the pressure level is Heavy or
+// worse. See CPUResidencyMetrics.IsLikelyThrottled for the per-CPU heuristic.
+func (m Metrics) IsLikelyThrottled(maxFreqMHz float64) bool {
+	if m.SystemSample == nil || !m.SystemSample.ThermalPressureElevated() {
+		return false
+	}
+
+	switch strings.ToLower(m.SystemSample.ThermalPressure) {
+	case "heavy", "trapping", "sleeping":
+		return true
+	}
+
+	for _, cpu := range m.CPUResidencies {
+		if cpu.IsLikelyThrottled(maxFreqMHz) {
+			return true
+		}
+	}
+	return false
+}
+
+// GPUProcessBusyTotal sums BusyPercent across GPUProcessSamples. It is meant
+// as a cross-check against GPUResidency.HWActiveResidency: the per-process
+// total should roughly track overall GPU activity, although overlapping work
+// from several processes can push it above the hardware residency.
+func (m Metrics) GPUProcessBusyTotal() float64 {
+	total := 0.0
+	for _, proc := range m.GPUProcessSamples {
+		total += proc.BusyPercent
+	}
+	return total
 }
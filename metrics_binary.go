@@ -0,0 +1,119 @@
+package powermetrics
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"time"
+)
+
+// binaryVersion prefixes the MarshalBinary encoding so the format can evolve
+// without silently misreading older recordings. Version 2 added the presence
+// bits of SystemSample and ProcessSample.
+const binaryVersion byte = 2
+
+// metricsWire carries Metrics through gob. Metrics is held as metricsFields,
+// which has none of its methods, so gob encodes it field by field instead of
+// calling back into MarshalBinary. gob skips unexported fields, so the
+// sections with presence bits travel in their own wire structs.
+type metricsWire struct {
+	Metrics        metricsFields
+	SystemSample   *systemSampleWire
+	ProcessSamples []processSampleWire
+	DeadTasks      *processSampleWire
+	Window         time.Duration
+	Header         uint64
+}
+
+type systemSampleWire struct {
+	Sample         SystemSample
+	Measured       uint32
+	CPUBusyDerived bool
+}
+
+type processSampleWire struct {
+	Sample               ProcessSample
+	EnergyImpactReported bool
+}
+
+func processSampleToWire(s ProcessSample) processSampleWire {
+	return processSampleWire{Sample: s, EnergyImpactReported: s.energyImpactReported}
+}
+
+func (w processSampleWire) sample() ProcessSample {
+	s := w.Sample
+	s.energyImpactReported = w.EnergyImpactReported
+	return s
+}
+
+func metricsToWire(m Metrics) metricsWire {
+	wire := metricsWire{Metrics: metricsFields(m), Window: m.window, Header: m.header}
+	wire.Metrics.SystemSample, wire.Metrics.ProcessSamples, wire.Metrics.DeadTasks = nil, nil, nil
+	if s := m.SystemSample; s != nil {
+		wire.SystemSample = &systemSampleWire{Sample: *s, Measured: uint32(s.measured), CPUBusyDerived: s.cpuBusyDerived}
+	}
+	if m.ProcessSamples != nil {
+		wire.ProcessSamples = make([]processSampleWire, len(m.ProcessSamples))
+		for i, sample := range m.ProcessSamples {
+			wire.ProcessSamples[i] = processSampleToWire(sample)
+		}
+	}
+	if m.DeadTasks != nil {
+		dead := processSampleToWire(*m.DeadTasks)
+		wire.DeadTasks = &dead
+	}
+	return wire
+}
+
+func (w metricsWire) metrics() Metrics {
+	m := Metrics(w.Metrics)
+	m.window, m.header = w.Window, w.Header
+	if w.SystemSample != nil {
+		s := w.SystemSample.Sample
+		s.measured = systemField(w.SystemSample.Measured)
+		s.cpuBusyDerived = w.SystemSample.CPUBusyDerived
+		m.SystemSample = &s
+	}
+	if w.ProcessSamples != nil {
+		m.ProcessSamples = make([]ProcessSample, len(w.ProcessSamples))
+		for i, sample := range w.ProcessSamples {
+			m.ProcessSamples[i] = sample.sample()
+		}
+	}
+	if w.DeadTasks != nil {
+		dead := w.DeadTasks.sample()
+		m.DeadTasks = &dead
+	}
+	return m
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler using a versioned gob
+// encoding, which is considerably smaller and cheaper than JSON and keeps the
+// frequency residency maps intact. Use it to ship samples over a wire or to
+// record them to a compact file for later replay.
+func (m Metrics) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(binaryVersion)
+	if err := gob.NewEncoder(&buf).Encode(metricsToWire(m)); err != nil {
+		return nil, fmt.Errorf("encode metrics: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for data produced by
+// MarshalBinary.
+func (m *Metrics) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("decode metrics: empty input")
+	}
+	if data[0] != binaryVersion {
+		return fmt.Errorf("decode metrics: unsupported encoding version %d", data[0])
+	}
+
+	var wire metricsWire
+	if err := gob.NewDecoder(bytes.NewReader(data[1:])).Decode(&wire); err != nil {
+		return fmt.Errorf("decode metrics: %w", err)
+	}
+	*m = wire.metrics()
+	return nil
+}
@@ -1,7 +1,9 @@
 package powermetrics
 
+import "encoding/json"
+
 // CPUResidencyData represents frequency residency percentages for a CPU.
-type CPUResidencyData map[float64]float64
+type CPUResidencyData = FrequencyResidencyData
 
 // CPUResidencyMetrics captures detailed CPU residency information.
 type CPUResidencyMetrics struct {
@@ -12,12 +14,66 @@ type CPUResidencyMetrics struct {
 	Frequency       float64
 }
 
+// sortedCPUResidency encodes a CPUResidencyMetrics for
+// JSONOptions.SortedResidency.
+type sortedCPUResidency CPUResidencyMetrics
+
+// MarshalJSON encodes ActiveResidency as a frequency-ordered array.
+func (c sortedCPUResidency) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		CPUResidencyMetrics
+		ActiveResidency []ResidencyPair
+	}{CPUResidencyMetrics(c), c.ActiveResidency.SortedPairs()})
+}
+
+const (
+	// throttleBusyPercent is the active residency above which a CPU is
+	// considered to be under a heavy workload.
+	throttleBusyPercent = 50.0
+	// throttleLowFreqRatio marks frequencies below this fraction of the
+	// maximum as "low" for throttling detection.
+	throttleLowFreqRatio = 0.5
+)
+
+// IsLikelyThrottled reports whether the CPU is busy but spends most of its
+// active residency at low frequencies. Frequencies below half of maxFreqMHz
+// count as low; when maxFreqMHz is not positive, the highest frequency in the
+// residency map is used instead.
+func (c CPUResidencyMetrics) IsLikelyThrottled(maxFreqMHz float64) bool {
+	active := CalculateTotalActive(c.ActiveResidency)
+	if active < throttleBusyPercent {
+		return false
+	}
+
+	if maxFreqMHz <= 0 {
+		for freq := range c.ActiveResidency {
+			if freq > maxFreqMHz {
+				maxFreqMHz = freq
+			}
+		}
+	}
+	if maxFreqMHz <= 0 {
+		return false
+	}
+
+	low := 0.0
+	for freq, percent := range c.ActiveResidency {
+		if freq < maxFreqMHz*throttleLowFreqRatio {
+			low += percent
+		}
+	}
+	return low > active/2
+}
+
 // ClusterInfo captures summary information about a CPU cluster.
 type ClusterInfo struct {
 	Name          string
 	Type          string // "Performance" or "Efficiency"
 	OnlinePercent float64
 	HWActiveFreq  float64
+	// PowerWatts is the "<name> Power" reading, which only some machines
+	// report per cluster; zero otherwise.
+	PowerWatts float64
 }
 
 // ClusterResidencyMetrics captures detailed cluster residency information.
@@ -27,7 +83,77 @@ type ClusterResidencyMetrics struct {
 	OnlinePercent         float64
 	HWActiveFreq          float64
 	HWActiveResidency     float64
-	HWActiveFreqResidency map[float64]float64
+	HWActiveFreqResidency FrequencyResidencyData
 	IdleResidency         float64
 	DownResidency         float64
+	PowerWatts            float64
+}
+
+// sortedClusterResidency encodes a ClusterResidencyMetrics for
+// JSONOptions.SortedResidency.
+type sortedClusterResidency ClusterResidencyMetrics
+
+// MarshalJSON encodes HWActiveFreqResidency as a frequency-ordered array.
+func (c sortedClusterResidency) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ClusterResidencyMetrics
+		HWActiveFreqResidency []ResidencyPair
+	}{ClusterResidencyMetrics(c), c.HWActiveFreqResidency.SortedPairs()})
+}
+
+// BusyPercent returns the share of the sample the cluster was active, clamped
+// to 0-100. It uses HWActiveResidency when reported and otherwise derives it
+// as 100 - IdleResidency - DownResidency; with no residency data it is 0.
+func (c ClusterResidencyMetrics) BusyPercent() float64 {
+	if c.HWActiveResidency > 0 {
+		return clampPercent(c.HWActiveResidency)
+	}
+	if c.IdleResidency == 0 && c.DownResidency == 0 {
+		return 0
+	}
+	return clampPercent(100 - c.IdleResidency - c.DownResidency)
+}
+
+// ClusterActivityBalance returns each cluster's share of the sample's CPU
+// activity, in percent, keyed by cluster name. A cluster's activity is its
+// HW active residency, so {"E-Cluster": 100, "P0-Cluster": 0} means all work
+// ran on the efficiency cores. Shares sum to 100; it returns nil when no
+// cluster reported any activity. powermetrics does not say which cluster a
+// CPU belongs to, so per-CPU residency is not used here.
+func (m Metrics) ClusterActivityBalance() map[string]float64 {
+	total := 0.0
+	for _, c := range m.ClusterResidencies {
+		total += c.HWActiveResidency
+	}
+	if total <= 0 {
+		return nil
+	}
+
+	balance := make(map[string]float64, len(m.ClusterResidencies))
+	for _, c := range m.ClusterResidencies {
+		balance[c.Name] = c.HWActiveResidency / total * 100
+	}
+	return balance
+}
+
+// WeightedSystemFrequencyMHz returns the mean cluster frequency weighted by
+// each cluster's OnlinePercent, so a cluster that was offline for part of the
+// sample counts for that much less and a fully offline one not at all. It
+// uses ClusterResidencies and returns 0 when no cluster reported both a
+// frequency and a positive online percentage.
+func (m Metrics) WeightedSystemFrequencyMHz() float64 {
+	weighted := 0.0
+	weight := 0.0
+	for _, c := range m.ClusterResidencies {
+		if c.HWActiveFreq <= 0 || c.OnlinePercent <= 0 {
+			continue
+		}
+		online := clampPercent(c.OnlinePercent)
+		weighted += c.HWActiveFreq * online
+		weight += online
+	}
+	if weight == 0 {
+		return 0
+	}
+	return weighted / weight
 }
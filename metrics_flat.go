@@ -0,0 +1,178 @@
+package powermetrics
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FlatRow flattens the sample into a single-level key/value map suitable for
+// columnar export (CSV, Arrow, Parquet, pandas/Polars). The fixed keys below
+// are always present so every row shares the same columns; values for
+// sections missing from this sample are nil. Per-CPU and per-cluster keys
+// (e.g. "cpu0.busy_pct", "e-cluster.online_pct") are present for each CPU or
+// cluster in the sample, which is stable for a given machine. The "_w"
+// power values are in watts.
+func (m Metrics) FlatRow() map[string]interface{} {
+	row := make(map[string]interface{}, 64)
+
+	row["host"] = nil
+	if m.Host != "" {
+		row["host"] = m.Host
+	}
+	row["timestamp"] = nil
+	if !m.Timestamp.IsZero() {
+		row["timestamp"] = m.Timestamp
+	}
+	row["received_at"] = nil
+	if !m.ReceivedAt.IsZero() {
+		row["received_at"] = m.ReceivedAt
+	}
+	row["elapsed_ms"] = nil
+	if m.Elapsed > 0 {
+		row["elapsed_ms"] = float64(m.Elapsed) / 1e6
+	}
+
+	systemKeys := []string{
+		"cpu.power_w", "cpu.freq_mhz", "cpu.temp_c", "cpu.busy_pct",
+		"gpu.power_w", "gpu.freq_mhz", "gpu.temp_c", "gpu.busy_pct",
+		"ane.power_w", "ane.busy_pct", "dram.power_w",
+		"dram.read_gb_s", "dram.write_gb_s",
+		"package.power_w", "package.energy_j", "cpu.energy_j", "gpu.energy_j",
+		"battery.pct", "backlight.pct", "ac.on", "wakeups_s", "thermal.pressure",
+	}
+	for _, key := range systemKeys {
+		row[key] = nil
+	}
+	if s := m.SystemSample; s != nil {
+		row["cpu.power_w"] = s.CPUPowerWatts
+		row["cpu.freq_mhz"] = s.CPUFrequencyMHz
+		row["cpu.temp_c"] = s.CPUTemperatureC
+		row["cpu.busy_pct"] = s.CPUBusyPercent
+		row["gpu.power_w"] = s.GPUPowerWatts
+		row["gpu.freq_mhz"] = s.GPUFrequencyMHz
+		row["gpu.temp_c"] = s.GPUTemperatureC
+		row["gpu.busy_pct"] = s.GPUBusyPercent
+		row["ane.power_w"] = s.ANEPowerWatts
+		row["ane.busy_pct"] = s.ANEBusyPercent
+		row["dram.power_w"] = s.DRAMPowerWatts
+		row["dram.read_gb_s"] = s.DRAMReadBandwidthGBs
+		row["dram.write_gb_s"] = s.DRAMWriteBandwidthGBs
+		row["package.power_w"] = s.PackagePowerWatts
+		row["package.energy_j"] = s.PackageEnergyJoules
+		row["cpu.energy_j"] = s.CPUEnergyJoules
+		row["gpu.energy_j"] = s.GPUEnergyJoules
+		row["battery.pct"] = s.BatteryPercent
+		row["backlight.pct"] = s.BacklightPercent
+		if s.OnACReported() {
+			row["ac.on"] = s.OnAC
+		}
+		row["wakeups_s"] = s.SystemWakeupsPerSec
+		row["thermal.pressure"] = s.ThermalPressure
+	}
+
+	row["gpu.active_pct"] = nil
+	row["gpu.idle_pct"] = nil
+	if g := m.GPUResidency; g != nil {
+		row["gpu.active_pct"] = g.HWActiveResidency
+		row["gpu.idle_pct"] = g.IdleResidency
+	}
+
+	row["net.in_packets_s"] = nil
+	row["net.in_bytes_s"] = nil
+	row["net.out_packets_s"] = nil
+	row["net.out_bytes_s"] = nil
+	if n := m.Network; n != nil {
+		row["net.in_packets_s"] = n.InPacketsPerSec
+		row["net.in_bytes_s"] = n.InBytesPerSec
+		row["net.out_packets_s"] = n.OutPacketsPerSec
+		row["net.out_bytes_s"] = n.OutBytesPerSec
+	}
+
+	row["disk.read_ops_s"] = nil
+	row["disk.read_bytes_s"] = nil
+	row["disk.write_ops_s"] = nil
+	row["disk.write_bytes_s"] = nil
+	if d := m.Disk; d != nil {
+		row["disk.read_ops_s"] = d.ReadOpsPerSec
+		row["disk.read_bytes_s"] = d.ReadBytesPerSec
+		row["disk.write_ops_s"] = d.WriteOpsPerSec
+		row["disk.write_bytes_s"] = d.WriteBytesPerSec
+	}
+
+	for _, cpu := range m.CPUResidencies {
+		prefix := fmt.Sprintf("cpu%d.", cpu.CPUID)
+		row[prefix+"busy_pct"] = CalculateTotalActive(cpu.ActiveResidency)
+		row[prefix+"idle_pct"] = cpu.IdleResidency
+		row[prefix+"down_pct"] = cpu.DownResidency
+		row[prefix+"freq_mhz"] = cpu.Frequency
+	}
+
+	for _, intr := range m.Interrupts {
+		prefix := fmt.Sprintf("cpu%d.", intr.CPUID)
+		row[prefix+"irq_s"] = intr.TotalIRQ
+		row[prefix+"ipi_s"] = intr.IPI
+		row[prefix+"timer_s"] = intr.TIMER
+	}
+
+	for _, cluster := range m.Clusters {
+		prefix := strings.ToLower(cluster.Name) + "."
+		row[prefix+"online_pct"] = cluster.OnlinePercent
+		row[prefix+"freq_mhz"] = cluster.HWActiveFreq
+	}
+
+	return row
+}
+
+// scalarLineFields are the SystemSample values AppendScalarLine writes, in
+// order, after the timestamp, with the bit recording that each was measured.
+var scalarLineFields = []struct {
+	key      string
+	measured systemField
+	value    func(*SystemSample) float64
+}{
+	{"cpu_w", measuredCPUPower, func(s *SystemSample) float64 { return s.CPUPowerWatts }},
+	{"gpu_w", measuredGPUPower, func(s *SystemSample) float64 { return s.GPUPowerWatts }},
+	{"ane_w", measuredANEPower, func(s *SystemSample) float64 { return s.ANEPowerWatts }},
+	{"dram_w", measuredDRAMPower, func(s *SystemSample) float64 { return s.DRAMPowerWatts }},
+	{"cpu_mhz", measuredCPUFrequency, func(s *SystemSample) float64 { return s.CPUFrequencyMHz }},
+	{"gpu_mhz", measuredGPUFrequency, func(s *SystemSample) float64 { return s.GPUFrequencyMHz }},
+	{"cpu_c", measuredCPUTemperature, func(s *SystemSample) float64 { return s.CPUTemperatureC }},
+	{"gpu_c", measuredGPUTemperature, func(s *SystemSample) float64 { return s.GPUTemperatureC }},
+	{"batt_pct", measuredBattery, func(s *SystemSample) float64 { return s.BatteryPercent }},
+}
+
+// AppendScalarLine appends a compact one-line summary of the power,
+// frequency, temperature and battery readings to b and returns the extended
+// buffer, for high-frequency logging where marshaling the whole Metrics is
+// too heavy. The format is fixed:
+//
+//	ts=<unix ms> cpu_w=<f> gpu_w=<f> ane_w=<f> dram_w=<f> cpu_mhz=<f> gpu_mhz=<f> cpu_c=<f> gpu_c=<f> batt_pct=<f>\n
+//
+// with three decimals per value and power in watts. ts is Timestamp, or
+// ReceivedAt for input without sample headers, and "-" when neither is set. A value is "-" when
+// powermetrics did not report it, or when the sample has no SystemSample.
+// Reusing b across calls avoids allocating.
+func (m Metrics) AppendScalarLine(b []byte) []byte {
+	at := m.Timestamp
+	if at.IsZero() {
+		at = m.ReceivedAt
+	}
+	b = append(b, "ts="...)
+	if at.IsZero() {
+		b = append(b, '-')
+	} else {
+		b = strconv.AppendInt(b, at.UnixMilli(), 10)
+	}
+	for _, field := range scalarLineFields {
+		b = append(b, ' ')
+		b = append(b, field.key...)
+		b = append(b, '=')
+		if m.SystemSample == nil || m.SystemSample.measured&field.measured == 0 {
+			b = append(b, '-')
+			continue
+		}
+		b = strconv.AppendFloat(b, field.value(m.SystemSample), 'f', 3, 64)
+	}
+	return append(b, '\n')
+}
@@ -1,16 +1,39 @@
 package powermetrics
 
+import (
+	"encoding/json"
+	"strings"
+)
+
 // GPUSoftwareStateData represents software state residency percentages.
 type GPUSoftwareStateData map[string]float64
 
 // GPUResidencyMetrics captures detailed GPU residency information.
 type GPUResidencyMetrics struct {
-	HWActiveResidency     float64
-	HWActiveFreqResidency map[float64]float64
+	HWActiveResidency float64
+	// HWActiveFreqMHz is the GPU frequency, whichever label powermetrics
+	// printed it under; it always matches SystemSample.GPUFrequencyMHz.
+	HWActiveFreqMHz       float64
+	HWActiveFreqResidency FrequencyResidencyData
 	SWRequestedStates     GPUSoftwareStateData
 	SWStates              GPUSoftwareStateData
-	IdleResidency         float64
-	PowerMilliwatts       float64
+	// CStates holds the "GPU C-state" residency distribution reported by
+	// some GPUs alongside the P-states; nil when the block is absent.
+	CStates         GPUSoftwareStateData
+	IdleResidency   float64
+	PowerMilliwatts float64
+}
+
+// sortedGPUResidency encodes a GPUResidencyMetrics for
+// JSONOptions.SortedResidency.
+type sortedGPUResidency GPUResidencyMetrics
+
+// MarshalJSON encodes HWActiveFreqResidency as a frequency-ordered array.
+func (g sortedGPUResidency) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		GPUResidencyMetrics
+		HWActiveFreqResidency []ResidencyPair
+	}{GPUResidencyMetrics(g), g.HWActiveFreqResidency.SortedPairs()})
 }
 
 // GPUProcessSample captures per-process GPU metrics.
@@ -21,3 +44,88 @@ type GPUProcessSample struct {
 	ActiveNanos  uint64
 	FrequencyMHz float64
 }
+
+// PowerWatts returns the GPU power in watts. It is parsed from the same
+// "GPU Power" line as SystemSample.GPUPowerWatts, so the two agree.
+func (g GPUResidencyMetrics) PowerWatts() float64 {
+	return g.PowerMilliwatts / 1000.0
+}
+
+// LoadScore blends activity and power draw into a single 0-100 GPU load
+// figure:
+//
+//	score = (HWActiveResidency + 100 * min(power / maxPowerMilliwatts, 1)) / 2
+//
+// where power is the GPU power in milliwatts. Both halves are clamped to
+// 0-100, so a GPU pinned at full residency but drawing half its budget
+// scores 75. When maxPowerMilliwatts is not positive the score is the
+// active residency alone.
+func (g GPUResidencyMetrics) LoadScore(maxPowerMilliwatts float64) float64 {
+	active := clampPercent(g.HWActiveResidency)
+	if maxPowerMilliwatts <= 0 {
+		return active
+	}
+	power := clampPercent(g.PowerWatts() * 1000 / maxPowerMilliwatts * 100)
+	return (active + power) / 2
+}
+
+// FilterGPUProcesses returns the GPU process samples for which pred reports
+// true, in their original order. It returns nil when none match.
+func (m Metrics) FilterGPUProcesses(pred func(GPUProcessSample) bool) []GPUProcessSample {
+	var matched []GPUProcessSample
+	for _, proc := range m.GPUProcessSamples {
+		if pred(proc) {
+			matched = append(matched, proc)
+		}
+	}
+	return matched
+}
+
+// GPUProcessesByName aggregates GPUProcessSamples by name, e.g. to total the
+// GPU work of several helper processes. BusyPercent and ActiveNanos are
+// summed. Names are compared in the form the parser stores them, without the
+// parentheses powermetrics puts around some of them, so "(WindowServer)" and
+// "WindowServer" are one entry keyed and named "WindowServer". PID is set when
+// a single process has the name and 0 otherwise. It returns nil when there
+// are no GPU process samples.
+func (m Metrics) GPUProcessesByName() map[string]GPUProcessSample {
+	if len(m.GPUProcessSamples) == 0 {
+		return nil
+	}
+
+	byName := make(map[string]GPUProcessSample, len(m.GPUProcessSamples))
+	for _, proc := range m.GPUProcessSamples {
+		name := gpuProcessName(proc.Name)
+		total, ok := byName[name]
+		if !ok {
+			proc.Name = name
+			byName[name] = proc
+			continue
+		}
+		total.PID = 0
+		total.BusyPercent += proc.BusyPercent
+		total.ActiveNanos += proc.ActiveNanos
+		byName[name] = total
+	}
+	return byName
+}
+
+// gpuProcessName returns name without surrounding whitespace and the
+// parentheses powermetrics prints around some GPU process names.
+func gpuProcessName(name string) string {
+	return strings.Trim(strings.TrimSpace(name), "()")
+}
+
+// ByBusyAtLeast matches GPU processes whose BusyPercent is at least pct.
+func ByBusyAtLeast(pct float64) func(GPUProcessSample) bool {
+	return func(proc GPUProcessSample) bool {
+		return proc.BusyPercent >= pct
+	}
+}
+
+// ByNameContains matches GPU processes whose Name contains substr.
+func ByNameContains(substr string) func(GPUProcessSample) bool {
+	return func(proc GPUProcessSample) bool {
+		return strings.Contains(proc.Name, substr)
+	}
+}
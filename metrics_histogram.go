@@ -0,0 +1,67 @@
+package powermetrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// residencyHistogramName is the metric family written by
+// WriteResidencyHistogram.
+const residencyHistogramName = "powermetrics_cpu_frequency_residency_percent"
+
+// CPUFrequencyResidency sums ActiveResidency across every CPU in the sample,
+// giving the total residency (in percent of one core, so it can exceed 100)
+// spent at each frequency. It returns nil when the sample has no CPU
+// residency data.
+func (m Metrics) CPUFrequencyResidency() FrequencyResidencyData {
+	var combined FrequencyResidencyData
+	for _, cpu := range m.CPUResidencies {
+		for freq, percent := range cpu.ActiveResidency {
+			if combined == nil {
+				combined = make(FrequencyResidencyData)
+			}
+			combined[freq] += percent
+		}
+	}
+	return combined
+}
+
+// WriteResidencyHistogram writes CPUFrequencyResidency as a Prometheus
+// histogram in the text exposition format, with one bucket per reported
+// frequency (le is the frequency in MHz) and the summed residency as the
+// observation weight. Buckets are cumulative as Prometheus requires, _count
+// is the total residency and _sum is the residency-weighted frequency, which
+// is what Grafana's heatmap panel expects. Nothing is written when the sample
+// has no CPU residency data.
+func (m Metrics) WriteResidencyHistogram(w io.Writer) error {
+	pairs := m.CPUFrequencyResidency().SortedPairs()
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# HELP %s CPU active residency by frequency in MHz, summed across cores.\n", residencyHistogramName)
+	fmt.Fprintf(bw, "# TYPE %s histogram\n", residencyHistogramName)
+
+	cumulative := 0.0
+	sum := 0.0
+	for _, pair := range pairs {
+		cumulative += pair.Percent
+		sum += pair.FrequencyMHz * pair.Percent
+		fmt.Fprintf(bw, "%s_bucket{le=%q} %s\n", residencyHistogramName, formatPromFloat(pair.FrequencyMHz), formatPromFloat(cumulative))
+	}
+	fmt.Fprintf(bw, "%s_bucket{le=\"+Inf\"} %s\n", residencyHistogramName, formatPromFloat(cumulative))
+	fmt.Fprintf(bw, "%s_sum %s\n", residencyHistogramName, formatPromFloat(sum))
+	fmt.Fprintf(bw, "%s_count %s\n", residencyHistogramName, formatPromFloat(cumulative))
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("write residency histogram: %w", err)
+	}
+	return nil
+}
+
+func formatPromFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -1,5 +1,7 @@
 package powermetrics
 
+import "time"
+
 // NetworkMetrics captures network activity statistics.
 type NetworkMetrics struct {
 	InPacketsPerSec  float64
@@ -15,3 +17,71 @@ type DiskMetrics struct {
 	WriteOpsPerSec   float64
 	WriteBytesPerSec float64
 }
+
+// The rates above are already normalized by powermetrics to the actual
+// elapsed window of the sample. The *ThisSample helpers below turn them back
+// into totals for one sample; pass Metrics.Elapsed rather than the requested
+// interval, since the two can differ.
+
+// NetworkBytesThisSample returns the bytes received and sent during this
+// sample: the network rates multiplied by Elapsed, or by the configured
+// sample window when no header reported the elapsed time. It returns zeros
+// when the sample has no network section.
+func (m Metrics) NetworkBytesThisSample() (in, out float64) {
+	if m.Network == nil {
+		return 0, 0
+	}
+	window := m.Elapsed
+	if window <= 0 {
+		window = m.window
+	}
+	return m.Network.InBytesThisSample(window), m.Network.OutBytesThisSample(window)
+}
+
+// InBytesThisSample returns the bytes received during a sample of the given
+// elapsed time.
+func (n NetworkMetrics) InBytesThisSample(elapsed time.Duration) float64 {
+	return n.InBytesPerSec * elapsed.Seconds()
+}
+
+// OutBytesThisSample returns the bytes sent during a sample of the given
+// elapsed time.
+func (n NetworkMetrics) OutBytesThisSample(elapsed time.Duration) float64 {
+	return n.OutBytesPerSec * elapsed.Seconds()
+}
+
+// InPacketsThisSample returns the packets received during a sample of the
+// given elapsed time.
+func (n NetworkMetrics) InPacketsThisSample(elapsed time.Duration) float64 {
+	return n.InPacketsPerSec * elapsed.Seconds()
+}
+
+// OutPacketsThisSample returns the packets sent during a sample of the given
+// elapsed time.
+func (n NetworkMetrics) OutPacketsThisSample(elapsed time.Duration) float64 {
+	return n.OutPacketsPerSec * elapsed.Seconds()
+}
+
+// ReadBytesThisSample returns the bytes read during a sample of the given
+// elapsed time.
+func (d DiskMetrics) ReadBytesThisSample(elapsed time.Duration) float64 {
+	return d.ReadBytesPerSec * elapsed.Seconds()
+}
+
+// WriteBytesThisSample returns the bytes written during a sample of the given
+// elapsed time.
+func (d DiskMetrics) WriteBytesThisSample(elapsed time.Duration) float64 {
+	return d.WriteBytesPerSec * elapsed.Seconds()
+}
+
+// ReadOpsThisSample returns the read operations during a sample of the given
+// elapsed time.
+func (d DiskMetrics) ReadOpsThisSample(elapsed time.Duration) float64 {
+	return d.ReadOpsPerSec * elapsed.Seconds()
+}
+
+// WriteOpsThisSample returns the write operations during a sample of the
+// given elapsed time.
+func (d DiskMetrics) WriteOpsThisSample(elapsed time.Duration) float64 {
+	return d.WriteOpsPerSec * elapsed.Seconds()
+}
@@ -0,0 +1,55 @@
+package powermetrics
+
+import "encoding/json"
+
+// JSONOptions selects how Metrics are encoded as JSON. The zero value
+// encodes every field, exactly like json.Marshal.
+type JSONOptions struct {
+	// OmitUnmeasured leaves SystemSample fields powermetrics did not report
+	// (e.g. temperatures on Apple Silicon) out instead of encoding them as
+	// 0, so downstream stores do not mistake them for real measurements.
+	OmitUnmeasured bool
+	// SortedResidency encodes the CPU, cluster and GPU frequency residency
+	// maps as arrays of {freq, percent} objects ordered by frequency,
+	// instead of objects keyed by the frequency formatted as a string.
+	SortedResidency bool
+}
+
+// metricsFields marshals Metrics without its methods.
+type metricsFields Metrics
+
+// Marshal encodes m as JSON according to o.
+func (o JSONOptions) Marshal(m Metrics) ([]byte, error) {
+	if o == (JSONOptions{}) {
+		return json.Marshal(m)
+	}
+
+	out := struct {
+		metricsFields
+		SystemSample       interface{}
+		CPUResidencies     interface{}
+		ClusterResidencies interface{}
+		GPUResidency       interface{}
+	}{metricsFields(m), m.SystemSample, m.CPUResidencies, m.ClusterResidencies, m.GPUResidency}
+	if o.OmitUnmeasured {
+		out.SystemSample = (*measuredSystemSample)(m.SystemSample)
+	}
+	if o.SortedResidency {
+		if m.CPUResidencies != nil {
+			cpus := make([]sortedCPUResidency, len(m.CPUResidencies))
+			for i, cpu := range m.CPUResidencies {
+				cpus[i] = sortedCPUResidency(cpu)
+			}
+			out.CPUResidencies = cpus
+		}
+		if m.ClusterResidencies != nil {
+			clusters := make([]sortedClusterResidency, len(m.ClusterResidencies))
+			for i, cluster := range m.ClusterResidencies {
+				clusters[i] = sortedClusterResidency(cluster)
+			}
+			out.ClusterResidencies = clusters
+		}
+		out.GPUResidency = (*sortedGPUResidency)(m.GPUResidency)
+	}
+	return json.Marshal(out)
+}
@@ -1,5 +1,13 @@
 package powermetrics
 
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
 // ProcessSample captures per-process CPU metrics from the powermetrics "Running tasks" table.
 type ProcessSample struct {
 	PID               int
@@ -10,4 +18,132 @@ type ProcessSample struct {
 	Deadlines2To5Ms   float64
 	WakeupsInterrupts float64
 	WakeupsPkgIdle    float64
+	// GPUMsPerSec and EnergyImpact are only filled when the tasks table has
+	// "GPU ms/s" and "Energy Impact" columns, e.g. with --show-process-gpu
+	// and --show-process-energy; EnergyImpactReported tells a reported 0
+	// from a missing column.
+	GPUMsPerSec  float64
+	EnergyImpact float64
+	// Path is the full executable path when Config.ResolveProcessPaths is
+	// set and the process could still be looked up; empty otherwise.
+	Path string
+	// energyImpactReported is set when the row had an Energy Impact column.
+	energyImpactReported bool
+}
+
+// EnergyImpactReported reports whether the tasks table had an Energy Impact
+// column, as opposed to EnergyImpact holding its zero default.
+func (s ProcessSample) EnergyImpactReported() bool {
+	return s.energyImpactReported
+}
+
+// processPath caches one resolved PID, keyed by name to notice PID reuse.
+// gen is the tasks table the PID was last listed in.
+type processPath struct {
+	name string
+	path string
+	gen  uint64
+}
+
+// resolveProcessPaths fills in Path for samples, consulting the cache first.
+// Lookups that fail (typically because the process already exited) leave
+// Path empty and are cached too, so they are not retried every sample.
+// Aggregate rows with a negative PID are not looked up, and PIDs missing
+// from samples are evicted so the cache only holds live processes.
+func (p *Parser) resolveProcessPaths(samples []ProcessSample) {
+	resolve := p.config.ProcessPathResolver
+	if resolve == nil {
+		resolve = lookupProcessPath
+	}
+	if p.processPaths == nil {
+		p.processPaths = make(map[int]processPath)
+	}
+
+	p.processPathsGen++
+	for i := range samples {
+		sample := &samples[i]
+		if sample.PID < 0 {
+			continue
+		}
+		cached, ok := p.processPaths[sample.PID]
+		if !ok || cached.name != sample.Name {
+			path, err := resolve(sample.PID)
+			if err != nil {
+				p.logf("powermetrics: resolving path of pid %d (%s): %v", sample.PID, sample.Name, err)
+				path = ""
+			}
+			cached = processPath{name: sample.Name, path: path}
+		}
+		cached.gen = p.processPathsGen
+		p.processPaths[sample.PID] = cached
+		sample.Path = cached.path
+	}
+
+	for pid, cached := range p.processPaths {
+		if cached.gen != p.processPathsGen {
+			delete(p.processPaths, pid)
+		}
+	}
+}
+
+// lookupProcessPath returns the executable path of pid, from /proc where it
+// exists and from ps(1) otherwise (which prints the full path on macOS).
+func lookupProcessPath(pid int) (string, error) {
+	if path, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid)); err == nil {
+		return path, nil
+	}
+	out, err := exec.Command("ps", "-o", "comm=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return "", err
+	}
+	path := strings.TrimSpace(string(out))
+	if path == "" {
+		return "", fmt.Errorf("no process with pid %d", pid)
+	}
+	return path, nil
+}
+
+// ProcessesByName aggregates ProcessSamples by Name, e.g. to total every
+// "Google Chrome Helper" PID. The CPU time, deadline, wakeup, GPU time and
+// energy impact rates are summed; UserPercent is averaged weighted by
+// CPUMsPerSec, since it is a share of each process's own CPU time. PID is
+// set when a single process has the name and 0 otherwise, and Path is kept
+// when every process agrees on it. DeadTasks and the aggregate rows with a
+// negative PID, such as ALL_TASKS, are not included. It returns nil when
+// there are no process samples.
+func (m Metrics) ProcessesByName() map[string]ProcessSample {
+	if len(m.ProcessSamples) == 0 {
+		return nil
+	}
+
+	byName := make(map[string]ProcessSample, len(m.ProcessSamples))
+	userMs := make(map[string]float64, len(m.ProcessSamples))
+	for _, proc := range m.ProcessSamples {
+		if proc.PID < 0 {
+			continue
+		}
+		userMs[proc.Name] += proc.UserPercent * proc.CPUMsPerSec
+		total, ok := byName[proc.Name]
+		if !ok {
+			byName[proc.Name] = proc
+			continue
+		}
+		total.PID = 0
+		if total.Path != proc.Path {
+			total.Path = ""
+		}
+		total.CPUMsPerSec += proc.CPUMsPerSec
+		total.DeadlinesLT2Ms += proc.DeadlinesLT2Ms
+		total.Deadlines2To5Ms += proc.Deadlines2To5Ms
+		total.WakeupsInterrupts += proc.WakeupsInterrupts
+		total.WakeupsPkgIdle += proc.WakeupsPkgIdle
+		total.GPUMsPerSec += proc.GPUMsPerSec
+		total.EnergyImpact += proc.EnergyImpact
+		total.energyImpactReported = total.energyImpactReported || proc.energyImpactReported
+		if total.CPUMsPerSec > 0 {
+			total.UserPercent = userMs[proc.Name] / total.CPUMsPerSec
+		}
+		byName[proc.Name] = total
+	}
+	return byName
 }
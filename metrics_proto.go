@@ -0,0 +1,413 @@
+package powermetrics
+
+import (
+	"time"
+
+	pb "github.com/BinSquare/powermetrics-go/proto"
+)
+
+// ToProto converts m to its protobuf form (see proto/metrics.proto) for
+// shipping over gRPC pipelines. Frequency residency maps, whose float keys
+// protobuf cannot express, become repeated entries ordered by frequency.
+// Empty slices and maps convert to nil, which is all the wire format can
+// tell apart.
+func (m Metrics) ToProto() *pb.Metrics {
+	out := &pb.Metrics{
+		TimestampUnixNano:  unixNano(m.Timestamp),
+		ElapsedNanos:       int64(m.Elapsed),
+		ReceivedAtUnixNano: unixNano(m.ReceivedAt),
+		Sequence:           m.Sequence,
+		PowerUnit:          string(m.PowerUnit),
+		Host:               m.Host,
+		CPUPowerByCluster:  copyStringMap(m.CPUPowerByCluster),
+	}
+	if s := m.SystemSample; s != nil {
+		out.SystemSample = systemSampleToProto(s)
+	}
+	for _, s := range m.ProcessSamples {
+		out.ProcessSamples = append(out.ProcessSamples, processSampleToProto(s))
+	}
+	if m.DeadTasks != nil {
+		out.DeadTasks = processSampleToProto(*m.DeadTasks)
+	}
+	for _, s := range m.GPUProcessSamples {
+		out.GPUProcessSamples = append(out.GPUProcessSamples, &pb.GPUProcessSample{
+			PID:          int64(s.PID),
+			Name:         s.Name,
+			BusyPercent:  s.BusyPercent,
+			ActiveNanos:  s.ActiveNanos,
+			FrequencyMHz: s.FrequencyMHz,
+		})
+	}
+	for _, c := range m.Clusters {
+		out.Clusters = append(out.Clusters, &pb.ClusterInfo{
+			Name:          c.Name,
+			Type:          c.Type,
+			OnlinePercent: c.OnlinePercent,
+			HWActiveFreq:  c.HWActiveFreq,
+			PowerWatts:    c.PowerWatts,
+		})
+	}
+	for _, c := range m.CPUResidencies {
+		out.CPUResidencies = append(out.CPUResidencies, &pb.CPUResidency{
+			CPUID:           int64(c.CPUID),
+			ActiveResidency: residencyToProto(c.ActiveResidency),
+			IdleResidency:   c.IdleResidency,
+			DownResidency:   c.DownResidency,
+			Frequency:       c.Frequency,
+		})
+	}
+	for _, c := range m.ClusterResidencies {
+		out.ClusterResidencies = append(out.ClusterResidencies, &pb.ClusterResidency{
+			Name:                  c.Name,
+			Type:                  c.Type,
+			OnlinePercent:         c.OnlinePercent,
+			HWActiveFreq:          c.HWActiveFreq,
+			HWActiveResidency:     c.HWActiveResidency,
+			HWActiveFreqResidency: residencyToProto(c.HWActiveFreqResidency),
+			IdleResidency:         c.IdleResidency,
+			DownResidency:         c.DownResidency,
+			PowerWatts:            c.PowerWatts,
+		})
+	}
+	if g := m.GPUResidency; g != nil {
+		out.GPUResidency = &pb.GPUResidency{
+			HWActiveResidency:     g.HWActiveResidency,
+			HWActiveFreqResidency: residencyToProto(g.HWActiveFreqResidency),
+			SWRequestedStates:     copyStringMap(g.SWRequestedStates),
+			SWStates:              copyStringMap(g.SWStates),
+			CStates:               copyStringMap(g.CStates),
+			IdleResidency:         g.IdleResidency,
+			PowerMilliwatts:       g.PowerMilliwatts,
+			HWActiveFreqMHz:       g.HWActiveFreqMHz,
+		}
+	}
+	out.Network = networkToProto(m.Network)
+	out.Disk = diskToProto(m.Disk)
+	for _, i := range m.Interrupts {
+		out.Interrupts = append(out.Interrupts, &pb.InterruptMetrics{
+			CPUID:    int64(i.CPUID),
+			TotalIRQ: i.TotalIRQ,
+			IPI:      i.IPI,
+			TIMER:    i.TIMER,
+		})
+	}
+	out.RawNetwork = networkToProto(m.RawNetwork)
+	out.RawDisk = diskToProto(m.RawDisk)
+	if len(m.Batteries) > 0 {
+		out.Batteries = append([]float64(nil), m.Batteries...)
+	}
+	return out
+}
+
+// MetricsFromProto converts the protobuf form produced by ToProto back to
+// Metrics, including which SystemSample fields were measured.
+func MetricsFromProto(in *pb.Metrics) Metrics {
+	if in == nil {
+		return Metrics{}
+	}
+	m := Metrics{
+		Timestamp:         fromUnixNano(in.TimestampUnixNano),
+		Elapsed:           time.Duration(in.ElapsedNanos),
+		ReceivedAt:        fromUnixNano(in.ReceivedAtUnixNano),
+		Sequence:          in.Sequence,
+		PowerUnit:         PowerUnit(in.PowerUnit),
+		Host:              in.Host,
+		CPUPowerByCluster: copyStringMap(in.CPUPowerByCluster),
+	}
+	if s := in.SystemSample; s != nil {
+		m.SystemSample = systemSampleFromProto(s)
+	}
+	for _, s := range in.ProcessSamples {
+		m.ProcessSamples = append(m.ProcessSamples, processSampleFromProto(s))
+	}
+	if in.DeadTasks != nil {
+		dead := processSampleFromProto(in.DeadTasks)
+		m.DeadTasks = &dead
+	}
+	for _, s := range in.GPUProcessSamples {
+		m.GPUProcessSamples = append(m.GPUProcessSamples, GPUProcessSample{
+			PID:          int(s.PID),
+			Name:         s.Name,
+			BusyPercent:  s.BusyPercent,
+			ActiveNanos:  s.ActiveNanos,
+			FrequencyMHz: s.FrequencyMHz,
+		})
+	}
+	for _, c := range in.Clusters {
+		m.Clusters = append(m.Clusters, ClusterInfo{
+			Name:          c.Name,
+			Type:          c.Type,
+			OnlinePercent: c.OnlinePercent,
+			HWActiveFreq:  c.HWActiveFreq,
+			PowerWatts:    c.PowerWatts,
+		})
+	}
+	for _, c := range in.CPUResidencies {
+		m.CPUResidencies = append(m.CPUResidencies, CPUResidencyMetrics{
+			CPUID:           int(c.CPUID),
+			ActiveResidency: residencyFromProto(c.ActiveResidency),
+			IdleResidency:   c.IdleResidency,
+			DownResidency:   c.DownResidency,
+			Frequency:       c.Frequency,
+		})
+	}
+	for _, c := range in.ClusterResidencies {
+		m.ClusterResidencies = append(m.ClusterResidencies, ClusterResidencyMetrics{
+			Name:                  c.Name,
+			Type:                  c.Type,
+			OnlinePercent:         c.OnlinePercent,
+			HWActiveFreq:          c.HWActiveFreq,
+			HWActiveResidency:     c.HWActiveResidency,
+			HWActiveFreqResidency: residencyFromProto(c.HWActiveFreqResidency),
+			IdleResidency:         c.IdleResidency,
+			DownResidency:         c.DownResidency,
+			PowerWatts:            c.PowerWatts,
+		})
+	}
+	if g := in.GPUResidency; g != nil {
+		m.GPUResidency = &GPUResidencyMetrics{
+			HWActiveResidency:     g.HWActiveResidency,
+			HWActiveFreqResidency: residencyFromProto(g.HWActiveFreqResidency),
+			SWRequestedStates:     copyStringMap(g.SWRequestedStates),
+			SWStates:              copyStringMap(g.SWStates),
+			CStates:               copyStringMap(g.CStates),
+			IdleResidency:         g.IdleResidency,
+			PowerMilliwatts:       g.PowerMilliwatts,
+			HWActiveFreqMHz:       g.HWActiveFreqMHz,
+		}
+	}
+	m.Network = networkFromProto(in.Network)
+	m.Disk = diskFromProto(in.Disk)
+	for _, i := range in.Interrupts {
+		m.Interrupts = append(m.Interrupts, InterruptMetrics{
+			CPUID:    int(i.CPUID),
+			TotalIRQ: i.TotalIRQ,
+			IPI:      i.IPI,
+			TIMER:    i.TIMER,
+		})
+	}
+	m.RawNetwork = networkFromProto(in.RawNetwork)
+	m.RawDisk = diskFromProto(in.RawDisk)
+	if len(in.Batteries) > 0 {
+		m.Batteries = append([]float64(nil), in.Batteries...)
+	}
+	return m
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// copyStringMap copies a string-keyed map, returning nil for an empty one.
+func copyStringMap[M ~map[string]float64](src M) M {
+	if len(src) == 0 {
+		return nil
+	}
+	dst := make(M, len(src))
+	for key, value := range src {
+		dst[key] = value
+	}
+	return dst
+}
+
+func residencyToProto(d FrequencyResidencyData) []*pb.FrequencyResidency {
+	if len(d) == 0 {
+		return nil
+	}
+	out := make([]*pb.FrequencyResidency, 0, len(d))
+	for _, pair := range d.SortedPairs() {
+		out = append(out, &pb.FrequencyResidency{FrequencyMHz: pair.FrequencyMHz, Percent: pair.Percent})
+	}
+	return out
+}
+
+func residencyFromProto(entries []*pb.FrequencyResidency) FrequencyResidencyData {
+	if len(entries) == 0 {
+		return nil
+	}
+	d := make(FrequencyResidencyData, len(entries))
+	for _, entry := range entries {
+		d[entry.FrequencyMHz] = entry.Percent
+	}
+	return d
+}
+
+// systemProtoFields pairs each optional double of pb.SystemSample with the
+// SystemSample field it carries and the bit recording that it was measured.
+func systemProtoFields(s *SystemSample, p *pb.SystemSample) []struct {
+	measured systemField
+	value    *float64
+	proto    **float64
+} {
+	return []struct {
+		measured systemField
+		value    *float64
+		proto    **float64
+	}{
+		{measuredCPUPower, &s.CPUPowerWatts, &p.CPUPowerWatts},
+		{measuredCPUFrequency, &s.CPUFrequencyMHz, &p.CPUFrequencyMHz},
+		{measuredCPUBusy, &s.CPUBusyPercent, &p.CPUBusyPercent},
+		{measuredGPUBusy, &s.GPUBusyPercent, &p.GPUBusyPercent},
+		{measuredGPUPower, &s.GPUPowerWatts, &p.GPUPowerWatts},
+		{measuredGPUFrequency, &s.GPUFrequencyMHz, &p.GPUFrequencyMHz},
+		{measuredGPUTemperature, &s.GPUTemperatureC, &p.GPUTemperatureC},
+		{measuredCPUTemperature, &s.CPUTemperatureC, &p.CPUTemperatureC},
+		{measuredANEBusy, &s.ANEBusyPercent, &p.ANEBusyPercent},
+		{measuredANEPower, &s.ANEPowerWatts, &p.ANEPowerWatts},
+		{measuredDRAMPower, &s.DRAMPowerWatts, &p.DRAMPowerWatts},
+		{measuredDRAMReadBandwidth, &s.DRAMReadBandwidthGBs, &p.DRAMReadBandwidthGBs},
+		{measuredDRAMWriteBandwidth, &s.DRAMWriteBandwidthGBs, &p.DRAMWriteBandwidthGBs},
+		{measuredBattery, &s.BatteryPercent, &p.BatteryPercent},
+		{measuredBacklight, &s.BacklightPercent, &p.BacklightPercent},
+		{measuredSystemWakeups, &s.SystemWakeupsPerSec, &p.SystemWakeupsPerSec},
+		{measuredPackagePower, &s.PackagePowerWatts, &p.PackagePowerWatts},
+		{measuredPackageEnergy, &s.PackageEnergyJoules, &p.PackageEnergyJoules},
+		{measuredCPUEnergy, &s.CPUEnergyJoules, &p.CPUEnergyJoules},
+		{measuredGPUEnergy, &s.GPUEnergyJoules, &p.GPUEnergyJoules},
+	}
+}
+
+// systemSampleToProto sets the fields powermetrics reported, and a derived
+// CPU busy, leaving the others unset.
+func systemSampleToProto(s *SystemSample) *pb.SystemSample {
+	out := &pb.SystemSample{
+		ThermalPressure: s.ThermalPressure,
+		CPUBusyDerived:  s.cpuBusyDerived,
+	}
+	measured := s.measured
+	if s.cpuBusyDerived {
+		measured |= measuredCPUBusy
+	}
+	for _, f := range systemProtoFields(s, out) {
+		if measured&f.measured != 0 {
+			v := *f.value
+			*f.proto = &v
+		}
+	}
+	if s.OnACReported() {
+		onAC := s.OnAC
+		out.OnAC = &onAC
+	}
+	return out
+}
+
+// systemSampleFromProto restores the measured bits from the set fields.
+func systemSampleFromProto(in *pb.SystemSample) *SystemSample {
+	s := &SystemSample{
+		OnAC:            true,
+		ThermalPressure: in.ThermalPressure,
+		cpuBusyDerived:  in.CPUBusyDerived,
+	}
+	for _, f := range systemProtoFields(s, in) {
+		if *f.proto != nil {
+			*f.value = **f.proto
+			s.mark(f.measured)
+		}
+	}
+	if s.cpuBusyDerived {
+		s.measured &^= measuredCPUBusy
+	}
+	if in.OnAC != nil {
+		s.OnAC = *in.OnAC
+		s.mark(measuredOnAC)
+	}
+	return s
+}
+
+func processSampleToProto(s ProcessSample) *pb.ProcessSample {
+	out := &pb.ProcessSample{
+		PID:               int64(s.PID),
+		Name:              s.Name,
+		CPUMsPerSec:       s.CPUMsPerSec,
+		UserPercent:       s.UserPercent,
+		DeadlinesLT2Ms:    s.DeadlinesLT2Ms,
+		Deadlines2To5Ms:   s.Deadlines2To5Ms,
+		WakeupsInterrupts: s.WakeupsInterrupts,
+		WakeupsPkgIdle:    s.WakeupsPkgIdle,
+		GPUMsPerSec:       s.GPUMsPerSec,
+		Path:              s.Path,
+	}
+	if s.energyImpactReported {
+		energyImpact := s.EnergyImpact
+		out.EnergyImpact = &energyImpact
+	}
+	return out
+}
+
+func processSampleFromProto(s *pb.ProcessSample) ProcessSample {
+	out := ProcessSample{
+		PID:               int(s.PID),
+		Name:              s.Name,
+		CPUMsPerSec:       s.CPUMsPerSec,
+		UserPercent:       s.UserPercent,
+		DeadlinesLT2Ms:    s.DeadlinesLT2Ms,
+		Deadlines2To5Ms:   s.Deadlines2To5Ms,
+		WakeupsInterrupts: s.WakeupsInterrupts,
+		WakeupsPkgIdle:    s.WakeupsPkgIdle,
+		GPUMsPerSec:       s.GPUMsPerSec,
+		Path:              s.Path,
+	}
+	if s.EnergyImpact != nil {
+		out.EnergyImpact, out.energyImpactReported = *s.EnergyImpact, true
+	}
+	return out
+}
+
+func networkToProto(n *NetworkMetrics) *pb.NetworkMetrics {
+	if n == nil {
+		return nil
+	}
+	return &pb.NetworkMetrics{
+		InPacketsPerSec:  n.InPacketsPerSec,
+		InBytesPerSec:    n.InBytesPerSec,
+		OutPacketsPerSec: n.OutPacketsPerSec,
+		OutBytesPerSec:   n.OutBytesPerSec,
+	}
+}
+
+func networkFromProto(n *pb.NetworkMetrics) *NetworkMetrics {
+	if n == nil {
+		return nil
+	}
+	return &NetworkMetrics{
+		InPacketsPerSec:  n.InPacketsPerSec,
+		InBytesPerSec:    n.InBytesPerSec,
+		OutPacketsPerSec: n.OutPacketsPerSec,
+		OutBytesPerSec:   n.OutBytesPerSec,
+	}
+}
+
+func diskToProto(d *DiskMetrics) *pb.DiskMetrics {
+	if d == nil {
+		return nil
+	}
+	return &pb.DiskMetrics{
+		ReadOpsPerSec:    d.ReadOpsPerSec,
+		ReadBytesPerSec:  d.ReadBytesPerSec,
+		WriteOpsPerSec:   d.WriteOpsPerSec,
+		WriteBytesPerSec: d.WriteBytesPerSec,
+	}
+}
+
+func diskFromProto(d *pb.DiskMetrics) *DiskMetrics {
+	if d == nil {
+		return nil
+	}
+	return &DiskMetrics{
+		ReadOpsPerSec:    d.ReadOpsPerSec,
+		ReadBytesPerSec:  d.ReadBytesPerSec,
+		WriteOpsPerSec:   d.WriteOpsPerSec,
+		WriteBytesPerSec: d.WriteBytesPerSec,
+	}
+}
@@ -0,0 +1,46 @@
+package powermetrics
+
+import "sort"
+
+// FrequencyResidencyData maps a frequency in MHz to the percentage of time
+// spent at that frequency. It is shared by CPU, cluster and GPU residency
+// breakdowns so they expose the same helpers.
+type FrequencyResidencyData map[float64]float64
+
+// ResidencyPair is a single frequency/residency entry.
+type ResidencyPair struct {
+	FrequencyMHz float64 `json:"freq"`
+	Percent      float64 `json:"percent"`
+}
+
+// SortedPairs returns the residency entries ordered by ascending frequency.
+func (d FrequencyResidencyData) SortedPairs() []ResidencyPair {
+	pairs := make([]ResidencyPair, 0, len(d))
+	for freq, percent := range d {
+		pairs = append(pairs, ResidencyPair{FrequencyMHz: freq, Percent: percent})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].FrequencyMHz < pairs[j].FrequencyMHz
+	})
+	return pairs
+}
+
+// Total returns the summed residency across all frequencies.
+func (d FrequencyResidencyData) Total() float64 {
+	return CalculateTotalActive(d)
+}
+
+// WeightedMeanMHz returns the residency-weighted average frequency, i.e. the
+// mean frequency while active. It returns 0 when there is no residency.
+func (d FrequencyResidencyData) WeightedMeanMHz() float64 {
+	total := 0.0
+	weighted := 0.0
+	for freq, percent := range d {
+		total += percent
+		weighted += freq * percent
+	}
+	if total == 0 {
+		return 0
+	}
+	return weighted / total
+}
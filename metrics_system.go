@@ -1,9 +1,19 @@
 package powermetrics
 
+import (
+	"encoding/json"
+	"strings"
+)
+
 // SystemSample captures system-level metrics reported by powermetrics.
 type SystemSample struct {
 	CPUPowerWatts   float64
 	CPUFrequencyMHz float64
+	// CPUBusyPercent is the CPU-wide "CPU busy" summary some macOS versions
+	// print alongside the per-core residency. Without that line it is the
+	// mean active residency of the CPUs seen so far (CPUBusyDerived reports
+	// which), and zero when neither is available.
+	CPUBusyPercent  float64
 	GPUBusyPercent  float64
 	GPUPowerWatts   float64
 	GPUFrequencyMHz float64
@@ -12,5 +22,223 @@ type SystemSample struct {
 	ANEBusyPercent  float64
 	ANEPowerWatts   float64
 	DRAMPowerWatts  float64
-	BatteryPercent  float64
+	// DRAMReadBandwidthGBs and DRAMWriteBandwidthGBs are the DRAM read and
+	// write bandwidth in GB/s, which only newer powermetrics versions report.
+	DRAMReadBandwidthGBs  float64
+	DRAMWriteBandwidthGBs float64
+	// BatteryPercent is the first battery reading of the sample; see
+	// Metrics.Batteries for samples with more than one.
+	BatteryPercent float64
+	// BacklightPercent is the display backlight level from the battery
+	// sampler, scaled to 0-100; zero on machines without a built-in display.
+	BacklightPercent float64
+	// SystemWakeupsPerSec is the system-wide wakeup rate from the "Total
+	// wakeups" line, as opposed to the per-CPU interrupt totals.
+	SystemWakeupsPerSec float64
+	// PackagePowerWatts is the combined CPU+GPU package power on Intel Macs,
+	// either reported directly or derived from PackageEnergyJoules.
+	PackagePowerWatts float64
+	// PackageEnergyJoules, CPUEnergyJoules and GPUEnergyJoules are the
+	// cumulative energy counters some Intel machines report. When a sample
+	// carries only counters, the matching power field is derived from the
+	// increase since the previous sample over the sample window.
+	PackageEnergyJoules float64
+	CPUEnergyJoules     float64
+	GPUEnergyJoules     float64
+	// OnAC reports whether the machine runs on external power. It is set from
+	// AC/power-source lines of the battery sampler and defaults to true, since
+	// desktops never report one; OnACReported tells the two cases apart.
+	OnAC bool
+	// ThermalPressure is the level reported by the thermal sampler
+	// (e.g. "Nominal", "Moderate", "Heavy"); empty when not reported.
+	ThermalPressure string
+
+	// measured records which numeric fields powermetrics actually reported.
+	measured systemField
+	// cpuBusyDerived is set when CPUBusyPercent was computed from the
+	// per-core residency rather than reported.
+	cpuBusyDerived bool
+}
+
+// systemField is a bit set of SystemSample fields.
+type systemField uint32
+
+const (
+	measuredCPUPower systemField = 1 << iota
+	measuredCPUFrequency
+	measuredGPUBusy
+	measuredGPUPower
+	measuredGPUFrequency
+	measuredGPUTemperature
+	measuredCPUTemperature
+	measuredANEBusy
+	measuredANEPower
+	measuredDRAMPower
+	measuredDRAMReadBandwidth
+	measuredDRAMWriteBandwidth
+	measuredBattery
+	measuredBacklight
+	measuredSystemWakeups
+	measuredPackagePower
+	measuredPackageEnergy
+	measuredCPUEnergy
+	measuredGPUEnergy
+	measuredOnAC
+	measuredCPUBusy
+)
+
+func (s *SystemSample) mark(field systemField) {
+	s.measured |= field
+}
+
+// systemSampleJSON mirrors SystemSample with every field optional.
+type systemSampleJSON struct {
+	CPUPowerWatts         *float64 `json:",omitempty"`
+	CPUFrequencyMHz       *float64 `json:",omitempty"`
+	CPUBusyPercent        *float64 `json:",omitempty"`
+	GPUBusyPercent        *float64 `json:",omitempty"`
+	GPUPowerWatts         *float64 `json:",omitempty"`
+	GPUFrequencyMHz       *float64 `json:",omitempty"`
+	GPUTemperatureC       *float64 `json:",omitempty"`
+	CPUTemperatureC       *float64 `json:",omitempty"`
+	ANEBusyPercent        *float64 `json:",omitempty"`
+	ANEPowerWatts         *float64 `json:",omitempty"`
+	DRAMPowerWatts        *float64 `json:",omitempty"`
+	DRAMReadBandwidthGBs  *float64 `json:",omitempty"`
+	DRAMWriteBandwidthGBs *float64 `json:",omitempty"`
+	BatteryPercent        *float64 `json:",omitempty"`
+	BacklightPercent      *float64 `json:",omitempty"`
+	SystemWakeupsPerSec   *float64 `json:",omitempty"`
+	PackagePowerWatts     *float64 `json:",omitempty"`
+	PackageEnergyJoules   *float64 `json:",omitempty"`
+	CPUEnergyJoules       *float64 `json:",omitempty"`
+	GPUEnergyJoules       *float64 `json:",omitempty"`
+	OnAC                  *bool    `json:",omitempty"`
+	ThermalPressure       string   `json:",omitempty"`
+}
+
+// measuredSystemSample encodes a SystemSample for
+// JSONOptions.OmitUnmeasured.
+type measuredSystemSample SystemSample
+
+// MarshalJSON leaves out the fields powermetrics never reported.
+func (s measuredSystemSample) MarshalJSON() ([]byte, error) {
+	measured := s.measured
+	if s.cpuBusyDerived {
+		measured |= measuredCPUBusy
+	}
+	value := func(field systemField, v float64) *float64 {
+		if measured&field == 0 {
+			return nil
+		}
+		return &v
+	}
+	var onAC *bool
+	if s.measured&measuredOnAC != 0 {
+		onAC = &s.OnAC
+	}
+	return json.Marshal(systemSampleJSON{
+		CPUPowerWatts:         value(measuredCPUPower, s.CPUPowerWatts),
+		CPUFrequencyMHz:       value(measuredCPUFrequency, s.CPUFrequencyMHz),
+		CPUBusyPercent:        value(measuredCPUBusy, s.CPUBusyPercent),
+		GPUBusyPercent:        value(measuredGPUBusy, s.GPUBusyPercent),
+		GPUPowerWatts:         value(measuredGPUPower, s.GPUPowerWatts),
+		GPUFrequencyMHz:       value(measuredGPUFrequency, s.GPUFrequencyMHz),
+		GPUTemperatureC:       value(measuredGPUTemperature, s.GPUTemperatureC),
+		CPUTemperatureC:       value(measuredCPUTemperature, s.CPUTemperatureC),
+		ANEBusyPercent:        value(measuredANEBusy, s.ANEBusyPercent),
+		ANEPowerWatts:         value(measuredANEPower, s.ANEPowerWatts),
+		DRAMPowerWatts:        value(measuredDRAMPower, s.DRAMPowerWatts),
+		DRAMReadBandwidthGBs:  value(measuredDRAMReadBandwidth, s.DRAMReadBandwidthGBs),
+		DRAMWriteBandwidthGBs: value(measuredDRAMWriteBandwidth, s.DRAMWriteBandwidthGBs),
+		BatteryPercent:        value(measuredBattery, s.BatteryPercent),
+		BacklightPercent:      value(measuredBacklight, s.BacklightPercent),
+		SystemWakeupsPerSec:   value(measuredSystemWakeups, s.SystemWakeupsPerSec),
+		PackagePowerWatts:     value(measuredPackagePower, s.PackagePowerWatts),
+		PackageEnergyJoules:   value(measuredPackageEnergy, s.PackageEnergyJoules),
+		CPUEnergyJoules:       value(measuredCPUEnergy, s.CPUEnergyJoules),
+		GPUEnergyJoules:       value(measuredGPUEnergy, s.GPUEnergyJoules),
+		OnAC:                  onAC,
+		ThermalPressure:       s.ThermalPressure,
+	})
+}
+
+// OnACReported reports whether powermetrics reported the power source, as
+// opposed to OnAC holding its desktop default.
+func (s SystemSample) OnACReported() bool {
+	return s.measured&measuredOnAC != 0
+}
+
+// CPUBusyDerived reports whether CPUBusyPercent was computed from the
+// per-core active residency because powermetrics printed no CPU busy line.
+func (s SystemSample) CPUBusyDerived() bool {
+	return s.cpuBusyDerived
+}
+
+// ThermalPressureElevated reports whether powermetrics reported a thermal
+// pressure level above Nominal.
+func (s SystemSample) ThermalPressureElevated() bool {
+	level := strings.ToLower(strings.TrimSpace(s.ThermalPressure))
+	return level != "" && level != "nominal"
+}
+
+// HottestComponent returns the name ("CPU" or "GPU") and temperature of the
+// hottest reported component. powermetrics does not report per-core
+// temperatures, so only the CPU and GPU readings are compared; ties favor the
+// CPU. When neither temperature is set it returns ("", 0).
+func (s SystemSample) HottestComponent() (string, float64) {
+	switch {
+	case s.CPUTemperatureC <= 0 && s.GPUTemperatureC <= 0:
+		return "", 0
+	case s.GPUTemperatureC > s.CPUTemperatureC:
+		return "GPU", s.GPUTemperatureC
+	default:
+		return "CPU", s.CPUTemperatureC
+	}
+}
+
+// PowerReadings holds every power reading of a sample in a single unit.
+// Readings powermetrics did not report are zero.
+type PowerReadings struct {
+	Unit    PowerUnit
+	Package float64
+	CPU     float64
+	GPU     float64
+	ANE     float64
+	DRAM    float64
+	// Clusters is CPUPowerByCluster in Unit; nil when the sample has no
+	// per-cluster power lines.
+	Clusters map[string]float64
+}
+
+// Power returns the power readings of m in unit, converting the watt
+// fields and GPUResidencyMetrics.PowerMilliwatts alike. An empty unit uses
+// m.PowerUnit, and watts when that is empty too. The stored fields are not
+// changed.
+func (m Metrics) Power(unit PowerUnit) PowerReadings {
+	if unit == "" {
+		unit = m.PowerUnit
+	}
+	if unit == "" {
+		unit = PowerUnitWatts
+	}
+
+	readings := PowerReadings{Unit: unit}
+	if s := m.SystemSample; s != nil {
+		readings.Package = unit.fromWatts(s.PackagePowerWatts)
+		readings.CPU = unit.fromWatts(s.CPUPowerWatts)
+		readings.GPU = unit.fromWatts(s.GPUPowerWatts)
+		readings.ANE = unit.fromWatts(s.ANEPowerWatts)
+		readings.DRAM = unit.fromWatts(s.DRAMPowerWatts)
+	}
+	if g := m.GPUResidency; g != nil && (m.SystemSample == nil || m.SystemSample.measured&measuredGPUPower == 0) {
+		readings.GPU = unit.fromWatts(g.PowerWatts())
+	}
+	if m.CPUPowerByCluster != nil {
+		readings.Clusters = make(map[string]float64, len(m.CPUPowerByCluster))
+		for name, watts := range m.CPUPowerByCluster {
+			readings.Clusters[name] = unit.fromWatts(watts)
+		}
+	}
+	return readings
 }
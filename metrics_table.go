@@ -0,0 +1,116 @@
+package powermetrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Table renders the key metrics of the sample as an aligned plain-text table
+// with SECTION, METRIC and VALUE columns, for terminal output. Sections the
+// sample does not carry are left out, as are system readings the sample did
+// not report; an empty sample yields "". Power is shown in m.PowerUnit, as
+// Power reports it. Clusters, CPUs, interrupts and GPU processes are listed
+// in a stable order.
+func (m Metrics) Table() string {
+	var buf strings.Builder
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+
+	rows := 0
+	row := func(section, metric, format string, args ...interface{}) {
+		if rows == 0 {
+			fmt.Fprintln(tw, "SECTION\tMETRIC\tVALUE")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", section, metric, fmt.Sprintf(format, args...))
+		rows++
+	}
+
+	if !m.Timestamp.IsZero() {
+		row("Sample", "time", "%s", m.Timestamp.Format("2006-01-02 15:04:05 -0700"))
+	}
+	if m.Elapsed > 0 {
+		row("Sample", "elapsed", "%s", m.Elapsed)
+	}
+
+	power := m.Power("")
+	unit := string(power.Unit)
+	if s := m.SystemSample; s != nil {
+		for _, r := range []struct {
+			field  systemField
+			metric string
+			format string
+			value  float64
+		}{
+			{measuredCPUPower, "CPU power", "%.2f " + unit, power.CPU},
+			{measuredGPUPower, "GPU power", "%.2f " + unit, power.GPU},
+			{measuredANEPower, "ANE power", "%.2f " + unit, power.ANE},
+			{measuredDRAMPower, "DRAM power", "%.2f " + unit, power.DRAM},
+			{measuredCPUFrequency, "CPU frequency", "%.0f MHz", s.CPUFrequencyMHz},
+			{measuredGPUFrequency, "GPU frequency", "%.0f MHz", s.GPUFrequencyMHz},
+			{measuredCPUTemperature, "CPU temperature", "%.1f °C", s.CPUTemperatureC},
+			{measuredGPUTemperature, "GPU temperature", "%.1f °C", s.GPUTemperatureC},
+			{measuredCPUBusy, "CPU busy", "%.1f%%", s.CPUBusyPercent},
+			{measuredGPUBusy, "GPU busy", "%.1f%%", s.GPUBusyPercent},
+			{measuredANEBusy, "ANE busy", "%.1f%%", s.ANEBusyPercent},
+			{measuredBattery, "battery", "%.0f%%", s.BatteryPercent},
+		} {
+			if s.measured&r.field != 0 || (r.field == measuredCPUBusy && s.cpuBusyDerived) {
+				row("System", r.metric, r.format, r.value)
+			}
+		}
+		if s.ThermalPressure != "" {
+			row("System", "thermal pressure", "%s", s.ThermalPressure)
+		}
+	}
+
+	clusters := append([]ClusterResidencyMetrics(nil), m.ClusterResidencies...)
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Name < clusters[j].Name })
+	for _, c := range clusters {
+		row("Cluster", c.Name, "%.1f%% active, %.0f MHz, %.0f%% online", c.HWActiveResidency, c.HWActiveFreq, c.OnlinePercent)
+	}
+
+	cpus := append([]CPUResidencyMetrics(nil), m.CPUResidencies...)
+	sort.Slice(cpus, func(i, j int) bool { return cpus[i].CPUID < cpus[j].CPUID })
+	for _, cpu := range cpus {
+		row("CPU", fmt.Sprintf("CPU %d", cpu.CPUID), "%.1f%% active, %.0f MHz", CalculateTotalActive(cpu.ActiveResidency), cpu.Frequency)
+	}
+
+	if g := m.GPUResidency; g != nil {
+		row("GPU", "active", "%.1f%%", g.HWActiveResidency)
+		row("GPU", "idle", "%.1f%%", g.IdleResidency)
+		row("GPU", "power", "%.2f "+unit, power.Unit.fromWatts(g.PowerWatts()))
+	}
+
+	procs := append([]GPUProcessSample(nil), m.GPUProcessSamples...)
+	sort.SliceStable(procs, func(i, j int) bool { return procs[i].BusyPercent > procs[j].BusyPercent })
+	for _, proc := range procs {
+		row("GPU process", fmt.Sprintf("%s (%d)", proc.Name, proc.PID), "%.1f%%", proc.BusyPercent)
+	}
+
+	if len(m.ProcessSamples) > 0 {
+		row("Tasks", "count", "%d", len(m.ProcessSamples))
+	}
+
+	if n := m.Network; n != nil {
+		row("Network", "in", "%.0f B/s, %.0f packets/s", n.InBytesPerSec, n.InPacketsPerSec)
+		row("Network", "out", "%.0f B/s, %.0f packets/s", n.OutBytesPerSec, n.OutPacketsPerSec)
+	}
+
+	if d := m.Disk; d != nil {
+		row("Disk", "read", "%.0f B/s, %.0f ops/s", d.ReadBytesPerSec, d.ReadOpsPerSec)
+		row("Disk", "write", "%.0f B/s, %.0f ops/s", d.WriteBytesPerSec, d.WriteOpsPerSec)
+	}
+
+	interrupts := append([]InterruptMetrics(nil), m.Interrupts...)
+	sort.Slice(interrupts, func(i, j int) bool { return interrupts[i].CPUID < interrupts[j].CPUID })
+	for _, intr := range interrupts {
+		row("Interrupts", fmt.Sprintf("CPU %d", intr.CPUID), "%.0f IRQ/s (IPI %.0f, TIMER %.0f)", intr.TotalIRQ, intr.IPI, intr.TIMER)
+	}
+
+	if rows == 0 {
+		return ""
+	}
+	_ = tw.Flush()
+	return buf.String()
+}
@@ -0,0 +1,68 @@
+package powermetrics
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// gpuBusyTolerance is the allowed gap, in percentage points, between the
+	// system GPU busy figure and the GPU HW active residency.
+	gpuBusyTolerance = 10.0
+	// residencyTolerance absorbs rounding in powermetrics' residency columns.
+	residencyTolerance = 1.0
+)
+
+// Validate checks the sample for internally inconsistent or physically
+// impossible values and returns a human-readable warning for each one found.
+// It returns nil when nothing looks wrong. Warnings usually point at either a
+// parser bug or a hardware/reporting oddity worth investigating.
+func (m Metrics) Validate() []string {
+	var warnings []string
+	warnf := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	if s := m.SystemSample; s != nil {
+		if s.BatteryPercent < 0 || s.BatteryPercent > 100 {
+			warnf("battery %.2f%% is outside 0-100%%", s.BatteryPercent)
+		}
+		if s.CPUBusyPercent < 0 || s.CPUBusyPercent > 100 {
+			warnf("CPU busy %.2f%% is outside 0-100%%", s.CPUBusyPercent)
+		}
+		if s.GPUBusyPercent < 0 || s.GPUBusyPercent > 100 {
+			warnf("GPU busy %.2f%% is outside 0-100%%", s.GPUBusyPercent)
+		}
+		if s.ANEBusyPercent < 0 || s.ANEBusyPercent > 100 {
+			warnf("ANE busy %.2f%% is outside 0-100%%", s.ANEBusyPercent)
+		}
+		if m.GPUResidency != nil && math.Abs(s.GPUBusyPercent-m.GPUResidency.HWActiveResidency) > gpuBusyTolerance {
+			warnf("GPU busy %.2f%% but GPU residency %.2f%%", s.GPUBusyPercent, m.GPUResidency.HWActiveResidency)
+		}
+	}
+
+	if g := m.GPUResidency; g != nil {
+		if total := g.HWActiveResidency + g.IdleResidency; total > 100+residencyTolerance {
+			warnf("GPU active %.2f%% + idle %.2f%% exceeds 100%%", g.HWActiveResidency, g.IdleResidency)
+		}
+	}
+
+	for _, cpu := range m.CPUResidencies {
+		active := CalculateTotalActive(cpu.ActiveResidency)
+		if total := active + cpu.IdleResidency + cpu.DownResidency; total > 100+residencyTolerance {
+			warnf("CPU %d active %.2f%% + idle %.2f%% + down %.2f%% exceeds 100%%",
+				cpu.CPUID, active, cpu.IdleResidency, cpu.DownResidency)
+		}
+	}
+
+	for _, intr := range m.Interrupts {
+		if intr.IPI > intr.TotalIRQ {
+			warnf("CPU %d interrupt IPI %.2f/s > total %.2f/s", intr.CPUID, intr.IPI, intr.TotalIRQ)
+		}
+		if intr.TIMER > intr.TotalIRQ {
+			warnf("CPU %d interrupt TIMER %.2f/s > total %.2f/s", intr.CPUID, intr.TIMER, intr.TotalIRQ)
+		}
+	}
+
+	return warnings
+}
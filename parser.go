@@ -3,42 +3,380 @@ package powermetrics
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
+const utf8BOM = "\uFEFF"
+
+// ErrNotPowermetricsOutput is reported on a stream's Errors channel when the
+// first lines of the input contain binary data and nothing powermetrics
+// would print, e.g. because the wrong file was piped in. The stream keeps
+// reading, but is unlikely to produce metrics.
+var ErrNotPowermetricsOutput = errors.New("powermetrics: input does not look like powermetrics output")
+
+// garbageProbeLines is how many leading lines are checked for
+// ErrNotPowermetricsOutput.
+const garbageProbeLines = 32
+
 // Parser handles invoking powermetrics and parsing its output.
 type Parser struct {
-	config             Config
-	system             SystemSample
-	frequencyMHz       float64
-	processSamples     []ProcessSample
-	clusterInfo        map[string]*ClusterInfo
-	cpuResidencies     map[int]*CPUResidencyMetrics
-	clusterResidencies map[string]*ClusterResidencyMetrics
-	networkInfo        *NetworkMetrics
-	diskInfo           *DiskMetrics
-	interruptInfo      map[int]*InterruptMetrics
-	gpuResidency       *GPUResidencyMetrics
+	config         Config
+	system         SystemSample
+	frequencyMHz   float64
+	processSamples []ProcessSample
+	deadTasks      *ProcessSample
+	clusters       map[string]*ClusterResidencyMetrics
+	cpuResidencies map[int]*CPUResidencyMetrics
+	networkInfo    *NetworkMetrics
+	diskInfo       *DiskMetrics
+	interruptInfo  map[int]*InterruptMetrics
+	gpuResidency   *GPUResidencyMetrics
+	sampleTime     time.Time
+	elapsed        time.Duration
+	paused         atomic.Bool
+	// powerAvg is the --poweravg sample count from the arguments; it scales
+	// the configured SampleWindow when no header reports the elapsed time.
+	powerAvg int
+	// gpuProcessSamples accumulates GPU process lines until the next section
+	// boundary, like processSamples.
+	gpuProcessSamples []GPUProcessSample
+	// frequencySteps holds, per frequency domain, the sorted frequencies
+	// observed so far when Config.FrequencySnapMHz is set.
+	frequencySteps [frequencyDomainCount][]float64
+	// seenHeader is set by the first sample header; complete is set once a
+	// sample has been closed by the next header or the end of the stream.
+	seenHeader bool
+	complete   atomic.Bool
+	// reportedPower records which power fields powermetrics reported
+	// directly, so energy counters do not overwrite them with derived values.
+	reportedPower systemField
+	// cpuBusyPending is set by a per-core active residency line until the
+	// per-CPU block ends and finishCPUBusy derives CPUBusyPercent.
+	cpuBusyPending bool
+	// batteries holds every "Battery: percent_charge" reading of the current
+	// sample for Metrics.Batteries.
+	batteries []float64
+	// processPaths caches Config.ResolveProcessPaths lookups by PID for the
+	// processes of the last tasks table; processPathsGen numbers the tables
+	// so entries of PIDs that dropped out can be evicted.
+	processPaths    map[int]processPath
+	processPathsGen uint64
+	// residencyHistory keeps the last Config.CPUResidencyHistoryDepth
+	// residency maps per CPU, oldest first; guarded by historyMu since
+	// CPUResidencyHistory may be called while a stream is running.
+	historyMu        sync.Mutex
+	residencyHistory map[int][]CPUResidencyData
+	// clusterPowers holds the latest "<name> Power" reading per cluster for
+	// Metrics.CPUPowerByCluster.
+	clusterPowers map[string]float64
+	// observed is a bit set of the section indexes that have appeared in
+	// emitted metrics.
+	observed atomic.Uint32
+	// discardedErrors reports the first error dropped by Run.
+	discardedErrors sync.Once
+	// interruptCPU is the entry of the interrupt block opened by the last
+	// "CPU N:" line, which the following detail lines belong to.
+	interruptCPU *InterruptMetrics
+	// sampleReadings holds the readings seen since the last sample header;
+	// overwrites counts, per section index, readings that repeated one of
+	// them.
+	sampleReadings map[readingKey]struct{}
+	overwrites     [sectionCount]atomic.Uint64
+	// emitOn is the set of Config.EmitOn; emitTrigger is the set of sections
+	// that produced the metrics parseLine last returned.
+	emitOn      sectionSet
+	emitTrigger sectionSet
+	// processColumns maps the value columns of tasks table rows to
+	// ProcessSample fields, as read from the last header row; nil uses
+	// defaultProcessColumns.
+	processColumns []processColumn
+	// configErr is a configuration error found by NewParser, such as an
+	// unknown EmitOn section or Profile; streams fail with it instead of
+	// running.
+	configErr error
+	// headers counts the sample headers parsed so far; it numbers the
+	// samples for Metrics.header.
+	headers uint64
 }
 
 // NewParser creates a parser using the provided configuration, filling in defaults as required.
 func NewParser(cfg Config) *Parser {
 	normalized := normalizeConfig(cfg)
 
-	return &Parser{
-		config:             normalized,
-		clusterInfo:        make(map[string]*ClusterInfo),
-		cpuResidencies:     make(map[int]*CPUResidencyMetrics),
-		clusterResidencies: make(map[string]*ClusterResidencyMetrics),
-		interruptInfo:      make(map[int]*InterruptMetrics),
+	p := &Parser{
+		config:         normalized,
+		system:         SystemSample{OnAC: true},
+		powerAvg:       powerAverageCount(normalized.PowermetricsArgs),
+		emitOn:         sectionSetOf(normalized.EmitOn),
+		clusters:       make(map[string]*ClusterResidencyMetrics),
+		cpuResidencies: make(map[int]*CPUResidencyMetrics),
+		interruptInfo:  make(map[int]*InterruptMetrics),
 		gpuResidency: &GPUResidencyMetrics{
-			HWActiveFreqResidency: make(map[float64]float64),
+			HWActiveFreqResidency: make(FrequencyResidencyData),
 			SWRequestedStates:     make(GPUSoftwareStateData),
 			SWStates:              make(GPUSoftwareStateData),
 		},
 	}
+	p.configErr = checkEmitOn(normalized.EmitOn)
+	if p.configErr == nil {
+		p.configErr = checkProfile(normalized.Profile)
+	}
+	if explicit, ok := intervalArgument(cfg.PowermetricsArgs); ok && explicit != normalized.SampleWindow {
+		p.logf("powermetrics: -i %d in PowermetricsArgs overridden by SampleWindow %v; set RespectExplicitInterval to keep it",
+			explicit.Milliseconds(), normalized.SampleWindow)
+	}
+	return p
+}
+
+// now reads Config.Clock, falling back to the wall clock.
+func (p *Parser) now() time.Time {
+	if p.config.Clock != nil {
+		return p.config.Clock()
+	}
+	return time.Now()
+}
+
+// Pause stops forwarding metrics to the stream without closing it. Output is
+// still read and parsed while paused so state stays current, but any metrics
+// produced in the meantime are dropped. Pause is safe to call concurrently
+// with a running stream.
+func (p *Parser) Pause() {
+	p.paused.Store(true)
+}
+
+// Resume restarts forwarding metrics after Pause.
+func (p *Parser) Resume() {
+	p.paused.Store(false)
+}
+
+// Paused reports whether metrics forwarding is currently paused.
+func (p *Parser) Paused() bool {
+	return p.paused.Load()
+}
+
+// EffectiveInterval returns the sampling interval powermetrics is asked for,
+// read back from the "-i" argument after normalization, which aligns it with
+// Config.SampleWindow (one second when unset) unless
+// Config.RespectExplicitInterval kept the caller's "-i".
+func (p *Parser) EffectiveInterval() time.Duration {
+	if interval, ok := intervalArgument(p.config.PowermetricsArgs); ok {
+		return interval
+	}
+	return p.config.SampleWindow
+}
+
+// HasCompleteSample reports whether at least one full sample, from its
+// "Sampled system activity" header to the next header or the end of the
+// stream, has been parsed. Consumers can use it as a readiness check to avoid
+// acting on partial warm-up data. It is safe to call concurrently with a
+// running stream.
+func (p *Parser) HasCompleteSample() bool {
+	return p.complete.Load()
+}
+
+// CPUResidencyHistory returns the residency maps recorded for cpuID, oldest
+// first, when Config.CPUResidencyHistoryDepth is set; nil otherwise. The maps
+// are copies and it is safe to call concurrently with a running stream.
+func (p *Parser) CPUResidencyHistory(cpuID int) []CPUResidencyData {
+	p.historyMu.Lock()
+	defer p.historyMu.Unlock()
+
+	history := p.residencyHistory[cpuID]
+	if len(history) == 0 {
+		return nil
+	}
+	out := make([]CPUResidencyData, len(history))
+	for i, residency := range history {
+		out[i] = cloneFloatResidencyMap(residency)
+	}
+	return out
+}
+
+// recordResidency appends a copy of a CPU's residency map to its history,
+// dropping the oldest entries beyond Config.CPUResidencyHistoryDepth.
+func (p *Parser) recordResidency(cpuID int, residency CPUResidencyData) {
+	depth := p.config.CPUResidencyHistoryDepth
+	if depth <= 0 {
+		return
+	}
+
+	p.historyMu.Lock()
+	defer p.historyMu.Unlock()
+
+	if p.residencyHistory == nil {
+		p.residencyHistory = make(map[int][]CPUResidencyData)
+	}
+	history := append(p.residencyHistory[cpuID], cloneFloatResidencyMap(residency))
+	if over := len(history) - depth; over > 0 {
+		history = append(history[:0], history[over:]...)
+	}
+	p.residencyHistory[cpuID] = history
+}
+
+// Indexes of the sections ObservedSections reports and Stats counts
+// overwrites for.
+const (
+	sectionSystem = iota
+	sectionTasks
+	sectionGPUProcesses
+	sectionClusters
+	sectionCPUResidency
+	sectionGPU
+	sectionNetwork
+	sectionDisk
+	sectionInterrupts
+	sectionCount
+)
+
+// sectionNames holds the name of each section index, in the order
+// ObservedSections reports them.
+var sectionNames = [sectionCount]string{
+	sectionSystem:       string(SectionSystem),
+	sectionTasks:        string(SectionTasks),
+	sectionGPUProcesses: string(SectionGPUProcesses),
+	sectionClusters:     string(SectionClusters),
+	sectionCPUResidency: string(SectionCPUResidency),
+	sectionGPU:          string(SectionGPU),
+	sectionNetwork:      string(SectionNetwork),
+	sectionDisk:         string(SectionDisk),
+	sectionInterrupts:   string(SectionInterrupts),
+}
+
+// ObservedSections lists the sections that have appeared in the metrics
+// parsed so far, from "system", "tasks", "gpu_processes", "clusters",
+// "cpu_residency", "gpu", "network", "disk" and "interrupts", in that order.
+// After the first sample it lets consumers confirm the samplers they expect
+// are producing data. It is safe to call concurrently with a running stream.
+func (p *Parser) ObservedSections() []string {
+	observed := p.observed.Load()
+	var sections []string
+	for i, name := range sectionNames {
+		if observed&(1<<i) != 0 {
+			sections = append(sections, name)
+		}
+	}
+	return sections
+}
+
+// observe records the sections present in metrics for ObservedSections.
+func (p *Parser) observe(metrics *Metrics) {
+	var present [sectionCount]bool
+	present[sectionSystem] = metrics.SystemSample != nil && (metrics.SystemSample.measured != 0 || metrics.SystemSample.ThermalPressure != "")
+	present[sectionTasks] = len(metrics.ProcessSamples) > 0 || metrics.DeadTasks != nil
+	present[sectionGPUProcesses] = len(metrics.GPUProcessSamples) > 0
+	present[sectionClusters] = len(metrics.Clusters) > 0 || len(metrics.ClusterResidencies) > 0
+	present[sectionCPUResidency] = len(metrics.CPUResidencies) > 0
+	present[sectionGPU] = metrics.GPUResidency != nil
+	present[sectionNetwork] = metrics.Network != nil
+	present[sectionDisk] = metrics.Disk != nil
+	present[sectionInterrupts] = len(metrics.Interrupts) > 0
+
+	var bits uint32
+	for i, ok := range present {
+		if ok {
+			bits |= 1 << i
+		}
+	}
+	for {
+		old := p.observed.Load()
+		if old|bits == old || p.observed.CompareAndSwap(old, old|bits) {
+			return
+		}
+	}
+}
+
+// sampleReading is a line that powermetrics prints once per sample, or once
+// per CPU or cluster, so a repeat within a sample overwrites a value Stats
+// reports as lost.
+type sampleReading uint8
+
+const (
+	readingNetworkOut sampleReading = iota
+	readingNetworkIn
+	readingDiskRead
+	readingDiskWrite
+	readingCPUFrequency
+	readingClusterFrequency
+	readingGPUFrequency
+	readingInterrupts
+)
+
+// readingSections maps each sampleReading to the section index its
+// overwrites are counted under.
+var readingSections = [...]int{
+	readingNetworkOut:       sectionNetwork,
+	readingNetworkIn:        sectionNetwork,
+	readingDiskRead:         sectionDisk,
+	readingDiskWrite:        sectionDisk,
+	readingCPUFrequency:     sectionCPUResidency,
+	readingClusterFrequency: sectionClusters,
+	readingGPUFrequency:     sectionGPU,
+	readingInterrupts:       sectionInterrupts,
+}
+
+// readingKey identifies a reading within a sample: cpu is the CPU id of
+// per-CPU lines and cluster the name of per-cluster lines.
+type readingKey struct {
+	reading sampleReading
+	cpu     int
+	cluster string
+}
+
+// ParserStats holds diagnostic counters of a Parser.
+type ParserStats struct {
+	// Overwrites counts, per section name as reported by ObservedSections,
+	// readings that replaced a value already read within the same sample,
+	// e.g. a second "out:" line or a second "CPU 3 frequency" line. Such
+	// values are lost; a non-zero count usually means the sample window
+	// captures several powermetrics samples at once. Only sections with
+	// overwrites are present. The system section is not counted, since
+	// powermetrics repeats some of its lines (e.g. "GPU Power") within a
+	// sample, and neither are the tasks and GPU process tables, whose rows
+	// are collected rather than overwritten. Counting relies on sample
+	// headers, so headerless input never reports any.
+	Overwrites map[string]uint64
+}
+
+// Stats returns the parser's diagnostic counters. It is safe to call
+// concurrently with a running stream.
+func (p *Parser) Stats() ParserStats {
+	var stats ParserStats
+	for i := range p.overwrites {
+		if n := p.overwrites[i].Load(); n > 0 {
+			if stats.Overwrites == nil {
+				stats.Overwrites = make(map[string]uint64)
+			}
+			stats.Overwrites[sectionNames[i]] += n
+		}
+	}
+	return stats
+}
+
+// noteReading records reading for the current sample, counting an overwrite
+// of its section when it was already seen since the last header.
+func (p *Parser) noteReading(reading sampleReading, cpu int, cluster string) {
+	if !p.seenHeader {
+		return
+	}
+	key := readingKey{reading: reading, cpu: cpu, cluster: cluster}
+	if _, seen := p.sampleReadings[key]; seen {
+		p.overwrites[readingSections[reading]].Add(1)
+		return
+	}
+	if p.sampleReadings == nil {
+		p.sampleReadings = make(map[readingKey]struct{})
+	}
+	p.sampleReadings[key] = struct{}{}
 }
 
 // Stream represents a metrics stream paired with an error channel.
@@ -49,27 +387,36 @@ type Stream struct {
 
 type readerFactory func(context.Context) (io.Reader, func() error, error)
 
-// Run executes powermetrics and returns a channel of metrics.
+// Run executes powermetrics and returns a channel of metrics. Stream errors
+// are discarded; the first one is reported once through Config.Logger.
 // Deprecated: prefer RunWithErrors to also receive runtime diagnostics.
 func (p *Parser) Run(ctx context.Context) (<-chan Metrics, error) {
 	stream, err := p.RunWithErrors(ctx)
 	if err != nil {
 		return nil, err
 	}
+	return p.discardErrors(stream), nil
+}
 
-	// Drain errors to avoid goroutine leaks while keeping backward compatibility.
+// discardErrors drains the errors of stream to avoid goroutine leaks while
+// keeping backward compatibility, logging the first one so the loss is not
+// silent.
+func (p *Parser) discardErrors(stream *Stream) <-chan Metrics {
 	go func() {
-		for range stream.Errors {
+		for err := range stream.Errors {
+			p.discardedErrors.Do(func() {
+				p.logf("powermetrics: Run discards stream errors, use RunWithErrors to receive them; first discarded: %v", err)
+			})
 		}
 	}()
 
-	return stream.Metrics, nil
+	return stream.Metrics
 }
 
 // RunWithErrors executes powermetrics and returns a Stream that includes both metrics and errors.
 func (p *Parser) RunWithErrors(ctx context.Context) (*Stream, error) {
 	return p.newStream(ctx, func(ctx context.Context) (io.Reader, func() error, error) {
-		cmd := exec.CommandContext(ctx, p.config.PowermetricsPath, p.config.PowermetricsArgs...)
+		cmd := p.command(ctx)
 		stdout, err := cmd.StdoutPipe()
 		if err != nil {
 			return nil, nil, err
@@ -83,88 +430,360 @@ func (p *Parser) RunWithErrors(ctx context.Context) (*Stream, error) {
 	})
 }
 
+// CommandLine returns the powermetrics invocation RunWithErrors would start:
+// the executable path and its arguments after applying the profile, the
+// defaults and the SampleWindow interval. Nothing is executed, so it can be
+// used to check a privileged invocation before running it. Config.Env is not
+// part of the command line.
+func (p *Parser) CommandLine() (path string, args []string) {
+	return p.config.PowermetricsPath, append([]string(nil), p.config.PowermetricsArgs...)
+}
+
+// command builds the powermetrics invocation described by the config.
+func (p *Parser) command(ctx context.Context) *exec.Cmd {
+	path, args := p.CommandLine()
+	cmd := exec.CommandContext(ctx, path, args...)
+	if len(p.config.Env) > 0 {
+		cmd.Env = append(os.Environ(), p.config.Env...)
+	}
+	return cmd
+}
+
 // RunWithReader parses powermetrics output from an arbitrary io.Reader (e.g., a log file).
 // The caller is responsible for closing the reader if needed.
 func (p *Parser) RunWithReader(ctx context.Context, reader io.Reader) *Stream {
 	if reader == nil {
 		panic("powermetrics: reader cannot be nil")
 	}
-	stream, err := p.newStream(ctx, func(context.Context) (io.Reader, func() error, error) {
-		return reader, nil, nil
-	})
+	if p.configErr != nil {
+		return failedStream(p.configErr)
+	}
+	if p.config.RawLogPath == "" {
+		return p.streamFromReader(ctx, reader, nil, nil, nil)
+	}
+	recorded, wait, err := recordTo(p.config.RawLogPath, reader, nil, false)
 	if err != nil {
-		panic(fmt.Sprintf("powermetrics: reader stream failed: %v", err))
+		return failedStream(err)
 	}
-	return stream
+	return p.streamFromReader(ctx, recorded, wait, nil, nil)
+}
+
+// failedStream returns an already-closed stream that reports err.
+func failedStream(err error) *Stream {
+	metricsCh := make(chan Metrics)
+	errCh := make(chan error, 1)
+	errCh <- err
+	close(metricsCh)
+	close(errCh)
+	return &Stream{Metrics: metricsCh, Errors: errCh}
 }
 
 func (p *Parser) newStream(ctx context.Context, factory readerFactory) (*Stream, error) {
 	if factory == nil {
 		return nil, fmt.Errorf("powermetrics: reader factory cannot be nil")
 	}
+	if p.configErr != nil {
+		return nil, p.configErr
+	}
 
-	reader, wait, err := factory(ctx)
+	// The source gets its own context so a read timeout can stop it.
+	ctx, stop := context.WithCancel(ctx)
+	reader, wait, err := p.open(ctx, factory, false)
 	if err != nil {
+		stop()
 		return nil, err
 	}
+
+	return p.streamFromReader(ctx, reader, wait, factory, stop), nil
+}
+
+// open obtains a reader from factory, recording it to RawLogPath when set.
+// Restarts append to the raw log instead of truncating it.
+func (p *Parser) open(ctx context.Context, factory readerFactory, restart bool) (io.Reader, func() error, error) {
+	reader, wait, err := factory(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
 	if reader == nil {
-		return nil, fmt.Errorf("powermetrics: reader factory returned nil reader")
+		return nil, nil, fmt.Errorf("powermetrics: reader factory returned nil reader")
 	}
 
-	return p.streamFromReader(ctx, reader, wait), nil
+	if p.config.RawLogPath != "" {
+		reader, wait, err = recordTo(p.config.RawLogPath, reader, wait, restart)
+		if err != nil {
+			if wait != nil {
+				_ = wait()
+			}
+			return nil, nil, err
+		}
+	}
+
+	return reader, wait, nil
 }
 
-func (p *Parser) streamFromReader(ctx context.Context, reader io.Reader, wait func() error) *Stream {
+// recordTo tees everything read from reader into the file at path,
+// truncating it unless appending is requested. The returned wait function
+// flushes and closes the file once the stream ends, before delegating to the
+// original wait.
+func recordTo(path string, reader io.Reader, wait func() error, appending bool) (io.Reader, func() error, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appending {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	file, err := os.OpenFile(path, flags, 0o666)
+	if err != nil {
+		return reader, wait, fmt.Errorf("powermetrics: create raw log: %w", err)
+	}
+	buffered := bufio.NewWriter(file)
+
+	recordedWait := func() error {
+		flushErr := buffered.Flush()
+		closeErr := file.Close()
+
+		var err error
+		if wait != nil {
+			err = wait()
+		}
+		if err == nil && flushErr != nil {
+			err = fmt.Errorf("powermetrics: write raw log: %w", flushErr)
+		}
+		if err == nil && closeErr != nil {
+			err = fmt.Errorf("powermetrics: close raw log: %w", closeErr)
+		}
+		return err
+	}
+
+	return io.TeeReader(reader, buffered), recordedWait, nil
+}
+
+// streamFromReader parses reader until it ends. When restart is non-nil and
+// Config.RestartPolicy allows it, an unexpected exit (wait failing while ctx
+// is still live) reopens the source from restart and keeps parsing into the
+// same stream. stop, when non-nil, cancels the context the source was opened
+// with; it is called when a read times out and when the stream ends.
+func (p *Parser) streamFromReader(ctx context.Context, reader io.Reader, wait func() error, restart readerFactory, stop context.CancelFunc) *Stream {
 	metricsCh := make(chan Metrics, 128)
 	errCh := make(chan error, 16)
 
 	go func() {
 		defer close(metricsCh)
 		defer close(errCh)
+		if stop != nil {
+			defer stop()
+		}
+
+		parseErrors := &errorCoalescer{out: errCh}
+		var sequence uint64
+		emit := func(metrics *Metrics) {
+			if metrics == nil || p.Paused() {
+				return
+			}
+			sequence++
+			metrics.ReceivedAt = p.now()
+			metrics.Sequence = sequence
+			metricsCh <- *metrics
+		}
 
-		scanner := bufio.NewScanner(reader)
-		for scanner.Scan() {
+		policy := p.config.RestartPolicy
+		for attempt := 1; ; attempt++ {
+			exitErr, done := p.consume(ctx, reader, wait, stop, emit, parseErrors, errCh)
+			if done {
+				return
+			}
+			if restart == nil || attempt > policy.MaxRetries || p.config.StopOnError {
+				errCh <- exitErr
+				return
+			}
+
+			delay := policy.delay(attempt)
+			errCh <- fmt.Errorf("powermetrics: restarting in %s (retry %d of %d) after unexpected exit: %w",
+				delay, attempt, policy.MaxRetries, exitErr)
+
+			timer := time.NewTimer(delay)
 			select {
 			case <-ctx.Done():
+				timer.Stop()
 				errCh <- ctx.Err()
-				if wait != nil {
-					_ = wait()
-				}
 				return
-			default:
+			case <-timer.C:
 			}
 
-			line := scanner.Text()
-			metrics, err := p.ParseLine(line)
+			var err error
+			reader, wait, err = p.open(ctx, restart, true)
 			if err != nil {
-				errCh <- fmt.Errorf("parse line: %w", err)
-				continue
+				errCh <- err
+				return
 			}
+		}
+	}()
+
+	return &Stream{
+		Metrics: metricsCh,
+		Errors:  errCh,
+	}
+}
+
+// consume parses reader line by line into emit. It returns the error from
+// wait when the source exited unexpectedly; done is true when there is
+// nothing left to do, either because the source ended cleanly or because ctx
+// was cancelled.
+func (p *Parser) consume(ctx context.Context, reader io.Reader, wait func() error, stop context.CancelFunc, emit func(*Metrics), parseErrors *errorCoalescer, errCh chan<- error) (exitErr error, done bool) {
+	if p.config.ReadTimeout > 0 {
+		timed := newTimeoutReader(reader, p.config.ReadTimeout)
+		defer timed.release()
+		reader = timed
+	}
 
-			if metrics != nil {
-				metricsCh <- *metrics
+	scanner := bufio.NewScanner(reader)
+	first := true
+	probe := garbageProbe{errCh: errCh}
+	for scanner.Scan() {
+		select {
+		case <-ctx.Done():
+			parseErrors.flush()
+			errCh <- ctx.Err()
+			if wait != nil {
+				_ = wait()
 			}
+			return nil, true
+		default:
 		}
 
-		if metrics := p.flushProcessSamples(); metrics != nil {
-			metricsCh <- *metrics
+		line := scanner.Text()
+		if first {
+			// Editors on some platforms prepend a byte order mark when
+			// saving captured logs; it would otherwise hide the first line.
+			line = strings.TrimPrefix(line, utf8BOM)
+			first = false
+		}
+		metrics, err := p.streamLine(line)
+		probe.line(line, metrics != nil || p.seenHeader)
+		if err != nil {
+			parseErrors.send(fmt.Errorf("parse line: %w", err))
+		} else {
+			emit(metrics)
+		}
+		if p.config.StopOnError && (err != nil || probe.reported) {
+			// The sample read so far is still emitted; the rest of the
+			// source is left unread.
+			emit(p.finishMetrics(p.emitFilter(p.flushProcessSamples())))
+			p.stopSource(stop, wait)
+			return nil, true
 		}
+	}
 
-		if err := scanner.Err(); err != nil {
-			errCh <- err
+	probe.finish()
+	emit(p.finishMetrics(p.emitFilter(p.flushProcessSamples())))
+	parseErrors.flush()
+	if p.seenHeader {
+		p.complete.Store(true)
+	}
+
+	if err := scanner.Err(); err != nil {
+		errCh <- err
+		// A stalled source would block wait forever; stop it first.
+		if errors.Is(err, ErrReadTimeout) && stop != nil {
+			stop()
 		}
+	}
 
-		if wait != nil {
-			if err := wait(); err != nil && ctx.Err() == nil {
-				errCh <- err
-			}
+	if wait != nil {
+		if err := wait(); err != nil && ctx.Err() == nil {
+			return err, false
 		}
-	}()
+	}
+	return nil, true
+}
 
-	return &Stream{
-		Metrics: metricsCh,
-		Errors:  errCh,
+// stopSource ends the source early for Config.StopOnError: it stops a
+// process started by RunWithErrors and waits for it, which also closes the
+// raw log. A reader passed to RunWithReader is left to the caller to close.
+func (p *Parser) stopSource(stop context.CancelFunc, wait func() error) {
+	if stop != nil {
+		stop()
+	}
+	if wait != nil {
+		_ = wait()
+	}
+}
+
+// garbageProbe watches the first garbageProbeLines lines of a stream and
+// reports ErrNotPowermetricsOutput when none of them was recognized and some
+// contain non-printable bytes.
+type garbageProbe struct {
+	errCh  chan<- error
+	lines  int
+	binary bool
+	done   bool
+	// reported is set once ErrNotPowermetricsOutput has been sent.
+	reported bool
+}
+
+func (g *garbageProbe) line(line string, recognized bool) {
+	if g.done {
+		return
+	}
+	if recognized {
+		g.done = true
+		return
+	}
+	g.binary = g.binary || hasNonPrintable(line)
+	if g.lines++; g.lines >= garbageProbeLines {
+		g.finish()
+	}
+}
+
+// finish settles the verdict, also for streams shorter than the probe.
+func (g *garbageProbe) finish() {
+	if g.done {
+		return
+	}
+	g.done = true
+	if g.binary {
+		g.errCh <- ErrNotPowermetricsOutput
+		g.reported = true
+	}
+}
+
+// hasNonPrintable reports whether line holds invalid UTF-8 or control
+// characters other than tabs, which powermetrics never prints.
+func hasNonPrintable(line string) bool {
+	for _, r := range line {
+		if r == utf8.RuneError || (unicode.IsControl(r) && r != '\t' && r != '\r') {
+			return true
+		}
+	}
+	return false
+}
+
+// errorCoalescer forwards parse errors, collapsing runs of identical errors
+// so a log full of the same malformed line does not flood the error channel.
+// The first error of a run is sent immediately; repeats are counted and
+// reported as a single summary error when a different error arrives or the
+// stream ends.
+type errorCoalescer struct {
+	out        chan<- error
+	last       error
+	suppressed int
+}
+
+func (c *errorCoalescer) send(err error) {
+	if c.last != nil && err.Error() == c.last.Error() {
+		c.suppressed++
+		return
+	}
+	c.flush()
+	c.out <- err
+	c.last = err
+}
+
+// flush reports any suppressed repeats of the last error.
+func (c *errorCoalescer) flush() {
+	if c.suppressed == 0 {
+		return
 	}
+	c.out <- fmt.Errorf("%d identical parse errors suppressed: %w", c.suppressed, c.last)
+	c.suppressed = 0
 }
 
 // RunWithConfig executes powermetrics with the given configuration and returns a channel of metrics.
@@ -1,10 +1,27 @@
 package powermetrics
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"os"
 	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
+
+	pb "github.com/BinSquare/powermetrics-go/proto"
 )
 
 func TestNormalizeConfig(t *testing.T) {
@@ -194,6 +211,11 @@ func TestParseTrailingValue(t *testing.T) {
 		{"no match", "CPU Frequency: 2.4 GHz", "w", 0, false},
 		{"non-numeric", "CPU Power: N/A W", "w", 0, false},
 		{"multiple numbers", "Total: 10.0 W out of 100.0 W", "w", 100.0, true},
+		{"negative value", "CPU Power: -5 W", "w", -5, true},
+		{"hyphenated label", "P0-Cluster Power: 12 mW", "mw", 12, true},
+		{"residency before frequency list", "E-Cluster HW active residency: 100.00% (1020 MHz: 75% 1404 MHz: 25%)", "%", 100, true},
+		{"residency with fractional list", "P0-Cluster HW active residency:   5.88% (1260 MHz: 2.6% 4512 MHz: .80%)", "%", 5.88, true},
+		{"cpu residency before frequency list", "CPU 0 active residency:  55.11% (1020 MHz:  39% 1404 MHz: 2.2%)", "%", 55.11, true},
 	}
 
 	for _, tt := range tests {
@@ -409,10 +431,14 @@ func TestParser_ParseLineGPUProcess(t *testing.T) {
 			// Create a new parser instance to avoid concurrent access
 			parser := NewParser(Config{SampleWindow: time.Second})
 
-			metrics, err := parser.ParseLine(tt.line)
-			if err != nil {
+			if _, err := parser.ParseLine(tt.line); err != nil {
 				t.Fatalf("ParseLine(%q) returned error: %v", tt.line, err)
 			}
+			// GPU process samples are emitted at the end of the block.
+			metrics, err := parser.ParseLine("")
+			if err != nil {
+				t.Fatalf("ParseLine(\"\") returned error: %v", err)
+			}
 
 			if (metrics != nil) != tt.hasGPUProcess {
 				t.Fatalf("ParseLine(%q) returned metrics=%t, want %t", tt.line, metrics != nil, tt.hasGPUProcess)
@@ -471,11 +497,11 @@ func TestParser_updateClusterInfo(t *testing.T) {
 		t.Fatalf("ParseLine(%q) returned error: %v", line1, err)
 	}
 
-	if len(parser.clusterInfo) != 1 {
-		t.Fatalf("Expected 1 cluster after parsing %q, got %d", line1, len(parser.clusterInfo))
+	if len(parser.clusters) != 1 {
+		t.Fatalf("Expected 1 cluster after parsing %q, got %d", line1, len(parser.clusters))
 	}
 
-	cluster, exists := parser.clusterInfo["E-Cluster"]
+	cluster, exists := parser.clusters["E-Cluster"]
 	if !exists {
 		t.Fatalf("Expected E-Cluster to exist after parsing %q", line1)
 	}
@@ -491,11 +517,11 @@ func TestParser_updateClusterInfo(t *testing.T) {
 		t.Fatalf("ParseLine(%q) returned error: %v", line2, err)
 	}
 
-	if len(parser.clusterInfo) != 2 {
-		t.Fatalf("Expected 2 clusters after parsing %q, got %d", line2, len(parser.clusterInfo))
+	if len(parser.clusters) != 2 {
+		t.Fatalf("Expected 2 clusters after parsing %q, got %d", line2, len(parser.clusters))
 	}
 
-	cluster2, exists := parser.clusterInfo["P1-Cluster"]
+	cluster2, exists := parser.clusters["P1-Cluster"]
 	if !exists {
 		t.Fatalf("Expected P1-Cluster to exist after parsing %q", line2)
 	}
@@ -866,6 +892,62 @@ func TestParser_DiskEmitsZeroTransition(t *testing.T) {
 	}
 }
 
+func TestParser_ClampsNegativePower(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	var logs bytes.Buffer
+	parser := NewParser(Config{Logger: log.New(&logs, "", 0)})
+
+	metrics, err := parser.ParseLine("CPU Power: -5 W")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil || metrics.SystemSample == nil {
+		t.Fatalf("expected system metrics, got %#v", metrics)
+	}
+	if metrics.SystemSample.CPUPowerWatts != 0 {
+		t.Fatalf("expected negative CPU power to be clamped to 0, got %.2f", metrics.SystemSample.CPUPowerWatts)
+	}
+	if !bytes.Contains(logs.Bytes(), []byte("clamped negative CPU power -5")) {
+		t.Fatalf("expected clamp diagnostic, got %q", logs.String())
+	}
+
+	if _, err := parser.ParseLine("GPU HW active frequency: 338 MHz"); err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	logs.Reset()
+	metrics, err = parser.ParseLine("GPU Frequency: -338 MHz")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil || metrics.SystemSample == nil || metrics.SystemSample.GPUFrequencyMHz != 0 {
+		t.Fatalf("expected negative GPU frequency to be clamped to 0, got %#v", metrics)
+	}
+	if logs.Len() == 0 {
+		t.Fatalf("expected clamp diagnostic for GPU frequency")
+	}
+
+	for _, tc := range []struct {
+		line, diagnostic string
+		busy             func(*SystemSample) float64
+	}{
+		{"CPU busy: -3%", "clamped negative CPU busy -3", func(s *SystemSample) float64 { return s.CPUBusyPercent }},
+		{"GPU busy: -3%", "clamped negative GPU busy -3", func(s *SystemSample) float64 { return s.GPUBusyPercent }},
+		{"ANE busy: -3%", "clamped negative ANE busy -3", func(s *SystemSample) float64 { return s.ANEBusyPercent }},
+	} {
+		logs.Reset()
+		metrics, err := NewParser(Config{Logger: log.New(&logs, "", 0)}).ParseLine(tc.line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", tc.line, err)
+		}
+		if metrics == nil || metrics.SystemSample == nil || tc.busy(metrics.SystemSample) != 0 {
+			t.Errorf("ParseLine(%q): expected busy clamped to 0, got %+v", tc.line, metrics)
+		}
+		if !strings.Contains(logs.String(), tc.diagnostic) {
+			t.Errorf("ParseLine(%q): expected %q diagnostic, got %q", tc.line, tc.diagnostic, logs.String())
+		}
+	}
+}
+
 func TestParser_SystemSampleImmutable(t *testing.T) {
 	// Don't use t.Parallel() to avoid race conditions
 	parser := NewParser(Config{})
@@ -1111,15 +1193,11 @@ func TestParseLineParsingFromSampleLog(t *testing.T) {
 	if metrics == nil || metrics.SystemSample == nil {
 		t.Errorf("Expected metrics from power line, got nil")
 	} else {
-		// The power value should be in watts. If it comes as 954 mW, it should get converted to 0.954 W
+		// The power value should be in watts: 954 mW is converted to 0.954 W
 		expectedValue := 0.954
 		actualValue := metrics.SystemSample.CPUPowerWatts
 		if actualValue != expectedValue {
-			// If the conversion doesn't work as expected, the value might be stored differently
-			// Let's accept either converted value or raw value depending on how parsing works
-			if actualValue != 954.0 { // If it's stored as raw mW
-				t.Errorf("Expected CPU Power 0.954W (from 954mW) or 954.0, got %f", actualValue)
-			}
+			t.Errorf("Expected CPU Power 0.954W (from 954mW), got %f", actualValue)
 		}
 	}
 }
@@ -1229,7 +1307,7 @@ func TestCompleteSampleLogParsing(t *testing.T) {
 
 	// Check that we collected cluster info
 	clusterFound := false
-	for _, cluster := range parser.clusterResidencies {
+	for _, cluster := range parser.clusters {
 		if cluster.Name == "E-Cluster" {
 			clusterFound = true
 			break
@@ -1255,3 +1333,4498 @@ func TestCompleteSampleLogParsing(t *testing.T) {
 		t.Errorf("Expected GPU HW active residency to be 1.63, got %v", parser.gpuResidency)
 	}
 }
+
+func TestCPUResidencyMetrics_IsLikelyThrottled(t *testing.T) {
+	t.Parallel()
+
+	throttled := CPUResidencyMetrics{
+		CPUID:           4,
+		ActiveResidency: CPUResidencyData{1260: 70, 1512: 15, 3624: 5, 4512: 2},
+	}
+	if !throttled.IsLikelyThrottled(4512) {
+		t.Errorf("expected busy CPU concentrated at low frequencies to be throttled")
+	}
+	if !throttled.IsLikelyThrottled(0) {
+		t.Errorf("expected max frequency to be inferred from the residency map")
+	}
+
+	fast := CPUResidencyMetrics{
+		CPUID:           4,
+		ActiveResidency: CPUResidencyData{1260: 5, 3624: 40, 4512: 45},
+	}
+	if fast.IsLikelyThrottled(4512) {
+		t.Errorf("expected CPU running at high frequencies not to be throttled")
+	}
+
+	idle := CPUResidencyMetrics{
+		CPUID:           0,
+		ActiveResidency: CPUResidencyData{1020: 10},
+	}
+	if idle.IsLikelyThrottled(2592) {
+		t.Errorf("expected lightly loaded CPU not to be throttled")
+	}
+}
+
+func TestMetrics_IsLikelyThrottled(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{})
+	metrics, err := parser.ParseLine("Current pressure level: Moderate")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil || metrics.SystemSample == nil || metrics.SystemSample.ThermalPressure != "Moderate" {
+		t.Fatalf("expected thermal pressure Moderate, got %#v", metrics)
+	}
+
+	metrics.CPUResidencies = []CPUResidencyMetrics{
+		{CPUID: 4, ActiveResidency: CPUResidencyData{1260: 80, 4512: 5}},
+	}
+	if !metrics.IsLikelyThrottled(4512) {
+		t.Errorf("expected moderate pressure with low-frequency residency to be throttled")
+	}
+
+	nominal := *metrics
+	nominal.SystemSample = &SystemSample{ThermalPressure: "Nominal"}
+	if nominal.IsLikelyThrottled(4512) {
+		t.Errorf("expected nominal pressure not to be throttled")
+	}
+
+	heavy := Metrics{SystemSample: &SystemSample{ThermalPressure: "Heavy"}}
+	if !heavy.IsLikelyThrottled(0) {
+		t.Errorf("expected heavy pressure to be throttled regardless of residency")
+	}
+}
+
+func TestMetrics_GPUProcessBusyTotal(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{SampleWindow: time.Second})
+
+	var metrics *Metrics
+	for _, line := range []string{
+		"pid 155    WindowServer               352ms  (35.2%)",
+		"pid 5678   (SampleApp)                125ms  (12.5%)",
+		"",
+	} {
+		parsed, err := parser.ParseLine(line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+		if parsed != nil {
+			metrics = parsed
+		}
+	}
+	if metrics == nil {
+		t.Fatalf("expected GPU process metrics at the end of the block")
+	}
+
+	if got := metrics.GPUProcessBusyTotal(); got != 47.7 {
+		t.Errorf("GPUProcessBusyTotal() = %v, want 47.7", got)
+	}
+	if got := (Metrics{}).GPUProcessBusyTotal(); got != 0 {
+		t.Errorf("expected 0 for no GPU processes, got %v", got)
+	}
+}
+
+func TestParser_SampleHeader(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{})
+
+	metrics, err := parser.ParseLine("*** Sampled system activity (Sat Nov  8 15:54:21 2025 +0900) (5021.96ms elapsed) ***")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics != nil {
+		t.Fatalf("expected header line to emit no metrics, got %#v", metrics)
+	}
+
+	metrics, err = parser.ParseLine("CPU Power: 954 mW")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil {
+		t.Fatalf("expected metrics from power line")
+	}
+
+	want := time.Date(2025, time.November, 8, 15, 54, 21, 0, time.FixedZone("", 9*60*60))
+	if !metrics.Timestamp.Equal(want) {
+		t.Errorf("Timestamp = %v, want %v", metrics.Timestamp, want)
+	}
+	if metrics.Elapsed != 5021960*time.Microsecond {
+		t.Errorf("Elapsed = %v, want 5.02196s", metrics.Elapsed)
+	}
+}
+
+func TestParser_GPUProcessBusyUsesElapsedWindow(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{SampleWindow: time.Second})
+
+	// The requested window is 1s, but powermetrics actually sampled for 2s.
+	if _, err := parser.ParseLine("*** Sampled system activity (Sat Nov  8 15:54:21 2025 +0900) (2000.00ms elapsed) ***"); err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+
+	if _, err := parser.ParseLine("pid 1234   Safari                     500ms"); err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	metrics, err := parser.ParseLine("")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil || len(metrics.GPUProcessSamples) != 1 {
+		t.Fatalf("expected one GPU process sample, got %#v", metrics)
+	}
+	if busy := metrics.GPUProcessSamples[0].BusyPercent; busy != 25 {
+		t.Errorf("BusyPercent = %v, want 25 (500ms of a 2s elapsed window)", busy)
+	}
+}
+
+func TestMetrics_Validate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		metrics Metrics
+		want    []string
+	}{
+		{
+			name: "consistent sample",
+			metrics: Metrics{
+				SystemSample: &SystemSample{GPUBusyPercent: 1.63, BatteryPercent: 36},
+				GPUResidency: &GPUResidencyMetrics{HWActiveResidency: 1.63, IdleResidency: 98.37},
+				CPUResidencies: []CPUResidencyMetrics{
+					{CPUID: 0, ActiveResidency: CPUResidencyData{1020: 55.11}, IdleResidency: 44.89},
+				},
+				Interrupts: []InterruptMetrics{{CPUID: 0, TotalIRQ: 2977.12, IPI: 2232.79, TIMER: 547.20}},
+			},
+		},
+		{
+			name: "gpu busy disagrees with residency",
+			metrics: Metrics{
+				SystemSample: &SystemSample{GPUBusyPercent: 50},
+				GPUResidency: &GPUResidencyMetrics{HWActiveResidency: 2},
+			},
+			want: []string{"GPU busy 50.00% but GPU residency 2.00%"},
+		},
+		{
+			name:    "battery over 100",
+			metrics: Metrics{SystemSample: &SystemSample{BatteryPercent: 120}},
+			want:    []string{"battery 120.00% is outside 0-100%"},
+		},
+		{
+			name:    "busy percentages out of range",
+			metrics: Metrics{SystemSample: &SystemSample{GPUBusyPercent: -1, ANEBusyPercent: 101}},
+			want:    []string{"GPU busy -1.00% is outside 0-100%", "ANE busy 101.00% is outside 0-100%"},
+		},
+		{
+			name:    "gpu residency over 100",
+			metrics: Metrics{GPUResidency: &GPUResidencyMetrics{HWActiveResidency: 60, IdleResidency: 60}},
+			want:    []string{"GPU active 60.00% + idle 60.00% exceeds 100%"},
+		},
+		{
+			name: "cpu residency over 100",
+			metrics: Metrics{CPUResidencies: []CPUResidencyMetrics{
+				{CPUID: 3, ActiveResidency: CPUResidencyData{1020: 70}, IdleResidency: 40, DownResidency: 5},
+			}},
+			want: []string{"CPU 3 active 70.00% + idle 40.00% + down 5.00% exceeds 100%"},
+		},
+		{
+			name: "interrupt breakdown exceeds total",
+			metrics: Metrics{Interrupts: []InterruptMetrics{
+				{CPUID: 4, TotalIRQ: 13.94, IPI: 15.13, TIMER: 1.59},
+				{CPUID: 6, TotalIRQ: 8.76, IPI: 1, TIMER: 9},
+			}},
+			want: []string{
+				"CPU 4 interrupt IPI 15.13/s > total 13.94/s",
+				"CPU 6 interrupt TIMER 9.00/s > total 8.76/s",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := tt.metrics.Validate()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeConfigProfiles(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		input    Config
+		expected []string
+	}{
+		{
+			name:     "battery profile",
+			input:    Config{Profile: ProfileBattery},
+			expected: []string{"--samplers", "battery,cpu_power,gpu_power,ane_power,thermal", "-i", "1000"},
+		},
+		{
+			name:     "performance profile",
+			input:    Config{Profile: ProfilePerformance, SampleWindow: 500 * time.Millisecond},
+			expected: []string{"--samplers", "tasks,cpu_power,gpu_power,ane_power,thermal", "--show-process-gpu", "-i", "500"},
+		},
+		{
+			name:     "full profile",
+			input:    Config{Profile: ProfileFull},
+			expected: []string{"--samplers", "tasks,battery,network,disk,interrupts,cpu_power,gpu_power,ane_power,thermal", "--show-process-gpu", "--show-initial-usage", "-i", "1000"},
+		},
+		{
+			name:     "explicit args win over profile",
+			input:    Config{Profile: ProfileBattery, PowermetricsArgs: []string{"--samplers", "cpu_power"}},
+			expected: []string{"--samplers", "cpu_power", "-i", "1000"},
+		},
+		{
+			name:     "unknown profile uses defaults",
+			input:    Config{Profile: "turbo"},
+			expected: defaultPowermetricsArgs,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			result := normalizeConfig(tt.input)
+			if !reflect.DeepEqual(result.PowermetricsArgs, tt.expected) {
+				t.Errorf("PowermetricsArgs: got %v, want %v", result.PowermetricsArgs, tt.expected)
+			}
+		})
+	}
+}
+
+func TestProfileArgsNotMutated(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	before := append([]string{}, profileArgs[ProfileBattery]...)
+	normalizeConfig(Config{Profile: ProfileBattery, SampleWindow: 250 * time.Millisecond})
+	if !reflect.DeepEqual(profileArgs[ProfileBattery], before) {
+		t.Fatalf("profile args mutated: %v", profileArgs[ProfileBattery])
+	}
+}
+
+func TestParser_DeadTasksFromSampleLog(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	data, err := os.ReadFile("powermetrics_sample.log")
+	if err != nil {
+		t.Fatalf("read sample log: %v", err)
+	}
+
+	parser := NewParser(Config{})
+	var processMetrics *Metrics
+	for _, line := range strings.Split(string(data), "\n") {
+		metrics, err := parser.ParseLine(line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+		if metrics != nil && len(metrics.ProcessSamples) > 0 {
+			processMetrics = metrics
+			break
+		}
+	}
+
+	if processMetrics == nil {
+		t.Fatalf("expected process metrics from sample log")
+	}
+	dead := processMetrics.DeadTasks
+	if dead == nil {
+		t.Fatalf("expected DEAD_TASKS row to be captured")
+	}
+	if dead.PID != -1 || dead.CPUMsPerSec != 323.32 || dead.WakeupsInterrupts != 83.04 {
+		t.Errorf("unexpected DEAD_TASKS row: %+v", *dead)
+	}
+	for _, proc := range processMetrics.ProcessSamples {
+		if proc.Name == "DEAD_TASKS" {
+			t.Fatalf("DEAD_TASKS should not be mixed into ProcessSamples")
+		}
+	}
+	if processMetrics.ProcessSamples[0].Name != "iTerm2" {
+		t.Errorf("expected first live process iTerm2, got %q", processMetrics.ProcessSamples[0].Name)
+	}
+}
+
+func TestCPUResidencyData_Helpers(t *testing.T) {
+	t.Parallel()
+
+	data := parseFreqResidency("1020 MHz:  39% 1404 MHz: 2.2% 1788 MHz: 3.2% 2112 MHz: 3.2%")
+	cpu := CPUResidencyMetrics{CPUID: 0, ActiveResidency: data}
+
+	pairs := cpu.ActiveResidency.SortedPairs()
+	wantFreqs := []float64{1020, 1404, 1788, 2112}
+	if len(pairs) != len(wantFreqs) {
+		t.Fatalf("expected %d pairs, got %d", len(wantFreqs), len(pairs))
+	}
+	for i, freq := range wantFreqs {
+		if pairs[i].FrequencyMHz != freq {
+			t.Errorf("pair %d: frequency %v, want %v", i, pairs[i].FrequencyMHz, freq)
+		}
+	}
+	if pairs[0].Percent != 39 {
+		t.Errorf("expected 1020 MHz residency 39, got %v", pairs[0].Percent)
+	}
+
+	if total := cpu.ActiveResidency.Total(); math.Abs(total-47.6) > 1e-9 {
+		t.Errorf("Total() = %v, want 47.6", total)
+	}
+
+	wantMean := (1020*39 + 1404*2.2 + 1788*3.2 + 2112*3.2) / 47.6
+	if mean := cpu.ActiveResidency.WeightedMeanMHz(); math.Abs(mean-wantMean) > 1e-9 {
+		t.Errorf("WeightedMeanMHz() = %v, want %v", mean, wantMean)
+	}
+
+	if mean := (CPUResidencyData{}).WeightedMeanMHz(); mean != 0 {
+		t.Errorf("expected 0 weighted mean for empty data, got %v", mean)
+	}
+}
+
+func TestGPUResidencyMetrics_FrequencyHelpers(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{})
+	metrics, err := parser.ParseLine("GPU HW active residency:  20.00% (338 MHz: 10% 618 MHz: 5.0% 796 MHz:   0% 924 MHz: 5.0%)")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil || metrics.GPUResidency == nil {
+		t.Fatalf("expected GPU residency metrics, got %#v", metrics)
+	}
+
+	freqs := metrics.GPUResidency.HWActiveFreqResidency
+	pairs := freqs.SortedPairs()
+	wantFreqs := []float64{338, 618, 796, 924}
+	if len(pairs) != len(wantFreqs) {
+		t.Fatalf("expected %d pairs, got %d", len(wantFreqs), len(pairs))
+	}
+	for i, freq := range wantFreqs {
+		if pairs[i].FrequencyMHz != freq {
+			t.Errorf("pair %d: frequency %v, want %v", i, pairs[i].FrequencyMHz, freq)
+		}
+	}
+
+	if total := freqs.Total(); total != 20 {
+		t.Errorf("Total() = %v, want 20", total)
+	}
+
+	wantMean := (338*10 + 618*5.0 + 924*5.0) / 20
+	if mean := freqs.WeightedMeanMHz(); math.Abs(mean-wantMean) > 1e-9 {
+		t.Errorf("WeightedMeanMHz() = %v, want %v", mean, wantMean)
+	}
+}
+
+func TestParser_PauseResume(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{})
+	reader, writer := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream := parser.RunWithReader(ctx, reader)
+	go func() {
+		for range stream.Errors {
+		}
+	}()
+
+	writeLine := func(line string) {
+		if _, err := io.WriteString(writer, line+"\n"); err != nil {
+			t.Fatalf("write %q: %v", line, err)
+		}
+	}
+	nextCPUPower := func() float64 {
+		select {
+		case metrics := <-stream.Metrics:
+			if metrics.SystemSample == nil {
+				t.Fatalf("expected system metrics, got %#v", metrics)
+			}
+			return metrics.SystemSample.CPUPowerWatts
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for metrics")
+		}
+		return 0
+	}
+
+	writeLine("CPU Power: 1 W")
+	if got := nextCPUPower(); got != 1 {
+		t.Fatalf("expected 1 W before pause, got %v", got)
+	}
+
+	parser.Pause()
+	if !parser.Paused() {
+		t.Fatalf("expected parser to report paused")
+	}
+	writeLine("CPU Power: 2 W")
+	// A non-metric line: once the pipe accepts it, the previous line has been handled.
+	writeLine("-- sync --")
+	select {
+	case metrics := <-stream.Metrics:
+		t.Fatalf("expected no metrics while paused, got %#v", metrics)
+	default:
+	}
+
+	parser.Resume()
+	writeLine("CPU Power: 3 W")
+	if got := nextCPUPower(); got != 3 {
+		t.Fatalf("expected 3 W after resume, got %v", got)
+	}
+
+	writer.Close()
+	for range stream.Metrics {
+	}
+}
+
+func TestMetrics_FlatRow(t *testing.T) {
+	t.Parallel()
+
+	full := Metrics{
+		Elapsed:      5021960 * time.Microsecond,
+		SystemSample: &SystemSample{CPUPowerWatts: 0.954, GPUPowerWatts: 0.028, BatteryPercent: 36},
+		Network:      &NetworkMetrics{InBytesPerSec: 113827.21, OutBytesPerSec: 4586.65},
+		CPUResidencies: []CPUResidencyMetrics{
+			{CPUID: 0, ActiveResidency: CPUResidencyData{1020: 39, 1404: 2.2}, IdleResidency: 44.89, Frequency: 1338},
+		},
+		Clusters: []ClusterInfo{{Name: "E-Cluster", OnlinePercent: 100, HWActiveFreq: 1293}},
+	}
+
+	row := full.FlatRow()
+	checks := map[string]interface{}{
+		"cpu.power_w":          0.954,
+		"gpu.power_w":          0.028,
+		"battery.pct":          36.0,
+		"net.in_bytes_s":       113827.21,
+		"net.out_bytes_s":      4586.65,
+		"elapsed_ms":           5021.96,
+		"cpu0.busy_pct":        41.2,
+		"cpu0.freq_mhz":        1338.0,
+		"e-cluster.online_pct": 100.0,
+		"disk.read_bytes_s":    nil,
+		"timestamp":            nil,
+	}
+	for key, want := range checks {
+		got, ok := row[key]
+		if !ok {
+			t.Errorf("missing key %q", key)
+			continue
+		}
+		if wantF, isFloat := want.(float64); isFloat {
+			gotF, _ := got.(float64)
+			if math.Abs(gotF-wantF) > 1e-9 {
+				t.Errorf("%s = %v, want %v", key, got, want)
+			}
+		} else if got != want {
+			t.Errorf("%s = %v, want %v", key, got, want)
+		}
+	}
+
+	// Fixed columns must not depend on which sections a sample carries.
+	empty := Metrics{}.FlatRow()
+	for key := range empty {
+		if _, ok := row[key]; !ok {
+			t.Errorf("key %q present for empty sample but missing for full sample", key)
+		}
+	}
+	for _, key := range []string{"cpu.power_w", "net.in_bytes_s", "disk.write_ops_s", "gpu.active_pct"} {
+		if value, ok := empty[key]; !ok || value != nil {
+			t.Errorf("expected %q to be present and nil for empty sample, got %v (present=%t)", key, value, ok)
+		}
+	}
+}
+
+func TestMetrics_BinaryRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	original := Metrics{
+		Timestamp: time.Date(2024, time.March, 4, 10, 15, 30, 0, time.FixedZone("PST", -8*3600)),
+		Elapsed:   5021960 * time.Microsecond,
+		SystemSample: &SystemSample{
+			CPUPowerWatts:   0.954,
+			GPUPowerWatts:   0.028,
+			BatteryPercent:  36,
+			ThermalPressure: "Nominal",
+		},
+		ProcessSamples:    []ProcessSample{{Name: "WindowServer", PID: 412, CPUMsPerSec: 21.5}},
+		DeadTasks:         &ProcessSample{Name: deadTasksName, PID: -1, CPUMsPerSec: 3.1},
+		GPUProcessSamples: []GPUProcessSample{{Name: "WindowServer", PID: 412, ActiveNanos: 17600000, BusyPercent: 35.2}},
+		Clusters:          []ClusterInfo{{Name: "E-Cluster", Type: "Efficiency", OnlinePercent: 100, HWActiveFreq: 1293}},
+		CPUResidencies: []CPUResidencyMetrics{
+			{CPUID: 0, ActiveResidency: CPUResidencyData{1020: 39, 1404: 2.2}, IdleResidency: 44.89, Frequency: 1338},
+		},
+		GPUResidency: &GPUResidencyMetrics{
+			HWActiveResidency:     4.2,
+			HWActiveFreqResidency: FrequencyResidencyData{338: 3.1, 618: 1.1},
+			SWStates:              map[string]float64{"SW_P1": 4.2},
+		},
+		Network:    &NetworkMetrics{InBytesPerSec: 113827.21},
+		Disk:       &DiskMetrics{WriteOpsPerSec: 12},
+		Interrupts: []InterruptMetrics{{CPUID: 0, TotalIRQ: 1130.8, IPI: 284.63, TIMER: 553.21}},
+	}
+
+	data, err := original.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() error = %v", err)
+	}
+
+	var decoded Metrics
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary() error = %v", err)
+	}
+
+	if !decoded.Timestamp.Equal(original.Timestamp) {
+		t.Errorf("Timestamp = %v, want %v", decoded.Timestamp, original.Timestamp)
+	}
+	decoded.Timestamp = original.Timestamp
+	if !reflect.DeepEqual(decoded, original) {
+		t.Errorf("round trip mismatch:\n got %+v\nwant %+v", decoded, original)
+	}
+
+	if err := decoded.UnmarshalBinary(nil); err == nil {
+		t.Error("expected error for empty input")
+	}
+	if data[0] != 2 {
+		t.Errorf("encoding version = %d, want 2", data[0])
+	}
+	if err := decoded.UnmarshalBinary(append([]byte{1}, data[1:]...)); err == nil {
+		t.Error("expected error for the version 1 encoding, which lacks the presence bits")
+	}
+	if err := decoded.UnmarshalBinary(append([]byte{99}, data[1:]...)); err == nil {
+		t.Error("expected error for unknown encoding version")
+	}
+}
+
+func TestMetrics_BinaryRoundTripParsedFixture(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	data, err := os.ReadFile("testdata/recorded_run.log")
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	energy := strings.Join([]string{
+		"*** Running tasks ***",
+		"",
+		"Name                               ID     CPU ms/s  User%  Deadlines (<2 ms, 2-5 ms)  Wakeups (Intr, Pkg idle)  Energy Impact",
+		"mdworker_shared                    2001   0.10      50.00  0.00    0.00               0.20    0.10              0.00",
+		"DEAD_TASKS                         -1     4.21      51.95  0.00    0.00               9.36    0.00              0.00",
+		"",
+	}, "\n")
+
+	stream := RunReader(context.Background(), Config{}, io.MultiReader(bytes.NewReader(data), strings.NewReader(energy)))
+	var samples []Metrics
+	for metrics := range stream.Metrics {
+		samples = append(samples, metrics)
+	}
+	for err := range stream.Errors {
+		t.Fatalf("unexpected stream error: %v", err)
+	}
+
+	reported := false
+	for _, original := range samples {
+		encoded, err := original.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary() error = %v", err)
+		}
+		var decoded Metrics
+		if err := decoded.UnmarshalBinary(encoded); err != nil {
+			t.Fatalf("UnmarshalBinary() error = %v", err)
+		}
+		// gob keeps the instant and offset but not the *time.Location.
+		if !decoded.Timestamp.Equal(original.Timestamp) || !decoded.ReceivedAt.Equal(original.ReceivedAt) {
+			t.Fatalf("sample %d: times changed in the round trip", original.Sequence)
+		}
+		decoded.Timestamp, decoded.ReceivedAt = original.Timestamp, original.ReceivedAt
+		if !reflect.DeepEqual(decoded, original) {
+			t.Fatalf("sample %d: round trip mismatch:\n got %+v\nwant %+v", original.Sequence, decoded, original)
+		}
+
+		opts := JSONOptions{OmitUnmeasured: true}
+		before, _ := opts.Marshal(original)
+		after, _ := opts.Marshal(decoded)
+		if !bytes.Equal(before, after) {
+			t.Fatalf("sample %d: JSON changed in the round trip:\n got %s\nwant %s", original.Sequence, after, before)
+		}
+		if original.DeadTasks != nil && original.DeadTasks.EnergyImpactReported() {
+			reported = decoded.DeadTasks.EnergyImpactReported() && decoded.ProcessSamples[0].EnergyImpactReported()
+		}
+	}
+	if len(samples) == 0 || !reported {
+		t.Errorf("expected the Energy Impact presence to survive the round trip (%d samples)", len(samples))
+	}
+}
+
+func TestRunWithReader_RecordsRawLog(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	data, err := os.ReadFile("powermetrics_sample.log")
+	if err != nil {
+		t.Fatalf("read sample log: %v", err)
+	}
+
+	rawPath := t.TempDir() + "/raw.log"
+	stream := RunReader(context.Background(), Config{RawLogPath: rawPath}, bytes.NewReader(data))
+
+	count := 0
+	for range stream.Metrics {
+		count++
+	}
+	for err := range stream.Errors {
+		t.Fatalf("unexpected stream error: %v", err)
+	}
+	if count == 0 {
+		t.Fatalf("expected metrics while recording")
+	}
+
+	recorded, err := os.ReadFile(rawPath)
+	if err != nil {
+		t.Fatalf("read raw log: %v", err)
+	}
+	if !bytes.Equal(recorded, data) {
+		t.Fatalf("recorded raw log differs from input: got %d bytes, want %d", len(recorded), len(data))
+	}
+}
+
+func TestRunWithReader_RawLogCreateError(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	rawPath := t.TempDir() + "/missing/raw.log"
+	stream := RunReader(context.Background(), Config{RawLogPath: rawPath}, strings.NewReader(""))
+
+	for range stream.Metrics {
+		t.Fatalf("expected no metrics")
+	}
+	err, ok := <-stream.Errors
+	if !ok || err == nil || !strings.Contains(err.Error(), "create raw log") {
+		t.Fatalf("expected create raw log error, got %v", err)
+	}
+}
+
+func TestRunWithReader_StripsUTF8BOM(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	// The fixture is the first sample of recorded_run.log with a UTF-8 BOM
+	// prepended, as editors on Windows save it.
+	data, err := os.ReadFile("testdata/bom_sample.log")
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte("\xef\xbb\xbfMachine model:")) {
+		t.Fatalf("expected the fixture to start with a BOM and the preamble")
+	}
+
+	collect := func(input []byte) []Metrics {
+		stream := RunReader(context.Background(), Config{}, bytes.NewReader(input))
+		var got []Metrics
+		for metrics := range stream.Metrics {
+			// Per-CPU sections come out in map order.
+			sort.Slice(metrics.CPUResidencies, func(i, j int) bool {
+				return metrics.CPUResidencies[i].CPUID < metrics.CPUResidencies[j].CPUID
+			})
+			sort.Slice(metrics.Interrupts, func(i, j int) bool {
+				return metrics.Interrupts[i].CPUID < metrics.Interrupts[j].CPUID
+			})
+			metrics.ReceivedAt = time.Time{}
+			got = append(got, metrics)
+		}
+		for err := range stream.Errors {
+			t.Fatalf("unexpected stream error: %v", err)
+		}
+		return got
+	}
+
+	got := collect(data)
+	want := collect(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	if len(got) == 0 || !reflect.DeepEqual(got, want) {
+		t.Fatalf("BOM input parsed differently: got %d samples, want %d", len(got), len(want))
+	}
+	tasks := false
+	for _, metrics := range got {
+		tasks = tasks || len(metrics.ProcessSamples) > 0
+	}
+	last := got[len(got)-1]
+	if !tasks || last.SystemSample == nil || last.SystemSample.CPUPowerWatts == 0 || last.Timestamp.IsZero() {
+		t.Errorf("expected a timestamped sample with system and task metrics, got %+v", last)
+	}
+}
+
+func TestParser_CommandEnv(t *testing.T) {
+	t.Parallel()
+
+	parser := NewParser(Config{PowermetricsPath: "/bin/echo", Env: []string{"LC_ALL=C"}})
+	cmd := parser.command(context.Background())
+
+	if cmd.Path != "/bin/echo" {
+		t.Errorf("cmd.Path = %q, want /bin/echo", cmd.Path)
+	}
+	if len(cmd.Env) == 0 || cmd.Env[len(cmd.Env)-1] != "LC_ALL=C" {
+		t.Fatalf("expected LC_ALL=C appended to the environment, got %v", cmd.Env)
+	}
+	if len(cmd.Env) != len(os.Environ())+1 {
+		t.Errorf("expected the current environment to be inherited, got %d entries", len(cmd.Env))
+	}
+
+	plain := NewParser(Config{}).command(context.Background())
+	if plain.Env != nil {
+		t.Errorf("expected nil Env to inherit the environment unchanged, got %v", plain.Env)
+	}
+}
+
+func TestParseLine_DecimalComma(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{DecimalComma: true})
+
+	metrics, err := parser.ParseLine("CPU Power: 15,5 W")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil || metrics.SystemSample == nil || metrics.SystemSample.CPUPowerWatts != 15.5 {
+		t.Fatalf("expected CPU power 15.5 W, got %+v", metrics)
+	}
+
+	metrics, err = parser.ParseLine("Battery: percent_charge: 36,25")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil || metrics.SystemSample == nil || metrics.SystemSample.BatteryPercent != 36.25 {
+		t.Fatalf("expected battery 36.25%%, got %+v", metrics)
+	}
+
+	metrics, err = parser.ParseLine("in: 12,5 packets/s, 345,25 bytes/s")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil || metrics.Network == nil {
+		t.Fatalf("expected network metrics, got %+v", metrics)
+	}
+	if metrics.Network.InPacketsPerSec != 12.5 || metrics.Network.InBytesPerSec != 345.25 {
+		t.Errorf("unexpected network metrics: %+v", *metrics.Network)
+	}
+
+	// Without the flag the comma value is not read as 15.5.
+	plain := NewParser(Config{})
+	metrics, err = plain.ParseLine("CPU Power: 15,5 W")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics != nil && metrics.SystemSample != nil && metrics.SystemSample.CPUPowerWatts == 15.5 {
+		t.Errorf("expected comma decimals to be ignored without DecimalComma")
+	}
+}
+
+func TestNormalizeDecimalCommas(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in, want string
+	}{
+		{"CPU Power: 15,5 W", "CPU Power: 15.5 W"},
+		{"out: 12 packets/s, 345 bytes/s", "out: 12 packets/s, 345 bytes/s"},
+		{"GPU SW state: (SW_P1 : 0,5% SW_P2 : 1,25%)", "GPU SW state: (SW_P1 : 0.5% SW_P2 : 1.25%)"},
+		{"no numbers here", "no numbers here"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.in, func(t *testing.T) {
+			t.Parallel()
+			if got := normalizeDecimalCommas(tt.in); got != tt.want {
+				t.Errorf("normalizeDecimalCommas(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunWithReader_RecordedRunIntegration(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	file, err := os.Open("testdata/recorded_run.log")
+	if err != nil {
+		t.Fatalf("open fixture: %v", err)
+	}
+	defer file.Close()
+
+	stream := RunReader(context.Background(), Config{}, file)
+
+	// The parser emits incremental snapshots as lines arrive; group them by
+	// sample header and keep the last snapshot plus the tasks table for each.
+	type sample struct {
+		last      Metrics
+		processes []ProcessSample
+		deadTasks *ProcessSample
+	}
+	var order []time.Time
+	samples := make(map[time.Time]*sample)
+	for metrics := range stream.Metrics {
+		s, ok := samples[metrics.Timestamp]
+		if !ok {
+			s = &sample{}
+			samples[metrics.Timestamp] = s
+			order = append(order, metrics.Timestamp)
+		}
+		if len(metrics.ProcessSamples) > 0 {
+			s.processes = metrics.ProcessSamples
+			s.deadTasks = metrics.DeadTasks
+			continue
+		}
+		s.last = metrics
+	}
+	for err := range stream.Errors {
+		t.Fatalf("unexpected stream error: %v", err)
+	}
+
+	if len(order) != 3 {
+		t.Fatalf("expected 3 samples, got %d (%v)", len(order), order)
+	}
+
+	wantElapsed := []time.Duration{5021960 * time.Microsecond, 5003120 * time.Microsecond, 4998400 * time.Microsecond}
+	wantBattery := []float64{36, 36, 35}
+	wantPressure := []string{"Nominal", "Nominal", "Moderate"}
+	var lastCPUPower float64
+
+	for i, ts := range order {
+		if ts.IsZero() {
+			t.Fatalf("sample %d has no timestamp", i)
+		}
+		if i > 0 && ts.Sub(order[i-1]) != 5*time.Second {
+			t.Errorf("sample %d: expected timestamps 5s apart, got %v after %v", i, ts, order[i-1])
+		}
+
+		s := samples[ts]
+		m := s.last
+		if m.Elapsed != wantElapsed[i] {
+			t.Errorf("sample %d: Elapsed = %v, want %v", i, m.Elapsed, wantElapsed[i])
+		}
+		if m.SystemSample == nil {
+			t.Fatalf("sample %d: missing system sample", i)
+		}
+		if m.SystemSample.BatteryPercent != wantBattery[i] {
+			t.Errorf("sample %d: BatteryPercent = %v, want %v", i, m.SystemSample.BatteryPercent, wantBattery[i])
+		}
+		if m.SystemSample.ThermalPressure != wantPressure[i] {
+			t.Errorf("sample %d: ThermalPressure = %q, want %q", i, m.SystemSample.ThermalPressure, wantPressure[i])
+		}
+		if m.SystemSample.CPUPowerWatts <= lastCPUPower {
+			t.Errorf("sample %d: expected CPU power to rise across the fixture, got %v after %v", i, m.SystemSample.CPUPowerWatts, lastCPUPower)
+		}
+		lastCPUPower = m.SystemSample.CPUPowerWatts
+
+		if len(m.Clusters) != 3 {
+			t.Errorf("sample %d: expected 3 clusters, got %d", i, len(m.Clusters))
+		}
+		if len(m.CPUResidencies) != 14 {
+			t.Errorf("sample %d: expected 14 CPU residencies, got %d", i, len(m.CPUResidencies))
+		}
+		if len(m.Interrupts) != 14 {
+			t.Errorf("sample %d: expected 14 interrupt entries, got %d", i, len(m.Interrupts))
+		}
+		if m.GPUResidency == nil || m.GPUResidency.HWActiveResidency != 1.63 {
+			t.Errorf("sample %d: unexpected GPU residency %+v", i, m.GPUResidency)
+		}
+		if m.Network == nil || m.Network.InBytesPerSec != 113827.21 {
+			t.Errorf("sample %d: unexpected network metrics %+v", i, m.Network)
+		}
+		if m.Disk == nil || m.Disk.WriteOpsPerSec != 73.88 {
+			t.Errorf("sample %d: unexpected disk metrics %+v", i, m.Disk)
+		}
+
+		if len(s.processes) != 17 {
+			t.Errorf("sample %d: expected 17 processes, got %d", i, len(s.processes))
+		}
+		if s.deadTasks == nil {
+			t.Errorf("sample %d: expected DEAD_TASKS row", i)
+		}
+	}
+}
+
+func TestMetrics_ClusterResidenciesJoinClusterLines(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{})
+	lines := []string{
+		"E-Cluster Online: 100%",
+		"E-Cluster HW active frequency: 1293 MHz",
+		"E-Cluster HW active residency: 100.00% (1020 MHz:  75% 1404 MHz: 3.5%)",
+		"E-Cluster Power: 120 mW",
+		"P0-Cluster Online: 14%",
+		"P0-Cluster HW active frequency: 2507 MHz",
+		"P0-Cluster HW active residency:   5.88% (1260 MHz: 2.6% 4512 MHz: .80%)",
+		"P0-Cluster Power: 2.1 W",
+	}
+
+	var last *Metrics
+	for _, line := range lines {
+		metrics, err := parser.ParseLine(line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+		if metrics != nil {
+			last = metrics
+		}
+	}
+	if last == nil {
+		t.Fatalf("expected metrics")
+	}
+
+	clusters := last.ClusterResidencies
+	if len(clusters) != 2 {
+		t.Fatalf("expected 2 cluster residencies, got %+v", clusters)
+	}
+
+	e, p := clusters[0], clusters[1]
+	if e.Name != "E-Cluster" || e.Type != "Efficiency" || e.OnlinePercent != 100 || e.HWActiveFreq != 1293 {
+		t.Errorf("unexpected E-Cluster info: %+v", e)
+	}
+	if e.HWActiveResidency != 100 || e.HWActiveFreqResidency[1020] != 75 || math.Abs(e.PowerWatts-0.12) > 1e-9 {
+		t.Errorf("unexpected E-Cluster residency/power: %+v", e)
+	}
+	if p.Name != "P0-Cluster" || p.Type != "Performance" || p.OnlinePercent != 14 || p.HWActiveFreq != 2507 {
+		t.Errorf("unexpected P0-Cluster info: %+v", p)
+	}
+	if p.HWActiveResidency != 5.88 || p.HWActiveFreqResidency[4512] != 0.8 || p.PowerWatts != 2.1 {
+		t.Errorf("unexpected P0-Cluster residency/power: %+v", p)
+	}
+}
+
+func TestParser_ClusterLinesShareOneObject(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{})
+	lines := []string{
+		"E-Cluster Online: 100%",
+		"E-Cluster HW active frequency: 1293 MHz",
+		"E-Cluster HW active residency: 100.00% (1020 MHz:  75% 1404 MHz: 3.5%)",
+	}
+
+	var last *Metrics
+	for _, line := range lines {
+		metrics, err := parser.ParseLine(line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+		if metrics != nil {
+			last = metrics
+		}
+	}
+
+	if len(parser.clusters) != 1 {
+		t.Fatalf("expected a single tracked cluster, got %d", len(parser.clusters))
+	}
+	cluster := parser.clusters["E-Cluster"]
+	if cluster == nil {
+		t.Fatalf("expected E-Cluster to be tracked")
+	}
+	if cluster.OnlinePercent != 100 || cluster.HWActiveFreq != 1293 || cluster.HWActiveResidency != 100 || cluster.HWActiveFreqResidency[1020] != 75 {
+		t.Errorf("expected online, frequency and residency on the same object, got %+v", *cluster)
+	}
+
+	if last == nil || len(last.Clusters) != 1 || len(last.ClusterResidencies) != 1 {
+		t.Fatalf("expected one cluster in both views, got %+v", last)
+	}
+	if last.Clusters[0].OnlinePercent != last.ClusterResidencies[0].OnlinePercent ||
+		last.Clusters[0].HWActiveFreq != last.ClusterResidencies[0].HWActiveFreq {
+		t.Errorf("cluster views diverged: %+v vs %+v", last.Clusters[0], last.ClusterResidencies[0])
+	}
+}
+
+func TestParser_ClusterResidencyNaming(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	tests := []struct {
+		line     string
+		wantName string
+		want     float64
+	}{
+		{"E-Cluster HW active residency: 100.00% (1020 MHz:  75% 1404 MHz: 3.5%)", "E-Cluster", 100},
+		{"P1-Cluster HW active residency:  35.43% (1260 MHz:  11% 4512 MHz: 2.9%)", "P1-Cluster", 35.43},
+		{"[sample 3] P0-Cluster HW active residency:   5.88% (1260 MHz: 2.6%)", "P0-Cluster", 5.88},
+	}
+
+	for _, tt := range tests {
+		parser := NewParser(Config{})
+		if _, err := parser.ParseLine(tt.line); err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", tt.line, err)
+		}
+		if len(parser.clusters) != 1 {
+			t.Fatalf("%q: expected one cluster, got %d", tt.line, len(parser.clusters))
+		}
+		cluster, ok := parser.clusters[tt.wantName]
+		if !ok {
+			t.Fatalf("%q: expected cluster %q, got %v", tt.line, tt.wantName, parser.clusters)
+		}
+		if cluster.HWActiveResidency != tt.want {
+			t.Errorf("%q: HWActiveResidency = %v, want %v", tt.line, cluster.HWActiveResidency, tt.want)
+		}
+		if len(cluster.HWActiveFreqResidency) == 0 {
+			t.Errorf("%q: expected frequency residency to be parsed", tt.line)
+		}
+	}
+}
+
+func TestParser_GPUCStates(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{})
+
+	metrics, err := parser.ParseLine("GPU C-state: (C0 :  12.5% C6 :  87.5%)")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil || metrics.GPUResidency == nil {
+		t.Fatalf("expected GPU residency metrics, got %+v", metrics)
+	}
+	want := GPUSoftwareStateData{"C0": 12.5, "C6": 87.5}
+	if !reflect.DeepEqual(metrics.GPUResidency.CStates, want) {
+		t.Errorf("CStates = %v, want %v", metrics.GPUResidency.CStates, want)
+	}
+
+	// The P-state block must not be mistaken for C-states and vice versa.
+	parser = NewParser(Config{})
+	metrics, err = parser.ParseLine("GPU SW state: (SW_P1 : 1.6% SW_P2 :   0%)")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil || metrics.GPUResidency == nil {
+		t.Fatalf("expected GPU residency metrics, got %+v", metrics)
+	}
+	if metrics.GPUResidency.CStates != nil {
+		t.Errorf("expected nil CStates when the block is absent, got %v", metrics.GPUResidency.CStates)
+	}
+	if metrics.GPUResidency.SWStates["SW_P1"] != 1.6 {
+		t.Errorf("unexpected SW states: %v", metrics.GPUResidency.SWStates)
+	}
+
+	// Metrics emitted by a later system line keep a GPU section that only
+	// has state residency.
+	for _, states := range []string{"GPU C-state: (C0 :  12.5% C6 :  87.5%)", "GPU SW state: (SW_P1 : 1.6% SW_P2 :   0%)"} {
+		parser = NewParser(Config{})
+		if _, err := parser.ParseLine(states); err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", states, err)
+		}
+		metrics, err = parser.ParseLine("CPU Power: 954 mW")
+		if err != nil {
+			t.Fatalf("ParseLine returned error: %v", err)
+		}
+		if metrics == nil || metrics.SystemSample == nil || metrics.GPUResidency == nil {
+			t.Errorf("after %q: expected system and GPU residency metrics, got %+v", states, metrics)
+		}
+	}
+}
+
+func TestSystemSample_HottestComponent(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		sample   SystemSample
+		wantName string
+		wantTemp float64
+	}{
+		{"cpu hotter", SystemSample{CPUTemperatureC: 78.5, GPUTemperatureC: 61}, "CPU", 78.5},
+		{"gpu hotter", SystemSample{CPUTemperatureC: 55, GPUTemperatureC: 70.25}, "GPU", 70.25},
+		{"only gpu reported", SystemSample{GPUTemperatureC: 48}, "GPU", 48},
+		{"tie favors cpu", SystemSample{CPUTemperatureC: 60, GPUTemperatureC: 60}, "CPU", 60},
+		{"unset", SystemSample{}, "", 0},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			name, temp := tt.sample.HottestComponent()
+			if name != tt.wantName || temp != tt.wantTemp {
+				t.Errorf("HottestComponent() = (%q, %v), want (%q, %v)", name, temp, tt.wantName, tt.wantTemp)
+			}
+		})
+	}
+}
+
+func TestErrorCoalescer_CollapsesRepeats(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	out := make(chan error, 8)
+	coalescer := &errorCoalescer{out: out}
+	for i := 0; i < 100; i++ {
+		coalescer.send(fmt.Errorf("parse line: %w", errors.New("broken")))
+	}
+	coalescer.send(errors.New("other"))
+	coalescer.flush()
+	close(out)
+
+	var errs []error
+	for err := range out {
+		errs = append(errs, err)
+	}
+	if len(errs) != 3 {
+		t.Fatalf("expected first error, suppression summary and the distinct error, got %d: %v", len(errs), errs)
+	}
+	if errs[0].Error() != "parse line: broken" {
+		t.Errorf("unexpected first error: %v", errs[0])
+	}
+	if !strings.HasPrefix(errs[1].Error(), "99 identical parse errors suppressed") || errors.Unwrap(errs[1]) != errs[0] {
+		t.Errorf("expected suppression summary wrapping the first error, got %v", errs[1])
+	}
+	if errs[2].Error() != "other" {
+		t.Errorf("expected the distinct error to be reported, got %v", errs[2])
+	}
+
+	// A run still pending when the stream ends is reported by flush.
+	out = make(chan error, 8)
+	coalescer = &errorCoalescer{out: out}
+	coalescer.send(errors.New("broken"))
+	coalescer.send(errors.New("broken"))
+	coalescer.flush()
+	coalescer.flush()
+	close(out)
+	errs = errs[:0]
+	for err := range out {
+		errs = append(errs, err)
+	}
+	if len(errs) != 2 || !strings.HasPrefix(errs[1].Error(), "1 identical parse errors suppressed") {
+		t.Errorf("expected the error and one summary, got %v", errs)
+	}
+}
+
+func TestSystemSample_MarshalJSONOmitsUnmeasured(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parse := func(opts JSONOptions) map[string]interface{} {
+		parser := NewParser(Config{})
+		var last *Metrics
+		for _, line := range []string{"CPU Power: 1.5 W", "ANE Power: 0 W"} {
+			metrics, err := parser.ParseLine(line)
+			if err != nil {
+				t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+			}
+			if metrics != nil {
+				last = metrics
+			}
+		}
+		if last == nil || last.SystemSample == nil {
+			t.Fatalf("expected system metrics")
+		}
+
+		data, err := opts.Marshal(*last)
+		if err != nil {
+			t.Fatalf("Marshal returned error: %v", err)
+		}
+		var decoded struct {
+			SystemSample map[string]interface{}
+		}
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("json.Unmarshal returned error: %v", err)
+		}
+		return decoded.SystemSample
+	}
+
+	omitted := parse(JSONOptions{OmitUnmeasured: true})
+	if _, ok := omitted["CPUTemperatureC"]; ok {
+		t.Errorf("expected unmeasured CPUTemperatureC to be omitted, got %v", omitted)
+	}
+	if _, ok := omitted["ThermalPressure"]; ok {
+		t.Errorf("expected unreported ThermalPressure to be omitted, got %v", omitted)
+	}
+	if omitted["CPUPowerWatts"] != 1.5 {
+		t.Errorf("expected CPUPowerWatts 1.5, got %v", omitted["CPUPowerWatts"])
+	}
+	if value, ok := omitted["ANEPowerWatts"]; !ok || value != 0.0 {
+		t.Errorf("expected measured zero ANEPowerWatts to be kept, got %v (present=%t)", value, ok)
+	}
+
+	full := parse(JSONOptions{})
+	if value, ok := full["CPUTemperatureC"]; !ok || value != 0.0 {
+		t.Errorf("expected CPUTemperatureC 0 by default, got %v (present=%t)", value, ok)
+	}
+
+	// WriterSink formats with the same options.
+	var buf bytes.Buffer
+	sink := &WriterSink{W: &buf, JSON: JSONOptions{OmitUnmeasured: true}}
+	if err := sink.Write(Metrics{SystemSample: &SystemSample{CPUPowerWatts: 1.5, measured: measuredCPUPower}}); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	if got, want := buf.String(), `"SystemSample":{"CPUPowerWatts":1.5}`; !strings.Contains(got, want) || !strings.HasSuffix(got, "}\n") {
+		t.Errorf("sink wrote %q, want a line containing %s", got, want)
+	}
+}
+
+func TestParser_BacklightPercent(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	tests := []struct {
+		line string
+		want float64
+	}{
+		{"Backlight level: 512 (range 0-1024)", 50},
+		{"Backlight level: 75%", 75},
+		{"Backlight level: 2048 (range 0-1024)", 100},
+	}
+
+	for _, tt := range tests {
+		parser := NewParser(Config{})
+		metrics, err := parser.ParseLine(tt.line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", tt.line, err)
+		}
+		if metrics == nil || metrics.SystemSample == nil {
+			t.Fatalf("%q: expected system metrics", tt.line)
+		}
+		if metrics.SystemSample.BacklightPercent != tt.want {
+			t.Errorf("%q: BacklightPercent = %v, want %v", tt.line, metrics.SystemSample.BacklightPercent, tt.want)
+		}
+	}
+
+	// Desktop Macs report only the battery line, if anything.
+	parser := NewParser(Config{})
+	metrics, err := parser.ParseLine("Battery: percent_charge: 36")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil || metrics.SystemSample == nil || metrics.SystemSample.BacklightPercent != 0 {
+		t.Errorf("expected BacklightPercent to stay unset, got %+v", metrics)
+	}
+}
+
+func TestRunWithReader_StampsReceivedAt(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	input := strings.Join([]string{
+		"CPU Power: 1 W",
+		"GPU Power: 2 W",
+		"ANE Power: 3 W",
+		"pid 155    WindowServer               352ms  (35.2%)",
+	}, "\n")
+
+	before := time.Now()
+	stream := RunReader(context.Background(), Config{}, strings.NewReader(input))
+
+	var stamps []time.Time
+	for metrics := range stream.Metrics {
+		if !metrics.Timestamp.IsZero() {
+			t.Fatalf("expected no parsed sample timestamp without headers, got %v", metrics.Timestamp)
+		}
+		stamps = append(stamps, metrics.ReceivedAt)
+	}
+	for err := range stream.Errors {
+		t.Fatalf("unexpected stream error: %v", err)
+	}
+
+	if len(stamps) != 4 {
+		t.Fatalf("expected 4 metrics, got %d", len(stamps))
+	}
+	for i, stamp := range stamps {
+		if stamp.Before(before) || stamp.After(time.Now()) {
+			t.Errorf("metrics %d: ReceivedAt %v outside the test window", i, stamp)
+		}
+		if i > 0 && stamp.Before(stamps[i-1]) {
+			t.Errorf("metrics %d: ReceivedAt %v went backwards from %v", i, stamp, stamps[i-1])
+		}
+	}
+}
+
+func TestGPUResidencyMetrics_PowerWattsMatchesSystem(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	for _, tc := range []struct {
+		line string
+		want float64
+	}{
+		{"GPU Power: 1234 mW", 1.234},
+		{"GPU Power: 2.5 W", 2.5},
+	} {
+		parser := NewParser(Config{})
+		if _, err := parser.ParseLine("GPU HW active residency:  25.00% (389 MHz: 25%)"); err != nil {
+			t.Fatalf("ParseLine returned error: %v", err)
+		}
+		metrics, err := parser.ParseLine(tc.line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", tc.line, err)
+		}
+		if metrics == nil || metrics.GPUResidency == nil || metrics.SystemSample == nil {
+			t.Fatalf("ParseLine(%q): expected GPU residency and system sample, got %+v", tc.line, metrics)
+		}
+		if got := metrics.SystemSample.GPUPowerWatts; math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%q: SystemSample.GPUPowerWatts = %v, want %v", tc.line, got, tc.want)
+		}
+		if got := metrics.GPUResidency.PowerWatts(); math.Abs(got-metrics.SystemSample.GPUPowerWatts) > 1e-9 {
+			t.Errorf("%q: GPUResidency.PowerWatts() = %v, SystemSample.GPUPowerWatts = %v", tc.line, got, metrics.SystemSample.GPUPowerWatts)
+		}
+	}
+}
+
+func TestMetrics_SortedResidencyJSON(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	encode := func(opts JSONOptions) []byte {
+		parser := NewParser(Config{})
+		var last *Metrics
+		for _, line := range []string{
+			"CPU 0 active residency:  55.11% (1788 MHz: 3.2% 1020 MHz:  39% 1404 MHz: 2.2%)",
+			"GPU HW active residency:  25.00% (1398 MHz: 5% 389 MHz: 20%)",
+		} {
+			metrics, err := parser.ParseLine(line)
+			if err != nil {
+				t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+			}
+			if metrics != nil {
+				last = metrics
+			}
+		}
+		if last == nil || len(last.CPUResidencies) == 0 || last.GPUResidency == nil {
+			t.Fatalf("expected CPU and GPU residency metrics, got %+v", last)
+		}
+		data, err := opts.Marshal(*last)
+		if err != nil {
+			t.Fatalf("Marshal returned error: %v", err)
+		}
+		return data
+	}
+
+	var sorted struct {
+		CPUResidencies []struct {
+			CPUID           int
+			ActiveResidency []map[string]float64
+		}
+		GPUResidency struct {
+			HWActiveResidency     float64
+			HWActiveFreqResidency []map[string]float64
+		}
+	}
+	if err := json.Unmarshal(encode(JSONOptions{SortedResidency: true, OmitUnmeasured: true}), &sorted); err != nil {
+		t.Fatalf("json.Unmarshal returned error: %v", err)
+	}
+	wantCPU := []map[string]float64{
+		{"freq": 1020, "percent": 39},
+		{"freq": 1404, "percent": 2.2},
+		{"freq": 1788, "percent": 3.2},
+	}
+	if !reflect.DeepEqual(sorted.CPUResidencies[0].ActiveResidency, wantCPU) {
+		t.Errorf("CPU ActiveResidency = %v, want %v", sorted.CPUResidencies[0].ActiveResidency, wantCPU)
+	}
+	wantGPU := []map[string]float64{
+		{"freq": 389, "percent": 20},
+		{"freq": 1398, "percent": 5},
+	}
+	if !reflect.DeepEqual(sorted.GPUResidency.HWActiveFreqResidency, wantGPU) {
+		t.Errorf("GPU HWActiveFreqResidency = %v, want %v", sorted.GPUResidency.HWActiveFreqResidency, wantGPU)
+	}
+	if sorted.GPUResidency.HWActiveResidency != 25 {
+		t.Errorf("expected other GPU fields to be kept, got HWActiveResidency %v", sorted.GPUResidency.HWActiveResidency)
+	}
+
+	var keyed struct {
+		CPUResidencies []struct {
+			ActiveResidency map[string]float64
+		}
+	}
+	if err := json.Unmarshal(encode(JSONOptions{}), &keyed); err != nil {
+		t.Fatalf("json.Unmarshal returned error: %v", err)
+	}
+	if keyed.CPUResidencies[0].ActiveResidency["1020"] != 39 {
+		t.Errorf("expected map-keyed residency by default, got %v", keyed.CPUResidencies[0].ActiveResidency)
+	}
+}
+
+func TestMetrics_WriteResidencyHistogram(t *testing.T) {
+	metrics := Metrics{
+		CPUResidencies: []CPUResidencyMetrics{
+			{CPUID: 0, ActiveResidency: CPUResidencyData{600: 10, 1020: 20}},
+			{CPUID: 4, ActiveResidency: CPUResidencyData{1020: 5, 3000: 40}},
+		},
+	}
+
+	want := FrequencyResidencyData{600: 10, 1020: 25, 3000: 40}
+	if got := metrics.CPUFrequencyResidency(); !reflect.DeepEqual(got, want) {
+		t.Errorf("CPUFrequencyResidency() = %v, want %v", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := metrics.WriteResidencyHistogram(&buf); err != nil {
+		t.Fatalf("WriteResidencyHistogram returned error: %v", err)
+	}
+	for _, line := range []string{
+		"# TYPE powermetrics_cpu_frequency_residency_percent histogram",
+		`powermetrics_cpu_frequency_residency_percent_bucket{le="600"} 10`,
+		`powermetrics_cpu_frequency_residency_percent_bucket{le="1020"} 35`,
+		`powermetrics_cpu_frequency_residency_percent_bucket{le="3000"} 75`,
+		`powermetrics_cpu_frequency_residency_percent_bucket{le="+Inf"} 75`,
+		"powermetrics_cpu_frequency_residency_percent_sum 151500",
+		"powermetrics_cpu_frequency_residency_percent_count 75",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("histogram output missing %q:\n%s", line, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := (Metrics{}).WriteResidencyHistogram(&buf); err != nil || buf.Len() != 0 {
+		t.Errorf("expected no output without residency data, got %q (err %v)", buf.String(), err)
+	}
+}
+
+func TestMetrics_ClusterActivityBalance(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{})
+	lines := []string{
+		"E-Cluster Online: 100%",
+		"E-Cluster HW active residency:  60.00% (1020 MHz:  55% 1404 MHz: 5%)",
+		"P0-Cluster Online: 14%",
+		"P0-Cluster HW active residency:  20.00% (1260 MHz: 15% 4512 MHz: 5%)",
+	}
+
+	var last *Metrics
+	for _, line := range lines {
+		metrics, err := parser.ParseLine(line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+		if metrics != nil {
+			last = metrics
+		}
+	}
+	if last == nil {
+		t.Fatalf("expected metrics")
+	}
+
+	balance := last.ClusterActivityBalance()
+	if len(balance) != 2 {
+		t.Fatalf("expected 2 clusters, got %v", balance)
+	}
+	if math.Abs(balance["E-Cluster"]-75) > 1e-9 || math.Abs(balance["P0-Cluster"]-25) > 1e-9 {
+		t.Errorf("unexpected balance: %v", balance)
+	}
+
+	idle := Metrics{ClusterResidencies: []ClusterResidencyMetrics{{Name: "E-Cluster"}, {Name: "P-Cluster"}}}
+	if got := idle.ClusterActivityBalance(); got != nil {
+		t.Errorf("expected nil balance for idle clusters, got %v", got)
+	}
+}
+
+func TestParser_PowerAvgScalesSampleWindow(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	for _, tc := range []struct {
+		args []string
+		want float64
+	}{
+		{[]string{"--samplers", "gpu_power", "--poweravg", "4"}, 12.5},
+		{[]string{"--poweravg=2"}, 25},
+		{[]string{"--samplers", "gpu_power"}, 50},
+	} {
+		parser := NewParser(Config{SampleWindow: time.Second, PowermetricsArgs: tc.args})
+
+		if _, err := parser.ParseLine("pid 1234   Safari                     500ms"); err != nil {
+			t.Fatalf("ParseLine returned error: %v", err)
+		}
+		metrics, err := parser.ParseLine("")
+		if err != nil {
+			t.Fatalf("ParseLine returned error: %v", err)
+		}
+		if metrics == nil || len(metrics.GPUProcessSamples) != 1 {
+			t.Fatalf("expected one GPU process sample, got %#v", metrics)
+		}
+		if busy := metrics.GPUProcessSamples[0].BusyPercent; busy != tc.want {
+			t.Errorf("args %v: BusyPercent = %v, want %v", tc.args, busy, tc.want)
+		}
+	}
+}
+
+func TestParser_DRAMBandwidth(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{})
+	var last *Metrics
+	for _, line := range []string{
+		"DRAM Power: 412 mW",
+		"DRAM read bandwidth: 12.5 GB/s",
+		"DRAM write bandwidth: 850 MB/s",
+	} {
+		metrics, err := parser.ParseLine(line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+		if metrics == nil || metrics.SystemSample == nil {
+			t.Fatalf("%q: expected system metrics", line)
+		}
+		last = metrics
+	}
+
+	s := last.SystemSample
+	if s.DRAMReadBandwidthGBs != 12.5 {
+		t.Errorf("DRAMReadBandwidthGBs = %v, want 12.5", s.DRAMReadBandwidthGBs)
+	}
+	if math.Abs(s.DRAMWriteBandwidthGBs-0.85) > 1e-9 {
+		t.Errorf("DRAMWriteBandwidthGBs = %v, want 0.85", s.DRAMWriteBandwidthGBs)
+	}
+	if math.Abs(s.DRAMPowerWatts-0.412) > 1e-9 {
+		t.Errorf("DRAMPowerWatts = %v, want 0.412", s.DRAMPowerWatts)
+	}
+}
+
+func TestParser_RestartPolicyRestartsAfterCrash(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	crash := errors.New("exit status 1")
+	runs := []struct {
+		output string
+		exit   error
+	}{
+		{"CPU Power: 1.5 W\n", crash},
+		{"CPU Power: 2.5 W\n", nil},
+	}
+
+	newFactory := func() (readerFactory, *int) {
+		calls := 0
+		return func(context.Context) (io.Reader, func() error, error) {
+			run := runs[calls]
+			calls++
+			return strings.NewReader(run.output), func() error { return run.exit }, nil
+		}, &calls
+	}
+
+	parser := NewParser(Config{RestartPolicy: RestartPolicy{MaxRetries: 2, Backoff: time.Millisecond}})
+	factory, calls := newFactory()
+	stream, err := parser.newStream(context.Background(), factory)
+	if err != nil {
+		t.Fatalf("newStream returned error: %v", err)
+	}
+
+	var power []float64
+	for metrics := range stream.Metrics {
+		if metrics.SystemSample != nil {
+			power = append(power, metrics.SystemSample.CPUPowerWatts)
+		}
+	}
+	var errs []error
+	for err := range stream.Errors {
+		errs = append(errs, err)
+	}
+
+	if *calls != 2 {
+		t.Errorf("expected powermetrics to be started twice, got %d", *calls)
+	}
+	if !reflect.DeepEqual(power, []float64{1.5, 2.5}) {
+		t.Errorf("expected metrics from both runs on one stream, got %v", power)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], crash) || !strings.Contains(errs[0].Error(), "restarting") {
+		t.Errorf("expected a single restart diagnostic wrapping the crash, got %v", errs)
+	}
+
+	// Without a policy the crash ends the stream.
+	parser = NewParser(Config{})
+	factory, calls = newFactory()
+	stream, err = parser.newStream(context.Background(), factory)
+	if err != nil {
+		t.Fatalf("newStream returned error: %v", err)
+	}
+	for range stream.Metrics {
+	}
+	errs = errs[:0]
+	for err := range stream.Errors {
+		errs = append(errs, err)
+	}
+	if *calls != 1 || len(errs) != 1 || errs[0] != crash {
+		t.Errorf("expected the crash to be reported without restarting, got %d calls and %v", *calls, errs)
+	}
+}
+
+func TestRestartPolicy_Delay(t *testing.T) {
+	policy := RestartPolicy{Backoff: 100 * time.Millisecond}
+	for attempt, want := range map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		3:  400 * time.Millisecond,
+		20: time.Minute,
+	} {
+		if got := policy.delay(attempt); got != want {
+			t.Errorf("delay(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+func TestMetrics_FilterGPUProcesses(t *testing.T) {
+	metrics := Metrics{
+		GPUProcessSamples: []GPUProcessSample{
+			{PID: 155, Name: "WindowServer", BusyPercent: 35.2},
+			{PID: 5678, Name: "SampleApp", BusyPercent: 12.5},
+			{PID: 9012, Name: "Safari Web Content", BusyPercent: 0.4},
+		},
+	}
+
+	busy := metrics.FilterGPUProcesses(ByBusyAtLeast(12.5))
+	if len(busy) != 2 || busy[0].PID != 155 || busy[1].PID != 5678 {
+		t.Errorf("ByBusyAtLeast(12.5) = %+v, want WindowServer and SampleApp", busy)
+	}
+
+	named := metrics.FilterGPUProcesses(ByNameContains("Safari"))
+	if len(named) != 1 || named[0].PID != 9012 {
+		t.Errorf("ByNameContains(\"Safari\") = %+v, want Safari Web Content", named)
+	}
+
+	if got := metrics.FilterGPUProcesses(ByBusyAtLeast(50)); got != nil {
+		t.Errorf("expected nil when nothing matches, got %+v", got)
+	}
+}
+
+func TestParser_GPUProcessSamplesGroupedPerSample(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	input := strings.Join([]string{
+		"*** Sampled system activity (Sat Nov  8 15:54:21 2025 +0900) (1000.00ms elapsed) ***",
+		"pid 155    WindowServer               352ms  (35.2%)",
+		"pid 5678   (SampleApp)                125ms  (12.5%)",
+		"pid 9012   Safari                     40ms   (4.0%)",
+		"",
+		"*** Sampled system activity (Sat Nov  8 15:54:22 2025 +0900) (1000.00ms elapsed) ***",
+		"pid 155    WindowServer               100ms  (10.0%)",
+	}, "\n") + "\n"
+
+	stream := RunReader(context.Background(), Config{}, strings.NewReader(input))
+	var groups [][]GPUProcessSample
+	for metrics := range stream.Metrics {
+		if len(metrics.GPUProcessSamples) > 0 {
+			groups = append(groups, metrics.GPUProcessSamples)
+		}
+	}
+	for err := range stream.Errors {
+		t.Errorf("unexpected stream error: %v", err)
+	}
+
+	if len(groups) != 2 {
+		t.Fatalf("expected one GPU process group per sample, got %d: %+v", len(groups), groups)
+	}
+	var pids []int
+	for _, proc := range groups[0] {
+		pids = append(pids, proc.PID)
+	}
+	if !reflect.DeepEqual(pids, []int{155, 5678, 9012}) {
+		t.Errorf("first sample PIDs = %v, want [155 5678 9012]", pids)
+	}
+	if len(groups[1]) != 1 || groups[1][0].BusyPercent != 10 {
+		t.Errorf("unexpected second sample: %+v", groups[1])
+	}
+}
+
+func TestParser_MinGPUProcessBusyPercent(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	lines := []string{
+		"pid 155    WindowServer               352ms  (35.2%)",
+		"pid 5678   (SampleApp)                125ms  (12.5%)",
+		"pid 9012   Safari                     40ms   (4.0%)",
+		"",
+	}
+	parse := func(cfg Config) []GPUProcessSample {
+		parser := NewParser(cfg)
+		var last *Metrics
+		for _, line := range lines {
+			metrics, err := parser.ParseLine(line)
+			if err != nil {
+				t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+			}
+			if metrics != nil {
+				last = metrics
+			}
+		}
+		if last == nil {
+			return nil
+		}
+		return last.GPUProcessSamples
+	}
+
+	kept := parse(Config{MinGPUProcessBusyPercent: 10})
+	if len(kept) != 2 || kept[0].PID != 155 || kept[1].PID != 5678 {
+		t.Errorf("expected WindowServer and SampleApp above 10%%, got %+v", kept)
+	}
+	if all := parse(Config{}); len(all) != 3 {
+		t.Errorf("expected all 3 processes by default, got %+v", all)
+	}
+	if none := parse(Config{MinGPUProcessBusyPercent: 50}); none != nil {
+		t.Errorf("expected no GPU processes above 50%%, got %+v", none)
+	}
+}
+
+func TestParser_InterruptRateSuffixVariants(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	tests := []struct {
+		name   string
+		suffix string
+	}{
+		{"interrupts/sec", " interrupts/sec"},
+		{"ints/s", " ints/s"},
+		{"irqs", " irqs"},
+		{"missing unit", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := NewParser(Config{})
+			for _, line := range []string{
+				"CPU 0:",
+				"\tTotal IRQ: 2977.12" + tt.suffix,
+				"\t|-> IPI: 2232.79" + tt.suffix,
+				"\t|-> TIMER: 547.20" + tt.suffix,
+			} {
+				if _, err := parser.ParseLine(line); err != nil {
+					t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+				}
+			}
+
+			got := parser.interruptInfo[0]
+			if got == nil {
+				t.Fatalf("expected interrupt info for CPU 0")
+			}
+			if got.TotalIRQ != 2977.12 || got.IPI != 2232.79 || got.TIMER != 547.20 {
+				t.Errorf("unexpected interrupt rates: %+v", *got)
+			}
+		})
+	}
+
+	// The label anchors the match; other units are not mistaken for rates.
+	parser := NewParser(Config{})
+	for _, line := range []string{"CPU 0:", "Total IRQ: 12 ms"} {
+		if _, err := parser.ParseLine(line); err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+	}
+	if got := parser.interruptInfo[0].TotalIRQ; got != 0 {
+		t.Errorf("expected TotalIRQ to stay unset for an unknown unit, got %v", got)
+	}
+}
+
+func TestMetrics_TableGolden(t *testing.T) {
+	metrics := Metrics{
+		Timestamp: time.Date(2025, time.November, 8, 15, 54, 21, 0, time.FixedZone("JST", 9*3600)),
+		Elapsed:   1004 * time.Millisecond,
+		SystemSample: &SystemSample{
+			CPUPowerWatts:   0.954,
+			GPUPowerWatts:   0.028,
+			CPUFrequencyMHz: 1338,
+			GPUFrequencyMHz: 338,
+			GPUBusyPercent:  1.63,
+			BatteryPercent:  36,
+			ThermalPressure: "Nominal",
+			// ANE, DRAM, temperatures and CPU busy were not reported and
+			// must not be rendered as zero rows.
+			measured: measuredCPUPower | measuredGPUPower | measuredCPUFrequency |
+				measuredGPUFrequency | measuredGPUBusy | measuredBattery,
+		},
+		Clusters: []ClusterInfo{{Name: "E-Cluster", Type: "Efficiency", OnlinePercent: 100, HWActiveFreq: 1293}},
+		ClusterResidencies: []ClusterResidencyMetrics{
+			{Name: "E-Cluster", Type: "Efficiency", OnlinePercent: 100, HWActiveFreq: 1293, HWActiveResidency: 100},
+		},
+		CPUResidencies: []CPUResidencyMetrics{
+			{CPUID: 1, ActiveResidency: CPUResidencyData{1020: 20}, Frequency: 1020},
+			{CPUID: 0, ActiveResidency: CPUResidencyData{1020: 39, 1404: 16.11}, Frequency: 1338},
+		},
+		GPUResidency:      &GPUResidencyMetrics{HWActiveResidency: 1.63, IdleResidency: 98.37, PowerMilliwatts: 28},
+		GPUProcessSamples: []GPUProcessSample{{PID: 5678, Name: "SampleApp", BusyPercent: 12.5}, {PID: 155, Name: "WindowServer", BusyPercent: 35.2}},
+		Network:           &NetworkMetrics{InPacketsPerSec: 86.02, InBytesPerSec: 113827.21, OutPacketsPerSec: 12.5, OutBytesPerSec: 4586.65},
+		Interrupts:        []InterruptMetrics{{CPUID: 0, TotalIRQ: 2977.12, IPI: 2232.79, TIMER: 547.2}},
+	}
+
+	want, err := os.ReadFile("testdata/table.golden")
+	if err != nil {
+		t.Fatalf("read golden file: %v", err)
+	}
+	if got := metrics.Table(); got != string(want) {
+		t.Errorf("table output mismatch:\n got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if got := (Metrics{}).Table(); got != "" {
+		t.Errorf("expected empty table for an empty sample, got %q", got)
+	}
+}
+
+func TestParser_SystemWakeups(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{})
+
+	metrics, err := parser.ParseLine("Total wakeups: 1834.21 wakeups/s")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil || metrics.SystemSample == nil {
+		t.Fatalf("expected system metrics from the wakeups line")
+	}
+	if got := metrics.SystemSample.SystemWakeupsPerSec; got != 1834.21 {
+		t.Errorf("SystemWakeupsPerSec = %v, want 1834.21", got)
+	}
+
+	// Per-CPU interrupt totals must not be mistaken for system wakeups.
+	for _, line := range []string{"CPU 0:", "Total IRQ: 2977.12 interrupts/sec"} {
+		if _, err := parser.ParseLine(line); err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+	}
+	if got := parser.system.SystemWakeupsPerSec; got != 1834.21 {
+		t.Errorf("SystemWakeupsPerSec changed to %v after a per-CPU IRQ line", got)
+	}
+	if got := parser.interruptInfo[0].TotalIRQ; got != 2977.12 {
+		t.Errorf("TotalIRQ = %v, want 2977.12", got)
+	}
+}
+
+func TestParser_FrequencySnapMHz(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	lines := []string{
+		"CPU 0 active residency:  40.00% (1020 MHz:  30% 1404 MHz: 10%)",
+		"CPU 0 active residency:  40.00% (1019.6 MHz:  20% 1020.3 MHz: 5% 1404.4 MHz: 15%)",
+	}
+	parse := func(cfg Config) []CPUResidencyData {
+		parser := NewParser(cfg)
+		var got []CPUResidencyData
+		for _, line := range lines {
+			metrics, err := parser.ParseLine(line)
+			if err != nil {
+				t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+			}
+			if metrics == nil || len(metrics.CPUResidencies) != 1 {
+				t.Fatalf("%q: expected one CPU residency, got %+v", line, metrics)
+			}
+			got = append(got, metrics.CPUResidencies[0].ActiveResidency)
+		}
+		return got
+	}
+
+	snapped := parse(Config{FrequencySnapMHz: 1})
+	if want := (CPUResidencyData{1020: 25, 1404: 15}); !reflect.DeepEqual(snapped[1], want) {
+		t.Errorf("snapped residency = %v, want %v", snapped[1], want)
+	}
+
+	raw := parse(Config{})
+	if want := (CPUResidencyData{1019.6: 20, 1020.3: 5, 1404.4: 15}); !reflect.DeepEqual(raw[1], want) {
+		t.Errorf("unsnapped residency = %v, want %v", raw[1], want)
+	}
+
+	// A GPU step close to an already-seen CPU step must not snap onto it:
+	// each domain keeps its own step table.
+	parser := NewParser(Config{FrequencySnapMHz: 1})
+	for _, line := range append(lines, "GPU HW active residency:   1.63% (1020.5 MHz: 1.6% 1404 MHz: 0%)") {
+		if _, err := parser.ParseLine(line); err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+	}
+	if want := (FrequencyResidencyData{1020.5: 1.6, 1404: 0}); !reflect.DeepEqual(parser.gpuResidency.HWActiveFreqResidency, want) {
+		t.Errorf("GPU residency = %v, want %v", parser.gpuResidency.HWActiveFreqResidency, want)
+	}
+}
+
+func TestRunWithReader_IgnoresPreamble(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	input := strings.Join([]string{
+		"Machine model: Mac16,6",
+		"OS version: 24F74",
+		"Boot arguments: cpus=8 cpu_power_limit=15W",
+		"Boot time: Fri Sep 26 05:52:25 2025",
+		"Sampling...",
+		"",
+		"",
+		"*** Sampled system activity (Sat Nov  8 15:54:21 2025 +0900) (1004.61ms elapsed) ***",
+		"",
+		"CPU Power: 954 mW",
+		"",
+	}, "\n")
+
+	stream := RunReader(context.Background(), Config{}, strings.NewReader(input))
+	var emitted []Metrics
+	for metrics := range stream.Metrics {
+		emitted = append(emitted, metrics)
+	}
+	for err := range stream.Errors {
+		t.Errorf("unexpected stream error: %v", err)
+	}
+
+	if len(emitted) != 1 {
+		t.Fatalf("expected only the data line to emit metrics, got %d: %+v", len(emitted), emitted)
+	}
+	if emitted[0].Timestamp.IsZero() || emitted[0].SystemSample == nil || emitted[0].SystemSample.CPUPowerWatts != 0.954 {
+		t.Errorf("unexpected metrics after the preamble: %+v", emitted[0])
+	}
+}
+
+func TestNetworkAndDiskThisSample(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{SampleWindow: 5 * time.Second})
+	var last *Metrics
+	for _, line := range []string{
+		"*** Sampled system activity (Sat Nov  8 15:54:21 2025 +0900) (5021.96ms elapsed) ***",
+		"out: 12.5 packets/s, 4586.65 bytes/s",
+		"in:  86.02 packets/s, 113827.21 bytes/s",
+		"read: 8.56 ops/s 45.67 KBytes/s",
+		"write: 73.88 ops/s 2070.85 KBytes/s",
+	} {
+		metrics, err := parser.ParseLine(line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+		if metrics != nil {
+			last = metrics
+		}
+	}
+	if last == nil || last.Network == nil || last.Disk == nil {
+		t.Fatalf("expected network and disk metrics, got %+v", last)
+	}
+
+	elapsed := last.Elapsed
+	if elapsed != 5021960*time.Microsecond {
+		t.Fatalf("Elapsed = %v, want 5.02196s", elapsed)
+	}
+	checks := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"InBytesThisSample", last.Network.InBytesThisSample(elapsed), 113827.21 * 5.02196},
+		{"OutBytesThisSample", last.Network.OutBytesThisSample(elapsed), 4586.65 * 5.02196},
+		{"InPacketsThisSample", last.Network.InPacketsThisSample(elapsed), 86.02 * 5.02196},
+		{"OutPacketsThisSample", last.Network.OutPacketsThisSample(elapsed), 12.5 * 5.02196},
+		{"ReadBytesThisSample", last.Disk.ReadBytesThisSample(elapsed), 45.67 * 1024 * 5.02196},
+		{"WriteBytesThisSample", last.Disk.WriteBytesThisSample(elapsed), 2070.85 * 1024 * 5.02196},
+		{"ReadOpsThisSample", last.Disk.ReadOpsThisSample(elapsed), 8.56 * 5.02196},
+		{"WriteOpsThisSample", last.Disk.WriteOpsThisSample(elapsed), 73.88 * 5.02196},
+	}
+	for _, c := range checks {
+		if math.Abs(c.got-c.want) > 1e-6 {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestParser_HasCompleteSample(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{})
+	steps := []struct {
+		line string
+		want bool
+	}{
+		{"CPU Power: 954 mW", false},
+		{"*** Sampled system activity (Sat Nov  8 15:54:21 2025 +0900) (1004.61ms elapsed) ***", false},
+		{"CPU Power: 954 mW", false},
+		{"", false},
+		{"*** Sampled system activity (Sat Nov  8 15:54:22 2025 +0900) (1001.02ms elapsed) ***", true},
+		{"CPU Power: 1.2 W", true},
+	}
+	for _, step := range steps {
+		if _, err := parser.ParseLine(step.line); err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", step.line, err)
+		}
+		if got := parser.HasCompleteSample(); got != step.want {
+			t.Errorf("after %q: HasCompleteSample() = %t, want %t", step.line, got, step.want)
+		}
+	}
+
+	// The end of the stream also closes the last sample.
+	parser = NewParser(Config{})
+	stream := parser.RunWithReader(context.Background(), strings.NewReader(
+		"*** Sampled system activity (Sat Nov  8 15:54:21 2025 +0900) (1004.61ms elapsed) ***\nCPU Power: 954 mW\n"))
+	for range stream.Metrics {
+	}
+	for range stream.Errors {
+	}
+	if !parser.HasCompleteSample() {
+		t.Errorf("expected a complete sample once the stream ended")
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	missing := Config{PowermetricsArgs: []string{"--samplers", "cpu_power,thermal", "--show-process-gpu"}}
+	err := missing.Validate()
+	if err == nil {
+		t.Fatalf("expected an error for --show-process-gpu without tasks/gpu_power")
+	}
+	if !strings.Contains(err.Error(), "--show-process-gpu requires the tasks,gpu_power sampler(s)") {
+		t.Errorf("unexpected error message: %v", err)
+	}
+
+	valid := []Config{
+		{},
+		{Profile: ProfilePerformance},
+		{PowermetricsArgs: []string{"--samplers=tasks,gpu_power", "--show-process-gpu"}},
+		{PowermetricsArgs: []string{"--show-process-gpu"}},
+		{PowermetricsArgs: []string{"-s", "all", "--show-process-energy"}},
+	}
+	for _, cfg := range valid {
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Validate(%+v) returned error: %v", cfg.PowermetricsArgs, err)
+		}
+	}
+}
+
+func TestRunWithReader_StampsSequence(t *testing.T) {
+	t.Parallel()
+
+	input := strings.Join([]string{
+		"CPU Power: 1 W",
+		"GPU Power: 2 W",
+		"ANE Power: 3 W",
+		"pid 155    WindowServer               352ms  (35.2%)",
+	}, "\n")
+	stream := RunReader(context.Background(), Config{}, strings.NewReader(input))
+
+	var sequences []uint64
+	for metrics := range stream.Metrics {
+		sequences = append(sequences, metrics.Sequence)
+	}
+	for err := range stream.Errors {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(sequences) != 4 {
+		t.Fatalf("expected 4 metrics, got %d", len(sequences))
+	}
+	for i, seq := range sequences {
+		if seq != uint64(i+1) {
+			t.Errorf("metrics %d: Sequence = %d, want %d", i, seq, i+1)
+		}
+	}
+}
+
+func TestClusterResidencyMetrics_BusyPercent(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{})
+	lines := []string{
+		"P0-Cluster HW active residency:   5.89% (1260 MHz: 2.6% 4512 MHz: 3.29%)",
+		"P0-Cluster idle residency:   7.58%",
+		"P0-Cluster down residency:  86.53%",
+	}
+
+	var last *Metrics
+	for _, line := range lines {
+		metrics, err := parser.ParseLine(line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+		if metrics != nil {
+			last = metrics
+		}
+	}
+	if last == nil || len(last.ClusterResidencies) != 1 {
+		t.Fatalf("expected one cluster residency, got %+v", last)
+	}
+
+	cluster := last.ClusterResidencies[0]
+	if cluster.IdleResidency != 7.58 || cluster.DownResidency != 86.53 {
+		t.Errorf("unexpected idle/down residency: %v/%v", cluster.IdleResidency, cluster.DownResidency)
+	}
+	if got := cluster.BusyPercent(); math.Abs(got-5.89) > 1e-9 {
+		t.Errorf("BusyPercent() = %v, want 5.89", got)
+	}
+
+	derived := ClusterResidencyMetrics{IdleResidency: 45.52, DownResidency: 19.04}
+	if got := derived.BusyPercent(); math.Abs(got-35.44) > 1e-9 {
+		t.Errorf("derived BusyPercent() = %v, want 35.44", got)
+	}
+
+	overReported := ClusterResidencyMetrics{IdleResidency: 80, DownResidency: 30}
+	if got := overReported.BusyPercent(); got != 0 {
+		t.Errorf("expected BusyPercent clamped to 0, got %v", got)
+	}
+	if got := (ClusterResidencyMetrics{}).BusyPercent(); got != 0 {
+		t.Errorf("expected 0 without residency data, got %v", got)
+	}
+}
+
+func TestParser_PowerUnit(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	lines := []string{
+		"CPU Power: 1500 mW",
+		"GPU HW active residency:  20.00% (389 MHz: 20%)",
+		"GPU Power: 250 mW",
+		"P0-Cluster Power: 2 W",
+	}
+	parse := func(unit PowerUnit) *Metrics {
+		parser := NewParser(Config{PowerUnit: unit})
+		var last *Metrics
+		for _, line := range lines {
+			metrics, err := parser.ParseLine(line)
+			if err != nil {
+				t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+			}
+			if metrics != nil {
+				last = metrics
+			}
+		}
+		if last == nil || last.SystemSample == nil || last.GPUResidency == nil || len(last.ClusterResidencies) != 1 {
+			t.Fatalf("unit %q: expected system, GPU and cluster metrics, got %+v", unit, last)
+		}
+		return last
+	}
+
+	for _, tc := range []struct {
+		unit              PowerUnit
+		cpu, gpu, cluster float64
+		label             string
+	}{
+		{"", 1.5, 0.25, 2, "W"},
+		{PowerUnitWatts, 1.5, 0.25, 2, "W"},
+		{PowerUnitMilliwatts, 1500, 250, 2000, "mW"},
+	} {
+		m := parse(tc.unit)
+		if m.PowerUnit != tc.unit {
+			t.Errorf("unit %q: Metrics.PowerUnit = %q", tc.unit, m.PowerUnit)
+		}
+		// The stored fields keep the units their names carry.
+		if math.Abs(m.SystemSample.CPUPowerWatts-1.5) > 1e-9 || math.Abs(m.SystemSample.GPUPowerWatts-0.25) > 1e-9 {
+			t.Errorf("unit %q: unexpected system power %v/%v", tc.unit, m.SystemSample.CPUPowerWatts, m.SystemSample.GPUPowerWatts)
+		}
+		if math.Abs(m.GPUResidency.PowerMilliwatts-250) > 1e-9 {
+			t.Errorf("unit %q: GPUResidency.PowerMilliwatts = %v, want 250", tc.unit, m.GPUResidency.PowerMilliwatts)
+		}
+		if math.Abs(m.ClusterResidencies[0].PowerWatts-2) > 1e-9 {
+			t.Errorf("unit %q: cluster power = %v, want 2", tc.unit, m.ClusterResidencies[0].PowerWatts)
+		}
+
+		power := m.Power("")
+		if string(power.Unit) != tc.label {
+			t.Errorf("unit %q: Power unit = %q, want %q", tc.unit, power.Unit, tc.label)
+		}
+		if math.Abs(power.CPU-tc.cpu) > 1e-9 || math.Abs(power.GPU-tc.gpu) > 1e-9 || math.Abs(power.Clusters["P0-Cluster"]-tc.cluster) > 1e-9 {
+			t.Errorf("unit %q: Power = %+v, want CPU %v GPU %v cluster %v", tc.unit, power, tc.cpu, tc.gpu, tc.cluster)
+		}
+		if table := m.Table(); !strings.Contains(table, fmt.Sprintf("%.2f %s", tc.cpu, tc.label)) {
+			t.Errorf("unit %q: expected CPU power in %s in table:\n%s", tc.unit, tc.label, table)
+		}
+		row := m.FlatRow()
+		if cpu, gpu := row["cpu.power_w"].(float64), row["gpu.power_w"].(float64); math.Abs(cpu-1.5) > 1e-9 || math.Abs(gpu-0.25) > 1e-9 {
+			t.Errorf("unit %q: FlatRow power = %v/%v, want watts 1.5/0.25", tc.unit, cpu, gpu)
+		}
+	}
+
+	// An explicit unit overrides Metrics.PowerUnit, and GPU power falls back
+	// to GPUResidency when the sample has no system GPU power.
+	gpuOnly := Metrics{PowerUnit: PowerUnitWatts, GPUResidency: &GPUResidencyMetrics{PowerMilliwatts: 250}}
+	if got := gpuOnly.Power(PowerUnitMilliwatts); got.Unit != PowerUnitMilliwatts || math.Abs(got.GPU-250) > 1e-9 || got.Clusters != nil {
+		t.Errorf("Power(mW) without SystemSample = %+v, want GPU 250 mW", got)
+	}
+
+	if err := (Config{PowerUnit: "kW"}).Validate(); err == nil {
+		t.Errorf("expected Validate to reject an unknown PowerUnit")
+	}
+}
+
+func TestConfig_UnknownProfile(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	cfg := Config{Profile: "turbo"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), `unknown Profile "turbo"`) {
+		t.Errorf("expected Validate to reject the profile, got %v", err)
+	}
+
+	stream := RunReader(context.Background(), cfg, strings.NewReader("CPU Power: 1000 mW\n"))
+	for metrics := range stream.Metrics {
+		t.Fatalf("unexpected metrics %+v", metrics)
+	}
+	if err := <-stream.Errors; err == nil || !strings.Contains(err.Error(), `"turbo"`) {
+		t.Fatalf("expected an unknown profile error, got %v", err)
+	}
+	if _, err := NewParser(cfg).RunWithErrors(context.Background()); err == nil {
+		t.Fatal("expected RunWithErrors to reject an unknown profile")
+	}
+
+	for _, profile := range []Profile{"", ProfileBattery, ProfilePerformance, ProfileFull} {
+		if err := (Config{Profile: profile}).Validate(); err != nil {
+			t.Errorf("Validate(%q) = %v, want nil", profile, err)
+		}
+	}
+}
+
+func TestParser_ObservedSections(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	file, err := os.Open("testdata/recorded_run.log")
+	if err != nil {
+		t.Fatalf("open fixture: %v", err)
+	}
+	defer file.Close()
+
+	parser := NewParser(Config{})
+	if got := parser.ObservedSections(); len(got) != 0 {
+		t.Fatalf("expected no sections before parsing, got %v", got)
+	}
+
+	stream := parser.RunWithReader(context.Background(), file)
+	for range stream.Metrics {
+	}
+	for err := range stream.Errors {
+		t.Fatalf("unexpected stream error: %v", err)
+	}
+
+	want := []string{"system", "tasks", "clusters", "cpu_residency", "gpu", "network", "disk", "interrupts"}
+	if got := parser.ObservedSections(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ObservedSections() = %v, want %v", got, want)
+	}
+}
+
+func TestParser_IntelEnergyCounters(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{})
+	lines := []string{
+		"*** Sampled system activity (Sat Nov  8 15:54:21 2025 +0900) (2000.00ms elapsed) ***",
+		"Package Joules: 100.0",
+		"CPU energy: 60.0 J",
+		"*** Sampled system activity (Sat Nov  8 15:54:23 2025 +0900) (2000.00ms elapsed) ***",
+		"Package Joules: 110.0",
+		"CPU energy: 66.0 J",
+	}
+	for _, line := range lines {
+		if _, err := parser.ParseLine(line); err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+	}
+
+	s := parser.system
+	if s.PackageEnergyJoules != 110 || s.CPUEnergyJoules != 66 {
+		t.Errorf("unexpected counters: package %v, cpu %v", s.PackageEnergyJoules, s.CPUEnergyJoules)
+	}
+	if math.Abs(s.PackagePowerWatts-5) > 1e-9 {
+		t.Errorf("PackagePowerWatts = %v, want 5 (10 J over 2 s)", s.PackagePowerWatts)
+	}
+	if math.Abs(s.CPUPowerWatts-3) > 1e-9 {
+		t.Errorf("CPUPowerWatts = %v, want 3 (6 J over 2 s)", s.CPUPowerWatts)
+	}
+
+	// Directly reported power wins over counter-derived values.
+	reported := NewParser(Config{})
+	for _, line := range []string{
+		"*** Sampled system activity (Sat Nov  8 15:54:21 2025 +0900) (2000.00ms elapsed) ***",
+		"Intel energy model derived package power (CPUs+GT+SA): 3.37W",
+		"Package Joules: 100.0",
+		"*** Sampled system activity (Sat Nov  8 15:54:23 2025 +0900) (2000.00ms elapsed) ***",
+		"Package Joules: 110.0",
+	} {
+		if _, err := reported.ParseLine(line); err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+	}
+	if got := reported.system.PackagePowerWatts; got != 3.37 {
+		t.Errorf("PackagePowerWatts = %v, want the reported 3.37", got)
+	}
+	if got := reported.system.CPUPowerWatts; got != 0 {
+		t.Errorf("package power line leaked into CPUPowerWatts: %v", got)
+	}
+}
+
+func TestSmoothIO_ExponentialMovingAverage(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	in := make(chan Metrics, 8)
+	errs := make(chan error)
+	base := time.Date(2025, 11, 8, 15, 54, 21, 0, time.UTC)
+	// The third Metrics is a second snapshot of the second sample, which must
+	// not advance the average.
+	inputs := []struct {
+		offset time.Duration
+		rate   float64
+	}{{0, 100}, {time.Second, 200}, {time.Second, 200}, {2 * time.Second, 0}}
+	for _, input := range inputs {
+		in <- Metrics{
+			Timestamp: base.Add(input.offset),
+			Network:   &NetworkMetrics{InBytesPerSec: input.rate},
+			Disk:      &DiskMetrics{ReadBytesPerSec: input.rate * 2},
+		}
+	}
+	close(in)
+	close(errs)
+
+	stream := SmoothIO(&Stream{Metrics: in, Errors: errs}, 0.5)
+	want := []float64{100, 150, 150, 75}
+	var i int
+	for metrics := range stream.Metrics {
+		if i >= len(want) {
+			t.Fatalf("unexpected extra metrics %+v", metrics)
+		}
+		if got := metrics.Network.InBytesPerSec; math.Abs(got-want[i]) > 1e-9 {
+			t.Errorf("metrics %d: smoothed InBytesPerSec = %v, want %v", i, got, want[i])
+		}
+		if got := metrics.Disk.ReadBytesPerSec; math.Abs(got-2*want[i]) > 1e-9 {
+			t.Errorf("metrics %d: smoothed ReadBytesPerSec = %v, want %v", i, got, 2*want[i])
+		}
+		if metrics.RawNetwork == nil || metrics.RawDisk == nil {
+			t.Fatalf("metrics %d: expected raw values to be kept", i)
+		}
+		if got := metrics.RawNetwork.InBytesPerSec; got != inputs[i].rate {
+			t.Errorf("metrics %d: raw InBytesPerSec = %v, want %v", i, got, inputs[i].rate)
+		}
+		i++
+	}
+	if i != len(want) {
+		t.Fatalf("expected %d metrics, got %d", len(want), i)
+	}
+	for range stream.Errors {
+	}
+}
+
+func TestParser_MultipleBatteryReadings(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{})
+	lines := []string{
+		"**** Battery and backlight usage ****",
+		"Battery: percent_charge: 82",
+		"Battery: percent_charge: 45",
+	}
+
+	var last *Metrics
+	for _, line := range lines {
+		metrics, err := parser.ParseLine(line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+		if metrics != nil {
+			last = metrics
+		}
+	}
+	if last == nil || last.SystemSample == nil {
+		t.Fatalf("expected system metrics after the battery lines")
+	}
+	if got := last.SystemSample.BatteryPercent; got != 82 {
+		t.Errorf("BatteryPercent = %v, want the first reading 82", got)
+	}
+	if want := []float64{82, 45}; !reflect.DeepEqual(last.Batteries, want) {
+		t.Errorf("Batteries = %v, want %v", last.Batteries, want)
+	}
+
+	// The next sample starts a fresh list.
+	for _, line := range []string{"**** Battery and backlight usage ****", "Battery: percent_charge: 81"} {
+		metrics, err := parser.ParseLine(line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+		if metrics != nil {
+			last = metrics
+		}
+	}
+	if got := last.SystemSample.BatteryPercent; got != 81 {
+		t.Errorf("BatteryPercent = %v, want 81 in the next sample", got)
+	}
+	if want := []float64{81}; !reflect.DeepEqual(last.Batteries, want) {
+		t.Errorf("Batteries = %v, want %v", last.Batteries, want)
+	}
+}
+
+func TestParser_ClusterHWActiveResidencyIgnoresFrequencyList(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{})
+	line := "P0-Cluster HW active residency:   5.88% (1260 MHz: 2.6% 3000 MHz: 1.5% 4512 MHz: 93.1%)"
+	metrics, err := parser.ParseLine(line)
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil || len(metrics.ClusterResidencies) != 1 {
+		t.Fatalf("expected one cluster residency, got %+v", metrics)
+	}
+
+	cluster := metrics.ClusterResidencies[0]
+	if cluster.Name != "P0-Cluster" {
+		t.Errorf("Name = %q, want P0-Cluster", cluster.Name)
+	}
+	if cluster.HWActiveResidency != 5.88 {
+		t.Errorf("HWActiveResidency = %v, want 5.88 (not a value from the frequency list)", cluster.HWActiveResidency)
+	}
+	want := FrequencyResidencyData{1260: 2.6, 3000: 1.5, 4512: 93.1}
+	if !reflect.DeepEqual(cluster.HWActiveFreqResidency, want) {
+		t.Errorf("HWActiveFreqResidency = %v, want %v", cluster.HWActiveFreqResidency, want)
+	}
+	if s := metrics.SystemSample; s != nil && (s.CPUFrequencyMHz != 0 || s.GPUBusyPercent != 0) {
+		t.Errorf("cluster residency line leaked into system metrics: %+v", s)
+	}
+}
+
+func TestParseGPUStates_AcceptsBothKeyForms(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	requested := parseGPUStates("P1 :  12% P2 :   0% P15 :   3.5%")
+	if want := (GPUSoftwareStateData{"P1": 12, "P2": 0, "P15": 3.5}); !reflect.DeepEqual(requested, want) {
+		t.Errorf("requested states = %v, want %v", requested, want)
+	}
+
+	actual := parseGPUStates("SW_P1 : 1.6% SW_P2 :   0% SW_P15 :   0%")
+	if got, ok := actual["SW_P1"]; !ok || got != 1.6 {
+		t.Errorf("SW_P1 = %v (present %t), want 1.6", got, ok)
+	}
+	if len(actual) != 3 {
+		t.Errorf("expected 3 SW_ states, got %v", actual)
+	}
+}
+
+func TestMetrics_SystemSampleNilUntilMeasured(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{})
+
+	metrics, err := parser.ParseLine("in: 12.34 packets/s, 5678.90 bytes/s")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil || metrics.Network == nil {
+		t.Fatalf("expected network metrics, got %+v", metrics)
+	}
+	if metrics.SystemSample != nil {
+		t.Errorf("expected nil SystemSample before any system value, got %+v", metrics.SystemSample)
+	}
+	data, err := json.Marshal(metrics)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	if !strings.Contains(string(data), `"SystemSample":null`) || !strings.Contains(string(data), `"Disk":null`) {
+		t.Errorf("expected absent sections to encode as null, got %s", data)
+	}
+
+	metrics, err = parser.ParseLine("CPU Power: 0 mW")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil || metrics.SystemSample == nil {
+		t.Fatalf("expected a SystemSample once a value was measured")
+	}
+	if metrics.SystemSample.CPUPowerWatts != 0 {
+		t.Errorf("CPUPowerWatts = %v, want a measured 0", metrics.SystemSample.CPUPowerWatts)
+	}
+	if metrics.Network == nil {
+		t.Errorf("expected the network section to stay present")
+	}
+}
+
+func TestAggregateByInterval_AveragesPerBucket(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	in := make(chan Metrics, 16)
+	errs := make(chan error)
+	base := time.Date(2025, 11, 8, 15, 54, 0, 0, time.UTC)
+	send := func(offset time.Duration, m Metrics) {
+		m.Timestamp = base.Add(offset)
+		m.Elapsed = 20 * time.Second
+		in <- m
+	}
+
+	// First minute: three samples; the first arrives as two snapshots.
+	send(0, Metrics{SystemSample: &SystemSample{CPUPowerWatts: 1, ThermalPressure: "Nominal"}})
+	send(0, Metrics{Network: &NetworkMetrics{InBytesPerSec: 100}})
+	send(20*time.Second, Metrics{SystemSample: &SystemSample{CPUPowerWatts: 2}, Network: &NetworkMetrics{InBytesPerSec: 200}})
+	send(40*time.Second, Metrics{SystemSample: &SystemSample{CPUPowerWatts: 3, ThermalPressure: "Moderate"}, Network: &NetworkMetrics{InBytesPerSec: 300}})
+	// Second minute: a partial bucket flushed when the stream closes.
+	send(60*time.Second, Metrics{SystemSample: &SystemSample{CPUPowerWatts: 10}, Disk: &DiskMetrics{ReadBytesPerSec: 50}})
+	close(in)
+	close(errs)
+
+	stream := AggregateByInterval(&Stream{Metrics: in, Errors: errs}, time.Minute)
+	var got []Metrics
+	for m := range stream.Metrics {
+		got = append(got, m)
+	}
+	for range stream.Errors {
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 buckets, got %d: %+v", len(got), got)
+	}
+
+	first := got[0]
+	if !first.Timestamp.Equal(base) || first.Elapsed != time.Minute || first.Sequence != 1 {
+		t.Errorf("first bucket: Timestamp %v, Elapsed %v, Sequence %d", first.Timestamp, first.Elapsed, first.Sequence)
+	}
+	if first.SystemSample == nil || math.Abs(first.SystemSample.CPUPowerWatts-2) > 1e-9 {
+		t.Errorf("first bucket: expected mean CPU power 2, got %+v", first.SystemSample)
+	}
+	if first.SystemSample != nil && first.SystemSample.ThermalPressure != "Moderate" {
+		t.Errorf("first bucket: ThermalPressure = %q, want the last value", first.SystemSample.ThermalPressure)
+	}
+	if first.Network == nil || math.Abs(first.Network.InBytesPerSec-200) > 1e-9 {
+		t.Errorf("first bucket: expected mean InBytesPerSec 200, got %+v", first.Network)
+	}
+
+	second := got[1]
+	if !second.Timestamp.Equal(base.Add(time.Minute)) || second.Elapsed != 20*time.Second || second.Sequence != 2 {
+		t.Errorf("second bucket: Timestamp %v, Elapsed %v, Sequence %d", second.Timestamp, second.Elapsed, second.Sequence)
+	}
+	if second.SystemSample == nil || second.SystemSample.CPUPowerWatts != 10 {
+		t.Errorf("second bucket: expected CPU power 10, got %+v", second.SystemSample)
+	}
+	if second.Network != nil || second.Disk == nil || second.Disk.ReadBytesPerSec != 50 {
+		t.Errorf("second bucket: unexpected IO sections %+v / %+v", second.Network, second.Disk)
+	}
+}
+
+func TestParser_ResolveProcessPaths(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	calls := make(map[int]int)
+	resolver := func(pid int) (string, error) {
+		calls[pid]++
+		switch pid {
+		case 24739:
+			if calls[pid] > 1 {
+				return "/usr/local/bin/reused", nil
+			}
+			return "/Applications/iTerm.app/Contents/MacOS/iTerm2", nil
+		default:
+			return "", errors.New("no such process")
+		}
+	}
+	parser := NewParser(Config{ResolveProcessPaths: true, ProcessPathResolver: resolver})
+
+	parseSample := func(lines ...string) *Metrics {
+		var last *Metrics
+		for _, line := range append([]string{"*** Running tasks ***"}, append(lines, "")...) {
+			metrics, err := parser.ParseLine(line)
+			if err != nil {
+				t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+			}
+			if metrics != nil {
+				last = metrics
+			}
+		}
+		if last == nil {
+			t.Fatalf("expected process metrics")
+		}
+		return last
+	}
+
+	first := parseSample(
+		"DEAD_TASKS                         -1     323.32    32.03  81.64   0.40               83.04   0.00",
+		"iTerm2                             24739  250.43    78.27  0.20    0.00               171.69  0.00",
+		"shortlived                         555    10.00     1.00   0.00    0.00               1.00    0.00",
+	)
+	if len(first.ProcessSamples) != 2 {
+		t.Fatalf("expected 2 process samples, got %+v", first.ProcessSamples)
+	}
+	if got := first.ProcessSamples[0].Path; got != "/Applications/iTerm.app/Contents/MacOS/iTerm2" {
+		t.Errorf("iTerm2 Path = %q", got)
+	}
+	if got := first.ProcessSamples[1].Path; got != "" {
+		t.Errorf("expected an empty Path for an exited process, got %q", got)
+	}
+	if first.DeadTasks == nil || first.DeadTasks.Path != "" {
+		t.Errorf("expected DEAD_TASKS without a path, got %+v", first.DeadTasks)
+	}
+
+	// Known PIDs are served from the cache, including failed lookups.
+	parseSample(
+		"iTerm2                             24739  250.43    78.27  0.20    0.00               171.69  0.00",
+		"shortlived                         555    10.00     1.00   0.00    0.00               1.00    0.00",
+	)
+	if calls[24739] != 1 || calls[555] != 1 {
+		t.Errorf("expected one lookup per PID, got %v", calls)
+	}
+
+	// A reused PID with a new name is looked up again.
+	reused := parseSample("reused                             24739  1.00      1.00   0.00    0.00               1.00    0.00")
+	if got := reused.ProcessSamples[0].Path; got != "/usr/local/bin/reused" || calls[24739] != 2 {
+		t.Errorf("expected a fresh lookup for the reused PID, got %q after %d calls", got, calls[24739])
+	}
+
+	// PIDs missing from the last table are evicted, and aggregate rows with a
+	// negative PID are never looked up or cached.
+	parseSample(
+		"ALL_TASKS                          -2     323.32    32.03  81.64   0.40               83.04   0.00",
+		"reused                             24739  1.00      1.00   0.00    0.00               1.00    0.00",
+	)
+	if _, ok := parser.processPaths[555]; ok || len(parser.processPaths) != 1 {
+		t.Errorf("expected only PID 24739 cached, got %v", parser.processPaths)
+	}
+	if calls[-2] != 0 {
+		t.Errorf("expected no lookup for a negative PID, got %d", calls[-2])
+	}
+
+	plain := NewParser(Config{ProcessPathResolver: resolver})
+	for _, line := range []string{"*** Running tasks ***", "iTerm2                             24739  250.43    78.27  0.20    0.00               171.69  0.00", ""} {
+		metrics, _ := plain.ParseLine(line)
+		if metrics != nil && len(metrics.ProcessSamples) > 0 && metrics.ProcessSamples[0].Path != "" {
+			t.Errorf("expected no path resolution without ResolveProcessPaths")
+		}
+	}
+}
+
+func TestParser_OnAC(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{})
+	metrics, err := parser.ParseLine("Battery: percent_charge: 80")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil || metrics.SystemSample == nil {
+		t.Fatalf("expected system metrics")
+	}
+	if !metrics.SystemSample.OnAC || metrics.SystemSample.OnACReported() {
+		t.Errorf("expected the unreported desktop default OnAC=true, got %v (reported %t)",
+			metrics.SystemSample.OnAC, metrics.SystemSample.OnACReported())
+	}
+	if data, _ := (JSONOptions{OmitUnmeasured: true}).Marshal(*metrics); strings.Contains(string(data), "OnAC") {
+		t.Errorf("expected unreported OnAC to be omitted, got %s", data)
+	}
+
+	for _, tc := range []struct {
+		line string
+		want bool
+	}{
+		{"Power source: Battery Power", false},
+		{"AC attached: Yes", true},
+		{"External connected: No", false},
+	} {
+		metrics, err := parser.ParseLine(tc.line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", tc.line, err)
+		}
+		if metrics == nil || metrics.SystemSample == nil {
+			t.Fatalf("ParseLine(%q): expected system metrics", tc.line)
+		}
+		if got := metrics.SystemSample.OnAC; got != tc.want || !metrics.SystemSample.OnACReported() {
+			t.Errorf("ParseLine(%q): OnAC = %v (reported %t), want %v", tc.line, got, metrics.SystemSample.OnACReported(), tc.want)
+		}
+	}
+}
+
+func TestParser_CPUResidencyHistory(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{CPUResidencyHistoryDepth: 2})
+	lines := []string{
+		"CPU 0 active residency:  40.00% (1020 MHz:  40% 2000 MHz:   0%)",
+		"CPU 0 active residency:  50.00% (1020 MHz:  20% 2000 MHz:  30%)",
+		"CPU 1 active residency:  10.00% (1020 MHz:  10% 2000 MHz:   0%)",
+		"CPU 0 active residency:  60.00% (1020 MHz:   0% 2000 MHz:  60%)",
+	}
+	for _, line := range lines {
+		if _, err := parser.ParseLine(line); err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+	}
+
+	want := []CPUResidencyData{
+		{1020: 20, 2000: 30},
+		{1020: 0, 2000: 60},
+	}
+	history := parser.CPUResidencyHistory(0)
+	if !reflect.DeepEqual(history, want) {
+		t.Errorf("CPU 0 history = %v, want %v", history, want)
+	}
+	history[0][1020] = 99
+	if again := parser.CPUResidencyHistory(0); !reflect.DeepEqual(again, want) {
+		t.Errorf("history was modified through a returned map: %v", again)
+	}
+	if got := parser.CPUResidencyHistory(1); !reflect.DeepEqual(got, []CPUResidencyData{{1020: 10, 2000: 0}}) {
+		t.Errorf("CPU 1 history = %v", got)
+	}
+
+	disabled := NewParser(Config{})
+	if _, err := disabled.ParseLine(lines[0]); err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if got := disabled.CPUResidencyHistory(0); got != nil {
+		t.Errorf("expected no history without a depth, got %v", got)
+	}
+}
+
+func TestRunWithReader_ReadTimeout(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go func() {
+		// Deliver one full line, then stall in the middle of the next.
+		_, _ = pw.Write([]byte("CPU Power: 1500 mW\nGPU Po"))
+	}()
+
+	stream := RunReader(context.Background(), Config{ReadTimeout: 50 * time.Millisecond}, pr)
+
+	done := make(chan struct{})
+	var metrics []Metrics
+	var errs []error
+	go func() {
+		defer close(done)
+		for m := range stream.Metrics {
+			metrics = append(metrics, m)
+		}
+		for err := range stream.Errors {
+			errs = append(errs, err)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream did not end after the read timeout")
+	}
+
+	if len(metrics) != 1 || metrics[0].SystemSample == nil || metrics[0].SystemSample.CPUPowerWatts != 1.5 {
+		t.Errorf("expected the complete line to be parsed before the stall, got %+v", metrics)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], ErrReadTimeout) {
+		t.Errorf("expected a single ErrReadTimeout, got %v", errs)
+	}
+}
+
+func TestParser_CPUPowerByCluster(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	var logs bytes.Buffer
+	parser := NewParser(Config{Logger: log.New(&logs, "", 0)})
+
+	var last *Metrics
+	for _, line := range []string{
+		"E-Cluster Power: 120 mW",
+		"P-Cluster Power: 880 mW",
+		"CPU Power: 1000 mW",
+	} {
+		metrics, err := parser.ParseLine(line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+		if metrics != nil {
+			last = metrics
+		}
+	}
+	if last == nil || last.SystemSample == nil {
+		t.Fatalf("expected system metrics")
+	}
+	want := map[string]float64{"E-Cluster": 0.12, "P-Cluster": 0.88}
+	if got := last.CPUPowerByCluster; !reflect.DeepEqual(got, want) {
+		t.Errorf("CPUPowerByCluster = %v, want %v", got, want)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("expected no diagnostic when clusters reconcile, got %q", logs.String())
+	}
+
+	if _, err := parser.ParseLine("CPU Power: 1500 mW"); err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if !strings.Contains(logs.String(), "cluster powers sum to 1.000 W but CPU power is 1.500 W") {
+		t.Errorf("expected a reconciliation diagnostic, got %q", logs.String())
+	}
+}
+
+type countingSink struct {
+	writes int
+	failAt int
+}
+
+func (s *countingSink) Write(Metrics) error {
+	s.writes++
+	if s.writes == s.failAt {
+		return fmt.Errorf("write %d failed", s.writes)
+	}
+	return nil
+}
+
+func TestPump_CountingSink(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	feed := func(n int) <-chan Metrics {
+		ch := make(chan Metrics, n)
+		for i := 0; i < n; i++ {
+			ch <- Metrics{Sequence: uint64(i + 1)}
+		}
+		close(ch)
+		return ch
+	}
+
+	sink := &countingSink{}
+	if err := Pump(context.Background(), feed(3), sink); err != nil {
+		t.Fatalf("Pump returned error: %v", err)
+	}
+	if sink.writes != 3 {
+		t.Errorf("expected 3 writes, got %d", sink.writes)
+	}
+
+	sink = &countingSink{failAt: 2}
+	if err := Pump(context.Background(), feed(3), sink); err == nil || sink.writes != 2 {
+		t.Errorf("expected Pump to stop at the failing write, got err=%v after %d writes", err, sink.writes)
+	}
+
+	var logs bytes.Buffer
+	sink = &countingSink{failAt: 2}
+	if err := Pump(context.Background(), feed(3), ContinueOnError(sink, log.New(&logs, "", 0))); err != nil {
+		t.Fatalf("Pump returned error: %v", err)
+	}
+	if sink.writes != 3 || !strings.Contains(logs.String(), "write 2 failed") {
+		t.Errorf("expected all writes with the failure logged, got %d writes and %q", sink.writes, logs.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Pump(ctx, make(chan Metrics), sink); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Pump(context.Background(), feed(2), NewWriterSink(&out)); err != nil {
+		t.Fatalf("Pump returned error: %v", err)
+	}
+	if lines := strings.Count(out.String(), "\n"); lines != 2 || !strings.Contains(out.String(), `"Sequence":2`) {
+		t.Errorf("expected two JSON lines, got %q", out.String())
+	}
+}
+
+func TestConfig_ClockDrivesReceivedAtAndAggregation(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	base := time.Date(2025, 11, 8, 15, 54, 0, 0, time.UTC)
+	ticks := 0
+	clock := func() time.Time {
+		now := base.Add(time.Duration(ticks) * 30 * time.Second)
+		ticks++
+		return now
+	}
+	input := "CPU Power: 1000 mW\nCPU Power: 2000 mW\nCPU Power: 3000 mW\nCPU Power: 4000 mW\n"
+
+	parser := NewParser(Config{Clock: clock})
+	stream := parser.RunWithReader(context.Background(), strings.NewReader(input))
+	var received []time.Time
+	for m := range stream.Metrics {
+		received = append(received, m.ReceivedAt)
+	}
+	for range stream.Errors {
+	}
+	if len(received) != 4 {
+		t.Fatalf("expected 4 samples, got %d", len(received))
+	}
+	for i, at := range received {
+		if want := base.Add(time.Duration(i) * 30 * time.Second); !at.Equal(want) {
+			t.Errorf("sample %d: ReceivedAt = %v, want %v", i, at, want)
+		}
+	}
+
+	ticks = 0
+	parser = NewParser(Config{Clock: clock})
+	stream = AggregateByInterval(parser.RunWithReader(context.Background(), strings.NewReader(input)), time.Minute)
+	var buckets []Metrics
+	for m := range stream.Metrics {
+		buckets = append(buckets, m)
+	}
+	for range stream.Errors {
+	}
+	if len(buckets) != 2 {
+		t.Fatalf("expected 2 buckets, got %d", len(buckets))
+	}
+	for i, want := range []struct {
+		start time.Time
+		power float64
+	}{
+		{base, 1.5},
+		{base.Add(time.Minute), 3.5},
+	} {
+		got := buckets[i]
+		if !got.Timestamp.Equal(want.start) {
+			t.Errorf("bucket %d: Timestamp = %v, want %v", i, got.Timestamp, want.start)
+		}
+		if got.SystemSample == nil || math.Abs(got.SystemSample.CPUPowerWatts-want.power) > 1e-9 {
+			t.Errorf("bucket %d: expected mean CPU power %g, got %+v", i, want.power, got.SystemSample)
+		}
+	}
+	if last := buckets[1].ReceivedAt; !last.Equal(base.Add(90 * time.Second)) {
+		t.Errorf("expected the last bucket to carry the last sample's ReceivedAt, got %v", last)
+	}
+}
+
+func TestParser_EffectiveInterval(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	for _, tc := range []struct {
+		name string
+		cfg  Config
+		want time.Duration
+	}{
+		{"default", Config{}, time.Second},
+		{"sample window", Config{SampleWindow: 250 * time.Millisecond}, 250 * time.Millisecond},
+		{"args aligned to window", Config{SampleWindow: 2 * time.Second, PowermetricsArgs: []string{"--samplers", "cpu_power", "-i", "500"}}, 2 * time.Second},
+	} {
+		parser := NewParser(tc.cfg)
+		got := parser.EffectiveInterval()
+		if got != tc.want {
+			t.Errorf("%s: EffectiveInterval() = %v, want %v", tc.name, got, tc.want)
+		}
+
+		args := parser.config.PowermetricsArgs
+		var flag string
+		for i := 0; i < len(args)-1; i++ {
+			if args[i] == "-i" {
+				flag = args[i+1]
+			}
+		}
+		if want := strconv.FormatInt(got.Milliseconds(), 10); flag != want {
+			t.Errorf("%s: -i argument %q does not match EffectiveInterval %v", tc.name, flag, got)
+		}
+	}
+}
+
+func TestParser_RunLogsDiscardedErrorsOnce(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	var logs bytes.Buffer
+	parser := NewParser(Config{Logger: log.New(&logs, "", 0)})
+
+	metrics := make(chan Metrics)
+	close(metrics)
+	errs := make(chan error)
+	parser.discardErrors(&Stream{Metrics: metrics, Errors: errs})
+
+	// The channel is unbuffered, so once the last send completes the
+	// diagnostic for the first error has been written.
+	errs <- errors.New("first failure")
+	errs <- errors.New("second failure")
+	errs <- errors.New("third failure")
+	close(errs)
+
+	got := logs.String()
+	if strings.Count(got, "\n") != 1 || !strings.Contains(got, "RunWithErrors") || !strings.Contains(got, "first failure") {
+		t.Errorf("expected a single diagnostic naming the first error, got %q", got)
+	}
+}
+
+func TestGPUResidencyMetrics_LoadScore(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	for _, tc := range []struct {
+		name     string
+		gpu      GPUResidencyMetrics
+		maxPower float64
+		want     float64
+	}{
+		{"half budget", GPUResidencyMetrics{HWActiveResidency: 100, PowerMilliwatts: 5000}, 10000, 75},
+		{"idle", GPUResidencyMetrics{HWActiveResidency: 0, PowerMilliwatts: 0}, 10000, 0},
+		{"over budget capped", GPUResidencyMetrics{HWActiveResidency: 40, PowerMilliwatts: 15000}, 10000, 70},
+		{"no budget", GPUResidencyMetrics{HWActiveResidency: 40, PowerMilliwatts: 15000}, 0, 40},
+	} {
+		if got := tc.gpu.LoadScore(tc.maxPower); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%s: LoadScore(%g) = %g, want %g", tc.name, tc.maxPower, got, tc.want)
+		}
+	}
+}
+
+func TestParser_CPUBusySummary(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	for _, line := range []string{"CPU Busy: 42.0%", "CPU 42.0% busy"} {
+		parser := NewParser(Config{})
+		metrics, err := parser.ParseLine(line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+		if metrics == nil || metrics.SystemSample == nil {
+			t.Fatalf("ParseLine(%q): expected system metrics", line)
+		}
+		if got := metrics.SystemSample.CPUBusyPercent; got != 42 {
+			t.Errorf("ParseLine(%q): CPUBusyPercent = %g, want 42", line, got)
+		}
+		if metrics.SystemSample.GPUBusyPercent != 0 {
+			t.Errorf("ParseLine(%q): expected GPU busy untouched, got %g", line, metrics.SystemSample.GPUBusyPercent)
+		}
+		if data, _ := (JSONOptions{OmitUnmeasured: true}).Marshal(*metrics); !strings.Contains(string(data), `"CPUBusyPercent":42`) {
+			t.Errorf("ParseLine(%q): expected CPUBusyPercent in JSON, got %s", line, data)
+		}
+	}
+
+	parser := NewParser(Config{})
+	metrics, err := parser.ParseLine("GPU 30.0% busy")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil || metrics.SystemSample == nil || metrics.SystemSample.CPUBusyPercent != 0 || metrics.SystemSample.GPUBusyPercent != 30 {
+		t.Errorf("expected a GPU busy line to leave CPU busy unset, got %+v", metrics)
+	}
+}
+
+func TestParser_CPUBusyDerivedFromPerCoreResidency(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{})
+	var last *Metrics
+	for _, line := range []string{
+		"CPU 0 active residency:  40.00% (1020 MHz:  30% 2000 MHz:  10%)",
+		"CPU 0 idle residency:  60.00%",
+		"CPU 1 active residency:  20.00% (1020 MHz:  20% 2000 MHz:   0%)",
+		"CPU 1 idle residency:  80.00%",
+		// The first line after the per-CPU block finishes it.
+		"CPU Power: 1000 mW",
+	} {
+		metrics, err := parser.ParseLine(line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+		if metrics != nil && metrics.SystemSample != nil && metrics.SystemSample.CPUBusyDerived() && line != "CPU Power: 1000 mW" {
+			t.Errorf("ParseLine(%q): derived CPU busy %g before the per-CPU block ended", line, metrics.SystemSample.CPUBusyPercent)
+		}
+		if metrics != nil {
+			last = metrics
+		}
+	}
+	if last == nil || last.SystemSample == nil {
+		t.Fatalf("expected a SystemSample carrying the derived CPU busy")
+	}
+	s := last.SystemSample
+	if math.Abs(s.CPUBusyPercent-30) > 1e-9 || !s.CPUBusyDerived() {
+		t.Errorf("expected derived CPU busy 30%%, got %g (derived %t)", s.CPUBusyPercent, s.CPUBusyDerived())
+	}
+	if data, _ := (JSONOptions{OmitUnmeasured: true}).Marshal(*last); !strings.Contains(string(data), `"CPUBusyPercent":30`) {
+		t.Errorf("expected the derived value in JSON, got %s", data)
+	}
+
+	metrics, err := parser.ParseLine("CPU Busy: 55.0%")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil || metrics.SystemSample == nil {
+		t.Fatalf("expected system metrics")
+	}
+	if s := metrics.SystemSample; s.CPUBusyPercent != 55 || s.CPUBusyDerived() {
+		t.Errorf("expected the explicit line to win, got %g (derived %t)", s.CPUBusyPercent, s.CPUBusyDerived())
+	}
+
+	// Every metrics of a real sample carries either no derived CPU busy or
+	// the mean over all of its CPUs, never a partial one.
+	file, err := os.Open("testdata/recorded_run.log")
+	if err != nil {
+		t.Fatalf("open fixture: %v", err)
+	}
+	defer file.Close()
+	stream := NewParser(Config{}).RunWithReader(context.Background(), file)
+	busy := map[time.Time]float64{}
+	for m := range stream.Metrics {
+		if m.SystemSample == nil || !m.SystemSample.CPUBusyDerived() {
+			continue
+		}
+		if len(m.CPUResidencies) != 14 {
+			t.Fatalf("expected 14 CPUs with the derived busy, got %d", len(m.CPUResidencies))
+		}
+		if prev, ok := busy[m.Timestamp]; ok && prev != m.SystemSample.CPUBusyPercent {
+			t.Errorf("sample %v: CPU busy changed from %g to %g", m.Timestamp, prev, m.SystemSample.CPUBusyPercent)
+		}
+		busy[m.Timestamp] = m.SystemSample.CPUBusyPercent
+	}
+	for range stream.Errors {
+	}
+	if len(busy) == 0 {
+		t.Errorf("expected a derived CPU busy in recorded_run.log")
+	}
+}
+
+func TestRunReaders_ConcatenatedCaptures(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	data, err := os.ReadFile("testdata/recorded_run.log")
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	collect := func(stream *Stream) []Metrics {
+		var got []Metrics
+		for m := range stream.Metrics {
+			// The parser builds these from maps, so their order varies.
+			sort.Slice(m.CPUResidencies, func(i, j int) bool { return m.CPUResidencies[i].CPUID < m.CPUResidencies[j].CPUID })
+			sort.Slice(m.Interrupts, func(i, j int) bool { return m.Interrupts[i].CPUID < m.Interrupts[j].CPUID })
+			m.ReceivedAt = time.Time{}
+			got = append(got, m)
+		}
+		for err := range stream.Errors {
+			t.Errorf("unexpected stream error: %v", err)
+		}
+		return got
+	}
+	want := collect(NewParser(Config{}).RunWithReader(context.Background(), bytes.NewReader(data)))
+	if len(want) == 0 {
+		t.Fatalf("expected metrics from the fixture")
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	for _, tc := range []struct {
+		name  string
+		files func() []io.Reader
+	}{
+		{"split at a sample header, unterminated first file and BOM", func() []io.Reader {
+			head := strings.TrimSuffix(strings.Join(lines[:399], ""), "\n")
+			tail := utf8BOM + strings.Join(lines[399:], "")
+			return []io.Reader{strings.NewReader(head), strings.NewReader(tail)}
+		}},
+		{"sample split across files", func() []io.Reader {
+			return []io.Reader{
+				strings.NewReader(strings.Join(lines[:300], "")),
+				strings.NewReader(strings.Join(lines[300:], "")),
+			}
+		}},
+	} {
+		got := collect(RunReaders(context.Background(), Config{}, tc.files()...))
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %d metrics that differ from the single-file parse (%d metrics)", tc.name, len(got), len(want))
+		}
+	}
+}
+
+func TestMergeTimeline_InterleavesByTime(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	base := time.Date(2025, 11, 8, 15, 54, 0, 0, time.UTC)
+	metrics := make(chan Metrics, 3)
+	events := make(chan Event, 2)
+	metrics <- Metrics{Timestamp: base, Sequence: 1}
+	metrics <- Metrics{Timestamp: base.Add(2 * time.Second), Sequence: 2}
+	metrics <- Metrics{ReceivedAt: base.Add(4 * time.Second), Sequence: 3}
+	events <- Event{Time: base.Add(time.Second), Label: "warmup done"}
+	events <- Event{Time: base.Add(2 * time.Second), Label: "benchmark"}
+	close(metrics)
+	close(events)
+
+	var got []string
+	var times []time.Time
+	for entry := range MergeTimeline(metrics, events) {
+		switch {
+		case entry.Metrics != nil && entry.Event == nil:
+			got = append(got, fmt.Sprintf("sample %d", entry.Metrics.Sequence))
+		case entry.Event != nil && entry.Metrics == nil:
+			got = append(got, entry.Event.Label)
+		default:
+			t.Fatalf("expected exactly one of Metrics and Event, got %+v", entry)
+		}
+		times = append(times, entry.Time)
+	}
+
+	want := []string{"sample 1", "warmup done", "sample 2", "benchmark", "sample 3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("timeline = %v, want %v", got, want)
+	}
+	for i := 1; i < len(times); i++ {
+		if times[i].Before(times[i-1]) {
+			t.Errorf("timeline out of order at %d: %v before %v", i, times[i], times[i-1])
+		}
+	}
+}
+
+func TestParser_WideGPUSWStateLines(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	var requested, actual strings.Builder
+	requested.WriteString("GPU SW requested state:   (")
+	actual.WriteString("GPU SW state: (")
+	for i := 1; i <= 15; i++ {
+		fmt.Fprintf(&requested, "P%d  :   %d%%    ", i, i)
+		fmt.Fprintf(&actual, "SW_P%d :\t%d.5%%   ", i, i)
+	}
+	requested.WriteString(")")
+	actual.WriteString(")")
+
+	parser := NewParser(Config{})
+	var last *Metrics
+	for _, line := range []string{"GPU HW active residency:   1.62% (338 MHz: 1.6%)", requested.String(), actual.String()} {
+		metrics, err := parser.ParseLine(line)
+		if err != nil {
+			t.Fatalf("ParseLine returned error: %v", err)
+		}
+		if metrics != nil {
+			last = metrics
+		}
+	}
+	if last == nil || last.GPUResidency == nil {
+		t.Fatalf("expected GPU residency metrics")
+	}
+
+	gpu := last.GPUResidency
+	if len(gpu.SWRequestedStates) != 15 || len(gpu.SWStates) != 15 {
+		t.Fatalf("expected 15 requested and 15 actual states, got %d and %d: %v / %v",
+			len(gpu.SWRequestedStates), len(gpu.SWStates), gpu.SWRequestedStates, gpu.SWStates)
+	}
+	for i := 1; i <= 15; i++ {
+		if got := gpu.SWRequestedStates[fmt.Sprintf("P%d", i)]; got != float64(i) {
+			t.Errorf("requested P%d = %g, want %d", i, got, i)
+		}
+		if got := gpu.SWStates[fmt.Sprintf("SW_P%d", i)]; got != float64(i)+0.5 {
+			t.Errorf("actual SW_P%d = %g, want %g", i, got, float64(i)+0.5)
+		}
+	}
+	for name := range gpu.SWRequestedStates {
+		if _, ok := gpu.SWStates[name]; ok {
+			t.Errorf("requested state %q leaked into the actual states", name)
+		}
+	}
+	for name := range gpu.SWStates {
+		if _, ok := gpu.SWRequestedStates[name]; ok {
+			t.Errorf("actual state %q leaked into the requested states", name)
+		}
+	}
+}
+
+func TestMetrics_AppendScalarLine(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	at := time.UnixMilli(1762584861000)
+	m := Metrics{
+		Timestamp: at,
+		SystemSample: &SystemSample{
+			CPUPowerWatts:   1.5,
+			GPUPowerWatts:   0.25,
+			CPUFrequencyMHz: 1338,
+			CPUTemperatureC: 45.125,
+			BatteryPercent:  36,
+			measured:        measuredCPUPower | measuredGPUPower | measuredCPUFrequency | measuredCPUTemperature | measuredBattery,
+		},
+	}
+	got := string(m.AppendScalarLine([]byte("prefix ")))
+	want := "prefix ts=1762584861000 cpu_w=1.500 gpu_w=0.250 ane_w=- dram_w=- cpu_mhz=1338.000 gpu_mhz=- cpu_c=45.125 gpu_c=- batt_pct=36.000\n"
+	if got != want {
+		t.Errorf("AppendScalarLine =\n%q\nwant\n%q", got, want)
+	}
+
+	empty := Metrics{ReceivedAt: at}
+	got = string(empty.AppendScalarLine(nil))
+	want = "ts=1762584861000 cpu_w=- gpu_w=- ane_w=- dram_w=- cpu_mhz=- gpu_mhz=- cpu_c=- gpu_c=- batt_pct=-\n"
+	if got != want {
+		t.Errorf("AppendScalarLine without SystemSample =\n%q\nwant\n%q", got, want)
+	}
+	if got := string((Metrics{}).AppendScalarLine(nil)); !strings.HasPrefix(got, "ts=- ") {
+		t.Errorf("expected ts=- without Timestamp or ReceivedAt, got %q", got)
+	}
+
+	// Power stays in watts when Config.PowerUnit asks for milliwatts.
+	parser := NewParser(Config{PowerUnit: PowerUnitMilliwatts})
+	var parsed *Metrics
+	for _, line := range []string{"CPU Power: 1500 mW", "GPU Power: 250 mW"} {
+		metrics, err := parser.ParseLine(line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+		if metrics != nil {
+			parsed = metrics
+		}
+	}
+	if got := string(parsed.AppendScalarLine(nil)); !strings.Contains(got, " cpu_w=1.500 gpu_w=0.250 ane_w=- ") {
+		t.Errorf("expected watts under PowerUnit mW, got %q", got)
+	}
+
+	buf := make([]byte, 0, 256)
+	if allocs := testing.AllocsPerRun(100, func() { buf = m.AppendScalarLine(buf[:0]) }); allocs != 0 {
+		t.Errorf("expected no allocations with a reused buffer, got %v", allocs)
+	}
+}
+
+func BenchmarkMetrics_AppendScalarLine(b *testing.B) {
+	m := Metrics{
+		Timestamp:    time.Now(),
+		SystemSample: &SystemSample{CPUPowerWatts: 1.5, GPUPowerWatts: 0.25, CPUFrequencyMHz: 1338, BatteryPercent: 36},
+	}
+	buf := make([]byte, 0, 256)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf = m.AppendScalarLine(buf[:0])
+	}
+}
+
+func TestParseFreqResidency_GHzAndMHz(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	got := parseFreqResidency("1020 MHz:  39% 1.404 GHz: 2.2% 2.592GHz:   3%")
+	want := CPUResidencyData{1020: 39, 1404: 2.2, 2592: 3}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseFreqResidency = %v, want %v", got, want)
+	}
+
+	parser := NewParser(Config{})
+	metrics, err := parser.ParseLine("CPU 0 active residency:  44.20% (1020 MHz:  39% 1.404 GHz: 2.2% 2.592 GHz:   3%)")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil || len(metrics.CPUResidencies) != 1 {
+		t.Fatalf("expected one CPU residency, got %+v", metrics)
+	}
+	if got := metrics.CPUResidencies[0].ActiveResidency; !reflect.DeepEqual(got, want) {
+		t.Errorf("ActiveResidency = %v, want %v", got, want)
+	}
+}
+
+func TestConfig_HostLabelPropagates(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{HostLabel: "lab-mac-01"})
+	stream := parser.RunWithReader(context.Background(), strings.NewReader("CPU Power: 1000 mW\nGPU Power: 20 mW\n"))
+	count := 0
+	for m := range stream.Metrics {
+		count++
+		if m.Host != "lab-mac-01" {
+			t.Errorf("sample %d: Host = %q, want %q", m.Sequence, m.Host, "lab-mac-01")
+		}
+		if got := m.FlatRow()["host"]; got != "lab-mac-01" {
+			t.Errorf("sample %d: FlatRow host = %v", m.Sequence, got)
+		}
+	}
+	for range stream.Errors {
+	}
+	if count == 0 {
+		t.Fatalf("expected metrics")
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("os.Hostname: %v", err)
+	}
+	metrics, err := NewParser(Config{}).ParseLine("CPU Power: 1000 mW")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil || metrics.Host != hostname {
+		t.Errorf("expected the hostname %q by default, got %+v", hostname, metrics)
+	}
+}
+
+func TestConfig_ExplicitIntervalDisagreement(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	args := []string{"--samplers", "cpu_power", "-i", "500"}
+
+	var logs bytes.Buffer
+	parser := NewParser(Config{SampleWindow: 2 * time.Second, PowermetricsArgs: args, Logger: log.New(&logs, "", 0)})
+	if got := parser.EffectiveInterval(); got != 2*time.Second {
+		t.Errorf("default: EffectiveInterval() = %v, want SampleWindow 2s", got)
+	}
+	if !strings.Contains(logs.String(), "-i 500 in PowermetricsArgs overridden by SampleWindow 2s") {
+		t.Errorf("expected a diagnostic about the overridden -i, got %q", logs.String())
+	}
+
+	logs.Reset()
+	parser = NewParser(Config{SampleWindow: 2 * time.Second, PowermetricsArgs: args, Logger: log.New(&logs, "", 0), RespectExplicitInterval: true})
+	if got := parser.EffectiveInterval(); got != 500*time.Millisecond {
+		t.Errorf("RespectExplicitInterval: EffectiveInterval() = %v, want 500ms", got)
+	}
+	if parser.config.SampleWindow != 500*time.Millisecond {
+		t.Errorf("RespectExplicitInterval: SampleWindow = %v, want 500ms", parser.config.SampleWindow)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("expected no diagnostic when the explicit -i is kept, got %q", logs.String())
+	}
+
+	NewParser(Config{SampleWindow: 500 * time.Millisecond, PowermetricsArgs: args, Logger: log.New(&logs, "", 0)})
+	if logs.Len() != 0 {
+		t.Errorf("expected no diagnostic when -i agrees with SampleWindow, got %q", logs.String())
+	}
+}
+
+func TestMetrics_NetworkBytesThisSample(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	file, err := os.Open("testdata/recorded_run.log")
+	if err != nil {
+		t.Fatalf("open fixture: %v", err)
+	}
+	defer file.Close()
+
+	var sample *Metrics
+	stream := NewParser(Config{}).RunWithReader(context.Background(), file)
+	for m := range stream.Metrics {
+		if sample == nil && m.Network != nil && m.Network.InBytesPerSec > 0 {
+			m := m
+			sample = &m
+		}
+	}
+	for range stream.Errors {
+	}
+	if sample == nil {
+		t.Fatalf("expected a sample with network rates")
+	}
+	if sample.Elapsed != 5021960*time.Microsecond {
+		t.Fatalf("Elapsed = %v, want 5.02196s", sample.Elapsed)
+	}
+	in, out := sample.NetworkBytesThisSample()
+	if want := 113827.21 * 5.02196; math.Abs(in-want) > 1e-6 {
+		t.Errorf("in = %v, want %v", in, want)
+	}
+	if want := 4586.65 * 5.02196; math.Abs(out-want) > 1e-6 {
+		t.Errorf("out = %v, want %v", out, want)
+	}
+
+	// Without a header the configured sample window is used.
+	metrics, err := NewParser(Config{SampleWindow: 2 * time.Second}).ParseLine("in:  10.00 packets/s, 1000.00 bytes/s")
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if metrics == nil {
+		t.Fatalf("expected network metrics")
+	}
+	if in, out := metrics.NetworkBytesThisSample(); in != 2000 || out != 0 {
+		t.Errorf("NetworkBytesThisSample() = %v, %v, want 2000, 0", in, out)
+	}
+	if in, out := (Metrics{}).NetworkBytesThisSample(); in != 0 || out != 0 {
+		t.Errorf("expected zeros without a network section, got %v, %v", in, out)
+	}
+}
+
+func TestParser_InterleavedSamplerOutput(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	input := strings.Join([]string{
+		"*** Sampled system activity (Sat Nov  8 15:54:21 2025 +0900) (5021.96ms elapsed) ***",
+		"CPU 1:",
+		"E-Cluster HW active residency:  50.00% (1020 MHz:  50%)",
+		"\t|-> TIMER: 7.00 interrupts/sec",
+		"P-Cluster idle residency:  20.00%",
+		"\tTotal IRQ: 20.00 interrupts/sec",
+		"CPU 0:",
+		"CPU 0 active residency:  10.00% (1020 MHz:   5% 2000 MHz:   5%)",
+		"\t|-> IPI: 60.00 interrupts/sec",
+		"P-Cluster HW active residency:  70.00% (2000 MHz:  70%)",
+		"\tTotal IRQ: 100.00 interrupts/sec",
+		"E-Cluster idle residency:  45.00%",
+		"\t|-> TIMER: 40.00 interrupts/sec",
+		"CPU Power: 1000 mW",
+		"*** Sampled system activity (Sat Nov  8 15:54:26 2025 +0900) (5003.12ms elapsed) ***",
+		"CPU 0:",
+		"\t|-> TIMER: 30.00 interrupts/sec",
+		"\tTotal IRQ: 90.00 interrupts/sec",
+		"CPU Power: 1200 mW",
+		"",
+	}, "\n")
+
+	var samples []Metrics
+	stream := NewParser(Config{}).RunWithReader(context.Background(), strings.NewReader(input))
+	for m := range stream.Metrics {
+		samples = append(samples, m)
+	}
+	for range stream.Errors {
+	}
+
+	lastOf := func(at time.Time) Metrics {
+		var last Metrics
+		for _, m := range samples {
+			if m.Timestamp.Equal(at) {
+				last = m
+			}
+		}
+		return last
+	}
+	interrupts := func(m Metrics) map[int]InterruptMetrics {
+		byCPU := make(map[int]InterruptMetrics)
+		for _, intr := range m.Interrupts {
+			byCPU[intr.CPUID] = intr
+		}
+		return byCPU
+	}
+	if len(samples) == 0 {
+		t.Fatalf("expected metrics")
+	}
+
+	first := lastOf(samples[0].Timestamp)
+	got := interrupts(first)
+	if want := (InterruptMetrics{CPUID: 0, TotalIRQ: 100, IPI: 60, TIMER: 40}); got[0] != want {
+		t.Errorf("CPU 0 interrupts = %+v, want %+v", got[0], want)
+	}
+	if want := (InterruptMetrics{CPUID: 1, TotalIRQ: 20, TIMER: 7}); got[1] != want {
+		t.Errorf("CPU 1 interrupts = %+v, want %+v", got[1], want)
+	}
+	clusters := make(map[string]ClusterResidencyMetrics)
+	for _, c := range first.ClusterResidencies {
+		clusters[c.Name] = c
+	}
+	if e := clusters["E-Cluster"]; e.HWActiveResidency != 50 || e.IdleResidency != 45 {
+		t.Errorf("E-Cluster residency = %+v", e)
+	}
+	if p := clusters["P-Cluster"]; p.HWActiveResidency != 70 || p.IdleResidency != 20 {
+		t.Errorf("P-Cluster residency = %+v", p)
+	}
+
+	second := lastOf(samples[len(samples)-1].Timestamp)
+	if second.Timestamp.Equal(first.Timestamp) {
+		t.Fatalf("expected metrics from the second sample")
+	}
+	if got := interrupts(second)[0]; got.TotalIRQ != 90 || got.TIMER != 30 {
+		t.Errorf("expected the second sample to update CPU 0 interrupts, got %+v", got)
+	}
+}
+
+func TestMetrics_ToProtoRoundTrip(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	file, err := os.Open("testdata/recorded_run.log")
+	if err != nil {
+		t.Fatalf("open fixture: %v", err)
+	}
+	defer file.Close()
+
+	var samples []Metrics
+	stream := NewParser(Config{PowerUnit: PowerUnitWatts}).RunWithReader(context.Background(), file)
+	for m := range stream.Metrics {
+		samples = append(samples, m)
+	}
+	for range stream.Errors {
+	}
+	if len(samples) == 0 {
+		t.Fatalf("expected metrics")
+	}
+
+	for i, m := range samples {
+		want := m.ToProto()
+		data, err := want.Marshal()
+		if err != nil {
+			t.Fatalf("sample %d: marshal: %v", i, err)
+		}
+		var decoded pb.Metrics
+		if err := decoded.Unmarshal(data); err != nil {
+			t.Fatalf("sample %d: unmarshal: %v", i, err)
+		}
+		if !reflect.DeepEqual(&decoded, want) {
+			t.Fatalf("sample %d: wire round trip mismatch:\n got %+v\nwant %+v", i, &decoded, want)
+		}
+
+		restored := MetricsFromProto(&decoded)
+		if got := restored.ToProto(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("sample %d: struct round trip mismatch:\n got %+v\nwant %+v", i, got, want)
+		}
+		if m.SystemSample != nil && restored.SystemSample.measured != m.SystemSample.measured {
+			t.Errorf("sample %d: measured = %b, want %b", i, restored.SystemSample.measured, m.SystemSample.measured)
+		}
+		if m.GPUResidency != nil && restored.GPUResidency.PowerWatts() != m.GPUResidency.PowerWatts() {
+			t.Errorf("sample %d: GPU PowerWatts = %v, want %v", i, restored.GPUResidency.PowerWatts(), m.GPUResidency.PowerWatts())
+		}
+	}
+}
+
+func TestMetrics_ToProtoOptionalPresence(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	m := Metrics{SystemSample: &SystemSample{
+		CPUPowerWatts:   1.5,
+		CPUFrequencyMHz: 1338, // not measured, so left unset
+		ThermalPressure: "Nominal",
+		measured:        measuredCPUPower | measuredGPUTemperature | measuredOnAC,
+	}}
+	data, err := m.ToProto().Marshal()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	// Encoded by hand from metrics.proto, as protoc-generated code encodes
+	// it: set optional fields are written even when they hold 0 or false.
+	want := []byte{
+		0x3a, 0x1f, // system_sample, 31 bytes
+		0x09, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f, // cpu_power_watts = 1.5
+		0x39, 0, 0, 0, 0, 0, 0, 0, 0, // gpu_temperature_c = 0
+		0xb0, 0x01, 0x00, // on_ac = false
+		0xba, 0x01, 0x07, 'N', 'o', 'm', 'i', 'n', 'a', 'l', // thermal_pressure
+	}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("encoding = % x\nwant       % x", data, want)
+	}
+
+	var decoded pb.Metrics
+	if err := decoded.Unmarshal(want); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	s := MetricsFromProto(&decoded).SystemSample
+	if s.measured != m.SystemSample.measured || s.CPUPowerWatts != 1.5 || s.CPUFrequencyMHz != 0 || s.OnAC || !s.OnACReported() {
+		t.Errorf("decoded %+v, want the reported fields only", s)
+	}
+}
+
+func TestRunConn_PipeWithPartialReads(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	data, err := os.ReadFile("testdata/recorded_run.log")
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+
+	var want []Metrics
+	stream := RunReader(context.Background(), Config{}, bytes.NewReader(data))
+	for m := range stream.Metrics {
+		want = append(want, m)
+	}
+	for range stream.Errors {
+	}
+
+	server, client := net.Pipe()
+	go func() {
+		// Split writes at odd offsets so lines arrive across several reads.
+		for rest := data; len(rest) > 0; {
+			n := 7
+			if n > len(rest) {
+				n = len(rest)
+			}
+			if _, err := server.Write(rest[:n]); err != nil {
+				return
+			}
+			rest = rest[n:]
+		}
+		_ = server.Close()
+	}()
+
+	var got []Metrics
+	stream = RunConn(context.Background(), Config{}, client)
+	for m := range stream.Metrics {
+		got = append(got, m)
+	}
+	for err := range stream.Errors {
+		if !strings.HasPrefix(err.Error(), "parse line") {
+			t.Errorf("unexpected stream error: %v", err)
+		}
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if !got[i].Timestamp.Equal(want[i].Timestamp) || !reflect.DeepEqual(got[i].SystemSample, want[i].SystemSample) {
+			t.Errorf("sample %d differs from RunReader: got %+v, want %+v", i, got[i].SystemSample, want[i].SystemSample)
+		}
+	}
+}
+
+func TestRunConn_CancelClosesConn(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	server, client := net.Pipe()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := RunConn(ctx, Config{}, client)
+	cancel()
+
+	for range stream.Metrics {
+	}
+	var errs []error
+	for err := range stream.Errors {
+		errs = append(errs, err)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], context.Canceled) {
+		t.Fatalf("expected a single context.Canceled error, got %v", errs)
+	}
+	if _, err := server.Write([]byte("x")); err == nil {
+		t.Errorf("expected the connection to be closed after cancellation")
+	}
+}
+
+func TestParser_StatsCountsOverwrites(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{})
+	lines := []string{
+		"*** Sampled system activity (Sat Nov  8 15:54:21 2025 +0900) (5021.96ms elapsed) ***",
+		"**** Network activity ****",
+		"out: 10.00 packets/s, 1000.00 bytes/s",
+		"in:  20.00 packets/s, 2000.00 bytes/s",
+		"out: 11.00 packets/s, 1100.00 bytes/s",
+		"out: 12.00 packets/s, 1200.00 bytes/s",
+		"**** Disk activity ****",
+		"read: 1.00 ops/s 4.00 KBytes/s",
+		"write: 2.00 ops/s 8.00 KBytes/s",
+		"*** Sampled system activity (Sat Nov  8 15:54:26 2025 +0900) (5021.96ms elapsed) ***",
+		"**** Network activity ****",
+		"out: 13.00 packets/s, 1300.00 bytes/s",
+		"in:  21.00 packets/s, 2100.00 bytes/s",
+	}
+	for _, line := range lines {
+		if _, err := parser.ParseLine(line); err != nil {
+			t.Fatalf("ParseLine(%q): %v", line, err)
+		}
+	}
+
+	want := map[string]uint64{"network": 2}
+	if got := parser.Stats().Overwrites; !reflect.DeepEqual(got, want) {
+		t.Errorf("Overwrites = %v, want %v", got, want)
+	}
+
+	// Per-CPU and per-cluster lines only count when the same CPU or
+	// cluster repeats.
+	for _, line := range []string{
+		"*** Sampled system activity (Sat Nov  8 15:54:31 2025 +0900) (5021.96ms elapsed) ***",
+		"E-Cluster HW active frequency: 1293 MHz",
+		"P0-Cluster HW active frequency: 2507 MHz",
+		"CPU 0 frequency: 1338 MHz",
+		"CPU 1 frequency: 1364 MHz",
+		"CPU 0 frequency: 1340 MHz",
+		"E-Cluster HW active frequency: 1300 MHz",
+		"GPU HW active frequency: 338 MHz",
+		"GPU HW active frequency: 340 MHz",
+		"CPU 0:",
+		"CPU 1:",
+		"CPU 0:",
+	} {
+		if _, err := parser.ParseLine(line); err != nil {
+			t.Fatalf("ParseLine(%q): %v", line, err)
+		}
+	}
+	want = map[string]uint64{"network": 2, "cpu_residency": 1, "clusters": 1, "gpu": 1, "interrupts": 1}
+	if got := parser.Stats().Overwrites; !reflect.DeepEqual(got, want) {
+		t.Errorf("Overwrites = %v, want %v", got, want)
+	}
+
+	// A real capture repeats "GPU Power" within a sample but overwrites
+	// nothing.
+	file, err := os.Open("testdata/recorded_run.log")
+	if err != nil {
+		t.Fatalf("open fixture: %v", err)
+	}
+	defer file.Close()
+	recorded := NewParser(Config{})
+	stream := recorded.RunWithReader(context.Background(), file)
+	for range stream.Metrics {
+	}
+	for range stream.Errors {
+	}
+	if got := recorded.Stats().Overwrites; got != nil {
+		t.Errorf("expected no overwrites in recorded_run.log, got %v", got)
+	}
+	if got := NewParser(Config{}).Stats().Overwrites; got != nil {
+		t.Errorf("expected no overwrites for a fresh parser, got %v", got)
+	}
+}
+
+func TestParser_DetectsBinaryGarbage(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	garbage := make([]byte, 16*1024)
+	rand.New(rand.NewSource(1)).Read(garbage)
+
+	stream := RunReader(context.Background(), Config{}, bytes.NewReader(garbage))
+	for m := range stream.Metrics {
+		t.Errorf("expected no metrics from random bytes, got %+v", m)
+	}
+	detected := 0
+	for err := range stream.Errors {
+		if errors.Is(err, ErrNotPowermetricsOutput) {
+			detected++
+		}
+	}
+	if detected != 1 {
+		t.Errorf("expected ErrNotPowermetricsOutput once, got %d", detected)
+	}
+
+	// Short garbage is detected at the end of the stream.
+	stream = RunReader(context.Background(), Config{}, bytes.NewReader([]byte("\x00\x01\x02\nELF\x7f\n")))
+	for range stream.Metrics {
+	}
+	detected = 0
+	for err := range stream.Errors {
+		if errors.Is(err, ErrNotPowermetricsOutput) {
+			detected++
+		}
+	}
+	if detected != 1 {
+		t.Errorf("expected ErrNotPowermetricsOutput for short garbage, got %d", detected)
+	}
+
+	file, err := os.Open("testdata/recorded_run.log")
+	if err != nil {
+		t.Fatalf("open fixture: %v", err)
+	}
+	defer file.Close()
+	stream = RunReader(context.Background(), Config{}, file)
+	for range stream.Metrics {
+	}
+	for err := range stream.Errors {
+		if errors.Is(err, ErrNotPowermetricsOutput) {
+			t.Errorf("recorded powermetrics output reported as garbage")
+		}
+	}
+}
+
+func TestMetrics_WeightedSystemFrequencyMHz(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	m := Metrics{ClusterResidencies: []ClusterResidencyMetrics{
+		{Name: "E-Cluster", OnlinePercent: 100, HWActiveFreq: 1000},
+		{Name: "P-Cluster", OnlinePercent: 25, HWActiveFreq: 3000},
+		{Name: "P1-Cluster", OnlinePercent: 0, HWActiveFreq: 3500},
+	}}
+	// (1000*100 + 3000*25) / 125
+	if got, want := m.WeightedSystemFrequencyMHz(), 1400.0; math.Abs(got-want) > 1e-9 {
+		t.Errorf("WeightedSystemFrequencyMHz() = %v, want %v", got, want)
+	}
+
+	if got := (Metrics{}).WeightedSystemFrequencyMHz(); got != 0 {
+		t.Errorf("expected 0 without clusters, got %v", got)
+	}
+}
+
+func TestRecommendedSamplers(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	want := "tasks,battery,network,disk,interrupts,cpu_power,gpu_power,ane_power,thermal"
+	if got := strings.Join(RecommendedSamplers, ","); got != want {
+		t.Fatalf("RecommendedSamplers = %q, want %q", got, want)
+	}
+
+	parser := NewParser(Config{
+		SampleWindow:     500 * time.Millisecond,
+		PowermetricsArgs: []string{"--samplers", strings.Join(RecommendedSamplers, ","), "-i", "500"},
+	})
+	if got, wantArgs := parser.config.PowermetricsArgs, []string{"--samplers", want, "-i", "500"}; !reflect.DeepEqual(got, wantArgs) {
+		t.Errorf("args = %q, want %q", got, wantArgs)
+	}
+	if got := NewParser(Config{}).config.PowermetricsArgs[1]; got != want {
+		t.Errorf("default samplers = %q, want %q", got, want)
+	}
+	if got := NewParser(Config{Profile: ProfileFull}).config.PowermetricsArgs[1]; got != want {
+		t.Errorf("ProfileFull samplers = %q, want %q", got, want)
+	}
+}
+
+func TestParser_GPUFrequencyLabelVariants(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	for _, line := range []string{
+		"GPU HW active frequency: 444 MHz",
+		"GPU active frequency: 444 MHz",
+		"GPU Frequency: 444 MHz",
+		"GPU frequency: 444MHz",
+	} {
+		parser := NewParser(Config{})
+		var last *Metrics
+		for _, l := range []string{
+			"*** Sampled system activity (Sat Nov  8 15:54:21 2025 +0900) (5021.96ms elapsed) ***",
+			"**** GPU usage ****",
+			line,
+		} {
+			metrics, err := parser.ParseLine(l)
+			if err != nil {
+				t.Fatalf("%q: %v", l, err)
+			}
+			if metrics != nil {
+				last = metrics
+			}
+		}
+		if last == nil || last.SystemSample == nil || last.GPUResidency == nil {
+			t.Fatalf("%q: expected system and GPU residency metrics, got %+v", line, last)
+		}
+		if last.SystemSample.GPUFrequencyMHz != 444 {
+			t.Errorf("%q: SystemSample.GPUFrequencyMHz = %v, want 444", line, last.SystemSample.GPUFrequencyMHz)
+		}
+		if last.GPUResidency.HWActiveFreqMHz != 444 {
+			t.Errorf("%q: GPUResidency.HWActiveFreqMHz = %v, want 444", line, last.GPUResidency.HWActiveFreqMHz)
+		}
+	}
+
+	// A later sample updates both fields, not only the first reading.
+	parser := NewParser(Config{})
+	var last *Metrics
+	for _, l := range []string{"GPU HW active frequency: 400 MHz", "GPU HW active frequency: 900 MHz"} {
+		if metrics, _ := parser.ParseLine(l); metrics != nil {
+			last = metrics
+		}
+	}
+	if last == nil || last.SystemSample.GPUFrequencyMHz != 900 || last.GPUResidency.HWActiveFreqMHz != 900 {
+		t.Errorf("expected both GPU frequency fields to follow the latest reading, got %+v", last)
+	}
+}
+
+// BenchmarkParser_ParseLine parses a recorded capture line by line; ns/op and
+// allocs/op are per line.
+func BenchmarkParser_ParseLine(b *testing.B) {
+	data, err := os.ReadFile("testdata/recorded_run.log")
+	if err != nil {
+		b.Fatalf("read fixture: %v", err)
+	}
+	lines := strings.Split(string(data), "\n")
+
+	parser := NewParser(Config{})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = parser.ParseLine(lines[i%len(lines)])
+	}
+}
+
+func TestParser_ParseLineAllocations(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	data, err := os.ReadFile("testdata/recorded_run.log")
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	lines := strings.Split(string(data), "\n")
+	parser := NewParser(Config{})
+	for _, line := range lines {
+		_, _ = parser.ParseLine(line)
+	}
+
+	// Lines that change nothing skip the snapshots and clones: only the
+	// regex submatches of their handler allocate.
+	for _, tt := range []struct {
+		line string
+		max  float64
+	}{
+		{"", 0},
+		{"Boot arguments follow", 0},
+		{"**** Network activity ****", 0},
+		{"out: 12.50 packets/s, 4586.65 bytes/s", 2},
+		{"read: 3.99 ops/s 44.68 KBytes/s", 2},
+		{"|-> IPI: 60.00 interrupts/sec", 2},
+	} {
+		_, _ = parser.ParseLine(tt.line)
+		if allocs := testing.AllocsPerRun(100, func() { _, _ = parser.ParseLine(tt.line) }); allocs > tt.max {
+			t.Errorf("ParseLine(%q) allocated %v times, want at most %v", tt.line, allocs, tt.max)
+		}
+	}
+
+	// A whole capture is dominated by building the emitted Metrics.
+	perLine := testing.AllocsPerRun(5, func() {
+		for _, line := range lines {
+			_, _ = parser.ParseLine(line)
+		}
+	}) / float64(len(lines))
+	if perLine > 60 {
+		t.Errorf("ParseLine allocated %.1f times per line of recorded_run.log, want at most 60", perLine)
+	}
+}
+
+func TestClassifyLine(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	tests := []struct {
+		line string
+		want lineClass
+	}{
+		{"out: 12.50 packets/s, 4586.65 bytes/s", classNetwork},
+		{"read: 3.99 ops/s 44.68 KBytes/s", classDisk},
+		{"CPU 0 active residency:  44.20% (1020 MHz:  39%)", classProcess | classCPU | classSystem},
+		{"|-> IPI: 60.00 interrupts/sec", classInterrupt},
+		{"E-Cluster HW active frequency: 1020 MHz", classCluster | classSystem},
+		{"GPU HW active frequency: 444 MHz", classGPU | classSystem},
+		{"pid 123 WindowServer 12.3ms (4.5%)", classGPUProcess},
+		{"kernel_task 0 4.13 0.00 0.00 0.00 193.38 0.00", classProcess},
+		{"Battery: percent_charge: 86", classBattery},
+		{"AC Power: Yes", classBattery | classSystem},
+		{"Current pressure level: Nominal", classThermal},
+		{"**** Network activity ****", classSection},
+		{"Boot arguments follow", 0},
+		{"CPU die temperature: 45.2 °C", classAll},
+	}
+	for _, tt := range tests {
+		if got := classifyLine(tt.line); got != tt.want {
+			t.Errorf("classifyLine(%q) = %b, want %b", tt.line, got, tt.want)
+		}
+	}
+
+	line := "CPU 4 idle residency:  85.49%"
+	if allocs := testing.AllocsPerRun(100, func() { classifyLine(line) }); allocs != 0 {
+		t.Errorf("classifyLine allocated %v times per call", allocs)
+	}
+}
+
+func TestConfig_EmitOnBattery(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	file, err := os.Open("testdata/recorded_run.log")
+	if err != nil {
+		t.Fatalf("open fixture: %v", err)
+	}
+	defer file.Close()
+
+	stream := RunReader(context.Background(), Config{EmitOn: []Section{SectionBattery}}, file)
+	var charges []float64
+	for metrics := range stream.Metrics {
+		if len(metrics.Batteries) != 1 {
+			t.Fatalf("expected only battery emits, got %+v", metrics)
+		}
+		charges = append(charges, metrics.Batteries[0])
+	}
+	for err := range stream.Errors {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []float64{36, 36, 35}; !reflect.DeepEqual(charges, want) {
+		t.Fatalf("battery emits = %v, want %v", charges, want)
+	}
+
+	// The emit carries the latest state of every section.
+	input := "CPU Power: 1200 mW\nout: 12.50 packets/s, 4586.65 bytes/s\nBattery: percent_charge: 86\n"
+	var emitted []Metrics
+	stream = RunReader(context.Background(), Config{EmitOn: []Section{SectionBattery}}, strings.NewReader(input))
+	for metrics := range stream.Metrics {
+		emitted = append(emitted, metrics)
+	}
+	for err := range stream.Errors {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(emitted) != 1 || emitted[0].SystemSample == nil {
+		t.Fatalf("expected only the battery line to emit, got %+v", emitted)
+	}
+	if emitted[0].SystemSample.CPUPowerWatts != 1.2 || emitted[0].Network == nil {
+		t.Fatalf("expected the emit to carry the latest state, got %+v", emitted[0])
+	}
+
+	// ParseLine is not filtered.
+	parser := NewParser(Config{EmitOn: []Section{SectionBattery}})
+	if metrics, _ := parser.ParseLine("CPU Power: 1200 mW"); metrics == nil {
+		t.Fatal("expected ParseLine to ignore EmitOn")
+	}
+
+	// Interrupt lines, which ParseLine does not emit for, can trigger emits.
+	input = "CPU 0:\n\tTotal IRQ: 100.00 interrupts/sec\n\t|-> IPI: 60.00 interrupts/sec\n\t|-> IPI: 60.00 interrupts/sec\nCPU Power: 1000 mW\n"
+	stream = RunReader(context.Background(), Config{EmitOn: []Section{SectionInterrupts}}, strings.NewReader(input))
+	var irqs []float64
+	for metrics := range stream.Metrics {
+		if len(metrics.Interrupts) != 1 {
+			t.Fatalf("expected interrupt metrics, got %+v", metrics)
+		}
+		irqs = append(irqs, metrics.Interrupts[0].TotalIRQ+metrics.Interrupts[0].IPI)
+	}
+	for err := range stream.Errors {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []float64{100, 160}; !reflect.DeepEqual(irqs, want) {
+		t.Fatalf("interrupt emits = %v, want %v", irqs, want)
+	}
+
+	// An unknown section fails the stream rather than silencing it.
+	stream = RunReader(context.Background(), Config{EmitOn: []Section{"batery"}}, strings.NewReader(input))
+	for metrics := range stream.Metrics {
+		t.Fatalf("unexpected metrics %+v", metrics)
+	}
+	if err := <-stream.Errors; err == nil || !strings.Contains(err.Error(), `"batery"`) {
+		t.Fatalf("expected an unknown section error, got %v", err)
+	}
+	if _, err := NewParser(Config{EmitOn: []Section{"batery"}}).RunWithErrors(context.Background()); err == nil {
+		t.Fatal("expected RunWithErrors to reject an unknown section")
+	}
+
+	if err := (Config{EmitOn: []Section{"batery"}}).Validate(); err == nil || !strings.Contains(err.Error(), `"batery"`) {
+		t.Fatalf("expected an unknown section error, got %v", err)
+	}
+}
+
+func TestDedupSamples_DropsRepeatedTimestamp(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	in := make(chan Metrics, 8)
+	errs := make(chan error)
+	base := time.Date(2025, 11, 8, 15, 54, 21, 0, time.UTC)
+	sample := func(offset time.Duration, rate float64, received int) Metrics {
+		return Metrics{
+			Timestamp:  base.Add(offset),
+			ReceivedAt: base.Add(time.Duration(received) * time.Millisecond),
+			Sequence:   uint64(received),
+			Network:    &NetworkMetrics{InBytesPerSec: rate},
+		}
+	}
+	// The second Metrics repeats the first apart from ReceivedAt and
+	// Sequence; the third is a later snapshot of the same sample; the last
+	// two lack a header.
+	inputs := []Metrics{
+		sample(0, 100, 1),
+		sample(0, 100, 2),
+		sample(0, 150, 3),
+		sample(time.Second, 150, 4),
+		{Network: &NetworkMetrics{InBytesPerSec: 1}},
+		{Network: &NetworkMetrics{InBytesPerSec: 1}},
+	}
+	for _, metrics := range inputs {
+		in <- metrics
+	}
+	close(in)
+	close(errs)
+
+	stream := DedupSamples(&Stream{Metrics: in, Errors: errs})
+	var got []Metrics
+	for metrics := range stream.Metrics {
+		got = append(got, metrics)
+	}
+	for range stream.Errors {
+	}
+	want := []Metrics{inputs[0], inputs[2], inputs[3], inputs[4], inputs[5]}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("DedupSamples = %+v, want %+v", got, want)
+	}
+}
+
+func TestDedupSamples_ReplayedCapture(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	data, err := os.ReadFile("testdata/recorded_run.log")
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	// Repeat the second sample, as overlapping logs replayed back to back do.
+	text := string(data)
+	second := strings.Index(text, "*** Sampled system activity (Sat Nov  8 15:54:26")
+	third := strings.Index(text, "*** Sampled system activity (Sat Nov  8 15:54:31")
+	if second < 0 || third < 0 {
+		t.Fatalf("fixture lacks the expected sample headers")
+	}
+	replayed := text[:third] + text[second:third] + text[third:]
+
+	collect := func(input string) []Metrics {
+		stream := DedupSamples(RunReader(context.Background(), Config{HostLabel: "test"}, strings.NewReader(input)))
+		var samples []Metrics
+		for metrics := range stream.Metrics {
+			samples = append(samples, dedupKey(metrics))
+		}
+		for err := range stream.Errors {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return samples
+	}
+
+	want := collect(text)
+	got := collect(replayed)
+	if len(got) != len(want) {
+		t.Fatalf("expected the repeated sample to be dropped: got %d metrics, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Fatalf("metrics %d differs from the capture without the repeat:\n got %+v\nwant %+v", i, got[i], want[i])
+		}
+	}
+
+	// Without the decorator the repeat is passed through.
+	var raw int
+	stream := RunReader(context.Background(), Config{HostLabel: "test"}, strings.NewReader(replayed))
+	for range stream.Metrics {
+		raw++
+	}
+	for range stream.Errors {
+	}
+	if raw <= len(want) {
+		t.Fatalf("expected the undeduplicated replay to carry extra metrics, got %d", raw)
+	}
+}
+
+func TestParser_TabIndentedInterruptLines(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	tests := []struct {
+		name   string
+		indent string
+	}{
+		{"single tab", "\t"},
+		{"deep tabs", "\t\t\t\t"},
+		{"tabs and spaces", "\t  \t    "},
+		{"spaces", "        "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := []string{
+				tt.indent + "|-> IPI: 2232.79 interrupts/sec",
+				tt.indent + "|-> TIMER: 547.20 interrupts/sec",
+			}
+			// The regular expression itself must accept the indented line,
+			// not only the trimmed one ParseLine hands it.
+			for _, line := range lines {
+				if interruptIPITimerRegex.FindStringSubmatch(line) == nil {
+					t.Errorf("interruptIPITimerRegex does not match %q", line)
+				}
+			}
+
+			parser := NewParser(Config{})
+			input := append([]string{"CPU 0:", tt.indent + "Total IRQ: 2977.12 interrupts/sec"}, lines...)
+			for _, line := range input {
+				if _, err := parser.ParseLine(line); err != nil {
+					t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+				}
+			}
+			got := parser.interruptInfo[0]
+			if got == nil {
+				t.Fatalf("expected interrupt info for CPU 0")
+			}
+			if want := (InterruptMetrics{CPUID: 0, TotalIRQ: 2977.12, IPI: 2232.79, TIMER: 547.20}); *got != want {
+				t.Errorf("interrupts = %+v, want %+v", *got, want)
+			}
+		})
+	}
+}
+
+func TestParser_ProcessTableHeaderLayouts(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	tests := []struct {
+		name   string
+		header string
+		row    string
+		want   ProcessSample
+	}{
+		{
+			name:   "default",
+			header: "Name                               ID     CPU ms/s  User%  Deadlines (<2 ms, 2-5 ms)  Wakeups (Intr, Pkg idle)",
+			row:    "Google Chrome Helper               812    25.10     60.00  1.00    2.00               30.00   4.00",
+			want: ProcessSample{
+				PID: 812, Name: "Google Chrome Helper", CPUMsPerSec: 25.1, UserPercent: 60,
+				DeadlinesLT2Ms: 1, Deadlines2To5Ms: 2, WakeupsInterrupts: 30, WakeupsPkgIdle: 4,
+			},
+		},
+		{
+			name:   "gpu, qos and energy columns",
+			header: "Name                               ID     CPU ms/s  User%  Deadlines (<2 ms, 2-5 ms)  Wakeups (Intr, Pkg idle)  GPU ms/s  QoS (Disabled, Maintenance)  Energy Impact",
+			row:    "Google Chrome Helper               812    25.10     60.00  1.00    2.00               30.00   4.00              7.50      0.00     0.00                 12.34",
+			want: ProcessSample{
+				PID: 812, Name: "Google Chrome Helper", CPUMsPerSec: 25.1, UserPercent: 60,
+				DeadlinesLT2Ms: 1, Deadlines2To5Ms: 2, WakeupsInterrupts: 30, WakeupsPkgIdle: 4,
+				GPUMsPerSec: 7.5, EnergyImpact: 12.34, energyImpactReported: true,
+			},
+		},
+		{
+			name:   "reordered columns",
+			header: "Name\tCPU ms/s\tID\tEnergy Impact\tUser%\tWakeups (Intr, Pkg idle)\tDeadlines (<2 ms, 2-5 ms)",
+			row:    "kernel_task 3.50 0 9.00 0.00 100.00 20.00 5.00 6.00",
+			want: ProcessSample{
+				PID: 0, Name: "kernel_task", CPUMsPerSec: 3.5, EnergyImpact: 9, energyImpactReported: true,
+				WakeupsInterrupts: 100, WakeupsPkgIdle: 20, DeadlinesLT2Ms: 5, Deadlines2To5Ms: 6,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := NewParser(Config{})
+			var got *Metrics
+			for _, line := range []string{"*** Running tasks ***", tt.header, tt.row, ""} {
+				metrics, err := parser.ParseLine(line)
+				if err != nil {
+					t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+				}
+				if metrics != nil {
+					got = metrics
+				}
+			}
+			if got == nil || len(got.ProcessSamples) != 1 {
+				t.Fatalf("expected one process sample, got %+v", got)
+			}
+			if got.ProcessSamples[0] != tt.want {
+				t.Errorf("process sample = %+v, want %+v", got.ProcessSamples[0], tt.want)
+			}
+			if restored := MetricsFromProto(got.ToProto()); restored.ProcessSamples[0] != tt.want {
+				t.Errorf("proto round trip = %+v, want %+v", restored.ProcessSamples[0], tt.want)
+			}
+		})
+	}
+
+	// A header the parser cannot key rows by keeps the previous mapping.
+	parser := NewParser(Config{})
+	for _, line := range []string{
+		"Name  ID  CPU ms/s  User%  Deadlines (<2 ms, 2-5 ms)  Wakeups (Intr, Pkg idle)  Energy Impact",
+		"Name  Command  CPU ms/s  User%  Deadlines (<2 ms, 2-5 ms)  Wakeups (Intr, Pkg idle)",
+		"launchd 1 1.00 0.00 0.00 0.00 2.00 0.00 3.00",
+	} {
+		if _, err := parser.ParseLine(line); err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+	}
+	if len(parser.processSamples) != 1 || parser.processSamples[0].EnergyImpact != 3 {
+		t.Fatalf("expected the first header's mapping to be kept, got %+v", parser.processSamples)
+	}
+}
+
+func TestParser_ProcessEnergyImpactColumn(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	input := strings.Join([]string{
+		"*** Sampled system activity (Sat Nov  8 15:54:21 2025 +0900) (5021.96ms elapsed) ***",
+		"",
+		"*** Running tasks ***",
+		"",
+		"Name                               ID     CPU ms/s  User%  Deadlines (<2 ms, 2-5 ms)  Wakeups (Intr, Pkg idle)  Energy Impact",
+		"WindowServer                       407    85.47     42.31  12.95   0.40               290.27  54.15             136.23",
+		"Google Chrome Helper (Renderer)    1688   9.87      85.02  0.00    0.00               17.73   3.19              11.09",
+		"mdworker_shared                    2001   0.10      50.00  0.00    0.00               0.20    0.10              0.00",
+		"DEAD_TASKS                         -1     4.21      51.95  0.00    0.00               9.36    0.00              2.50",
+		"",
+		"**** Battery and backlight usage ****",
+		"",
+	}, "\n")
+
+	stream := RunReader(context.Background(), Config{}, strings.NewReader(input))
+	var processes *Metrics
+	for metrics := range stream.Metrics {
+		if len(metrics.ProcessSamples) > 0 {
+			m := metrics
+			processes = &m
+		}
+	}
+	for err := range stream.Errors {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if processes == nil || len(processes.ProcessSamples) != 3 {
+		t.Fatalf("expected three process samples, got %+v", processes)
+	}
+	want := map[string]float64{"WindowServer": 136.23, "Google Chrome Helper (Renderer)": 11.09, "mdworker_shared": 0}
+	for _, sample := range processes.ProcessSamples {
+		if sample.EnergyImpact != want[sample.Name] || !sample.EnergyImpactReported() {
+			t.Errorf("%s: EnergyImpact = %v (reported %t), want %v", sample.Name, sample.EnergyImpact, sample.EnergyImpactReported(), want[sample.Name])
+		}
+		if sample.WakeupsPkgIdle == 0 {
+			t.Errorf("%s: expected the wakeup columns to keep their positions", sample.Name)
+		}
+	}
+	if processes.DeadTasks == nil || processes.DeadTasks.EnergyImpact != 2.5 {
+		t.Errorf("DEAD_TASKS = %+v, want EnergyImpact 2.5", processes.DeadTasks)
+	}
+	for _, sample := range MetricsFromProto(processes.ToProto()).ProcessSamples {
+		if !sample.EnergyImpactReported() || sample.EnergyImpact != want[sample.Name] {
+			t.Errorf("%s: proto round trip lost the reported Energy Impact %v", sample.Name, want[sample.Name])
+		}
+	}
+}
+
+func TestMetrics_ProcessesByName(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	metrics := Metrics{
+		ProcessSamples: []ProcessSample{
+			{PID: 812, Name: "Google Chrome Helper", CPUMsPerSec: 30, UserPercent: 80, WakeupsInterrupts: 10, WakeupsPkgIdle: 2, DeadlinesLT2Ms: 1},
+			{PID: 1, Name: "launchd", CPUMsPerSec: 2, UserPercent: 50, WakeupsInterrupts: 4, Path: "/sbin/launchd"},
+			{PID: 913, Name: "Google Chrome Helper", CPUMsPerSec: 10, UserPercent: 40, WakeupsInterrupts: 5, WakeupsPkgIdle: 1, EnergyImpact: 3},
+		},
+		DeadTasks: &ProcessSample{PID: -1, Name: deadTasksName, CPUMsPerSec: 100},
+	}
+
+	got := metrics.ProcessesByName()
+	want := map[string]ProcessSample{
+		"Google Chrome Helper": {
+			Name: "Google Chrome Helper", CPUMsPerSec: 40, UserPercent: 70,
+			WakeupsInterrupts: 15, WakeupsPkgIdle: 3, DeadlinesLT2Ms: 1, EnergyImpact: 3,
+		},
+		"launchd": metrics.ProcessSamples[1],
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ProcessesByName() = %+v, want %+v", got, want)
+	}
+
+	if got := (Metrics{}).ProcessesByName(); got != nil {
+		t.Errorf("expected nil without process samples, got %+v", got)
+	}
+}
+
+func TestMetrics_ProcessesByNameRecordedRun(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	file, err := os.Open("testdata/recorded_run.log")
+	if err != nil {
+		t.Fatalf("open fixture: %v", err)
+	}
+	defer file.Close()
+
+	var sample *Metrics
+	stream := NewParser(Config{}).RunWithReader(context.Background(), file)
+	for m := range stream.Metrics {
+		if sample == nil && len(m.ProcessSamples) > 0 {
+			m := m
+			sample = &m
+		}
+	}
+	for range stream.Errors {
+	}
+	if sample == nil {
+		t.Fatalf("expected a sample with process samples")
+	}
+
+	byName := sample.ProcessesByName()
+	if _, ok := byName["ALL_TASKS"]; ok {
+		t.Errorf("expected ALL_TASKS to be skipped, got %+v", byName["ALL_TASKS"])
+	}
+	if _, ok := byName[deadTasksName]; ok {
+		t.Errorf("expected DEAD_TASKS to be skipped")
+	}
+	containers := byName["plugin-container"]
+	if want := 65.60 + 952.47 + 55.56 + 34.29; math.Abs(containers.CPUMsPerSec-want) > 1e-9 || containers.PID != 0 {
+		t.Errorf("plugin-container = %+v, want CPUMsPerSec %v and PID 0", containers, want)
+	}
+	var total float64
+	for _, proc := range byName {
+		total += proc.CPUMsPerSec
+	}
+	if total >= 2421.75 {
+		t.Errorf("expected the per-name totals to exclude ALL_TASKS, got %v", total)
+	}
+}
+
+func TestMetrics_GPUProcessesByName(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{SampleWindow: time.Second})
+	for _, line := range []string{
+		"pid 812 (Google Chrome Helper) 120ms (12%)",
+		"pid 913 Google Chrome Helper 80ms (8%)",
+		"pid 407 WindowServer 300ms (30%)",
+	} {
+		if _, err := parser.ParseLine(line); err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+	}
+	metrics := parser.flushProcessSamples()
+	if metrics == nil || len(metrics.GPUProcessSamples) != 3 {
+		t.Fatalf("expected three GPU process samples, got %+v", metrics)
+	}
+	// A sample built elsewhere may still carry the parenthesized form.
+	metrics.GPUProcessSamples = append(metrics.GPUProcessSamples,
+		GPUProcessSample{PID: 1001, Name: "(WindowServer)", BusyPercent: 5, ActiveNanos: 50e6})
+
+	got := metrics.GPUProcessesByName()
+	if len(got) != 2 {
+		t.Fatalf("expected two names, got %+v", got)
+	}
+	chrome := got["Google Chrome Helper"]
+	if chrome.PID != 0 || math.Abs(chrome.BusyPercent-20) > 1e-9 || chrome.ActiveNanos != 200e6 {
+		t.Errorf("Google Chrome Helper = %+v, want PID 0, 20%% busy, 200ms active", chrome)
+	}
+	server := got["WindowServer"]
+	if server.Name != "WindowServer" || math.Abs(server.BusyPercent-35) > 1e-9 || server.ActiveNanos != 350e6 {
+		t.Errorf("WindowServer = %+v, want 35%% busy, 350ms active", server)
+	}
+
+	if got := (Metrics{}).GPUProcessesByName(); got != nil {
+		t.Errorf("expected nil without GPU process samples, got %+v", got)
+	}
+}
+
+func TestParser_CommandLine(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{
+		PowermetricsPath: "/opt/bin/powermetrics",
+		Profile:          ProfileBattery,
+		SampleWindow:     250 * time.Millisecond,
+		Env:              []string{"LC_ALL=C"},
+	})
+	path, args := parser.CommandLine()
+	if path != "/opt/bin/powermetrics" {
+		t.Errorf("path = %q, want /opt/bin/powermetrics", path)
+	}
+	want := append(append([]string{}, profileArgs[ProfileBattery]...), "-i", "250")
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %q, want %q", args, want)
+	}
+
+	// The returned slice is a copy.
+	args[0] = "--changed"
+	if _, again := parser.CommandLine(); again[0] == "--changed" {
+		t.Errorf("CommandLine exposed the parser's arguments")
+	}
+
+	cmd := parser.command(context.Background())
+	if cmd.Path != path || !reflect.DeepEqual(cmd.Args[1:], want) {
+		t.Errorf("command = %q %q, want %q %q", cmd.Path, cmd.Args[1:], path, want)
+	}
+
+	if path, _ := NewParser(Config{}).CommandLine(); path != defaultPowermetricsPath {
+		t.Errorf("default path = %q, want %q", path, defaultPowermetricsPath)
+	}
+}
+
+func TestConfig_StopOnError(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	capture, err := os.ReadFile("testdata/recorded_run.log")
+	if err != nil {
+		t.Fatalf("read recorded_run.log: %v", err)
+	}
+	// A GPU process line left pending when the probe gives up on the
+	// binary garbage after it, followed by a real capture.
+	var input bytes.Buffer
+	input.WriteString("pid 1 WindowServer 12.3ms (4.5%)\n")
+	for i := 1; i < garbageProbeLines; i++ {
+		input.WriteString("\x00\x7fELF\x01\n")
+	}
+	input.Write(capture)
+
+	run := func(cfg Config) ([]Metrics, []error) {
+		stream := RunReader(context.Background(), cfg, bytes.NewReader(input.Bytes()))
+		var metrics []Metrics
+		for m := range stream.Metrics {
+			metrics = append(metrics, m)
+		}
+		var errs []error
+		for err := range stream.Errors {
+			errs = append(errs, err)
+		}
+		return metrics, errs
+	}
+
+	metrics, errs := run(Config{StopOnError: true})
+	if len(errs) != 1 || !errors.Is(errs[0], ErrNotPowermetricsOutput) {
+		t.Fatalf("expected ErrNotPowermetricsOutput alone, got %v", errs)
+	}
+	if len(metrics) != 1 || len(metrics[0].GPUProcessSamples) != 1 || metrics[0].GPUProcessSamples[0].Name != "WindowServer" {
+		t.Fatalf("expected only the pending GPU process sample to be flushed, got %d metrics: %+v", len(metrics), metrics)
+	}
+
+	// By default parsing carries on past the garbage.
+	metrics, errs = run(Config{})
+	if len(errs) != 1 || !errors.Is(errs[0], ErrNotPowermetricsOutput) {
+		t.Fatalf("expected ErrNotPowermetricsOutput alone, got %v", errs)
+	}
+	if len(metrics) < 2 || metrics[len(metrics)-1].Timestamp.IsZero() {
+		t.Fatalf("expected the default to parse the capture after the garbage, got %d metrics", len(metrics))
+	}
+
+	// A scanner error ends the stream after the sample read so far.
+	long := "pid 1 WindowServer 12.3ms (4.5%)\n" + strings.Repeat("x", bufio.MaxScanTokenSize+1) + "\nCPU Power: 1500 mW\n"
+	stream := RunReader(context.Background(), Config{StopOnError: true}, strings.NewReader(long))
+	metrics = metrics[:0]
+	for m := range stream.Metrics {
+		metrics = append(metrics, m)
+	}
+	errs = errs[:0]
+	for err := range stream.Errors {
+		errs = append(errs, err)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], bufio.ErrTooLong) {
+		t.Fatalf("expected bufio.ErrTooLong alone, got %v", errs)
+	}
+	if len(metrics) != 1 || len(metrics[0].GPUProcessSamples) != 1 || metrics[0].SystemSample != nil {
+		t.Fatalf("expected only the pending GPU process sample, got %+v", metrics)
+	}
+
+	// An unexpected exit is not restarted despite the RestartPolicy.
+	crash := errors.New("exit status 1")
+	calls := 0
+	parser := NewParser(Config{StopOnError: true, RestartPolicy: RestartPolicy{MaxRetries: 2, Backoff: time.Millisecond}})
+	stream, err = parser.newStream(context.Background(), func(context.Context) (io.Reader, func() error, error) {
+		calls++
+		return strings.NewReader("CPU Power: 1500 mW\n"), func() error { return crash }, nil
+	})
+	if err != nil {
+		t.Fatalf("newStream returned error: %v", err)
+	}
+	for range stream.Metrics {
+	}
+	errs = errs[:0]
+	for err := range stream.Errors {
+		errs = append(errs, err)
+	}
+	if calls != 1 || len(errs) != 1 || errs[0] != crash {
+		t.Errorf("expected the crash to end the stream without restarting, got %d calls and %v", calls, errs)
+	}
+}
+
+func TestSystemSample_Comparable(t *testing.T) {
+	// Don't use t.Parallel() to avoid race conditions
+	parser := NewParser(Config{})
+	for _, line := range []string{"E-Cluster Power: 120 mW", "P-Cluster Power: 880 mW", "CPU Power: 1000 mW"} {
+		if _, err := parser.ParseLine(line); err != nil {
+			t.Fatalf("ParseLine(%q) returned error: %v", line, err)
+		}
+	}
+	a, b := parser.systemSnapshot(), parser.systemSnapshot()
+	if *a != *b {
+		t.Fatalf("expected equal snapshots to compare equal with ==")
+	}
+	b.CPUPowerWatts++
+	if *a == *b {
+		t.Fatalf("expected differing snapshots to compare unequal")
+	}
+}
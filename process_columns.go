@@ -0,0 +1,92 @@
+package powermetrics
+
+import (
+	"regexp"
+	"strings"
+)
+
+// processColumn is the ProcessSample field a tasks table value column fills.
+type processColumn uint8
+
+const (
+	// columnIgnored is a column the parser does not map, e.g. the QoS or
+	// I/O columns some flags add; its values are skipped.
+	columnIgnored processColumn = iota
+	columnPID
+	columnCPUMsPerSec
+	columnUserPercent
+	columnDeadlinesLT2Ms
+	columnDeadlines2To5Ms
+	columnWakeupsInterrupts
+	columnWakeupsPkgIdle
+	columnGPUMsPerSec
+	columnEnergyImpact
+)
+
+// defaultProcessColumns is the layout of the plain tasks table, used until a
+// header row has been seen:
+//
+//	Name  ID  CPU ms/s  User%  Deadlines (<2 ms, 2-5 ms)  Wakeups (Intr, Pkg idle)
+var defaultProcessColumns = []processColumn{
+	columnPID,
+	columnCPUMsPerSec,
+	columnUserPercent,
+	columnDeadlinesLT2Ms,
+	columnDeadlines2To5Ms,
+	columnWakeupsInterrupts,
+	columnWakeupsPkgIdle,
+}
+
+// processHeaderLabels maps the lowercase labels of the tasks table header to
+// their columns. A label with a parenthesized, comma-separated list, such as
+// "Deadlines (<2 ms, 2-5 ms)", heads one column per list entry and is
+// matched by the text before the parenthesis.
+var processHeaderLabels = map[string][]processColumn{
+	"id":            {columnPID},
+	"pid":           {columnPID},
+	"cpu ms/s":      {columnCPUMsPerSec},
+	"user%":         {columnUserPercent},
+	"deadlines":     {columnDeadlinesLT2Ms, columnDeadlines2To5Ms},
+	"wakeups":       {columnWakeupsInterrupts, columnWakeupsPkgIdle},
+	"gpu ms/s":      {columnGPUMsPerSec},
+	"energy impact": {columnEnergyImpact},
+}
+
+// processHeaderSeparator splits the header into labels, which are separated
+// by at least two spaces or a tab, while words within a label are separated
+// by single spaces.
+var processHeaderSeparator = regexp.MustCompile(`\s{2,}|\t`)
+
+// parseProcessHeader maps the value columns of a tasks table header row,
+// i.e. every column after Name, to ProcessSample fields. Unknown labels map to
+// columnIgnored so the following columns keep their positions. It reports
+// false for a header without an ID column, which rows could not be keyed by.
+func parseProcessHeader(line string) ([]processColumn, bool) {
+	labels := processHeaderSeparator.Split(strings.TrimSpace(line), -1)
+	if len(labels) < 2 || !strings.EqualFold(labels[0], "name") {
+		return nil, false
+	}
+
+	var columns []processColumn
+	hasPID := false
+	for _, label := range labels[1:] {
+		label = strings.ToLower(strings.TrimSpace(label))
+		if label == "" {
+			continue
+		}
+		width := 1
+		if open := strings.Index(label, "("); open >= 0 {
+			width = strings.Count(label[open:], ",") + 1
+			label = strings.TrimSpace(label[:open])
+		}
+		mapped, ok := processHeaderLabels[label]
+		if !ok || len(mapped) != width {
+			mapped = make([]processColumn, width)
+		}
+		for _, column := range mapped {
+			hasPID = hasPID || column == columnPID
+		}
+		columns = append(columns, mapped...)
+	}
+	return columns, hasPID
+}
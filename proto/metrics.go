@@ -0,0 +1,622 @@
+// Package pb holds the protobuf form of powermetrics.Metrics described by
+// metrics.proto. The message types are plain structs with hand-written
+// Marshal and Unmarshal methods, so the module needs no protobuf runtime;
+// the encoding is wire-compatible with code generated from the schema. Use
+// powermetrics.Metrics.ToProto and powermetrics.MetricsFromProto to convert.
+package pb
+
+// Metrics mirrors powermetrics.Metrics.
+type Metrics struct {
+	TimestampUnixNano  int64
+	ElapsedNanos       int64
+	ReceivedAtUnixNano int64
+	Sequence           uint64
+	PowerUnit          string
+	Host               string
+	SystemSample       *SystemSample
+	ProcessSamples     []*ProcessSample
+	DeadTasks          *ProcessSample
+	GPUProcessSamples  []*GPUProcessSample
+	Clusters           []*ClusterInfo
+	CPUResidencies     []*CPUResidency
+	ClusterResidencies []*ClusterResidency
+	GPUResidency       *GPUResidency
+	Network            *NetworkMetrics
+	Disk               *DiskMetrics
+	Interrupts         []*InterruptMetrics
+	RawNetwork         *NetworkMetrics
+	RawDisk            *DiskMetrics
+	Batteries          []float64
+	CPUPowerByCluster  map[string]float64
+}
+
+// SystemSample mirrors powermetrics.SystemSample. A nil field was not
+// reported by powermetrics.
+type SystemSample struct {
+	CPUPowerWatts         *float64
+	CPUFrequencyMHz       *float64
+	CPUBusyPercent        *float64
+	GPUBusyPercent        *float64
+	GPUPowerWatts         *float64
+	GPUFrequencyMHz       *float64
+	GPUTemperatureC       *float64
+	CPUTemperatureC       *float64
+	ANEBusyPercent        *float64
+	ANEPowerWatts         *float64
+	DRAMPowerWatts        *float64
+	DRAMReadBandwidthGBs  *float64
+	DRAMWriteBandwidthGBs *float64
+	BatteryPercent        *float64
+	BacklightPercent      *float64
+	SystemWakeupsPerSec   *float64
+	PackagePowerWatts     *float64
+	PackageEnergyJoules   *float64
+	CPUEnergyJoules       *float64
+	GPUEnergyJoules       *float64
+	OnAC                  *bool
+	ThermalPressure       string
+	CPUBusyDerived        bool
+}
+
+// ProcessSample mirrors powermetrics.ProcessSample.
+type ProcessSample struct {
+	PID               int64
+	Name              string
+	CPUMsPerSec       float64
+	UserPercent       float64
+	DeadlinesLT2Ms    float64
+	Deadlines2To5Ms   float64
+	WakeupsInterrupts float64
+	WakeupsPkgIdle    float64
+	Path              string
+	GPUMsPerSec       float64
+	// EnergyImpact is nil when the tasks table had no Energy Impact column.
+	EnergyImpact *float64
+}
+
+// GPUProcessSample mirrors powermetrics.GPUProcessSample.
+type GPUProcessSample struct {
+	PID          int64
+	Name         string
+	BusyPercent  float64
+	ActiveNanos  uint64
+	FrequencyMHz float64
+}
+
+// ClusterInfo mirrors powermetrics.ClusterInfo.
+type ClusterInfo struct {
+	Name          string
+	Type          string
+	OnlinePercent float64
+	HWActiveFreq  float64
+	PowerWatts    float64
+}
+
+// FrequencyResidency is one entry of a frequency-keyed residency map.
+type FrequencyResidency struct {
+	FrequencyMHz float64
+	Percent      float64
+}
+
+// CPUResidency mirrors powermetrics.CPUResidencyMetrics.
+type CPUResidency struct {
+	CPUID           int64
+	ActiveResidency []*FrequencyResidency
+	IdleResidency   float64
+	DownResidency   float64
+	Frequency       float64
+}
+
+// ClusterResidency mirrors powermetrics.ClusterResidencyMetrics.
+type ClusterResidency struct {
+	Name                  string
+	Type                  string
+	OnlinePercent         float64
+	HWActiveFreq          float64
+	HWActiveResidency     float64
+	HWActiveFreqResidency []*FrequencyResidency
+	IdleResidency         float64
+	DownResidency         float64
+	PowerWatts            float64
+}
+
+// GPUResidency mirrors powermetrics.GPUResidencyMetrics.
+type GPUResidency struct {
+	HWActiveResidency     float64
+	HWActiveFreqResidency []*FrequencyResidency
+	SWRequestedStates     map[string]float64
+	SWStates              map[string]float64
+	CStates               map[string]float64
+	IdleResidency         float64
+	PowerMilliwatts       float64
+	HWActiveFreqMHz       float64
+}
+
+// NetworkMetrics mirrors powermetrics.NetworkMetrics.
+type NetworkMetrics struct {
+	InPacketsPerSec  float64
+	InBytesPerSec    float64
+	OutPacketsPerSec float64
+	OutBytesPerSec   float64
+}
+
+// DiskMetrics mirrors powermetrics.DiskMetrics.
+type DiskMetrics struct {
+	ReadOpsPerSec    float64
+	ReadBytesPerSec  float64
+	WriteOpsPerSec   float64
+	WriteBytesPerSec float64
+}
+
+// InterruptMetrics mirrors powermetrics.InterruptMetrics.
+type InterruptMetrics struct {
+	CPUID    int64
+	TotalIRQ float64
+	IPI      float64
+	TIMER    float64
+}
+
+// Marshal encodes m in the protobuf wire format.
+func (m *Metrics) Marshal() ([]byte, error) {
+	return m.appendTo(nil), nil
+}
+
+// Unmarshal decodes data produced by Marshal, or by any protobuf
+// implementation of metrics.proto, into m.
+func (m *Metrics) Unmarshal(data []byte) error {
+	*m = Metrics{}
+	return m.unmarshal(data)
+}
+
+func (m *Metrics) appendTo(b []byte) []byte {
+	b = appendInt64(b, 1, m.TimestampUnixNano)
+	b = appendInt64(b, 2, m.ElapsedNanos)
+	b = appendInt64(b, 3, m.ReceivedAtUnixNano)
+	b = appendUint64(b, 4, m.Sequence)
+	b = appendString(b, 5, m.PowerUnit)
+	b = appendString(b, 6, m.Host)
+	if m.SystemSample != nil {
+		b = appendMessage(b, 7, m.SystemSample.appendTo(nil))
+	}
+	for _, s := range m.ProcessSamples {
+		b = appendMessage(b, 8, s.appendTo(nil))
+	}
+	if m.DeadTasks != nil {
+		b = appendMessage(b, 9, m.DeadTasks.appendTo(nil))
+	}
+	for _, s := range m.GPUProcessSamples {
+		b = appendMessage(b, 10, s.appendTo(nil))
+	}
+	for _, c := range m.Clusters {
+		b = appendMessage(b, 11, c.appendTo(nil))
+	}
+	for _, c := range m.CPUResidencies {
+		b = appendMessage(b, 12, c.appendTo(nil))
+	}
+	for _, c := range m.ClusterResidencies {
+		b = appendMessage(b, 13, c.appendTo(nil))
+	}
+	if m.GPUResidency != nil {
+		b = appendMessage(b, 14, m.GPUResidency.appendTo(nil))
+	}
+	if m.Network != nil {
+		b = appendMessage(b, 15, m.Network.appendTo(nil))
+	}
+	if m.Disk != nil {
+		b = appendMessage(b, 16, m.Disk.appendTo(nil))
+	}
+	for _, i := range m.Interrupts {
+		b = appendMessage(b, 17, i.appendTo(nil))
+	}
+	if m.RawNetwork != nil {
+		b = appendMessage(b, 18, m.RawNetwork.appendTo(nil))
+	}
+	if m.RawDisk != nil {
+		b = appendMessage(b, 19, m.RawDisk.appendTo(nil))
+	}
+	b = appendPackedDoubles(b, 20, m.Batteries)
+	return appendStringDoubleMap(b, 21, m.CPUPowerByCluster)
+}
+
+func (m *Metrics) unmarshal(data []byte) error {
+	return forEachField(data, func(f field) error {
+		switch f.num {
+		case 1:
+			m.TimestampUnixNano = f.int64()
+		case 2:
+			m.ElapsedNanos = f.int64()
+		case 3:
+			m.ReceivedAtUnixNano = f.int64()
+		case 4:
+			m.Sequence = f.u
+		case 5:
+			m.PowerUnit = f.string()
+		case 6:
+			m.Host = f.string()
+		case 7:
+			m.SystemSample = &SystemSample{}
+			return m.SystemSample.unmarshal(f.data)
+		case 8:
+			s := &ProcessSample{}
+			m.ProcessSamples = append(m.ProcessSamples, s)
+			return s.unmarshal(f.data)
+		case 9:
+			m.DeadTasks = &ProcessSample{}
+			return m.DeadTasks.unmarshal(f.data)
+		case 10:
+			s := &GPUProcessSample{}
+			m.GPUProcessSamples = append(m.GPUProcessSamples, s)
+			return s.unmarshal(f.data)
+		case 11:
+			c := &ClusterInfo{}
+			m.Clusters = append(m.Clusters, c)
+			return c.unmarshal(f.data)
+		case 12:
+			c := &CPUResidency{}
+			m.CPUResidencies = append(m.CPUResidencies, c)
+			return c.unmarshal(f.data)
+		case 13:
+			c := &ClusterResidency{}
+			m.ClusterResidencies = append(m.ClusterResidencies, c)
+			return c.unmarshal(f.data)
+		case 14:
+			m.GPUResidency = &GPUResidency{}
+			return m.GPUResidency.unmarshal(f.data)
+		case 15:
+			m.Network = &NetworkMetrics{}
+			return m.Network.unmarshal(f.data)
+		case 16:
+			m.Disk = &DiskMetrics{}
+			return m.Disk.unmarshal(f.data)
+		case 17:
+			i := &InterruptMetrics{}
+			m.Interrupts = append(m.Interrupts, i)
+			return i.unmarshal(f.data)
+		case 18:
+			m.RawNetwork = &NetworkMetrics{}
+			return m.RawNetwork.unmarshal(f.data)
+		case 19:
+			m.RawDisk = &DiskMetrics{}
+			return m.RawDisk.unmarshal(f.data)
+		case 20:
+			values, err := f.doubles()
+			m.Batteries = append(m.Batteries, values...)
+			return err
+		case 21:
+			return readStringDoubleEntry(&m.CPUPowerByCluster, f.data)
+		}
+		return nil
+	})
+}
+
+func (s *SystemSample) appendTo(b []byte) []byte {
+	for num, v := range s.doubles() {
+		if num > 0 {
+			b = appendOptionalDouble(b, num, *v)
+		}
+	}
+	b = appendOptionalBool(b, 22, s.OnAC)
+	b = appendString(b, 23, s.ThermalPressure)
+	return appendBool(b, 25, s.CPUBusyDerived)
+}
+
+// doubles returns the optional double fields indexed by field number.
+func (s *SystemSample) doubles() []**float64 {
+	return []**float64{
+		1: &s.CPUPowerWatts, 2: &s.CPUFrequencyMHz, 3: &s.CPUBusyPercent,
+		4: &s.GPUBusyPercent, 5: &s.GPUPowerWatts, 6: &s.GPUFrequencyMHz,
+		7: &s.GPUTemperatureC, 8: &s.CPUTemperatureC, 9: &s.ANEBusyPercent,
+		10: &s.ANEPowerWatts, 11: &s.DRAMPowerWatts, 12: &s.DRAMReadBandwidthGBs,
+		13: &s.DRAMWriteBandwidthGBs, 14: &s.BatteryPercent, 15: &s.BacklightPercent,
+		16: &s.SystemWakeupsPerSec, 17: &s.PackagePowerWatts, 18: &s.PackageEnergyJoules,
+		19: &s.CPUEnergyJoules, 20: &s.GPUEnergyJoules,
+	}
+}
+
+func (s *SystemSample) unmarshal(data []byte) error {
+	doubles := s.doubles()
+	return forEachField(data, func(f field) error {
+		switch {
+		case f.num >= 1 && f.num <= 20:
+			v := f.double()
+			*doubles[f.num] = &v
+		case f.num == 22:
+			v := f.bool()
+			s.OnAC = &v
+		case f.num == 23:
+			s.ThermalPressure = f.string()
+		case f.num == 25:
+			s.CPUBusyDerived = f.bool()
+		}
+		return nil
+	})
+}
+
+func (s *ProcessSample) appendTo(b []byte) []byte {
+	b = appendInt64(b, 1, s.PID)
+	b = appendString(b, 2, s.Name)
+	b = appendDouble(b, 3, s.CPUMsPerSec)
+	b = appendDouble(b, 4, s.UserPercent)
+	b = appendDouble(b, 5, s.DeadlinesLT2Ms)
+	b = appendDouble(b, 6, s.Deadlines2To5Ms)
+	b = appendDouble(b, 7, s.WakeupsInterrupts)
+	b = appendDouble(b, 8, s.WakeupsPkgIdle)
+	b = appendString(b, 9, s.Path)
+	b = appendDouble(b, 10, s.GPUMsPerSec)
+	return appendOptionalDouble(b, 11, s.EnergyImpact)
+}
+
+func (s *ProcessSample) unmarshal(data []byte) error {
+	return forEachField(data, func(f field) error {
+		switch f.num {
+		case 1:
+			s.PID = f.int64()
+		case 2:
+			s.Name = f.string()
+		case 3:
+			s.CPUMsPerSec = f.double()
+		case 4:
+			s.UserPercent = f.double()
+		case 5:
+			s.DeadlinesLT2Ms = f.double()
+		case 6:
+			s.Deadlines2To5Ms = f.double()
+		case 7:
+			s.WakeupsInterrupts = f.double()
+		case 8:
+			s.WakeupsPkgIdle = f.double()
+		case 9:
+			s.Path = f.string()
+		case 10:
+			s.GPUMsPerSec = f.double()
+		case 11:
+			v := f.double()
+			s.EnergyImpact = &v
+		}
+		return nil
+	})
+}
+
+func (s *GPUProcessSample) appendTo(b []byte) []byte {
+	b = appendInt64(b, 1, s.PID)
+	b = appendString(b, 2, s.Name)
+	b = appendDouble(b, 3, s.BusyPercent)
+	b = appendUint64(b, 4, s.ActiveNanos)
+	return appendDouble(b, 5, s.FrequencyMHz)
+}
+
+func (s *GPUProcessSample) unmarshal(data []byte) error {
+	return forEachField(data, func(f field) error {
+		switch f.num {
+		case 1:
+			s.PID = f.int64()
+		case 2:
+			s.Name = f.string()
+		case 3:
+			s.BusyPercent = f.double()
+		case 4:
+			s.ActiveNanos = f.u
+		case 5:
+			s.FrequencyMHz = f.double()
+		}
+		return nil
+	})
+}
+
+func (c *ClusterInfo) appendTo(b []byte) []byte {
+	b = appendString(b, 1, c.Name)
+	b = appendString(b, 2, c.Type)
+	b = appendDouble(b, 3, c.OnlinePercent)
+	b = appendDouble(b, 4, c.HWActiveFreq)
+	return appendDouble(b, 5, c.PowerWatts)
+}
+
+func (c *ClusterInfo) unmarshal(data []byte) error {
+	return forEachField(data, func(f field) error {
+		switch f.num {
+		case 1:
+			c.Name = f.string()
+		case 2:
+			c.Type = f.string()
+		case 3:
+			c.OnlinePercent = f.double()
+		case 4:
+			c.HWActiveFreq = f.double()
+		case 5:
+			c.PowerWatts = f.double()
+		}
+		return nil
+	})
+}
+
+func appendResidencies(b []byte, num int, entries []*FrequencyResidency) []byte {
+	var entry []byte
+	for _, r := range entries {
+		entry = appendDouble(entry[:0], 1, r.FrequencyMHz)
+		entry = appendDouble(entry, 2, r.Percent)
+		b = appendMessage(b, num, entry)
+	}
+	return b
+}
+
+func readResidency(entries *[]*FrequencyResidency, data []byte) error {
+	r := &FrequencyResidency{}
+	*entries = append(*entries, r)
+	return forEachField(data, func(f field) error {
+		switch f.num {
+		case 1:
+			r.FrequencyMHz = f.double()
+		case 2:
+			r.Percent = f.double()
+		}
+		return nil
+	})
+}
+
+func (c *CPUResidency) appendTo(b []byte) []byte {
+	b = appendInt64(b, 1, c.CPUID)
+	b = appendResidencies(b, 2, c.ActiveResidency)
+	b = appendDouble(b, 3, c.IdleResidency)
+	b = appendDouble(b, 4, c.DownResidency)
+	return appendDouble(b, 5, c.Frequency)
+}
+
+func (c *CPUResidency) unmarshal(data []byte) error {
+	return forEachField(data, func(f field) error {
+		switch f.num {
+		case 1:
+			c.CPUID = f.int64()
+		case 2:
+			return readResidency(&c.ActiveResidency, f.data)
+		case 3:
+			c.IdleResidency = f.double()
+		case 4:
+			c.DownResidency = f.double()
+		case 5:
+			c.Frequency = f.double()
+		}
+		return nil
+	})
+}
+
+func (c *ClusterResidency) appendTo(b []byte) []byte {
+	b = appendString(b, 1, c.Name)
+	b = appendString(b, 2, c.Type)
+	b = appendDouble(b, 3, c.OnlinePercent)
+	b = appendDouble(b, 4, c.HWActiveFreq)
+	b = appendDouble(b, 5, c.HWActiveResidency)
+	b = appendResidencies(b, 6, c.HWActiveFreqResidency)
+	b = appendDouble(b, 7, c.IdleResidency)
+	b = appendDouble(b, 8, c.DownResidency)
+	return appendDouble(b, 9, c.PowerWatts)
+}
+
+func (c *ClusterResidency) unmarshal(data []byte) error {
+	return forEachField(data, func(f field) error {
+		switch f.num {
+		case 1:
+			c.Name = f.string()
+		case 2:
+			c.Type = f.string()
+		case 3:
+			c.OnlinePercent = f.double()
+		case 4:
+			c.HWActiveFreq = f.double()
+		case 5:
+			c.HWActiveResidency = f.double()
+		case 6:
+			return readResidency(&c.HWActiveFreqResidency, f.data)
+		case 7:
+			c.IdleResidency = f.double()
+		case 8:
+			c.DownResidency = f.double()
+		case 9:
+			c.PowerWatts = f.double()
+		}
+		return nil
+	})
+}
+
+func (g *GPUResidency) appendTo(b []byte) []byte {
+	b = appendDouble(b, 1, g.HWActiveResidency)
+	b = appendResidencies(b, 2, g.HWActiveFreqResidency)
+	b = appendStringDoubleMap(b, 3, g.SWRequestedStates)
+	b = appendStringDoubleMap(b, 4, g.SWStates)
+	b = appendStringDoubleMap(b, 5, g.CStates)
+	b = appendDouble(b, 6, g.IdleResidency)
+	b = appendDouble(b, 7, g.PowerMilliwatts)
+	return appendDouble(b, 8, g.HWActiveFreqMHz)
+}
+
+func (g *GPUResidency) unmarshal(data []byte) error {
+	return forEachField(data, func(f field) error {
+		switch f.num {
+		case 1:
+			g.HWActiveResidency = f.double()
+		case 2:
+			return readResidency(&g.HWActiveFreqResidency, f.data)
+		case 3:
+			return readStringDoubleEntry(&g.SWRequestedStates, f.data)
+		case 4:
+			return readStringDoubleEntry(&g.SWStates, f.data)
+		case 5:
+			return readStringDoubleEntry(&g.CStates, f.data)
+		case 6:
+			g.IdleResidency = f.double()
+		case 7:
+			g.PowerMilliwatts = f.double()
+		case 8:
+			g.HWActiveFreqMHz = f.double()
+		}
+		return nil
+	})
+}
+
+func (n *NetworkMetrics) appendTo(b []byte) []byte {
+	b = appendDouble(b, 1, n.InPacketsPerSec)
+	b = appendDouble(b, 2, n.InBytesPerSec)
+	b = appendDouble(b, 3, n.OutPacketsPerSec)
+	return appendDouble(b, 4, n.OutBytesPerSec)
+}
+
+func (n *NetworkMetrics) unmarshal(data []byte) error {
+	return forEachField(data, func(f field) error {
+		switch f.num {
+		case 1:
+			n.InPacketsPerSec = f.double()
+		case 2:
+			n.InBytesPerSec = f.double()
+		case 3:
+			n.OutPacketsPerSec = f.double()
+		case 4:
+			n.OutBytesPerSec = f.double()
+		}
+		return nil
+	})
+}
+
+func (d *DiskMetrics) appendTo(b []byte) []byte {
+	b = appendDouble(b, 1, d.ReadOpsPerSec)
+	b = appendDouble(b, 2, d.ReadBytesPerSec)
+	b = appendDouble(b, 3, d.WriteOpsPerSec)
+	return appendDouble(b, 4, d.WriteBytesPerSec)
+}
+
+func (d *DiskMetrics) unmarshal(data []byte) error {
+	return forEachField(data, func(f field) error {
+		switch f.num {
+		case 1:
+			d.ReadOpsPerSec = f.double()
+		case 2:
+			d.ReadBytesPerSec = f.double()
+		case 3:
+			d.WriteOpsPerSec = f.double()
+		case 4:
+			d.WriteBytesPerSec = f.double()
+		}
+		return nil
+	})
+}
+
+func (i *InterruptMetrics) appendTo(b []byte) []byte {
+	b = appendInt64(b, 1, i.CPUID)
+	b = appendDouble(b, 2, i.TotalIRQ)
+	b = appendDouble(b, 3, i.IPI)
+	return appendDouble(b, 4, i.TIMER)
+}
+
+func (i *InterruptMetrics) unmarshal(data []byte) error {
+	return forEachField(data, func(f field) error {
+		switch f.num {
+		case 1:
+			i.CPUID = f.int64()
+		case 2:
+			i.TotalIRQ = f.double()
+		case 3:
+			i.IPI = f.double()
+		case 4:
+			i.TIMER = f.double()
+		}
+		return nil
+	})
+}
@@ -0,0 +1,253 @@
+package pb
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// The helpers below implement the subset of the protobuf wire format the
+// messages in metrics.proto need. Encoding follows proto3 rules: scalar
+// fields holding their zero value are omitted.
+
+type wireType uint8
+
+const (
+	wireVarint  wireType = 0
+	wireFixed64 wireType = 1
+	wireBytes   wireType = 2
+	wireFixed32 wireType = 5
+)
+
+var (
+	errTruncated = errors.New("pb: truncated message")
+	errWireType  = errors.New("pb: unsupported wire type")
+)
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendTag(b []byte, num int, wt wireType) []byte {
+	return appendVarint(b, uint64(num)<<3|uint64(wt))
+}
+
+func appendFixed64(b []byte, v uint64) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24),
+		byte(v>>32), byte(v>>40), byte(v>>48), byte(v>>56))
+}
+
+func appendDouble(b []byte, num int, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendTag(b, num, wireFixed64)
+	return appendFixed64(b, math.Float64bits(v))
+}
+
+// appendOptionalDouble writes a proto3 optional double: it is written
+// whenever v is set, even when it holds 0.
+func appendOptionalDouble(b []byte, num int, v *float64) []byte {
+	if v == nil {
+		return b
+	}
+	b = appendTag(b, num, wireFixed64)
+	return appendFixed64(b, math.Float64bits(*v))
+}
+
+// appendOptionalBool writes a proto3 optional bool: it is written whenever
+// v is set, even when it holds false.
+func appendOptionalBool(b []byte, num int, v *bool) []byte {
+	if v == nil {
+		return b
+	}
+	b = appendTag(b, num, wireVarint)
+	if *v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+func appendUint64(b []byte, num int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendTag(b, num, wireVarint)
+	return appendVarint(b, v)
+}
+
+func appendInt64(b []byte, num int, v int64) []byte {
+	return appendUint64(b, num, uint64(v))
+}
+
+func appendBool(b []byte, num int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendUint64(b, num, 1)
+}
+
+func appendString(b []byte, num int, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = appendTag(b, num, wireBytes)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendMessage writes an embedded message; unlike scalars it is written even
+// when empty, so the receiver can tell a present message from a nil one.
+func appendMessage(b []byte, num int, msg []byte) []byte {
+	b = appendTag(b, num, wireBytes)
+	b = appendVarint(b, uint64(len(msg)))
+	return append(b, msg...)
+}
+
+func appendPackedDoubles(b []byte, num int, values []float64) []byte {
+	if len(values) == 0 {
+		return b
+	}
+	b = appendTag(b, num, wireBytes)
+	b = appendVarint(b, uint64(8*len(values)))
+	for _, v := range values {
+		b = appendFixed64(b, math.Float64bits(v))
+	}
+	return b
+}
+
+// appendStringDoubleMap writes a map<string, double> as its repeated entry
+// messages, ordered by key so the encoding is deterministic.
+func appendStringDoubleMap(b []byte, num int, m map[string]float64) []byte {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var entry []byte
+	for _, key := range keys {
+		entry = appendString(entry[:0], 1, key)
+		entry = appendDouble(entry, 2, m[key])
+		b = appendMessage(b, num, entry)
+	}
+	return b
+}
+
+// field is one decoded field: u holds varint and fixed values, data the
+// payload of length-delimited ones.
+type field struct {
+	num  int
+	wt   wireType
+	u    uint64
+	data []byte
+}
+
+func (f field) double() float64 { return math.Float64frombits(f.u) }
+func (f field) int64() int64    { return int64(f.u) }
+func (f field) bool() bool      { return f.u != 0 }
+func (f field) string() string  { return string(f.data) }
+
+// doubles decodes a repeated double field, packed or not.
+func (f field) doubles() ([]float64, error) {
+	if f.wt == wireFixed64 {
+		return []float64{f.double()}, nil
+	}
+	if len(f.data)%8 != 0 {
+		return nil, errTruncated
+	}
+	values := make([]float64, 0, len(f.data)/8)
+	for i := 0; i < len(f.data); i += 8 {
+		values = append(values, math.Float64frombits(readFixed64(f.data[i:])))
+	}
+	return values, nil
+}
+
+func readFixed64(b []byte) uint64 {
+	return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
+		uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56
+}
+
+func readVarint(b []byte) (uint64, int, error) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * i)
+		if b[i] < 0x80 {
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, errTruncated
+}
+
+// forEachField decodes data field by field, calling fn for each. Unknown
+// fields are passed to fn too, which ignores them, as protobuf requires.
+func forEachField(data []byte, fn func(field) error) error {
+	for len(data) > 0 {
+		tag, n, err := readVarint(data)
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+		f := field{num: int(tag >> 3), wt: wireType(tag & 7)}
+		switch f.wt {
+		case wireVarint:
+			if f.u, n, err = readVarint(data); err != nil {
+				return err
+			}
+		case wireFixed64:
+			if len(data) < 8 {
+				return errTruncated
+			}
+			f.u, n = readFixed64(data), 8
+		case wireFixed32:
+			if len(data) < 4 {
+				return errTruncated
+			}
+			f.u = uint64(data[0]) | uint64(data[1])<<8 | uint64(data[2])<<16 | uint64(data[3])<<24
+			n = 4
+		case wireBytes:
+			size, m, err := readVarint(data)
+			if err != nil {
+				return err
+			}
+			if uint64(len(data)-m) < size {
+				return errTruncated
+			}
+			f.data, n = data[m:m+int(size)], m+int(size)
+		default:
+			return errWireType
+		}
+		data = data[n:]
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readStringDoubleEntry decodes one map<string, double> entry message into m,
+// allocating it on first use.
+func readStringDoubleEntry(m *map[string]float64, data []byte) error {
+	var key string
+	var value float64
+	err := forEachField(data, func(f field) error {
+		switch f.num {
+		case 1:
+			key = f.string()
+		case 2:
+			value = f.double()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if *m == nil {
+		*m = make(map[string]float64)
+	}
+	(*m)[key] = value
+	return nil
+}
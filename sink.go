@@ -0,0 +1,74 @@
+package powermetrics
+
+import (
+	"context"
+	"io"
+	"log"
+)
+
+// Sink is a destination for parsed samples, such as a statsd client or a
+// time-series store. Write is called from a single goroutine by Pump.
+type Sink interface {
+	Write(Metrics) error
+}
+
+// SinkFunc adapts an ordinary function to a Sink.
+type SinkFunc func(Metrics) error
+
+// Write calls f(m).
+func (f SinkFunc) Write(m Metrics) error {
+	return f(m)
+}
+
+// Pump writes every Metrics received from metrics to sink until the channel
+// closes, returning nil, or ctx is done, returning ctx.Err(). The first
+// error sink returns stops the pump and is returned; wrap the sink with
+// ContinueOnError to log failed writes and keep going instead.
+func Pump(ctx context.Context, metrics <-chan Metrics, sink Sink) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case m, ok := <-metrics:
+			if !ok {
+				return nil
+			}
+			if err := sink.Write(m); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ContinueOnError wraps sink so a failed Write is reported to logger (when
+// not nil) and swallowed, letting Pump carry on with the next sample.
+func ContinueOnError(sink Sink, logger *log.Logger) Sink {
+	return SinkFunc(func(m Metrics) error {
+		if err := sink.Write(m); err != nil && logger != nil {
+			logger.Printf("powermetrics: sink write failed: %v", err)
+		}
+		return nil
+	})
+}
+
+// WriterSink is a Sink that encodes each Metrics as one line of JSON on W,
+// formatted according to JSON.
+type WriterSink struct {
+	W    io.Writer
+	JSON JSONOptions
+}
+
+// NewWriterSink returns a WriterSink writing JSON lines to w.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{W: w}
+}
+
+// Write encodes m followed by a newline.
+func (s *WriterSink) Write(m Metrics) error {
+	data, err := s.JSON.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = s.W.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,212 @@
+package powermetrics
+
+import "time"
+
+// averagedSystemFields lists the SystemSample rates AggregateByInterval
+// averages. The remaining fields (battery, backlight, thermal pressure and
+// the cumulative energy counters) keep the bucket's last value.
+var averagedSystemFields = []func(*SystemSample) *float64{
+	func(s *SystemSample) *float64 { return &s.CPUPowerWatts },
+	func(s *SystemSample) *float64 { return &s.CPUFrequencyMHz },
+	func(s *SystemSample) *float64 { return &s.CPUBusyPercent },
+	func(s *SystemSample) *float64 { return &s.GPUBusyPercent },
+	func(s *SystemSample) *float64 { return &s.GPUPowerWatts },
+	func(s *SystemSample) *float64 { return &s.GPUFrequencyMHz },
+	func(s *SystemSample) *float64 { return &s.GPUTemperatureC },
+	func(s *SystemSample) *float64 { return &s.CPUTemperatureC },
+	func(s *SystemSample) *float64 { return &s.ANEBusyPercent },
+	func(s *SystemSample) *float64 { return &s.ANEPowerWatts },
+	func(s *SystemSample) *float64 { return &s.DRAMPowerWatts },
+	func(s *SystemSample) *float64 { return &s.DRAMReadBandwidthGBs },
+	func(s *SystemSample) *float64 { return &s.DRAMWriteBandwidthGBs },
+	func(s *SystemSample) *float64 { return &s.SystemWakeupsPerSec },
+	func(s *SystemSample) *float64 { return &s.PackagePowerWatts },
+}
+
+// AggregateByInterval wraps stream so it emits one Metrics per fixed
+// wall-clock bucket of the given interval (e.g. one per minute) instead of
+// one per sample. Samples are assigned to buckets by their parsed Timestamp,
+// falling back to ReceivedAt for input without sample headers.
+//
+// The incremental snapshots of each sample are merged first, then the
+// SystemSample rates and the Network and Disk rates are averaged over the
+// bucket's samples. Every other section comes from the bucket's last sample.
+// The emitted Metrics carries the bucket start as Timestamp, the summed
+// sample windows as Elapsed, the last sample's ReceivedAt and its own
+// Sequence. A partial final bucket is
+// emitted when stream closes. Errors are passed through unchanged; an
+// interval that is not positive disables aggregation and returns stream.
+func AggregateByInterval(stream *Stream, interval time.Duration) *Stream {
+	if interval <= 0 {
+		return stream
+	}
+	out := make(chan Metrics, cap(stream.Metrics))
+
+	go func() {
+		defer close(out)
+
+		var (
+			sample   *Metrics
+			sampleAt time.Time
+			bucket   []Metrics
+			bucketAt time.Time
+			sequence uint64
+		)
+		emitBucket := func() {
+			if len(bucket) == 0 {
+				return
+			}
+			sequence++
+			aggregated := averageMetrics(bucket)
+			aggregated.Timestamp = bucketAt
+			aggregated.Sequence = sequence
+			out <- aggregated
+			bucket = nil
+		}
+		closeSample := func() {
+			if sample == nil {
+				return
+			}
+			at := sampleAt.Truncate(interval)
+			if len(bucket) > 0 && !at.Equal(bucketAt) {
+				emitBucket()
+			}
+			bucketAt = at
+			bucket = append(bucket, *sample)
+			sample = nil
+		}
+
+		for metrics := range stream.Metrics {
+			at := metrics.Timestamp
+			if at.IsZero() {
+				at = metrics.ReceivedAt
+			}
+			if sample != nil && !at.Equal(sampleAt) {
+				closeSample()
+			}
+			if sample == nil {
+				sample, sampleAt = &Metrics{}, at
+			}
+			mergeSnapshot(sample, metrics)
+		}
+		closeSample()
+		emitBucket()
+	}()
+
+	return &Stream{Metrics: out, Errors: stream.Errors}
+}
+
+// mergeSnapshot copies every section src carries onto dst, so the snapshots
+// the parser emits while a sample is read combine into one Metrics.
+func mergeSnapshot(dst *Metrics, src Metrics) {
+	dst.Timestamp, dst.Elapsed, dst.ReceivedAt = src.Timestamp, src.Elapsed, src.ReceivedAt
+	if src.PowerUnit != "" {
+		dst.PowerUnit = src.PowerUnit
+	}
+	if src.Host != "" {
+		dst.Host = src.Host
+	}
+	if src.SystemSample != nil {
+		dst.SystemSample = src.SystemSample
+	}
+	if len(src.ProcessSamples) > 0 || src.DeadTasks != nil {
+		dst.ProcessSamples, dst.DeadTasks = src.ProcessSamples, src.DeadTasks
+	}
+	if len(src.GPUProcessSamples) > 0 {
+		dst.GPUProcessSamples = src.GPUProcessSamples
+	}
+	if len(src.Clusters) > 0 {
+		dst.Clusters = src.Clusters
+	}
+	if len(src.CPUResidencies) > 0 {
+		dst.CPUResidencies = src.CPUResidencies
+	}
+	if len(src.ClusterResidencies) > 0 {
+		dst.ClusterResidencies = src.ClusterResidencies
+	}
+	if src.GPUResidency != nil {
+		dst.GPUResidency = src.GPUResidency
+	}
+	if src.Network != nil {
+		dst.Network, dst.RawNetwork = src.Network, src.RawNetwork
+	}
+	if src.Disk != nil {
+		dst.Disk, dst.RawDisk = src.Disk, src.RawDisk
+	}
+	if len(src.Interrupts) > 0 {
+		dst.Interrupts = src.Interrupts
+	}
+	if len(src.Batteries) > 0 {
+		dst.Batteries = src.Batteries
+	}
+}
+
+// averageMetrics returns the last of samples with the system, network and
+// disk rates replaced by their mean over the samples that carry them, and
+// Elapsed replaced by the total of the sample windows.
+func averageMetrics(samples []Metrics) Metrics {
+	result := samples[len(samples)-1]
+	result.Elapsed = 0
+
+	var system SystemSample
+	var network NetworkMetrics
+	var disk DiskMetrics
+	var systems, networks, disks float64
+	var measured systemField
+	for _, m := range samples {
+		result.Elapsed += m.Elapsed
+		if s := m.SystemSample; s != nil {
+			for _, field := range averagedSystemFields {
+				*field(&system) += *field(s)
+			}
+			measured |= s.measured
+			systems++
+		}
+		if n := m.Network; n != nil {
+			network.InPacketsPerSec += n.InPacketsPerSec
+			network.InBytesPerSec += n.InBytesPerSec
+			network.OutPacketsPerSec += n.OutPacketsPerSec
+			network.OutBytesPerSec += n.OutBytesPerSec
+			networks++
+		}
+		if d := m.Disk; d != nil {
+			disk.ReadOpsPerSec += d.ReadOpsPerSec
+			disk.ReadBytesPerSec += d.ReadBytesPerSec
+			disk.WriteOpsPerSec += d.WriteOpsPerSec
+			disk.WriteBytesPerSec += d.WriteBytesPerSec
+			disks++
+		}
+	}
+
+	if systems > 0 {
+		var last SystemSample
+		for i := len(samples) - 1; i >= 0; i-- {
+			if samples[i].SystemSample != nil {
+				last = *samples[i].SystemSample
+				break
+			}
+		}
+		for _, field := range averagedSystemFields {
+			*field(&last) = *field(&system) / systems
+		}
+		last.measured = measured
+		result.SystemSample = &last
+	}
+	if networks > 0 {
+		result.Network = &NetworkMetrics{
+			InPacketsPerSec:  network.InPacketsPerSec / networks,
+			InBytesPerSec:    network.InBytesPerSec / networks,
+			OutPacketsPerSec: network.OutPacketsPerSec / networks,
+			OutBytesPerSec:   network.OutBytesPerSec / networks,
+		}
+	}
+	if disks > 0 {
+		result.Disk = &DiskMetrics{
+			ReadOpsPerSec:    disk.ReadOpsPerSec / disks,
+			ReadBytesPerSec:  disk.ReadBytesPerSec / disks,
+			WriteOpsPerSec:   disk.WriteOpsPerSec / disks,
+			WriteBytesPerSec: disk.WriteBytesPerSec / disks,
+		}
+	}
+	return result
+}
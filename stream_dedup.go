@@ -0,0 +1,75 @@
+package powermetrics
+
+import (
+	"reflect"
+	"sort"
+	"time"
+)
+
+// DedupSamples wraps stream so repeated samples are dropped, as happens when
+// overlapping logs are replayed back to back. Two kinds of repeats are
+// dropped:
+//
+//   - a sample whose header repeats the Timestamp of the sample before it:
+//     every Metrics the parser emits for the repeated header is dropped, so
+//     the output matches a capture that contains the sample once;
+//   - a Metrics identical to the previous one with the same non-zero
+//     Timestamp, ignoring ReceivedAt, Sequence and the order of the
+//     per-CPU slices, which the parser fills from maps.
+//
+// The parser's successive snapshots of one sample share a Timestamp but
+// differ in content, so they are kept. Metrics without a sample header are
+// never dropped. Errors are passed through unchanged, and the returned
+// stream closes when stream does.
+func DedupSamples(stream *Stream) *Stream {
+	out := make(chan Metrics, cap(stream.Metrics))
+
+	go func() {
+		defer close(out)
+
+		var prev Metrics
+		seen := false
+		var repeated uint64
+		for metrics := range stream.Metrics {
+			if seen && metrics.header != 0 && metrics.header != prev.header && metrics.header != repeated &&
+				!prev.Timestamp.IsZero() && metrics.Timestamp.Equal(prev.Timestamp) {
+				repeated = metrics.header
+			}
+			if metrics.header != 0 && metrics.header == repeated {
+				continue
+			}
+			if seen && isDuplicateSample(prev, metrics) {
+				continue
+			}
+			prev, seen = metrics, true
+			out <- metrics
+		}
+	}()
+
+	return &Stream{Metrics: out, Errors: stream.Errors}
+}
+
+// isDuplicateSample reports whether b repeats a; see DedupSamples.
+func isDuplicateSample(a, b Metrics) bool {
+	if a.Timestamp.IsZero() || !a.Timestamp.Equal(b.Timestamp) {
+		return false
+	}
+	return reflect.DeepEqual(dedupKey(a), dedupKey(b))
+}
+
+// dedupKey returns the content of m that DedupSamples compares: the stream
+// bookkeeping is cleared and the slices built from maps are sorted.
+func dedupKey(m Metrics) Metrics {
+	m.ReceivedAt, m.Sequence, m.header = time.Time{}, 0, 0
+	if len(m.CPUResidencies) > 1 {
+		cpus := append([]CPUResidencyMetrics(nil), m.CPUResidencies...)
+		sort.Slice(cpus, func(i, j int) bool { return cpus[i].CPUID < cpus[j].CPUID })
+		m.CPUResidencies = cpus
+	}
+	if len(m.Interrupts) > 1 {
+		interrupts := append([]InterruptMetrics(nil), m.Interrupts...)
+		sort.Slice(interrupts, func(i, j int) bool { return interrupts[i].CPUID < interrupts[j].CPUID })
+		m.Interrupts = interrupts
+	}
+	return m
+}
@@ -0,0 +1,83 @@
+package powermetrics
+
+import "time"
+
+// SmoothIO wraps stream so the Network and Disk rates of each Metrics are
+// replaced by an exponential moving average with the given alpha (the weight
+// of the newest sample, in (0, 1]; other values disable smoothing). The
+// unsmoothed values are kept in Metrics.RawNetwork and Metrics.RawDisk.
+//
+// The parser emits several snapshots per sample, so the average advances
+// once per sample, keyed by Metrics.Timestamp; snapshots of the same sample
+// are all smoothed against the previous sample's average. Input without
+// sample headers advances the average on every Metrics. Errors are passed
+// through unchanged, and the returned stream closes when stream does.
+func SmoothIO(stream *Stream, alpha float64) *Stream {
+	if alpha <= 0 || alpha > 1 {
+		alpha = 1
+	}
+	out := make(chan Metrics, cap(stream.Metrics))
+
+	go func() {
+		defer close(out)
+
+		network := ioSmoother{alpha: alpha}
+		disk := ioSmoother{alpha: alpha}
+		for metrics := range stream.Metrics {
+			if n := metrics.Network; n != nil {
+				raw := *n
+				metrics.RawNetwork = &raw
+				in := network.apply(metrics.Timestamp, [4]float64{
+					raw.InPacketsPerSec, raw.InBytesPerSec, raw.OutPacketsPerSec, raw.OutBytesPerSec,
+				})
+				metrics.Network = &NetworkMetrics{
+					InPacketsPerSec:  in[0],
+					InBytesPerSec:    in[1],
+					OutPacketsPerSec: in[2],
+					OutBytesPerSec:   in[3],
+				}
+			}
+			if d := metrics.Disk; d != nil {
+				raw := *d
+				metrics.RawDisk = &raw
+				rw := disk.apply(metrics.Timestamp, [4]float64{
+					raw.ReadOpsPerSec, raw.ReadBytesPerSec, raw.WriteOpsPerSec, raw.WriteBytesPerSec,
+				})
+				metrics.Disk = &DiskMetrics{
+					ReadOpsPerSec:    rw[0],
+					ReadBytesPerSec:  rw[1],
+					WriteOpsPerSec:   rw[2],
+					WriteBytesPerSec: rw[3],
+				}
+			}
+			out <- metrics
+		}
+	}()
+
+	return &Stream{Metrics: out, Errors: stream.Errors}
+}
+
+// ioSmoother keeps the moving average of four rates. prev is the average as
+// of the previous sample and cur the average including the current one.
+type ioSmoother struct {
+	alpha           float64
+	seeded, hasPrev bool
+	sample          time.Time
+	prev, cur       [4]float64
+}
+
+func (s *ioSmoother) apply(sample time.Time, raw [4]float64) [4]float64 {
+	if !s.seeded || sample.IsZero() || !sample.Equal(s.sample) {
+		s.prev, s.hasPrev = s.cur, s.seeded
+		s.sample, s.seeded = sample, true
+	}
+	if !s.hasPrev {
+		// The first sample seeds the average.
+		s.cur = raw
+		return s.cur
+	}
+	for i := range raw {
+		s.cur[i] = s.alpha*raw[i] + (1-s.alpha)*s.prev[i]
+	}
+	return s.cur
+}
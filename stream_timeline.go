@@ -0,0 +1,78 @@
+package powermetrics
+
+import "time"
+
+// Event is an application event to place on the same timeline as the
+// samples, e.g. the start of a benchmark phase.
+type Event struct {
+	Time  time.Time
+	Label string
+}
+
+// TimelineEntry is one item of a merged timeline: exactly one of Metrics and
+// Event is set, and Time is the instant it was ordered by.
+type TimelineEntry struct {
+	Time    time.Time
+	Metrics *Metrics
+	Event   *Event
+}
+
+// MergeTimeline interleaves metrics and events into one channel ordered by
+// time, for annotating power graphs with application phases. Metrics are
+// ordered by their Timestamp, or ReceivedAt for input without sample
+// headers; on a tie the sample comes first. Each input is assumed to be in
+// order already.
+//
+// To keep the order, an entry is only emitted once the other input has
+// produced a later item or closed, so a quiet events channel holds back the
+// samples. The returned channel closes when both inputs have closed.
+func MergeTimeline(metrics <-chan Metrics, events <-chan Event) <-chan TimelineEntry {
+	out := make(chan TimelineEntry, cap(metrics))
+
+	go func() {
+		defer close(out)
+
+		var (
+			nextMetrics *Metrics
+			nextEvent   *Event
+		)
+		for metrics != nil || events != nil || nextMetrics != nil || nextEvent != nil {
+			// Wait until both inputs have a head or are closed.
+			if nextMetrics == nil && metrics != nil {
+				if m, ok := <-metrics; ok {
+					nextMetrics = &m
+				} else {
+					metrics = nil
+				}
+				continue
+			}
+			if nextEvent == nil && events != nil {
+				if e, ok := <-events; ok {
+					nextEvent = &e
+				} else {
+					events = nil
+				}
+				continue
+			}
+
+			switch {
+			case nextEvent == nil || (nextMetrics != nil && !timelineTime(nextMetrics).After(nextEvent.Time)):
+				out <- TimelineEntry{Time: timelineTime(nextMetrics), Metrics: nextMetrics}
+				nextMetrics = nil
+			default:
+				out <- TimelineEntry{Time: nextEvent.Time, Event: nextEvent}
+				nextEvent = nil
+			}
+		}
+	}()
+
+	return out
+}
+
+// timelineTime returns the instant MergeTimeline orders a sample by.
+func timelineTime(m *Metrics) time.Time {
+	if m.Timestamp.IsZero() {
+		return m.ReceivedAt
+	}
+	return m.Timestamp
+}
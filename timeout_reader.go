@@ -0,0 +1,85 @@
+package powermetrics
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrReadTimeout is reported on a stream's Errors channel when a read blocks
+// longer than Config.ReadTimeout.
+var ErrReadTimeout = errors.New("powermetrics: read timed out")
+
+// timeoutReadSize is the chunk size the pump goroutine reads at a time.
+const timeoutReadSize = 32 * 1024
+
+type readResult struct {
+	data []byte
+	err  error
+}
+
+// timeoutReader bounds how long each Read may block. A pump goroutine reads
+// from the source so Read can give up on a timer; once a read times out, every
+// later Read fails with ErrReadTimeout. The pump exits when the source
+// returns an error or, after release, with its next read.
+type timeoutReader struct {
+	timeout time.Duration
+	reads   chan readResult
+	done    chan struct{}
+	once    sync.Once
+	pending []byte
+	err     error
+}
+
+func newTimeoutReader(src io.Reader, timeout time.Duration) *timeoutReader {
+	r := &timeoutReader{
+		timeout: timeout,
+		reads:   make(chan readResult),
+		done:    make(chan struct{}),
+	}
+	go r.pump(src)
+	return r
+}
+
+func (r *timeoutReader) pump(src io.Reader) {
+	for {
+		buf := make([]byte, timeoutReadSize)
+		n, err := src.Read(buf)
+		select {
+		case r.reads <- readResult{data: buf[:n], err: err}:
+		case <-r.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (r *timeoutReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 && r.err == nil {
+		timer := time.NewTimer(r.timeout)
+		select {
+		case res := <-r.reads:
+			r.pending, r.err = res.data, res.err
+		case <-timer.C:
+			r.err = ErrReadTimeout
+			r.release()
+		}
+		timer.Stop()
+	}
+
+	if len(r.pending) > 0 {
+		n := copy(p, r.pending)
+		r.pending = r.pending[n:]
+		return n, nil
+	}
+	return 0, r.err
+}
+
+// release lets the pump goroutine exit instead of waiting to hand over data
+// nobody will read.
+func (r *timeoutReader) release() {
+	r.once.Do(func() { close(r.done) })
+}